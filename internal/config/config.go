@@ -1,18 +1,78 @@
 package config
 
-import "github.com/zeromicro/go-zero/rest"
+import (
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/rest"
+)
 
 type Config struct {
 	rest.RestConf
 
-	Swagger struct {
-		Host string `json:"Host"`
-	}
+	Swagger Swagger
+
+	FileServer []FileServer `json:",optional"`
+
+	Limit Limit `json:",optional"`
 
-	FileServer []FileServer
+	Storage Storage `json:",optional"`
+
+	DB DB `json:",optional"`
+
+	Metrics Metrics `json:",optional"`
+}
+
+type Swagger struct {
+	Host     string           `json:"Host"`
+	Schemes  []string         `json:",optional"` // 覆盖文档中的 schemes, 如 [https]
+	Auth     bool             `json:",optional"` // 开启后文档页面可以通过 Authorize 填写 Bearer token
+	Versions []SwaggerVersion `json:",optional"` // 同时展示的多个版本, 为空时只展示 swagger.json
+}
+
+type SwaggerVersion struct {
+	Name string // 版本名, 显示在文档页面右上角的下拉框中
+	File string // 相对 internal/handler/swagger 目录的文档路径, 如 v1/swagger.json
 }
 
 type FileServer struct {
 	ApiPrefix string
 	Dir       string
 }
+
+// 登录防爆破和下载限流
+type Limit struct {
+	Redis          redis.RedisConf `json:",optional"` // 配置 Host 后计数存到 Redis, 多实例共享; 否则存在进程内
+	Login          LoginLimit      `json:",optional"`
+	Download       RateLimit       `json:",optional"` // 作业下载、导出按 IP 限流
+	TrustedProxies []string        `json:",optional"` // 可信的反向代理 IP 或 CIDR, 只有来自它们的请求才采信 X-Forwarded-For
+}
+
+type LoginLimit struct {
+	MaxAccountFailures int           `json:",default=5"`   // 同一账号窗口内允许的失败次数, 0 不限制
+	MaxIPFailures      int           `json:",default=20"`  // 同一 IP 窗口内允许的失败次数, 0 不限制
+	FailureWindow      time.Duration `json:",default=15m"` // 失败计数窗口
+	LockDuration       time.Duration `json:",default=15m"` // 超过次数后的锁定时间
+}
+
+type RateLimit struct {
+	Rate  float64 `json:",default=0.2"` // 每秒补充的令牌数, 0 不限流
+	Burst int     `json:",default=3"`   // 令牌桶容量
+}
+
+// 作业产物存储
+type Storage struct {
+	Type  string `json:",default=local,options=local|filer"` // local 本地磁盘, filer 存到 weedfilesys 集群
+	Dir   string `json:",default=./work"`                    // 本地目录, 或 filer 上的目录
+	Filer string `json:",optional"`                          // filer 地址 host:port, Type 为 filer 时必填
+}
+
+type DB struct {
+	DataSource string `json:",optional"` // mysql dsn, 为空时不连接数据库, /readyz 跳过数据库检查
+}
+
+// 在 API 端口上暴露 go-zero 和 weedfilesys 的 prometheus 指标
+type Metrics struct {
+	Enable bool   `json:",default=true"`
+	Path   string `json:",default=/metrics"`
+}
@@ -1,8 +1,12 @@
 package chain
 
 import (
+	"cayoyibackend/dao/model"
+	"cayoyibackend/internal/health"
+	"cayoyibackend/internal/svc"
 	"context"
 	"testing"
+	"time"
 )
 
 func TestChain(t *testing.T) {
@@ -15,3 +19,32 @@ func TestChain(t *testing.T) {
 	ctx := context.Background()
 	driver.Chain(ctx)
 }
+
+func TestRunJobStalled(t *testing.T) {
+	cctx := &svc.ServiceContext{Scheduler: health.NewHeartbeat(50 * time.Millisecond)}
+	cctx.Scheduler.Beat()
+
+	release := make(chan struct{})
+	branch := NewBranch(WithBranchHandlers(
+		func(ctx context.Context, cctx *svc.ServiceContext, j *model.Job, next NextHandler) error {
+			<-release
+			return next(ctx, cctx, j)
+		},
+	))
+	finished := make(chan struct{})
+	go func() {
+		runJob(context.Background(), cctx, branch)
+		close(finished)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	if err := cctx.Scheduler.Check(context.Background()); err == nil {
+		t.Fatal("stalled job should fail the scheduler check")
+	}
+
+	close(release)
+	<-finished
+	if err := cctx.Scheduler.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
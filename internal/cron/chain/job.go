@@ -19,26 +19,34 @@ func CheckJobStatus(svc *svc.ServiceContext) {
 
 	go func() {
 		for _, job := range jobs {
-			logx.Info("CheckJobStatus jobId = %d", job.ID)
+			logx.Infof("CheckJobStatus jobId = %d", job.ID)
 			JobChannel <- job.ID
 		}
 	}()
 
+	// 调度器已启动，/healthz 据此不再跳过调度器检查
+	svc.Scheduler.Beat()
+
 	for id := range JobChannel {
-		go func() {
-			b := branchMap[int(id)]
-			d, err := NewDriver(
-				WithSvcCtx(svc),
-				WithDefaultBranch(b),
-			)
-			if err != nil {
-				return
-			}
-
-			err = d.Chain(ctx)
-			if err != nil {
-				return
-			}
-		}()
+		go runJob(ctx, svc, branchMap[int(id)])
+	}
+}
+
+// 派发和完成作业时心跳，作业卡住时 /healthz 报告调度器异常
+func runJob(ctx context.Context, svc *svc.ServiceContext, b *Branch) {
+	job := svc.Scheduler.Begin()
+	defer job.Done()
+
+	d, err := NewDriver(
+		WithSvcCtx(svc),
+		WithDefaultBranch(b),
+	)
+	if err != nil {
+		return
+	}
+
+	err = d.Chain(ctx)
+	if err != nil {
+		return
 	}
 }
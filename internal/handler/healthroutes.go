@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"net/http"
+	"runtime"
+	"runtime/debug"
+
+	"cayoyibackend/internal/health"
+	"cayoyibackend/internal/svc"
+	"cayoyibackend/weedfilesys/stats"
+	"cayoyibackend/weedfilesys/util/version"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	zeroprom "github.com/zeromicro/go-zero/core/prometheus"
+	"github.com/zeromicro/go-zero/rest"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+type versionResp struct {
+	Service   string `json:"service"`
+	Version   string `json:"version"` // weedfilesys 版本
+	Commit    string `json:"commit,omitempty"`
+	BuildTime string `json:"buildTime,omitempty"`
+	Modified  bool   `json:"modified,omitempty"` // 构建时工作区有未提交的修改
+	GoVersion string `json:"goVersion"`
+}
+
+func healthHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+		code := http.StatusOK
+		if !report.OK() {
+			code = http.StatusServiceUnavailable
+		}
+		httpx.WriteJsonCtx(r.Context(), w, code, report)
+	}
+}
+
+func versionHandler(serverCtx *svc.ServiceContext) http.HandlerFunc {
+	resp := versionResp{
+		Service:   serverCtx.Config.Name,
+		Version:   version.VERSION,
+		Commit:    version.COMMIT,
+		GoVersion: runtime.Version(),
+	}
+	// 没有通过 -ldflags 注入 commit 时从 go build 记录的 vcs 信息中读取
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if resp.Commit == "" {
+					resp.Commit = setting.Value
+				}
+			case "vcs.time":
+				resp.BuildTime = setting.Value
+			case "vcs.modified":
+				resp.Modified = setting.Value == "true"
+			}
+		}
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		httpx.OkJsonCtx(r.Context(), w, resp)
+	}
+}
+
+// go-zero 的指标注册在 prometheus 默认 registry，weedfilesys 的注册在 stats.Gather，合并后一起暴露
+func metricsHandler() http.HandlerFunc {
+	zeroprom.Enable()
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer, stats.Gather}
+	return promhttp.HandlerFor(gatherers, promhttp.HandlerOpts{}).ServeHTTP
+}
+
+func RegisterHealthHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
+	routes := []rest.Route{
+		{
+			// 存活检查
+			Method:  http.MethodGet,
+			Path:    "/healthz",
+			Handler: healthHandler(serverCtx.Liveness),
+		},
+		{
+			// 就绪检查
+			Method:  http.MethodGet,
+			Path:    "/readyz",
+			Handler: healthHandler(serverCtx.Readiness),
+		},
+		{
+			// 构建信息
+			Method:  http.MethodGet,
+			Path:    "/version",
+			Handler: versionHandler(serverCtx),
+		},
+	}
+	if c := serverCtx.Config.Metrics; c.Enable {
+		path := c.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		routes = append(routes, rest.Route{
+			Method:  http.MethodGet,
+			Path:    path,
+			Handler: metricsHandler(),
+		})
+	}
+
+	server.AddRoutes(routes)
+}
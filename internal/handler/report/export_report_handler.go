@@ -0,0 +1,45 @@
+package report
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"cayoyibackend/internal/logic/report"
+	"cayoyibackend/internal/svc"
+	"cayoyibackend/internal/types"
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+// 导出作业结果报表
+func ExportReportHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req types.ExportReportReq
+		if err := httpx.Parse(r, &req); err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		l := report.NewExportReportLogic(r.Context(), svcCtx)
+		resp, err := l.ExportReport(&req)
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		// 先完整生成再写出，生成失败时还能返回错误信息
+		body, err := resp.Bytes()
+		if err != nil {
+			httpx.ErrorCtx(r.Context(), w, err)
+			return
+		}
+
+		filename := resp.Filename()
+		w.Header().Set("Content-Type", resp.ContentType())
+		// 中文文件名用 filename* 传递，filename 留给不支持 RFC 5987 的客户端
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="report.%s"; filename*=UTF-8''%s`,
+			resp.Ext(), url.PathEscape(filename)))
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	}
+}
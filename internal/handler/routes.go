@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	job "cayoyibackend/internal/handler/job"
+	report "cayoyibackend/internal/handler/report"
 	user "cayoyibackend/internal/handler/user"
 	"cayoyibackend/internal/svc"
 
@@ -15,25 +16,37 @@ import (
 
 func RegisterHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
 	server.AddRoutes(
-		[]rest.Route{
-			{
-				// 作业文件下载
-				Method:  http.MethodPost,
-				Path:    "/download/jobs",
-				Handler: job.DownloadJobsHandler(serverCtx),
-			},
-		},
+		rest.WithMiddlewares(
+			[]rest.Middleware{serverCtx.DownloadLimit},
+			[]rest.Route{
+				{
+					// 作业文件下载
+					Method:  http.MethodPost,
+					Path:    "/download/jobs",
+					Handler: job.DownloadJobsHandler(serverCtx),
+				},
+			}...,
+		),
 		rest.WithPrefix("/api/job"),
 	)
 
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{serverCtx.DownloadLimit},
+			[]rest.Route{
+				{
+					// 导出作业结果报表
+					Method:  http.MethodPost,
+					Path:    "/export",
+					Handler: report.ExportReportHandler(serverCtx),
+				},
+			}...,
+		),
+		rest.WithPrefix("/api/report"),
+	)
+
 	server.AddRoutes(
 		[]rest.Route{
-			{
-				// 用户登录
-				Method:  http.MethodPost,
-				Path:    "/login",
-				Handler: user.LoginHandler(serverCtx),
-			},
 			{
 				// 获取 RSA 加密公钥
 				Method:  http.MethodGet,
@@ -44,6 +57,21 @@ func RegisterHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
 		rest.WithPrefix("/api/user"),
 	)
 
+	server.AddRoutes(
+		rest.WithMiddlewares(
+			[]rest.Middleware{serverCtx.LoginLimit},
+			[]rest.Route{
+				{
+					// 用户登录
+					Method:  http.MethodPost,
+					Path:    "/login",
+					Handler: user.LoginHandler(serverCtx),
+				},
+			}...,
+		),
+		rest.WithPrefix("/api/user"),
+	)
+
 	server.AddRoutes(
 		[]rest.Route{
 			{
@@ -1,18 +1,30 @@
 package handler
 
 import (
+	"cayoyibackend/internal/config"
 	"cayoyibackend/internal/svc"
 	"embed"
 	"encoding/json"
 	"fmt"
 	"github.com/zeromicro/go-zero/core/logx"
 	"github.com/zeromicro/go-zero/rest"
+	"io/fs"
+	"mime"
 	"net/http"
+	"path"
 )
 
 //go:embed swagger
 var f embed.FS
 
+const (
+	swaggerRoot        = "swagger"
+	swaggerInitializer = "swagger-initializer.js"
+	swaggerDefaultSpec = "swagger.json"
+	// goctl-swagger 生成的 securityDefinitions 中的名字
+	swaggerSecurityName = "apiKey"
+)
+
 type fileType string
 
 // fileType
@@ -25,109 +37,170 @@ const (
 	PNG  fileType = "png"  // .png
 )
 
+func toFileType(filepath string) fileType {
+	switch path.Ext(filepath) {
+	case ".js":
+		return JS
+	case ".css":
+		return CSS
+	case ".json":
+		return JSON
+	case ".png":
+		return PNG
+	}
+	return HTML
+}
+
 func addSwagger(filepath string, filetype fileType, svc *svc.ServiceContext) func(writer http.ResponseWriter, request *http.Request) {
 	return func(writer http.ResponseWriter, request *http.Request) {
 		body, err := f.ReadFile(filepath)
 		if err != nil {
-			panic("swagger file is not exist!")
+			logx.Errorf("swagger file %s read err: %v", filepath, err)
+			http.NotFound(writer, request)
+			return
 		}
 		switch filetype {
 		case JS:
 			writer.Header().Set("content-type", "application/javascript")
-			fmt.Fprint(writer, string(body))
 		case CSS:
 			writer.Header().Set("content-type", "text/css")
-			fmt.Fprint(writer, string(body))
 		case JSON:
-			var result map[string]interface{}
-			err = json.Unmarshal(body, &result)
-			if err != nil {
-				logx.Info("swagger parse err: ", err)
-			}
-			result["host"] = svc.Config.Swagger.Host
-			body, err = json.Marshal(result)
+			body, err = injectSwaggerSpec(body, svc.Config.Swagger)
 			if err != nil {
-				logx.Info("swagger parse err: ", err)
+				logx.Errorf("swagger file %s parse err: %v", filepath, err)
+				http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
 			}
 			writer.Header().Set("content-type", "application/json")
-			fmt.Fprint(writer, string(body))
 		case PNG:
 			writer.Header().Set("content-type", "image/png")
-			fmt.Fprint(writer, body)
 		case HTML:
-			fmt.Fprint(writer, string(body))
+			if ct := mime.TypeByExtension(path.Ext(filepath)); ct != "" {
+				writer.Header().Set("content-type", ct)
+			}
+		}
+		writer.Write(body)
+	}
+}
+
+// 运行时把配置中的 host、schemes 以及 Bearer 认证写入 swagger 文档
+func injectSwaggerSpec(body []byte, c config.Swagger) ([]byte, error) {
+	var result map[string]interface{}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, err
+	}
+
+	if c.Host != "" {
+		result["host"] = c.Host
+	}
+	if len(c.Schemes) > 0 {
+		result["schemes"] = c.Schemes
+	}
+	if c.Auth {
+		definitions, _ := result["securityDefinitions"].(map[string]interface{})
+		if definitions == nil {
+			definitions = make(map[string]interface{})
+		}
+		if _, ok := definitions[swaggerSecurityName]; !ok {
+			definitions[swaggerSecurityName] = map[string]interface{}{
+				"type":        "apiKey",
+				"description": "Enter JWT Bearer token **_only_**",
+				"name":        "Authorization",
+				"in":          "header",
+			}
+		}
+		result["securityDefinitions"] = definitions
+		// 全局生效，点击 Authorize 填写 token 后所有 try it out 请求都会带上
+		result["security"] = []map[string][]string{{swaggerSecurityName: {}}}
+	}
+
+	return json.Marshal(result)
+}
+
+// swagger-initializer.js 根据配置的版本列表动态生成，多版本时右上角可以切换
+func swaggerInitializerHandler(svc *svc.ServiceContext) func(writer http.ResponseWriter, request *http.Request) {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		type specUrl struct {
+			Url  string `json:"url"`
+			Name string `json:"name"`
+		}
+
+		var urls []specUrl
+		for _, v := range swaggerVersions(svc.Config.Swagger) {
+			urls = append(urls, specUrl{Url: "./" + v.File, Name: v.Name})
 		}
+		options := map[string]interface{}{
+			"dom_id":               "#swagger-ui",
+			"deepLinking":          true,
+			"persistAuthorization": true,
+			"layout":               "StandaloneLayout",
+		}
+		if len(urls) == 1 {
+			options["url"] = urls[0].Url
+		} else {
+			options["urls"] = urls
+			options["urls.primaryName"] = urls[0].Name
+		}
+		optionsJson, err := json.Marshal(options)
+		if err != nil {
+			logx.Errorf("swagger initializer marshal err: %v", err)
+			http.Error(writer, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		writer.Header().Set("content-type", "application/javascript")
+		fmt.Fprintf(writer, `window.onload = function() {
+  var options = %s;
+  options.presets = [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset];
+  options.plugins = [SwaggerUIBundle.plugins.DownloadUrl];
+  window.ui = SwaggerUIBundle(options);
+};
+`, optionsJson)
+	}
+}
+
+// 未配置版本列表时只展示 goctl 生成的 swagger.json
+func swaggerVersions(c config.Swagger) []config.SwaggerVersion {
+	if len(c.Versions) > 0 {
+		return c.Versions
 	}
+	return []config.SwaggerVersion{{Name: "current", File: swaggerDefaultSpec}}
 }
 
 func RegisterSwaggerHandlers(server *rest.Server, serverCtx *svc.ServiceContext) {
-	server.AddRoutes(
-		[]rest.Route{
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/index.html",
-				Handler: addSwagger("swagger/index.html", HTML, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/oauth2-redirect.html",
-				Handler: addSwagger("swagger/oauth2-redirect.html", HTML, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/index.css",
-				Handler: addSwagger("swagger/index.css", HTML, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui.css",
-				Handler: addSwagger("swagger/swagger-ui.css", CSS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/favicon-16x16.png",
-				Handler: addSwagger("swagger/favicon-16x16.png", PNG, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/favicon-32x32.png",
-				Handler: addSwagger("swagger/favicon-32x32.png", PNG, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-initializer.js",
-				Handler: addSwagger("swagger/swagger-initializer.js", JS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui.js",
-				Handler: addSwagger("swagger/swagger-ui.js", JS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui-bundle.js",
-				Handler: addSwagger("swagger/swagger-ui-bundle.js", JS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui-es-bundle.js",
-				Handler: addSwagger("swagger/swagger-ui-es-bundle.js", JS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui-es-bundle-core.js",
-				Handler: addSwagger("swagger/swagger-ui-es-bundle-core.js", JS, serverCtx),
-			},
-			{
-				Method:  http.MethodGet,
-				Path:    "/swagger/swagger-ui-standalone-preset.js",
-				Handler: addSwagger("swagger/swagger-ui-standalone-preset.js", JS, serverCtx),
-			},
-			{
+	var routes []rest.Route
+	err := fs.WalkDir(f, swaggerRoot, func(filepath string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		handler := addSwagger(filepath, toFileType(filepath), serverCtx)
+		if path.Base(filepath) == swaggerInitializer {
+			handler = swaggerInitializerHandler(serverCtx)
+		}
+		routes = append(routes, rest.Route{
+			Method:  http.MethodGet,
+			Path:    "/" + filepath,
+			Handler: handler,
+		})
+		return nil
+	})
+	if err != nil {
+		logx.Errorf("walk swagger files err: %v", err)
+		return
+	}
+
+	// 配置了但没有打包进来的版本文件返回 404，而不是让页面一直加载
+	for _, v := range swaggerVersions(serverCtx.Config.Swagger) {
+		specPath := path.Join(swaggerRoot, v.File)
+		if _, err := fs.Stat(f, specPath); err != nil {
+			logx.Errorf("swagger version %s file %s not found", v.Name, v.File)
+			routes = append(routes, rest.Route{
 				Method:  http.MethodGet,
-				Path:    "/swagger/swagger.json",
-				Handler: addSwagger("swagger/swagger.json", JSON, serverCtx),
-			},
-		},
-	)
+				Path:    "/" + specPath,
+				Handler: addSwagger(specPath, JSON, serverCtx),
+			})
+		}
+	}
+
+	server.AddRoutes(routes)
 }
@@ -1,8 +1,10 @@
 package user
 
 import (
+	"errors"
 	"net/http"
 
+	"cayoyibackend/internal/helper/limiter"
 	"cayoyibackend/internal/logic/user"
 	"cayoyibackend/internal/svc"
 	"cayoyibackend/internal/types"
@@ -20,7 +22,10 @@ func LoginHandler(svcCtx *svc.ServiceContext) http.HandlerFunc {
 
 		l := user.NewLoginLogic(r.Context(), svcCtx)
 		resp, err := l.Login(&req)
-		if err != nil {
+		var locked *limiter.LockedError
+		if errors.As(err, &locked) {
+			limiter.WriteTooManyRequests(w, r, locked.RetryAfterSeconds(), locked.Error())
+		} else if err != nil {
 			httpx.ErrorCtx(r.Context(), w, err)
 		} else {
 			httpx.OkJsonCtx(r.Context(), w, resp)
@@ -0,0 +1,62 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestChecker(t *testing.T) {
+	c := NewChecker()
+	c.Register("ok", func(ctx context.Context) error { return nil })
+	c.Register("skip", func(ctx context.Context) error { return ErrSkipped })
+
+	report := c.Run(context.Background())
+	if !report.OK() || report.Checks["skip"].Status != StatusSkip {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	c.Register("fail", func(ctx context.Context) error { return errors.New("boom") })
+	report = c.Run(context.Background())
+	if report.OK() || report.Checks["fail"].Error != "boom" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestHeartbeat(t *testing.T) {
+	h := NewHeartbeat(time.Minute)
+	if err := h.Check(context.Background()); !errors.Is(err, ErrSkipped) {
+		t.Fatalf("not started heartbeat should be skipped, got %v", err)
+	}
+
+	h.Beat()
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 空闲时没有心跳不算卡死
+	h.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	stuck, busy := h.Begin(), h.Begin()
+	stuck.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	busy.last.Store(time.Now().Add(-2 * time.Minute).UnixNano())
+	busy.Beat()
+	if err := h.Check(context.Background()); err == nil {
+		t.Fatal("stalled job should fail")
+	}
+
+	// 其他作业的进展不能掩盖卡住的作业
+	busy.Done()
+	if err := h.Check(context.Background()); err == nil {
+		t.Fatal("stalled job should still fail")
+	}
+
+	stuck.Done()
+	if err := h.Check(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,77 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat 后台任务在派发和完成作业时心跳，检查时运行中的作业超过 maxAge 没有进展视为卡死
+type Heartbeat struct {
+	last   atomic.Int64
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	running map[*Job]struct{}
+}
+
+// Job 一个运行中的作业，耗时长的作业通过 Beat 报告进展
+type Job struct {
+	h    *Heartbeat
+	last atomic.Int64
+}
+
+func NewHeartbeat(maxAge time.Duration) *Heartbeat {
+	return &Heartbeat{maxAge: maxAge, running: map[*Job]struct{}{}}
+}
+
+// Beat 标记任务已经启动
+func (h *Heartbeat) Beat() {
+	h.last.Store(time.Now().UnixNano())
+}
+
+// Begin 派发作业时调用，作业结束后必须调用 Done
+func (h *Heartbeat) Begin() *Job {
+	j := &Job{h: h}
+	j.Beat()
+	h.mu.Lock()
+	h.running[j] = struct{}{}
+	h.mu.Unlock()
+	h.Beat()
+	return j
+}
+
+func (j *Job) Beat() {
+	j.last.Store(time.Now().UnixNano())
+}
+
+func (j *Job) Done() {
+	j.h.mu.Lock()
+	delete(j.h.running, j)
+	j.h.mu.Unlock()
+	j.h.Beat()
+}
+
+// Check 任务没有启动时跳过，空闲时正常
+func (h *Heartbeat) Check(ctx context.Context) error {
+	if h.last.Load() == 0 {
+		return ErrSkipped
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var stalled int
+	var oldest time.Duration
+	for j := range h.running {
+		if age := time.Since(time.Unix(0, j.last.Load())); age > h.maxAge {
+			stalled++
+			oldest = max(oldest, age)
+		}
+	}
+	if stalled > 0 {
+		return fmt.Errorf("%d of %d running jobs made no progress for %s", stalled, len(h.running), oldest.Truncate(time.Second))
+	}
+	return nil
+}
@@ -83,3 +83,18 @@ func ReadCsv(fileName string) (map[string][]float64, error) {
 
 	return res, nil
 }
+
+// ReadTable 按原始顺序读取表头和数据行，适合表头不固定的结果文件
+func ReadTable(r io.Reader) (header []string, rows [][]string, err error) {
+	csvReader := csv.NewReader(skipBOM(r))
+	// 结果文件可能有空列，不校验每行字段数
+	csvReader.FieldsPerRecord = -1
+	all, err := csvReader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(all) == 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	return all[0], all[1:], nil
+}
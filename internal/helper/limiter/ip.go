@@ -0,0 +1,88 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// TrustedProxies 可信的反向代理, 只有直连的对端是可信代理时才采信 X-Forwarded-For
+type TrustedProxies []*net.IPNet
+
+// ParseTrustedProxies 解析 IP 或 CIDR 列表, 如 127.0.0.1、10.0.0.0/8
+func ParseTrustedProxies(proxies []string) (TrustedProxies, error) {
+	var trusted TrustedProxies
+	for _, proxy := range proxies {
+		proxy = strings.TrimSpace(proxy)
+		if !strings.Contains(proxy, "/") {
+			ip := net.ParseIP(proxy)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", proxy)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			trusted = append(trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", proxy, err)
+		}
+		trusted = append(trusted, ipNet)
+	}
+	return trusted, nil
+}
+
+func (p TrustedProxies) contains(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP 取请求来源 IP, 默认为直连的对端地址.
+// 对端是可信代理时, 从 X-Forwarded-For 的最右边往左跳过可信代理, 取第一个不可信的地址, 客户端自己伪造的部分不会被采信
+func (p TrustedProxies) ClientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !p.contains(ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(addr) == nil {
+			// 格式不对的地址无法继续往前追溯
+			break
+		}
+		ip = addr
+		if !p.contains(addr) {
+			break
+		}
+	}
+	return ip
+}
+
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+func ClientIPFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIPKey{}).(string)
+	return ip
+}
@@ -0,0 +1,42 @@
+package limiter
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ParseTrustedProxies([]string{"proxy"}); err == nil {
+		t.Fatalf("expect invalid proxy rejected")
+	}
+
+	cases := []struct {
+		remoteAddr string
+		forwarded  []string
+		expected   string
+	}{
+		// 不经过代理时忽略客户端自己设置的 X-Forwarded-For
+		{"192.168.1.5:5000", nil, "192.168.1.5"},
+		{"192.168.1.5:5000", []string{"1.2.3.4"}, "192.168.1.5"},
+		// 经过可信代理时取最右边的不可信地址, 左边伪造的部分不采信
+		{"127.0.0.1:5000", []string{"1.2.3.4"}, "1.2.3.4"},
+		{"127.0.0.1:5000", []string{"6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4"},
+		{"127.0.0.1:5000", []string{"6.6.6.6", "1.2.3.4"}, "1.2.3.4"},
+		{"127.0.0.1:5000", []string{"garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"127.0.0.1:5000", nil, "127.0.0.1"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = c.remoteAddr
+		for _, value := range c.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if ip := proxies.ClientIP(r); ip != c.expected {
+			t.Errorf("%s %v: got %s, expected %s", c.remoteAddr, c.forwarded, ip, c.expected)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const (
+	failAccountPrefix = "login:fail:acct:"
+	failIPPrefix      = "login:fail:ip:"
+	lockAccountPrefix = "login:lock:acct:"
+	lockIPPrefix      = "login:lock:ip:"
+)
+
+type LoginGuardConf struct {
+	MaxAccountFailures int           // 同一账号窗口内允许的失败次数
+	MaxIPFailures      int           // 同一 IP 窗口内允许的失败次数
+	FailureWindow      time.Duration // 失败计数窗口
+	LockDuration       time.Duration // 超过次数后的锁定时间
+}
+
+// 登录失败超过次数后锁定账号或 IP
+type LoginGuard struct {
+	conf  LoginGuardConf
+	store Store
+}
+
+func NewLoginGuard(conf LoginGuardConf, store Store) *LoginGuard {
+	return &LoginGuard{conf: conf, store: store}
+}
+
+// LockedError 锁定期间的请求返回该错误，RetryAfter 为剩余锁定时间
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("登录失败次数过多，请 %d 秒后重试", e.RetryAfterSeconds())
+}
+
+func (e *LockedError) RetryAfterSeconds() int {
+	return max(1, int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+// CheckIP 检查 IP 是否被锁定
+func (g *LoginGuard) CheckIP(ctx context.Context, ip string) error {
+	return g.check(ctx, lockIPPrefix+ip)
+}
+
+// Check 检查账号和 IP 是否被锁定
+func (g *LoginGuard) Check(ctx context.Context, account, ip string) error {
+	if err := g.CheckIP(ctx, ip); err != nil {
+		return err
+	}
+	return g.check(ctx, lockAccountPrefix+account)
+}
+
+// Fail 记录一次登录失败，超过次数后锁定
+func (g *LoginGuard) Fail(ctx context.Context, account, ip string) {
+	g.fail(ctx, failAccountPrefix+account, lockAccountPrefix+account, g.conf.MaxAccountFailures)
+	g.fail(ctx, failIPPrefix+ip, lockIPPrefix+ip, g.conf.MaxIPFailures)
+}
+
+// Succeed 登录成功后清除账号的失败计数，IP 的计数保留，避免用一个正确账号刷新 IP 计数
+func (g *LoginGuard) Succeed(ctx context.Context, account string) {
+	if err := g.store.Del(ctx, failAccountPrefix+account); err != nil {
+		logx.WithContext(ctx).Errorf("login guard reset %s err: %v", account, err)
+	}
+}
+
+func (g *LoginGuard) check(ctx context.Context, lockKey string) error {
+	left, err := g.store.LockedFor(ctx, lockKey)
+	if err != nil {
+		// 存储不可用时放行，不能因为限流影响登录
+		logx.WithContext(ctx).Errorf("login guard check %s err: %v", lockKey, err)
+		return nil
+	}
+	if left > 0 {
+		return &LockedError{RetryAfter: left}
+	}
+	return nil
+}
+
+func (g *LoginGuard) fail(ctx context.Context, failKey, lockKey string, maxFailures int) {
+	if maxFailures <= 0 {
+		return
+	}
+	n, err := g.store.Incr(ctx, failKey, g.conf.FailureWindow)
+	if err != nil {
+		logx.WithContext(ctx).Errorf("login guard incr %s err: %v", failKey, err)
+		return
+	}
+	if n < int64(maxFailures) {
+		return
+	}
+	if err = g.store.Lock(ctx, lockKey, g.conf.LockDuration); err != nil {
+		logx.WithContext(ctx).Errorf("login guard lock %s err: %v", lockKey, err)
+		return
+	}
+	if err = g.store.Del(ctx, failKey); err != nil {
+		logx.WithContext(ctx).Errorf("login guard reset %s err: %v", failKey, err)
+	}
+	logx.WithContext(ctx).Infof("login guard locked %s for %s", lockKey, g.conf.LockDuration)
+}
@@ -0,0 +1,57 @@
+package limiter
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLoginGuard(t *testing.T) {
+	ctx := context.Background()
+	g := NewLoginGuard(LoginGuardConf{
+		MaxAccountFailures: 3,
+		MaxIPFailures:      5,
+		FailureWindow:      time.Minute,
+		LockDuration:       time.Minute,
+	}, NewMemoryStore())
+
+	for i := 0; i < 3; i++ {
+		if err := g.Check(ctx, "admin", "10.0.0.1"); err != nil {
+			t.Fatalf("attempt %d locked too early: %v", i, err)
+		}
+		g.Fail(ctx, "admin", "10.0.0.1")
+	}
+
+	var locked *LockedError
+	if err := g.Check(ctx, "admin", "10.0.0.2"); !errors.As(err, &locked) {
+		t.Fatalf("account should be locked, got %v", err)
+	}
+	if err := g.Check(ctx, "other", "10.0.0.1"); err != nil {
+		t.Fatalf("ip should not be locked yet: %v", err)
+	}
+
+	// 换账号继续试，IP 计数累计到上限后锁定
+	g.Fail(ctx, "other", "10.0.0.1")
+	g.Fail(ctx, "other", "10.0.0.1")
+	if err := g.CheckIP(ctx, "10.0.0.1"); !errors.As(err, &locked) {
+		t.Fatalf("ip should be locked, got %v", err)
+	}
+}
+
+func TestMemoryStoreAllow(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := s.Allow(ctx, "k", 0.01, 2); !ok {
+			t.Fatalf("request %d should be allowed", i)
+		}
+	}
+	if ok, _ := s.Allow(ctx, "k", 0.01, 2); ok {
+		t.Fatal("bucket should be empty")
+	}
+	if ok, _ := s.Allow(ctx, "other", 0.01, 2); !ok {
+		t.Fatal("buckets should be per key")
+	}
+}
@@ -0,0 +1,101 @@
+package limiter
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/stores/redis"
+)
+
+// 令牌桶脚本，tokens 和 ts 存在同一个 hash 里，key 在桶回满之后过期
+const tokenBucketScript = `
+local rate = tonumber(ARGV[1])
+local capacity = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+
+local tokens = tonumber(redis.call("hget", KEYS[1], "tokens"))
+local ts = tonumber(redis.call("hget", KEYS[1], "ts"))
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) / 1000 * rate)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+
+redis.call("hset", KEYS[1], "tokens", tostring(tokens), "ts", tostring(now))
+redis.call("expire", KEYS[1], ttl)
+return allowed
+`
+
+var tokenBucket = redis.NewScript(tokenBucketScript)
+
+// Redis 存储，多实例部署时共享计数
+type redisStore struct {
+	rds *redis.Redis
+}
+
+func NewRedisStore(rds *redis.Redis) Store {
+	return &redisStore{rds: rds}
+}
+
+func (s *redisStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	n, err := s.rds.IncrCtx(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if n == 1 {
+		if err = s.rds.ExpireCtx(ctx, key, toSeconds(window)); err != nil {
+			return 0, err
+		}
+	}
+	return n, nil
+}
+
+func (s *redisStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	return s.rds.SetexCtx(ctx, key, "1", toSeconds(ttl))
+}
+
+func (s *redisStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	ttl, err := s.rds.TtlCtx(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	// -2 不存在，-1 没有过期时间
+	if ttl < 0 {
+		return 0, nil
+	}
+	return time.Duration(ttl) * time.Second, nil
+}
+
+func (s *redisStore) Del(ctx context.Context, keys ...string) error {
+	_, err := s.rds.DelCtx(ctx, keys...)
+	return err
+}
+
+func (s *redisStore) Allow(ctx context.Context, key string, r float64, burst int) (bool, error) {
+	ttl := toSeconds(time.Duration(float64(burst) / r * float64(time.Second)))
+	resp, err := s.rds.ScriptRunCtx(ctx, tokenBucket, []string{key},
+		strconv.FormatFloat(r, 'f', -1, 64),
+		strconv.Itoa(burst),
+		strconv.FormatInt(time.Now().UnixMilli(), 10),
+		strconv.Itoa(ttl),
+	)
+	if err != nil {
+		return false, err
+	}
+	allowed, _ := resp.(int64)
+	return allowed == 1, nil
+}
+
+// Redis 过期时间最小 1 秒
+func toSeconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
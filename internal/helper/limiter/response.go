@@ -0,0 +1,22 @@
+package limiter
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/zeromicro/go-zero/rest/httpx"
+)
+
+type tooManyRequests struct {
+	Code int    `json:"code"`
+	Msg  string `json:"msg"`
+}
+
+// WriteTooManyRequests 返回 429，并通过 Retry-After 告诉客户端多少秒后重试
+func WriteTooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter int, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	httpx.WriteJsonCtx(r.Context(), w, http.StatusTooManyRequests, tooManyRequests{
+		Code: http.StatusTooManyRequests,
+		Msg:  msg,
+	})
+}
@@ -0,0 +1,139 @@
+package limiter
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// 登录失败计数、锁定和令牌桶限流共用的存储
+// 默认使用进程内存储，多实例部署时配置 Redis，保证各实例看到同一份计数
+
+type Store interface {
+	// Incr 计数加一并返回当前值，window 内没有再次计数时自动清零
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Lock 在 ttl 内锁定 key
+	Lock(ctx context.Context, key string, ttl time.Duration) error
+	// LockedFor 返回 key 剩余的锁定时间，未锁定返回 0
+	LockedFor(ctx context.Context, key string) (time.Duration, error)
+	// Del 删除计数或锁定
+	Del(ctx context.Context, keys ...string) error
+	// Allow 令牌桶，每秒补充 r 个令牌，最多积攒 burst 个，取到令牌返回 true
+	Allow(ctx context.Context, key string, r float64, burst int) (bool, error)
+}
+
+const sweepInterval = time.Minute
+
+type counter struct {
+	value    int64
+	expireAt time.Time
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// 进程内存储，过期的 key 在访问时顺带清理
+type memoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewMemoryStore() Store {
+	return &memoryStore{
+		counters:  make(map[string]*counter),
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *memoryStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.maybeSweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || now.After(c.expireAt) {
+		c = &counter{expireAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.value++
+	return c.value, nil
+}
+
+func (s *memoryStore) Lock(ctx context.Context, key string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.counters[key] = &counter{value: 1, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *memoryStore) LockedFor(ctx context.Context, key string) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.counters[key]
+	if !ok {
+		return 0, nil
+	}
+	left := time.Until(c.expireAt)
+	if left <= 0 {
+		delete(s.counters, key)
+		return 0, nil
+	}
+	return left, nil
+}
+
+func (s *memoryStore) Del(ctx context.Context, keys ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range keys {
+		delete(s.counters, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) Allow(ctx context.Context, key string, r float64, burst int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.maybeSweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{limiter: rate.NewLimiter(rate.Limit(r), burst)}
+		s.buckets[key] = b
+	}
+	b.lastSeen = now
+	return b.limiter.AllowN(now, 1), nil
+}
+
+// 每分钟最多清理一次，令牌桶在长时间没有访问后已经回满，直接丢掉即可
+func (s *memoryStore) maybeSweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, c := range s.counters {
+		if now.After(c.expireAt) {
+			delete(s.counters, key)
+		}
+	}
+	for key, b := range s.buckets {
+		refill := time.Duration(float64(b.limiter.Burst()) / float64(b.limiter.Limit()) * float64(time.Second))
+		if now.Sub(b.lastSeen) > refill {
+			delete(s.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,279 @@
+package report
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/xuri/excelize/v2"
+)
+
+type Format string
+
+const (
+	CSV  Format = "csv"
+	XLSX Format = "xlsx"
+)
+
+// UTF-8 BOM，Excel 打开没有 BOM 的 UTF-8 CSV 时中文会乱码，csvreader 读取时会跳过
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+// excel 工作表名最长 31 个字符
+const maxSheetName = 31
+
+type Column struct {
+	Title string // 中文列名
+	Unit  string // 单位, 为空时不显示
+}
+
+// Header 列名带单位, 如 "压力(MPa)"
+func (c Column) Header() string {
+	if c.Unit == "" {
+		return c.Title
+	}
+	return fmt.Sprintf("%s(%s)", c.Title, c.Unit)
+}
+
+// Sheet 一个工作表，工况对比时每个工况一个工作表
+type Sheet struct {
+	Name    string
+	Columns []Column
+	Rows    [][]any // 单元格支持 string、数值类型
+}
+
+type Report struct {
+	Name   string // 文件名, 不含扩展名
+	Format Format
+	Sheets []Sheet
+}
+
+// Ext 文件扩展名，CSV 多个工作表时打成 zip
+func (r *Report) Ext() string {
+	if r.Format == CSV && len(r.Sheets) > 1 {
+		return "zip"
+	}
+	return string(r.Format)
+}
+
+func (r *Report) Filename() string {
+	return r.Name + "." + r.Ext()
+}
+
+func (r *Report) ContentType() string {
+	switch {
+	case r.Format == XLSX:
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	case len(r.Sheets) > 1:
+		return "application/zip"
+	default:
+		return "text/csv; charset=utf-8"
+	}
+}
+
+func (r *Report) Write(w io.Writer) error {
+	if len(r.Sheets) == 0 {
+		return fmt.Errorf("report %s has no sheet", r.Name)
+	}
+
+	switch r.Format {
+	case CSV:
+		if len(r.Sheets) == 1 {
+			return writeCSV(w, r.Sheets[0])
+		}
+		return r.writeCSVZip(w)
+	case XLSX:
+		return r.writeXLSX(w)
+	default:
+		return fmt.Errorf("unsupported report format %q", r.Format)
+	}
+}
+
+func (r *Report) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := r.Write(buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCSV(w io.Writer, sheet Sheet) error {
+	if _, err := w.Write(utf8BOM); err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(w)
+	header := make([]string, len(sheet.Columns))
+	for i, c := range sheet.Columns {
+		header[i] = c.Header()
+	}
+	if err := csvWriter.Write(header); err != nil {
+		return err
+	}
+
+	record := make([]string, len(sheet.Columns))
+	for _, row := range sheet.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatCell(row[i])
+			}
+		}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// 每个工作表一个 csv 文件
+func (r *Report) writeCSVZip(w io.Writer) error {
+	zipWriter := zip.NewWriter(w)
+	for i, sheet := range r.Sheets {
+		f, err := zipWriter.CreateHeader(&zip.FileHeader{
+			Name:   fmt.Sprintf("%02d-%s.csv", i+1, sheet.Name),
+			Method: zip.Deflate,
+			// 文件名是 UTF-8，设置标记位避免 Windows 解压后中文乱码
+			Flags: 0x800,
+		})
+		if err != nil {
+			return err
+		}
+		if err = writeCSV(f, sheet); err != nil {
+			return err
+		}
+	}
+	return zipWriter.Close()
+}
+
+func (r *Report) writeXLSX(w io.Writer) error {
+	f := excelize.NewFile()
+	defer f.Close()
+
+	headerStyle, err := f.NewStyle(&excelize.Style{
+		Font:      &excelize.Font{Bold: true},
+		Alignment: &excelize.Alignment{Horizontal: "center", Vertical: "center"},
+		Fill:      excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"DDEBF7"}},
+	})
+	if err != nil {
+		return err
+	}
+
+	used := make(map[string]int)
+	for i, sheet := range r.Sheets {
+		name := sheetName(sheet.Name, i, used)
+		if i == 0 {
+			// 新建的文件自带 Sheet1，直接改名
+			if err = f.SetSheetName(f.GetSheetName(0), name); err != nil {
+				return err
+			}
+		} else if _, err = f.NewSheet(name); err != nil {
+			return err
+		}
+
+		if err = writeSheet(f, name, sheet, headerStyle); err != nil {
+			return err
+		}
+	}
+
+	f.SetActiveSheet(0)
+	return f.Write(w)
+}
+
+func writeSheet(f *excelize.File, name string, sheet Sheet, headerStyle int) error {
+	// 流式写入，数据量大时不会把所有单元格都保存在内存
+	sw, err := f.NewStreamWriter(name)
+	if err != nil {
+		return err
+	}
+
+	for i, c := range sheet.Columns {
+		// 按表头宽度估算列宽，中文按两个字符算
+		width := float64(displayWidth(c.Header())) + 2
+		if err = sw.SetColWidth(i+1, i+1, max(width, 10)); err != nil {
+			return err
+		}
+	}
+	if err = sw.SetPanes(&excelize.Panes{Freeze: true, YSplit: 1, TopLeftCell: "A2", ActivePane: "bottomLeft"}); err != nil {
+		return err
+	}
+
+	header := make([]any, len(sheet.Columns))
+	for i, c := range sheet.Columns {
+		header[i] = excelize.Cell{StyleID: headerStyle, Value: c.Header()}
+	}
+	if err = sw.SetRow("A1", header); err != nil {
+		return err
+	}
+
+	for i, row := range sheet.Rows {
+		cell, err := excelize.CoordinatesToCellName(1, i+2)
+		if err != nil {
+			return err
+		}
+		if err = sw.SetRow(cell, row); err != nil {
+			return err
+		}
+	}
+	return sw.Flush()
+}
+
+// 工作表名不能重复、不能超过 31 个字符、不能包含 : \ / ? * [ ]
+func sheetName(name string, index int, used map[string]int) string {
+	runes := []rune(name)
+	for i, r := range runes {
+		switch r {
+		case ':', '\\', '/', '?', '*', '[', ']':
+			runes[i] = '_'
+		}
+	}
+	if len(runes) == 0 {
+		runes = []rune(fmt.Sprintf("Sheet%d", index+1))
+	}
+	if len(runes) > maxSheetName {
+		runes = runes[:maxSheetName]
+	}
+
+	name = string(runes)
+	used[name]++
+	if n := used[name]; n > 1 {
+		suffix := fmt.Sprintf("(%d)", n)
+		if len(runes)+len(suffix) > maxSheetName {
+			runes = runes[:maxSheetName-len(suffix)]
+		}
+		name = string(runes) + suffix
+		used[name]++
+	}
+	return name
+}
+
+func displayWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r > 0x7F {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+func formatCell(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return val
+	case float64:
+		return strconv.FormatFloat(val, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(val), 'f', -1, 32)
+	default:
+		return fmt.Sprint(val)
+	}
+}
@@ -0,0 +1,76 @@
+package report
+
+import (
+	"bytes"
+	"testing"
+
+	"cayoyibackend/internal/helper/csvreader"
+
+	"github.com/xuri/excelize/v2"
+)
+
+func testReport(format Format, sheets int) *Report {
+	r := &Report{Name: "流体计算结果", Format: format}
+	for i := 0; i < sheets; i++ {
+		r.Sheets = append(r.Sheets, Sheet{
+			Name:    "工况",
+			Columns: []Column{{Title: "时间"}, {Title: "压力", Unit: "MPa"}},
+			Rows:    [][]any{{"2025-01-01 00:00", 1.25}, {"2025-01-01 01:00", 1.5}},
+		})
+	}
+	return r
+}
+
+func TestWriteCSV(t *testing.T) {
+	body, err := testReport(CSV, 1).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(body, utf8BOM) {
+		t.Fatal("csv should start with utf-8 BOM")
+	}
+
+	header, rows, err := csvreader.ReadTable(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != "时间" || header[1] != "压力(MPa)" {
+		t.Fatalf("unexpected header %v", header)
+	}
+	if len(rows) != 2 || rows[1][1] != "1.5" {
+		t.Fatalf("unexpected rows %v", rows)
+	}
+}
+
+func TestWriteXLSX(t *testing.T) {
+	body, err := testReport(XLSX, 2).Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := excelize.OpenReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 重名的工作表自动加序号
+	sheets := f.GetSheetList()
+	if len(sheets) != 2 || sheets[0] != "工况" || sheets[1] != "工况(2)" {
+		t.Fatalf("unexpected sheets %v", sheets)
+	}
+	v, err := f.GetCellValue(sheets[1], "B3")
+	if err != nil || v != "1.5" {
+		t.Fatalf("unexpected cell value %q, %v", v, err)
+	}
+}
+
+func TestCSVZip(t *testing.T) {
+	r := testReport(CSV, 2)
+	if r.Ext() != "zip" || r.ContentType() != "application/zip" {
+		t.Fatalf("multi-sheet csv should be zipped, got %s %s", r.Ext(), r.ContentType())
+	}
+	if _, err := r.Bytes(); err != nil {
+		t.Fatal(err)
+	}
+}
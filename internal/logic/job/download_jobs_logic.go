@@ -11,6 +11,7 @@ import (
 	"io"
 	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 )
 
@@ -30,7 +31,7 @@ func NewDownloadJobsLogic(ctx context.Context, svcCtx *svc.ServiceContext) *Down
 }
 
 func (l *DownloadJobsLogic) DownloadJobs(req *types.DownloadJobsReq) (resp []byte, err error) {
-	baseDir := "./work" // 可改为配置路径
+	store := l.svcCtx.Storage
 
 	// 将 jobIds 转为 map，方便匹配
 	targetSet := make(map[string]bool)
@@ -40,22 +41,23 @@ func (l *DownloadJobsLogic) DownloadJobs(req *types.DownloadJobsReq) (resp []byt
 
 	// 找出目标路径（保留分类名）
 	var targets []struct {
-		absPath string
+		path    string
 		zipRoot string
 	}
 
-	err = filepath.Walk(baseDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil || !info.IsDir() {
+	err = fs.WalkDir(store, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
 			return nil
 		}
-		name := filepath.Base(path)
-		parent := filepath.Base(filepath.Dir(path))
+		name := path.Base(p)
+		parent := path.Base(path.Dir(p))
 		if targetSet[name] {
-			zipPath := filepath.Join(parent, name)
+			zipPath := path.Join(parent, name)
 			targets = append(targets, struct {
-				absPath string
+				path    string
 				zipRoot string
-			}{absPath: path, zipRoot: zipPath})
+			}{path: p, zipRoot: zipPath})
+			return fs.SkipDir
 		}
 		return nil
 	})
@@ -72,8 +74,11 @@ func (l *DownloadJobsLogic) DownloadJobs(req *types.DownloadJobsReq) (resp []byt
 	zipWriter := zip.NewWriter(buf)
 
 	for _, t := range targets {
-		fsys := os.DirFS(t.absPath)
-		err := addFsToZip(fsys, zipWriter, t.zipRoot)
+		fsys, err := fs.Sub(store, t.path)
+		if err != nil {
+			return nil, err
+		}
+		err = addFsToZip(fsys, zipWriter, t.zipRoot)
 		//err := addDirToZip(zipWriter, t.absPath, t.zipRoot)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,172 @@
+package report
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"cayoyibackend/internal/helper/csvreader"
+	"cayoyibackend/internal/helper/report"
+	"cayoyibackend/internal/svc"
+	"cayoyibackend/internal/types"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+type ExportReportLogic struct {
+	logx.Logger
+	ctx    context.Context
+	svcCtx *svc.ServiceContext
+}
+
+// 导出作业结果报表
+func NewExportReportLogic(ctx context.Context, svcCtx *svc.ServiceContext) *ExportReportLogic {
+	return &ExportReportLogic{
+		Logger: logx.WithContext(ctx),
+		ctx:    ctx,
+		svcCtx: svcCtx,
+	}
+}
+
+// 作业目录下的每个 CSV 结果文件导出为一个工作表，多个作业时按作业号区分，用于工况对比
+func (l *ExportReportLogic) ExportReport(req *types.ExportReportReq) (resp *report.Report, err error) {
+	if len(req.JobNumbers) == 0 {
+		return nil, fmt.Errorf("请选择要导出的作业")
+	}
+
+	jobDirs, err := l.findJobDirs(req.JobNumbers)
+	if err != nil {
+		return nil, err
+	}
+
+	resp = &report.Report{
+		Name:   reportName(req.JobNumbers),
+		Format: report.Format(req.Format),
+	}
+	for _, jobNumber := range req.JobNumbers {
+		dir, ok := jobDirs[jobNumber]
+		if !ok {
+			return nil, fmt.Errorf("未找到作业 %s", jobNumber)
+		}
+		files, err := l.findResultFiles(dir)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("作业 %s 没有 CSV 结果文件", jobNumber)
+		}
+		for _, file := range files {
+			sheet, err := l.readSheet(path.Join(dir, file))
+			if err != nil {
+				return nil, fmt.Errorf("作业 %s 读取 %s 失败: %w", jobNumber, file, err)
+			}
+			sheet.Name = strings.TrimSuffix(path.Base(file), path.Ext(file))
+			if len(req.JobNumbers) > 1 {
+				sheet.Name = jobNumber + "-" + sheet.Name
+			}
+			resp.Sheets = append(resp.Sheets, sheet)
+		}
+	}
+
+	return resp, nil
+}
+
+// 作业目录位于 <分类>/<作业号>，和作业下载保持一致
+func (l *ExportReportLogic) findJobDirs(jobNumbers []string) (map[string]string, error) {
+	dirs := make(map[string]string, len(jobNumbers))
+	err := fs.WalkDir(l.svcCtx.Storage, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		name := path.Base(p)
+		if _, found := dirs[name]; !found && slices.Contains(jobNumbers, name) {
+			dirs[name] = p
+			return fs.SkipDir
+		}
+		return nil
+	})
+	return dirs, err
+}
+
+// 作业目录下所有的 .csv 文件，返回相对作业目录的路径
+func (l *ExportReportLogic) findResultFiles(dir string) ([]string, error) {
+	fsys, err := fs.Sub(l.svcCtx.Storage, dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && strings.EqualFold(path.Ext(p), ".csv") {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// 结果文件名中的物理量代号及单位，如汛期水文模型的流量结果 asin_Q_10days.csv，每列是一个站点
+var resultUnits = map[string]string{
+	"Q": "m³/s", // 流量
+	"Z": "m",    // 水位
+	"V": "m/s",  // 流速
+}
+
+// resultUnit 按文件名中以 _ 分隔的物理量代号取单位，没有代号时为空
+func resultUnit(name string) string {
+	stem := strings.TrimSuffix(path.Base(name), path.Ext(name))
+	for _, code := range strings.Split(stem, "_") {
+		if unit, ok := resultUnits[code]; ok {
+			return unit
+		}
+	}
+	return ""
+}
+
+// 结果文件的格式见 csvreader.ReadCsv，第一行为列名，其余为数据
+func (l *ExportReportLogic) readSheet(name string) (report.Sheet, error) {
+	f, err := l.svcCtx.Storage.Open(name)
+	if err != nil {
+		return report.Sheet{}, err
+	}
+	defer f.Close()
+
+	header, rows, err := csvreader.ReadTable(f)
+	if err != nil {
+		return report.Sheet{}, err
+	}
+
+	var sheet report.Sheet
+	unit := resultUnit(name)
+	for _, h := range header {
+		sheet.Columns = append(sheet.Columns, report.Column{Title: h, Unit: unit})
+	}
+	for _, row := range rows {
+		cells := make([]any, len(row))
+		for i, cell := range row {
+			// 数值按数字写入，excel 中可以直接计算
+			if v, err := strconv.ParseFloat(cell, 64); err == nil {
+				cells[i] = v
+			} else {
+				cells[i] = cell
+			}
+		}
+		sheet.Rows = append(sheet.Rows, cells)
+	}
+	return sheet, nil
+}
+
+func reportName(jobNumbers []string) string {
+	jobs := jobNumbers[0]
+	if len(jobNumbers) > 1 {
+		jobs = fmt.Sprintf("%s等%d个工况对比", jobNumbers[0], len(jobNumbers))
+	}
+	return fmt.Sprintf("作业结果-%s-%s", jobs, time.Now().Format("20060102-150405"))
+}
@@ -0,0 +1,63 @@
+package report
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cayoyibackend/internal/helper/report"
+	"cayoyibackend/internal/storage"
+	"cayoyibackend/internal/svc"
+	"cayoyibackend/internal/types"
+)
+
+func TestExportReportUnits(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"流体/J0001/asin_Q_10days.csv": "九仙汤河,围里水\n34.85,24.93\n",
+		"流体/J0001/asin_Z_10days.csv": "罗湾水库\n210.5\n",
+		"流体/J0001/summary.csv":       "工况,说明\n1,汛期\n",
+	}
+	for name, data := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	l := NewExportReportLogic(context.Background(), &svc.ServiceContext{Storage: storage.NewLocalStorage(dir)})
+	resp, err := l.ExportReport(&types.ExportReportReq{JobNumbers: []string{"J0001"}, Format: string(report.CSV)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string][]string{}
+	for _, sheet := range resp.Sheets {
+		for _, c := range sheet.Columns {
+			headers[sheet.Name] = append(headers[sheet.Name], c.Header())
+		}
+	}
+	expected := map[string][]string{
+		"asin_Q_10days": {"九仙汤河(m³/s)", "围里水(m³/s)"},
+		"asin_Z_10days": {"罗湾水库(m)"},
+		"summary":       {"工况", "说明"},
+	}
+	for name, want := range expected {
+		got := headers[name]
+		if len(got) != len(want) {
+			t.Fatalf("sheet %s headers %v, want %v", name, got, want)
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Fatalf("sheet %s headers %v, want %v", name, got, want)
+			}
+		}
+	}
+	if v, ok := resp.Sheets[0].Rows[0][0].(float64); !ok || v != 34.85 {
+		t.Fatalf("first cell %v", resp.Sheets[0].Rows[0][0])
+	}
+}
@@ -3,6 +3,7 @@ package user
 import (
 	"context"
 
+	"cayoyibackend/internal/helper/limiter"
 	"cayoyibackend/internal/svc"
 	"cayoyibackend/internal/types"
 
@@ -25,6 +26,18 @@ func NewLoginLogic(ctx context.Context, svcCtx *svc.ServiceContext) *LoginLogic
 }
 
 func (l *LoginLogic) Login(req *types.LoginReq) (resp *types.LoginResp, err error) {
+	ip := limiter.ClientIPFromContext(l.ctx)
+	if err = l.svcCtx.LoginGuard.Check(l.ctx, req.Accout, ip); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			l.svcCtx.LoginGuard.Fail(l.ctx, req.Accout, ip)
+		} else {
+			l.svcCtx.LoginGuard.Succeed(l.ctx, req.Accout)
+		}
+	}()
+
 	// todo: add your logic here and delete this line
 
 	return
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+
+	"cayoyibackend/internal/config"
+	"cayoyibackend/internal/helper/limiter"
+
+	"github.com/zeromicro/go-zero/core/logx"
+)
+
+const downloadLimitPrefix = "rate:download:"
+
+// 作业下载、导出需要打包大量文件，按 IP 做令牌桶限流
+type DownloadLimitMiddleware struct {
+	conf    config.RateLimit
+	store   limiter.Store
+	proxies limiter.TrustedProxies
+}
+
+func NewDownloadLimitMiddleware(conf config.RateLimit, store limiter.Store, proxies limiter.TrustedProxies) *DownloadLimitMiddleware {
+	return &DownloadLimitMiddleware{conf: conf, store: store, proxies: proxies}
+}
+
+func (m *DownloadLimitMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.conf.Rate <= 0 || m.conf.Burst <= 0 {
+			next(w, r)
+			return
+		}
+
+		ip := m.proxies.ClientIP(r)
+		allowed, err := m.store.Allow(r.Context(), downloadLimitPrefix+ip, m.conf.Rate, m.conf.Burst)
+		if err != nil {
+			// 存储不可用时放行
+			logx.WithContext(r.Context()).Errorf("download limit %s err: %v", ip, err)
+			allowed = true
+		}
+		if !allowed {
+			// 补充一个令牌需要的时间
+			retryAfter := max(1, int(math.Ceil(1/m.conf.Rate)))
+			limiter.WriteTooManyRequests(w, r, retryAfter, "下载过于频繁，请稍后重试")
+			return
+		}
+
+		next(w, r)
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"cayoyibackend/internal/helper/limiter"
+)
+
+// 登录防爆破，IP 被锁定时直接拒绝，账号的锁定在登录逻辑中检查
+type LoginLimitMiddleware struct {
+	guard   *limiter.LoginGuard
+	proxies limiter.TrustedProxies
+}
+
+func NewLoginLimitMiddleware(guard *limiter.LoginGuard, proxies limiter.TrustedProxies) *LoginLimitMiddleware {
+	return &LoginLimitMiddleware{guard: guard, proxies: proxies}
+}
+
+func (m *LoginLimitMiddleware) Handle(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := m.proxies.ClientIP(r)
+		if err := m.guard.CheckIP(r.Context(), ip); err != nil {
+			var locked *limiter.LockedError
+			if errors.As(err, &locked) {
+				limiter.WriteTooManyRequests(w, r, locked.RetryAfterSeconds(), locked.Error())
+				return
+			}
+		}
+
+		next(w, r.WithContext(limiter.WithClientIP(r.Context(), ip)))
+	}
+}
@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/filer_pb"
+	"cayoyibackend/weedfilesys/util"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// weedfilesys filer 存储，目录和元数据走 filer gRPC，文件内容走 filer HTTP
+type filerStorage struct {
+	address        pb.ServerAddress // filer 地址 host:port 或 host:port.grpcPort, gRPC 端口默认 port+10000
+	root           string           // filer 上的根目录
+	grpcDialOption grpc.DialOption
+	client         *http.Client
+}
+
+func NewFilerStorage(address, dir string) Storage {
+	return &filerStorage{
+		address:        pb.ServerAddress(address),
+		root:           path.Join("/", dir),
+		grpcDialOption: grpc.WithTransportCredentials(insecure.NewCredentials()),
+		client:         &http.Client{},
+	}
+}
+
+// 实现 filer_pb.FilerClient
+
+func (s *filerStorage) WithFilerClient(streamingMode bool, fn func(client filer_pb.WeedfilesysFilerClient) error) error {
+	return pb.WithFilerClient(streamingMode, 0, s.address, s.grpcDialOption, fn)
+}
+
+func (s *filerStorage) AdjustedUrl(location *filer_pb.Location) string {
+	return location.Url
+}
+
+func (s *filerStorage) GetDataCenter() string {
+	return ""
+}
+
+func (s *filerStorage) fullPath(name string) util.FullPath {
+	return util.FullPath(path.Join(s.root, name))
+}
+
+func (s *filerStorage) fileUrl(name string) string {
+	u := url.URL{Scheme: "http", Host: s.address.ToHttpAddress(), Path: string(s.fullPath(name))}
+	return u.String()
+}
+
+func (s *filerStorage) lookup(op, name string) (*filer_pb.Entry, error) {
+	if err := checkName(op, name); err != nil {
+		return nil, err
+	}
+
+	fullPath := s.fullPath(name)
+	if fullPath == "/" {
+		return &filer_pb.Entry{Name: "/", IsDirectory: true}, nil
+	}
+	entry, err := filer_pb.GetEntry(context.Background(), s, fullPath)
+	if errors.Is(err, filer_pb.ErrNotFound) || (err == nil && entry == nil) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	return entry, nil
+}
+
+func (s *filerStorage) Open(name string) (fs.File, error) {
+	entry, err := s.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDirectory {
+		return &filerDir{storage: s, name: name, entry: entry}, nil
+	}
+	return &filerFile{storage: s, name: name, entry: entry}, nil
+}
+
+func (s *filerStorage) Stat(name string) (fs.FileInfo, error) {
+	entry, err := s.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return entryInfo{entry}, nil
+}
+
+func (s *filerStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	if err := checkName("readdir", name); err != nil {
+		return nil, err
+	}
+
+	var entries []fs.DirEntry
+	err := filer_pb.ReadDirAllEntries(context.Background(), s, s.fullPath(name), "", func(entry *filer_pb.Entry, isLast bool) error {
+		entries = append(entries, fs.FileInfoToDirEntry(entryInfo{entry}))
+		return nil
+	})
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	// filer 按名字顺序返回，和 fs.ReadDir 的约定一致
+	return entries, nil
+}
+
+// Put filer 的 PUT 接口会自动创建父目录
+func (s *filerStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName("put", name); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.fileUrl(name), r)
+	if err != nil {
+		return err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("put %s: %s %s", name, resp.Status, body)
+	}
+	return nil
+}
+
+func (s *filerStorage) Remove(ctx context.Context, name string) error {
+	if err := checkName("remove", name); err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+
+	dir, entryName := s.fullPath(name).DirAndName()
+	return filer_pb.Remove(ctx, s, dir, entryName, true, true, true, false, nil)
+}
+
+func (s *filerStorage) Ping(ctx context.Context) error {
+	return s.WithFilerClient(false, func(client filer_pb.WeedfilesysFilerClient) error {
+		_, err := client.Ping(ctx, &filer_pb.PingRequest{})
+		return err
+	})
+}
+
+// filer 上的文件，第一次 Read 时才发起下载
+type filerFile struct {
+	storage *filerStorage
+	name    string
+	entry   *filer_pb.Entry
+	body    io.ReadCloser
+}
+
+func (f *filerFile) Stat() (fs.FileInfo, error) {
+	return entryInfo{f.entry}, nil
+}
+
+func (f *filerFile) Read(p []byte) (int, error) {
+	if f.body == nil {
+		resp, err := f.storage.client.Get(f.storage.fileUrl(f.name))
+		if err != nil {
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: err}
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return 0, &fs.PathError{Op: "read", Path: f.name, Err: fmt.Errorf("filer %s", resp.Status)}
+		}
+		f.body = resp.Body
+	}
+	return f.body.Read(p)
+}
+
+func (f *filerFile) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
+
+// filer 上的目录
+type filerDir struct {
+	storage *filerStorage
+	name    string
+	entry   *filer_pb.Entry
+	entries []fs.DirEntry
+	offset  int
+	loaded  bool
+}
+
+func (d *filerDir) Stat() (fs.FileInfo, error) {
+	return entryInfo{d.entry}, nil
+}
+
+func (d *filerDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *filerDir) Close() error {
+	return nil
+}
+
+func (d *filerDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.loaded {
+		entries, err := d.storage.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.loaded = entries, true
+	}
+
+	rest := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(rest))
+	d.offset += n
+	return rest[:n], nil
+}
+
+// filer 目录项转 fs.FileInfo
+type entryInfo struct {
+	entry *filer_pb.Entry
+}
+
+func (i entryInfo) Name() string {
+	return i.entry.Name
+}
+
+func (i entryInfo) Size() int64 {
+	return int64(i.entry.GetAttributes().GetFileSize())
+}
+
+func (i entryInfo) Mode() fs.FileMode {
+	mode := fs.FileMode(i.entry.GetAttributes().GetFileMode()) & fs.ModePerm
+	if i.entry.IsDirectory {
+		mode |= fs.ModeDir
+	}
+	return mode
+}
+
+func (i entryInfo) ModTime() time.Time {
+	return time.Unix(i.entry.GetAttributes().GetMtime(), 0)
+}
+
+func (i entryInfo) IsDir() bool {
+	return i.entry.IsDirectory
+}
+
+func (i entryInfo) Sys() any {
+	return i.entry
+}
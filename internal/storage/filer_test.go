@@ -0,0 +1,295 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"cayoyibackend/weedfilesys/pb/filer_pb"
+
+	"google.golang.org/grpc"
+)
+
+// 进程内的假 filer, 目录和元数据走 gRPC, 文件内容走 HTTP, 和真实 filer 一致
+type fakeFiler struct {
+	filer_pb.UnimplementedWeedfilesysFilerServer
+
+	mu      sync.Mutex
+	entries map[string]*filer_pb.Entry // 全路径到目录项
+	content map[string][]byte
+}
+
+// 启动假 filer, 返回 host:port.grpcPort 形式的地址
+func startFakeFiler(t *testing.T) (*fakeFiler, string) {
+	f := &fakeFiler{
+		entries: map[string]*filer_pb.Entry{},
+		content: map[string][]byte{},
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	filer_pb.RegisterWeedfilesysFilerServer(grpcServer, f)
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(f.serveHTTP))
+	t.Cleanup(httpServer.Close)
+
+	return f, fmt.Sprintf("%s.%d", httpServer.Listener.Addr(), listener.Addr().(*net.TCPAddr).Port)
+}
+
+func (f *fakeFiler) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fullPath := path.Clean(r.URL.Path)
+	switch r.Method {
+	case http.MethodPut:
+		if entry, ok := f.entries[fullPath]; ok && entry.IsDirectory {
+			http.Error(w, "is a directory", http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		f.mkdirAll(path.Dir(fullPath))
+		f.entries[fullPath] = &filer_pb.Entry{
+			Name:       path.Base(fullPath),
+			Attributes: &filer_pb.FuseAttributes{FileSize: uint64(len(data)), FileMode: 0644, Mtime: time.Now().Unix()},
+		}
+		f.content[fullPath] = data
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet:
+		data, ok := f.content[fullPath]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeFiler) mkdirAll(dir string) {
+	for ; dir != "/"; dir = path.Dir(dir) {
+		if _, ok := f.entries[dir]; ok {
+			return
+		}
+		f.entries[dir] = &filer_pb.Entry{
+			Name:        path.Base(dir),
+			IsDirectory: true,
+			Attributes:  &filer_pb.FuseAttributes{FileMode: 0755, Mtime: time.Now().Unix()},
+		}
+	}
+}
+
+// 目录下的直接子项, 按名字排序
+func (f *fakeFiler) children(dir string) []string {
+	var names []string
+	for p := range f.entries {
+		if p != "/" && path.Dir(p) == dir {
+			names = append(names, path.Base(p))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *fakeFiler) LookupDirectoryEntry(ctx context.Context, req *filer_pb.LookupDirectoryEntryRequest) (*filer_pb.LookupDirectoryEntryResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, ok := f.entries[path.Join(req.Directory, req.Name)]
+	if !ok {
+		return nil, filer_pb.ErrNotFound
+	}
+	return &filer_pb.LookupDirectoryEntryResponse{Entry: entry}, nil
+}
+
+func (f *fakeFiler) ListEntries(req *filer_pb.ListEntriesRequest, stream grpc.ServerStreamingServer[filer_pb.ListEntriesResponse]) error {
+	f.mu.Lock()
+	var entries []*filer_pb.Entry
+	for _, name := range f.children(req.Directory) {
+		if !strings.HasPrefix(name, req.Prefix) || name < req.StartFromFileName ||
+			name == req.StartFromFileName && !req.InclusiveStartFrom {
+			continue
+		}
+		if req.Limit > 0 && uint32(len(entries)) >= req.Limit {
+			break
+		}
+		entries = append(entries, f.entries[path.Join(req.Directory, name)])
+	}
+	f.mu.Unlock()
+
+	for _, entry := range entries {
+		if err := stream.Send(&filer_pb.ListEntriesResponse{Entry: entry}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *fakeFiler) DeleteEntry(ctx context.Context, req *filer_pb.DeleteEntryRequest) (*filer_pb.DeleteEntryResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fullPath := path.Join(req.Directory, req.Name)
+	if _, ok := f.entries[fullPath]; !ok {
+		return &filer_pb.DeleteEntryResponse{Error: filer_pb.ErrNotFound.Error()}, nil
+	}
+	if len(f.children(fullPath)) > 0 && !req.IsRecursive {
+		return &filer_pb.DeleteEntryResponse{Error: fmt.Sprintf("%s is not empty", fullPath)}, nil
+	}
+	for p := range f.entries {
+		if p == fullPath || strings.HasPrefix(p, fullPath+"/") {
+			delete(f.entries, p)
+			delete(f.content, p)
+		}
+	}
+	return &filer_pb.DeleteEntryResponse{}, nil
+}
+
+func (f *fakeFiler) Ping(ctx context.Context, req *filer_pb.PingRequest) (*filer_pb.PingResponse, error) {
+	return &filer_pb.PingResponse{}, nil
+}
+
+func TestFilerStorage(t *testing.T) {
+	ctx := context.Background()
+	filer, address := startFakeFiler(t)
+	s := NewFilerStorage(address, "jobs")
+
+	if err := s.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"流体/J0001/result.csv": "a,b\n1,2\n",
+		"流体/J0001/log.txt":    "done",
+		"结构/J0002/result.csv": "c\n3\n",
+	}
+	for name, data := range files {
+		if err := s.Put(ctx, name, strings.NewReader(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := filer.content["/jobs/流体/J0001/result.csv"]; !ok {
+		t.Fatal("put should write under the storage root")
+	}
+	if err := fstest.TestFS(s, "流体/J0001/result.csv", "流体/J0001/log.txt", "结构/J0002/result.csv"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := fs.ReadFile(s, "流体/J0001/result.csv")
+	if err != nil || string(data) != files["流体/J0001/result.csv"] {
+		t.Fatalf("read file %q: %v", data, err)
+	}
+
+	// filer 返回错误状态时 Put 失败
+	if err := s.Put(ctx, "流体/J0001", strings.NewReader("x")); err == nil {
+		t.Fatal("put onto a directory should fail")
+	}
+	if err := s.Put(ctx, "../escape", strings.NewReader("x")); err == nil {
+		t.Fatal("put outside of storage dir should fail")
+	}
+
+	if err := s.Remove(ctx, "流体/J0001"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("流体/J0001/result.csv"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+	if _, ok := filer.content["/jobs/结构/J0002/result.csv"]; !ok {
+		t.Fatal("remove should only delete the given directory")
+	}
+	// 已经不存在的也算删除成功, 根目录不允许删除
+	if err := s.Remove(ctx, "流体/J0001"); err != nil {
+		t.Fatalf("remove missing: %v", err)
+	}
+	if err := s.Remove(ctx, "."); !errors.Is(err, fs.ErrPermission) {
+		t.Fatalf("remove root: %v", err)
+	}
+}
+
+func TestFilerEntryInfo(t *testing.T) {
+	mtime := time.Date(2025, 7, 24, 16, 12, 45, 0, time.Local)
+	file := entryInfo{&filer_pb.Entry{
+		Name:       "result.csv",
+		Attributes: &filer_pb.FuseAttributes{FileSize: 12, FileMode: 0644, Mtime: mtime.Unix()},
+	}}
+	if file.Name() != "result.csv" || file.Size() != 12 || file.Mode() != 0644 || !file.ModTime().Equal(mtime) || file.IsDir() {
+		t.Fatalf("file info %v %d %v %v", file.Name(), file.Size(), file.Mode(), file.ModTime())
+	}
+
+	// filer 的 FileMode 带有 os.ModeDir 等高位, 只保留权限位
+	dir := entryInfo{&filer_pb.Entry{
+		Name:        "J0001",
+		IsDirectory: true,
+		Attributes:  &filer_pb.FuseAttributes{FileMode: uint32(fs.ModeDir | 0755)},
+	}}
+	if !dir.IsDir() || dir.Mode() != fs.ModeDir|0755 || dir.Size() != 0 {
+		t.Fatalf("dir info %v %d", dir.Mode(), dir.Size())
+	}
+
+	// 没有属性的目录项不会 panic
+	empty := entryInfo{&filer_pb.Entry{Name: "x"}}
+	if empty.Size() != 0 || empty.Mode() != 0 {
+		t.Fatalf("empty info %v %d", empty.Mode(), empty.Size())
+	}
+}
+
+func TestFilerDirReadDir(t *testing.T) {
+	ctx := context.Background()
+	_, address := startFakeFiler(t)
+	s := NewFilerStorage(address, "/")
+	for _, name := range []string{"c.txt", "a.txt", "b.txt"} {
+		if err := s.Put(ctx, "dir/"+name, strings.NewReader(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	f, err := s.Open("dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		t.Fatalf("%T is not a directory", f)
+	}
+	if _, err = f.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read on a directory should fail")
+	}
+
+	// 分批读取按名字排序, 读完后返回 io.EOF
+	var names []string
+	for {
+		entries, err := dir.ReadDir(2)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+	}
+	if strings.Join(names, ",") != "a.txt,b.txt,c.txt" {
+		t.Fatalf("read dir %v", names)
+	}
+	if entries, err := dir.ReadDir(-1); err != nil || len(entries) != 0 {
+		t.Fatalf("read dir after end: %v %v", entries, err)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// 本地磁盘存储
+type localStorage struct {
+	fs.FS
+	dir string
+}
+
+func NewLocalStorage(dir string) Storage {
+	return &localStorage{FS: os.DirFS(dir), dir: dir}
+}
+
+func (s *localStorage) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(s.FS, name)
+}
+
+func (s *localStorage) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(s.FS, name)
+}
+
+func (s *localStorage) Put(ctx context.Context, name string, r io.Reader) error {
+	if err := checkName("put", name); err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// 先写临时文件再改名，读取方不会看到写了一半的文件
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *localStorage) Remove(ctx context.Context, name string) error {
+	if err := checkName("remove", name); err != nil {
+		return err
+	}
+	if name == "." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+	}
+	return os.RemoveAll(filepath.Join(s.dir, filepath.FromSlash(name)))
+}
+
+// Ping 目录存在且可读
+func (s *localStorage) Ping(ctx context.Context) error {
+	f, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err = f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
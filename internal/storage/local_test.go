@@ -0,0 +1,33 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLocalStorage(t *testing.T) {
+	ctx := context.Background()
+	s := NewLocalStorage(t.TempDir())
+
+	if err := s.Put(ctx, "流体/J0001/result.csv", strings.NewReader("a,b\n1,2\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := fstest.TestFS(s, "流体/J0001/result.csv"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Put(ctx, "../escape", strings.NewReader("x")); err == nil {
+		t.Fatal("put outside of storage dir should fail")
+	}
+
+	if err := s.Remove(ctx, "流体/J0001"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Stat("流体/J0001/result.csv"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist, got %v", err)
+	}
+}
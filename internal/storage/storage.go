@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"cayoyibackend/internal/config"
+)
+
+const (
+	TypeLocal = "local"
+	TypeFiler = "filer"
+
+	defaultDir = "./work"
+)
+
+// Storage 作业产物的存储，读取走 fs.FS 接口，业务代码不关心文件在本地还是集群
+// name 统一使用 fs.ValidPath 格式的相对路径，如 "流体/J0001/result.csv"
+type Storage interface {
+	fs.ReadDirFS
+	fs.StatFS
+
+	// Put 写入文件，父目录不存在时自动创建
+	Put(ctx context.Context, name string, r io.Reader) error
+	// Remove 删除文件或目录(递归)
+	Remove(ctx context.Context, name string) error
+	// Ping 检查存储是否可用
+	Ping(ctx context.Context) error
+}
+
+func NewStorage(c config.Storage) (Storage, error) {
+	dir := c.Dir
+	if dir == "" {
+		dir = defaultDir
+	}
+
+	switch c.Type {
+	case "", TypeLocal:
+		return NewLocalStorage(dir), nil
+	case TypeFiler:
+		if c.Filer == "" {
+			return nil, fmt.Errorf("storage type %s requires Filer address", c.Type)
+		}
+		return NewFilerStorage(c.Filer, dir), nil
+	default:
+		return nil, fmt.Errorf("unknown storage type %q", c.Type)
+	}
+}
+
+func MustNewStorage(c config.Storage) Storage {
+	s, err := NewStorage(c)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+func checkName(op, name string) error {
+	if !fs.ValidPath(name) {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return nil
+}
@@ -2,22 +2,94 @@ package svc
 
 import (
 	"cayoyibackend/internal/config"
+	"cayoyibackend/internal/health"
+	"cayoyibackend/internal/helper/limiter"
+	"cayoyibackend/internal/middleware"
+	"cayoyibackend/internal/storage"
+	"context"
 	"sync"
+	"time"
+
+	"github.com/zeromicro/go-zero/core/logx"
+	"github.com/zeromicro/go-zero/core/stores/redis"
+	"github.com/zeromicro/go-zero/core/stores/sqlx"
+	"github.com/zeromicro/go-zero/rest"
 )
 
 type ServiceContext struct {
 	// 配置相关
 	Config config.Config
 
+	// 存储相关
+	Storage storage.Storage
+	DB      sqlx.SqlConn // 未配置时为 nil
+
+	// 健康检查相关
+	Liveness  *health.Checker
+	Readiness *health.Checker
+	Scheduler *health.Heartbeat // 后台作业调度的心跳
+
+	// 限流相关
+	LoginGuard    *limiter.LoginGuard
+	LoginLimit    rest.Middleware
+	DownloadLimit rest.Middleware
+
 	// 应用相关
 	mu   sync.RWMutex
 	Keys map[string]any
 }
 
 func NewServiceContext(c config.Config) *ServiceContext {
-	return &ServiceContext{
-		Config: c,
+	limitStore := limiter.NewMemoryStore()
+	if c.Limit.Redis.Host != "" {
+		limitStore = limiter.NewRedisStore(redis.MustNewRedis(c.Limit.Redis))
+	}
+	loginGuard := limiter.NewLoginGuard(limiter.LoginGuardConf{
+		MaxAccountFailures: c.Limit.Login.MaxAccountFailures,
+		MaxIPFailures:      c.Limit.Login.MaxIPFailures,
+		FailureWindow:      c.Limit.Login.FailureWindow,
+		LockDuration:       c.Limit.Login.LockDuration,
+	}, limitStore)
+	trustedProxies, err := limiter.ParseTrustedProxies(c.Limit.TrustedProxies)
+	logx.Must(err)
+
+	svc := &ServiceContext{
+		Config:        c,
+		Storage:       storage.MustNewStorage(c.Storage),
+		Liveness:      health.NewChecker(),
+		Readiness:     health.NewChecker(),
+		Scheduler:     health.NewHeartbeat(schedulerMaxSilence),
+		LoginGuard:    loginGuard,
+		LoginLimit:    middleware.NewLoginLimitMiddleware(loginGuard, trustedProxies).Handle,
+		DownloadLimit: middleware.NewDownloadLimitMiddleware(c.Limit.Download, limitStore, trustedProxies).Handle,
 	}
+	if c.DB.DataSource != "" {
+		svc.DB = sqlx.NewMysql(c.DB.DataSource)
+	}
+	svc.registerHealthChecks()
+
+	return svc
+}
+
+// 运行中的作业超过该时间既没有完成也没有报告进展，视为卡死
+const schedulerMaxSilence = 2 * time.Minute
+
+// 存活检查只看进程内的调度器，依赖的外部服务放在就绪检查里，避免外部故障导致服务被反复重启
+func (svc *ServiceContext) registerHealthChecks() {
+	svc.Liveness.Register("scheduler", svc.Scheduler.Check)
+
+	svc.Readiness.Register("scheduler", svc.Scheduler.Check)
+	svc.Readiness.Register("storage", svc.Storage.Ping)
+	svc.Readiness.Register("database", func(ctx context.Context) error {
+		if svc.DB == nil {
+			return health.ErrSkipped
+		}
+		db, err := svc.DB.RawDB()
+		if err != nil {
+			return err
+		}
+		return db.PingContext(ctx)
+	})
 }
 
 func (svc *ServiceContext) Get(key string) (value any, exists bool) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"cayoyibackend/internal/config"
+	"cayoyibackend/internal/cron/chain"
 	"cayoyibackend/internal/handler"
 	"cayoyibackend/internal/svc"
 	"flag"
@@ -27,6 +28,11 @@ func main() {
 	ctx := svc.NewServiceContext(c)
 	handler.RegisterHandlers(server, ctx)
 	handler.RegisterSwaggerHandlers(server, ctx)
+	handler.RegisterHealthHandlers(server, ctx)
+
+	// 后台作业调度，/healthz 和 /readyz 通过它的心跳判断调度器是否卡死
+	go chain.CheckJobStatus(ctx)
+
 	fmt.Printf("Starting server at %s:%d...\n", c.Host, c.Port)
 	server.Start()
 }
@@ -0,0 +1,44 @@
+// ec_decode 把 EC 卷离线还原成普通卷：缺少数据分片时先用其余分片重建，然后写出 .dat、.idx 并删除 EC 文件。
+// 运行前需要先停掉卷服务器或卸载该卷的分片，并把至少数据分片个数的分片和 .ecx/.ecj/.vif 放到同一台机器上。
+//
+//	ec_decode -dir=/data -volumeId=3
+//	ec_decode -dir=/data -dir.idx=/index -collection=pics -volumeId=3
+package main
+
+import (
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	dir        = flag.String("dir", ".", "data directory of the ec shard files")
+	dirIdx     = flag.String("dir.idx", "", "directory of the .ecx and .ecj files, defaults to -dir")
+	collection = flag.String("collection", "", "volume collection name")
+	volumeId   = flag.Int("volumeId", -1, "ec volume id to decode")
+)
+
+func main() {
+	flag.Parse()
+	if *volumeId < 0 {
+		fmt.Fprintln(os.Stderr, "-volumeId is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dirIdx == "" {
+		*dirIdx = *dir
+	}
+
+	report, err := storage.DecodeEcVolume(*dir, *dirIdx, *collection, needle.VolumeId(*volumeId))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "decode ec volume %d: %v\n", *volumeId, err)
+		os.Exit(1)
+	}
+	fmt.Printf("%s ec volume %d decoded into a normal volume\n", report.Scheme, *volumeId)
+	fmt.Printf("  .dat size %d\n", report.DatFileSize)
+	if len(report.Rebuilt) > 0 {
+		fmt.Printf("  rebuilt shards %v\n", report.Rebuilt)
+	}
+}
@@ -0,0 +1,50 @@
+// volume_convert 把 v1/v2 格式的卷离线改写成 v3，转换后逐个校验 needle 再替换原文件。
+// 运行前需要先停掉卷服务器或卸载该卷。
+//
+//	volume_convert -dir=/data -volumeId=3
+//	volume_convert -dir=/data -collection=pics -volumeId=3 -keepOriginal
+package main
+
+import (
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	dir          = flag.String("dir", ".", "data directory of the .dat file")
+	dirIdx       = flag.String("dir.idx", "", "directory of the .idx file, defaults to -dir")
+	collection   = flag.String("collection", "", "volume collection name")
+	volumeId     = flag.Int("volumeId", -1, "volume id to convert")
+	keepOriginal = flag.Bool("keepOriginal", false, "keep the original .dat and .idx with a .v<version> suffix")
+)
+
+func main() {
+	flag.Parse()
+	if *volumeId < 0 {
+		fmt.Fprintln(os.Stderr, "-volumeId is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dirIdx == "" {
+		*dirIdx = *dir
+	}
+
+	report, err := storage.ConvertVolumeToVersion3(*dir, *dirIdx, *collection, needle.VolumeId(*volumeId), *keepOriginal)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "convert volume %d: %v\n", *volumeId, err)
+		os.Exit(1)
+	}
+	if !report.Converted {
+		fmt.Printf("volume %d is already version %d\n", *volumeId, report.FromVersion)
+		return
+	}
+	fmt.Printf("volume %d converted from version %d to %d\n", *volumeId, report.FromVersion, report.ToVersion)
+	fmt.Printf("  needles %d, deletions %d\n", report.NeedleCount, report.DeleteCount)
+	fmt.Printf("  .dat size %d -> %d\n", report.DatSizeFrom, report.DatSizeTo)
+	if report.BackupSuffix != "" {
+		fmt.Printf("  original files kept with suffix %s\n", report.BackupSuffix)
+	}
+}
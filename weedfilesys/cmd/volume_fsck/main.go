@@ -0,0 +1,83 @@
+// volume_fsck 离线检查卷的 .dat/.idx 是否一致，可选截断不完整的尾部或按 .dat 重建 .idx。
+// 运行前需要先停掉卷服务器或卸载该卷。
+//
+//	volume_fsck -dir=/data -volumeId=3
+//	volume_fsck -dir=/data -collection=pics -volumeId=3 -truncate -rebuildIndex
+//
+// 损坏在文件中间（.idx 还有记录指向损坏位置之后）时拒绝修复，确认可以丢弃这些 needle 后加 -force。
+package main
+
+import (
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"flag"
+	"fmt"
+	"os"
+)
+
+var (
+	dir          = flag.String("dir", ".", "data directory of the .dat file")
+	dirIdx       = flag.String("dir.idx", "", "directory of the .idx file, defaults to -dir")
+	collection   = flag.String("collection", "", "volume collection name")
+	volumeId     = flag.Int("volumeId", -1, "volume id to check")
+	truncate     = flag.Bool("truncate", false, "truncate torn needle at the end of .dat and partial entry at the end of .idx")
+	rebuildIndex = flag.Bool("rebuildIndex", false, "regenerate .idx from needles in .dat")
+	force        = flag.Bool("force", false, "repair even if indexed needles after a corrupt needle header would be dropped")
+	verbose      = flag.Bool("v", false, "print every issue")
+)
+
+func main() {
+	flag.Parse()
+	if *volumeId < 0 {
+		fmt.Fprintln(os.Stderr, "-volumeId is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *dirIdx == "" {
+		*dirIdx = *dir
+	}
+
+	report, err := storage.FsckVolume(*dir, *dirIdx, *collection, needle.VolumeId(*volumeId), storage.FsckOption{
+		TruncateTornTail: *truncate,
+		RebuildIndex:     *rebuildIndex,
+		Force:            *force,
+	})
+	if err != nil && report != nil && report.MidFileCorruption() {
+		fmt.Fprintf(os.Stderr, "fsck volume %d: %v, rerun with -force to repair anyway\n", *volumeId, err)
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fsck volume %d: %v\n", *volumeId, err)
+		os.Exit(1)
+	}
+
+	fmt.Printf("volume %d version %d\n", *volumeId, report.Version)
+	fmt.Printf("  .dat size %d, needles %d, deletions %d\n", report.DatFileSize, report.NeedleCount, report.TombstoneCount)
+	fmt.Printf("  .idx size %d, entries %d\n", report.IdxFileSize, report.IndexEntries)
+	if report.TornTailOffset >= 0 {
+		fmt.Printf("  torn .dat tail at %d\n", report.TornTailOffset)
+	}
+	if report.UnreadableOffset >= 0 {
+		fmt.Printf("  unreadable .dat from %d\n", report.UnreadableOffset)
+	}
+	if report.MidFileCorruption() {
+		fmt.Printf("  mid-file corruption: %d index entries point beyond the scanned data\n", report.IndexedBeyondScan)
+	}
+	if report.TornIndexBytes > 0 {
+		fmt.Printf("  torn .idx tail of %d bytes\n", report.TornIndexBytes)
+	}
+	fmt.Printf("  orphaned %d, missing %d, corrupt %d\n",
+		report.Count(storage.FsckOrphan), report.Count(storage.FsckMissing), report.Count(storage.FsckCorrupt))
+	if *verbose {
+		for _, issue := range report.Issues {
+			fmt.Printf("  %s\n", issue)
+		}
+	}
+	for _, action := range report.Repaired {
+		fmt.Printf("  repaired: %s\n", action)
+	}
+
+	if !report.Healthy() && len(report.Repaired) == 0 {
+		os.Exit(1)
+	}
+}
@@ -337,6 +337,9 @@ type syncBuffer struct {
 	nbytes uint64 // 写入文件的字节数
 }
 
+// 日志文件的写缓冲大小
+const bufferSize = 256 * 1024
+
 func (sb *syncBuffer) Sync() error {
 	return sb.file.Sync()
 }
@@ -369,12 +372,19 @@ func (sb *syncBuffer) rotateFile(now time.Time) error {
 	}
 	var err error
 	sb.file, _, err = create(severityName[sb.sev], now)
+	sb.nbytes = 0
+	if err != nil {
+		return err
+	}
+
+	sb.Writer = bufio.NewWriterSize(sb.file, bufferSize)
+
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "Log file created at: %s\n", now.Format("2006/01/02 15:04:05"))
 	fmt.Fprintf(&buf, "Running on machine: %s\n", host)
 	fmt.Fprintf(&buf, "Binary: Built with %s %s for %s/%s\n", runtime.Compiler, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 	fmt.Fprintf(&buf, "Log line format: [IWEF]mmdd hh:mm:ss threadid file:line] msg\n")
-	n, err := sb.file.Write(buf.Bytes())
+	n, err := sb.Writer.Write(buf.Bytes())
 	sb.nbytes += uint64(n)
 	return err
 }
@@ -0,0 +1,40 @@
+package glog
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 滚动后写入新文件，新文件以文件头开始
+func TestSyncBufferRotateFile(t *testing.T) {
+	onceLogDirs.Do(createLogDirs)
+	oldLogDirs := logDirs
+	logDirs = []string{t.TempDir()}
+	defer func() { logDirs = oldLogDirs }()
+
+	sb := &syncBuffer{logger: &loggingT{}, sev: infoLog}
+	for i, line := range []string{"first\n", "second\n"} {
+		if err := sb.rotateFile(time.Now().Add(time.Duration(i) * time.Second)); err != nil {
+			t.Fatalf("rotate: %v", err)
+		}
+		if _, err := sb.Write([]byte(line)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		if err := sb.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(sb.file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(data), "Log file created at: ") || !strings.HasSuffix(string(data), "\n"+line) {
+			t.Fatalf("log file %s:\n%s", sb.file.Name(), data)
+		}
+		if sb.nbytes != uint64(len(data)) {
+			t.Fatalf("nbytes %d, file size %d", sb.nbytes, len(data))
+		}
+	}
+	sb.file.Close()
+}
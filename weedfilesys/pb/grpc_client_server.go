@@ -4,6 +4,7 @@ import (
 	"cayoyibackend/weedfilesys/glog"
 	"cayoyibackend/weedfilesys/pb/filer_pb"
 	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
 	"cayoyibackend/weedfilesys/util"
 	"context"
 	"fmt"
@@ -108,6 +109,7 @@ func getOrCreateConnection(address string, waitForReady bool, opts ...grpc.DialO
 		version:    rand.Int(),
 		errCount:   0,
 	}
+	grpcClients[address] = vgc
 
 	return vgc, nil
 }
@@ -167,3 +169,10 @@ func WithMasterClient(streamingMode bool, master ServerAddress, grpcDialOption g
 	}, master.ToGrpcAddress(), waitForReady, grpcDialOption)
 
 }
+
+func WithVolumeServerClient(streamingMode bool, volumeServer ServerAddress, grpcDialOption grpc.DialOption, fn func(client volume_server_pb.VolumeServerClient) error) error {
+	return WithGrpcClient(streamingMode, 0, func(grpcConnection *grpc.ClientConn) error {
+		client := volume_server_pb.NewVolumeServerClient(grpcConnection)
+		return fn(client)
+	}, volumeServer.ToGrpcAddress(), false, grpcDialOption)
+}
@@ -543,6 +543,8 @@ type VolumeEcShardInformationMessage struct {
 	EcIndexBits   uint32                 `protobuf:"varint,3,opt,name=ec_index_bits,json=ecIndexBits,proto3" json:"ec_index_bits,omitempty"`
 	DiskType      string                 `protobuf:"bytes,4,opt,name=disk_type,json=diskType,proto3" json:"disk_type,omitempty"`
 	ExpireAtSec   uint64                 `protobuf:"varint,5,opt,name=expire_at_sec,json=expireAtSec,proto3" json:"expire_at_sec,omitempty"` // used to record the destruction time of ec volume
+	DataShards    uint32                 `protobuf:"varint,6,opt,name=data_shards,json=dataShards,proto3" json:"data_shards,omitempty"`      // ec scheme, 0 means the default 10+4
+	ParityShards  uint32                 `protobuf:"varint,7,opt,name=parity_shards,json=parityShards,proto3" json:"parity_shards,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -612,6 +614,20 @@ func (x *VolumeEcShardInformationMessage) GetExpireAtSec() uint64 {
 	return 0
 }
 
+func (x *VolumeEcShardInformationMessage) GetDataShards() uint32 {
+	if x != nil {
+		return x.DataShards
+	}
+	return 0
+}
+
+func (x *VolumeEcShardInformationMessage) GetParityShards() uint32 {
+	if x != nil {
+		return x.ParityShards
+	}
+	return 0
+}
+
 type StorageBackend struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Type          string                 `protobuf:"bytes,1,opt,name=type,proto3" json:"type,omitempty"`
@@ -4068,7 +4084,7 @@ const file_master_proto_rawDesc = "" +
 	"\aversion\x18\t \x01(\rR\aversion\x12\x10\n" +
 	"\x03ttl\x18\n" +
 	" \x01(\rR\x03ttl\x12\x1b\n" +
-	"\tdisk_type\x18\x0f \x01(\tR\bdiskType\"\xb6\x01\n" +
+	"\tdisk_type\x18\x0f \x01(\tR\bdiskType\"\xfc\x01\n" +
 	"\x1fVolumeEcShardInformationMessage\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\rR\x02id\x12\x1e\n" +
 	"\n" +
@@ -4076,7 +4092,10 @@ const file_master_proto_rawDesc = "" +
 	"collection\x12\"\n" +
 	"\rec_index_bits\x18\x03 \x01(\rR\vecIndexBits\x12\x1b\n" +
 	"\tdisk_type\x18\x04 \x01(\tR\bdiskType\x12\"\n" +
-	"\rexpire_at_sec\x18\x05 \x01(\x04R\vexpireAtSec\"\xbe\x01\n" +
+	"\rexpire_at_sec\x18\x05 \x01(\x04R\vexpireAtSec\x12\x1f\n" +
+	"\vdata_shards\x18\x06 \x01(\rR\n" +
+	"dataShards\x12#\n" +
+	"\rparity_shards\x18\a \x01(\rR\fparityShards\"\xbe\x01\n" +
 	"\x0eStorageBackend\x12\x12\n" +
 	"\x04type\x18\x01 \x01(\tR\x04type\x12\x0e\n" +
 	"\x02id\x18\x02 \x01(\tR\x02id\x12I\n" +
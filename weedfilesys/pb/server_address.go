@@ -1,6 +1,7 @@
 package pb
 
 import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
 	"fmt"
 	"net"
 	"strconv"
@@ -61,3 +62,30 @@ func NewServerAddressWithGrpcPort(address string, grpcPort int) ServerAddress {
 	}
 	return ServerAddress(address + "." + strconv.Itoa(grpcPort))
 }
+
+// NewServerAddressFromLocation 用 master 返回的位置信息拼出带 gRPC 端口的地址
+func NewServerAddressFromLocation(dn *master_pb.Location) ServerAddress {
+	return NewServerAddressWithGrpcPort(dn.Url, int(dn.GrpcPort))
+}
+
+// ToHttpAddress 去掉端口段中的 gRPC 端口，"127.0.0.1:8080.18080" → "127.0.0.1:8080"
+func (sa ServerAddress) ToHttpAddress() string {
+	portsSepIndex := strings.LastIndex(string(sa), ":")
+	if portsSepIndex < 0 || portsSepIndex+1 >= len(sa) {
+		return string(sa)
+	}
+	ports := string(sa[portsSepIndex+1:])
+	if sepIndex := strings.LastIndex(ports, "."); sepIndex >= 0 {
+		return net.JoinHostPort(string(sa[0:portsSepIndex]), ports[0:sepIndex])
+	}
+	return string(sa)
+}
+
+// ToLocation 转成 master 返回给客户端的位置信息
+func (sa ServerAddress) ToLocation() *master_pb.Location {
+	location := &master_pb.Location{Url: sa.ToHttpAddress(), PublicUrl: sa.ToHttpAddress()}
+	if _, grpcPort, err := hostAndPort(sa.ToGrpcAddress()); err == nil {
+		location.GrpcPort = uint32(grpcPort)
+	}
+	return location
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"fmt"
+)
+
+// AllocateVolume 在空闲的目录上新建卷，memory_map_max_size_mb 大于 0 时卷以 mmap 方式读写
+func (vs *VolumeServer) AllocateVolume(ctx context.Context, req *volume_server_pb.AllocateVolumeRequest) (*volume_server_pb.AllocateVolumeResponse, error) {
+	err := vs.store.AddVolume(needle.VolumeId(req.VolumeId), req.Collection, req.Replication, req.Ttl,
+		req.Preallocate, req.MemoryMapMaxSizeMb, types.ToDiskType(req.DiskType))
+	if err != nil {
+		return nil, fmt.Errorf("allocate volume %d: %v", req.VolumeId, err)
+	}
+	return &volume_server_pb.AllocateVolumeResponse{}, nil
+}
+
+func (vs *VolumeServer) VolumeMarkReadonly(ctx context.Context, req *volume_server_pb.VolumeMarkReadonlyRequest) (*volume_server_pb.VolumeMarkReadonlyResponse, error) {
+	if err := vs.store.MarkVolumeReadonly(needle.VolumeId(req.VolumeId), req.Persist); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeMarkReadonlyResponse{}, nil
+}
+
+func (vs *VolumeServer) VolumeMarkWritable(ctx context.Context, req *volume_server_pb.VolumeMarkWritableRequest) (*volume_server_pb.VolumeMarkWritableResponse, error) {
+	if err := vs.store.MarkVolumeWritable(needle.VolumeId(req.VolumeId)); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeMarkWritableResponse{}, nil
+}
+
+func (vs *VolumeServer) VolumeDelete(ctx context.Context, req *volume_server_pb.VolumeDeleteRequest) (*volume_server_pb.VolumeDeleteResponse, error) {
+	vid := needle.VolumeId(req.VolumeId)
+	if req.OnlyEmpty {
+		if v := vs.store.GetVolume(vid); v != nil && v.FileCount() > 0 {
+			return nil, fmt.Errorf("volume %d is not empty", vid)
+		}
+	}
+	if err := vs.store.DeleteVolume(vid); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeDeleteResponse{}, nil
+}
+
+func (vs *VolumeServer) ReadVolumeFileStatus(ctx context.Context, req *volume_server_pb.ReadVolumeFileStatusRequest) (*volume_server_pb.ReadVolumeFileStatusResponse, error) {
+	v := vs.store.GetVolume(needle.VolumeId(req.VolumeId))
+	if v == nil {
+		return nil, fmt.Errorf("volume %d not found", req.VolumeId)
+	}
+	datSize, idxSize, modTime := v.FileStat()
+	return &volume_server_pb.ReadVolumeFileStatusResponse{
+		VolumeId:                req.VolumeId,
+		IdxFileTimestampSeconds: uint64(modTime.Unix()),
+		IdxFileSize:             idxSize,
+		DatFileTimestampSeconds: uint64(modTime.Unix()),
+		DatFileSize:             datSize,
+		FileCount:               v.FileCount(),
+		CompactionRevision:      uint32(v.CompactionRevision),
+		Collection:              v.Collection,
+		DiskType:                string(v.DiskType()),
+		Version:                 uint32(v.Version()),
+	}, nil
+}
+
+// VolumeNeedleStatus 先查普通卷，没有时从本机的 EC 分片读取
+func (vs *VolumeServer) VolumeNeedleStatus(ctx context.Context, req *volume_server_pb.VolumeNeedleStatusRequest) (*volume_server_pb.VolumeNeedleStatusResponse, error) {
+	vid := needle.VolumeId(req.VolumeId)
+	n := &needle.Needle{Id: types.NeedleId(req.NeedleId)}
+	var err error
+	if vs.store.HasVolume(vid) {
+		_, err = vs.store.ReadVolumeNeedle(vid, n)
+	} else if _, found := vs.store.FindEcVolume(vid); found {
+		_, err = vs.store.ReadEcShardNeedle(vid, n)
+	} else {
+		err = fmt.Errorf("volume %d not found", vid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := &volume_server_pb.VolumeNeedleStatusResponse{
+		NeedleId:     uint64(n.Id),
+		Cookie:       uint32(n.Cookie),
+		Size:         uint32(n.Size),
+		LastModified: n.LastModified,
+		Crc:          n.Checksum.Value(),
+	}
+	if n.HasTtl() {
+		resp.Ttl = n.Ttl.String()
+	}
+	return resp, nil
+}
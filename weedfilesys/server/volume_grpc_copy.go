@@ -0,0 +1,124 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+// CopyFile 把卷或 EC 卷的一个文件流式发送给对方，StopOffset 为 0 时发送整个文件
+func (vs *VolumeServer) CopyFile(req *volume_server_pb.CopyFileRequest, stream volume_server_pb.VolumeServer_CopyFileServer) error {
+	vid := needle.VolumeId(req.VolumeId)
+	var fileName string
+	if req.IsEcVolume {
+		name, found := vs.store.EcFileName(req.Collection, vid, req.Ext)
+		if !found {
+			if req.IgnoreSourceFileNotFound {
+				return nil
+			}
+			return fmt.Errorf("ec volume %d file %s not found", vid, req.Ext)
+		}
+		fileName = name
+	} else {
+		v := vs.store.GetVolume(vid)
+		if v == nil {
+			return fmt.Errorf("volume %d not found", vid)
+		}
+		if uint32(v.CompactionRevision) != req.CompactionRevision && req.CompactionRevision != 0 {
+			return fmt.Errorf("volume %d is compacted", vid)
+		}
+		fileName = v.FileName(req.Ext)
+	}
+
+	file, err := os.Open(fileName)
+	if err != nil {
+		if os.IsNotExist(err) && req.IgnoreSourceFileNotFound {
+			return nil
+		}
+		return err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	stopOffset := uint64(stat.Size())
+	if req.StopOffset > 0 && req.StopOffset < stopOffset {
+		stopOffset = req.StopOffset
+	}
+
+	buf := make([]byte, BufferSizeLimit)
+	var offset uint64
+	for offset < stopOffset {
+		n, readErr := file.ReadAt(buf[:min(uint64(len(buf)), stopOffset-offset)], int64(offset))
+		if n > 0 {
+			if err = stream.Send(&volume_server_pb.CopyFileResponse{
+				FileContent:  buf[:n],
+				ModifiedTsNs: stat.ModTime().UnixNano(),
+			}); err != nil {
+				return err
+			}
+			offset += uint64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return nil
+}
+
+// 从 source 复制一个文件到本地，先写临时文件再改名，没有复制完整的文件不会被加载
+func (vs *VolumeServer) copyFileFromSource(ctx context.Context, source pb.ServerAddress, req *volume_server_pb.CopyFileRequest, fileName string) (copied bool, err error) {
+	err = pb.WithVolumeServerClient(true, source, vs.grpcDialOption, func(client volume_server_pb.VolumeServerClient) error {
+		stream, err := client.CopyFile(ctx, req)
+		if err != nil {
+			return err
+		}
+		tmpFileName := fileName + ".tmp"
+		file, err := os.OpenFile(tmpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmpFileName)
+		var received bool
+		for {
+			resp, recvErr := stream.Recv()
+			if recvErr == io.EOF {
+				break
+			}
+			if recvErr != nil {
+				file.Close()
+				return recvErr
+			}
+			received = true
+			if _, err = file.Write(resp.FileContent); err != nil {
+				file.Close()
+				return err
+			}
+		}
+		if err = file.Sync(); err != nil {
+			file.Close()
+			return err
+		}
+		if err = file.Close(); err != nil {
+			return err
+		}
+		// 源文件不存在且允许忽略时什么也收不到
+		if !received && req.IgnoreSourceFileNotFound {
+			return nil
+		}
+		copied = true
+		return os.Rename(tmpFileName, fileName)
+	})
+	if err != nil {
+		return false, fmt.Errorf("copy %d%s from %s: %v", req.VolumeId, req.Ext, source, err)
+	}
+	return copied, nil
+}
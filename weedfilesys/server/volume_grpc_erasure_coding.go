@@ -0,0 +1,165 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"fmt"
+	"io"
+)
+
+// 在线 EC 转换的流程由 master 驱动，见 topology.Topology.EcEncodeVolume：
+//  1. VolumeMarkReadonly 所有副本
+//  2. VolumeEcShardsGenerate 在一个副本上按 collection 的 EC 方案生成 .ec00~.ecNN、.ecx 和 .vif
+//  3. VolumeEcShardsCopy 各目标服务器从源服务器复制分到的分片，再 VolumeEcShardsMount
+//  4. 验证读取之后 VolumeEcShardsDelete 源服务器上已经分出去的分片，VolumeDelete 删除原卷
+
+func (vs *VolumeServer) VolumeEcShardsGenerate(ctx context.Context, req *volume_server_pb.VolumeEcShardsGenerateRequest) (*volume_server_pb.VolumeEcShardsGenerateResponse, error) {
+	scheme, err := erasure_coding.EcSchemeFromConfig(req.EcShardConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err = vs.store.GenerateEcShards(needle.VolumeId(req.VolumeId), req.Collection, scheme); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeEcShardsGenerateResponse{}, nil
+}
+
+// VolumeEcShardsRebuild 用本机的分片重建缺少的分片，不挂载
+func (vs *VolumeServer) VolumeEcShardsRebuild(ctx context.Context, req *volume_server_pb.VolumeEcShardsRebuildRequest) (*volume_server_pb.VolumeEcShardsRebuildResponse, error) {
+	rebuilt, err := vs.store.RebuildEcShards(needle.VolumeId(req.VolumeId), req.Collection)
+	if err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeEcShardsRebuildResponse{RebuiltShardIds: rebuilt}, nil
+}
+
+// VolumeEcShardsCopy 从源服务器复制分片以及 .ecx、.ecj、.vif，复制完不挂载。
+// 本机已经挂载了该 EC 卷时保留自己的 .ecx、.ecj 和 .vif，它们正在使用，并且记录着本机收到的删除
+func (vs *VolumeServer) VolumeEcShardsCopy(ctx context.Context, req *volume_server_pb.VolumeEcShardsCopyRequest) (*volume_server_pb.VolumeEcShardsCopyResponse, error) {
+	vid := needle.VolumeId(req.VolumeId)
+	if _, mounted := vs.store.FindEcVolume(vid); mounted {
+		req.CopyEcxFile, req.CopyEcjFile, req.CopyVifFile = false, false, false
+	}
+	location := vs.store.EcShardLocation(req.Collection, vid, types.HardDriveType)
+	if location == nil {
+		return nil, fmt.Errorf("no free location for ec volume %d", vid)
+	}
+	dataBaseFileName := erasure_coding.EcShardFileName(req.Collection, location.Directory, int(vid))
+	indexBaseFileName := erasure_coding.EcShardFileName(req.Collection, location.IdxDirectory, int(vid))
+	source := pb.ServerAddress(req.SourceDataNode)
+	copyFile := func(ext, fileName string, ignoreNotFound bool) error {
+		_, err := vs.copyFileFromSource(ctx, source, &volume_server_pb.CopyFileRequest{
+			VolumeId:                 req.VolumeId,
+			Ext:                      ext,
+			Collection:               req.Collection,
+			IsEcVolume:               true,
+			IgnoreSourceFileNotFound: ignoreNotFound,
+		}, fileName)
+		return err
+	}
+
+	for _, shardId := range req.ShardIds {
+		ext := erasure_coding.ToExt(int(shardId))
+		if err := copyFile(ext, dataBaseFileName+ext, false); err != nil {
+			return nil, err
+		}
+	}
+	if req.CopyEcxFile {
+		if err := copyFile(".ecx", indexBaseFileName+".ecx", false); err != nil {
+			return nil, err
+		}
+	}
+	if req.CopyEcjFile {
+		if err := copyFile(".ecj", indexBaseFileName+".ecj", true); err != nil {
+			return nil, err
+		}
+	}
+	if req.CopyVifFile {
+		if err := copyFile(".vif", dataBaseFileName+".vif", true); err != nil {
+			return nil, err
+		}
+	}
+	glog.V(0).Infof("copied ec volume %d shards %v from %s", vid, req.ShardIds, source)
+	return &volume_server_pb.VolumeEcShardsCopyResponse{}, nil
+}
+
+func (vs *VolumeServer) VolumeEcShardsMount(ctx context.Context, req *volume_server_pb.VolumeEcShardsMountRequest) (*volume_server_pb.VolumeEcShardsMountResponse, error) {
+	for _, shardId := range req.ShardIds {
+		if err := vs.store.MountEcShards(req.Collection, needle.VolumeId(req.VolumeId), erasure_coding.ShardId(shardId)); err != nil {
+			return nil, err
+		}
+	}
+	return &volume_server_pb.VolumeEcShardsMountResponse{}, nil
+}
+
+func (vs *VolumeServer) VolumeEcShardsUnmount(ctx context.Context, req *volume_server_pb.VolumeEcShardsUnmountRequest) (*volume_server_pb.VolumeEcShardsUnmountResponse, error) {
+	for _, shardId := range req.ShardIds {
+		if err := vs.store.UnmountEcShards(needle.VolumeId(req.VolumeId), erasure_coding.ShardId(shardId)); err != nil {
+			return nil, err
+		}
+	}
+	return &volume_server_pb.VolumeEcShardsUnmountResponse{}, nil
+}
+
+// VolumeEcShardsDelete 卸载并删除分片，分片全部删除后 .ecx 和 .ecj 随之删除
+func (vs *VolumeServer) VolumeEcShardsDelete(ctx context.Context, req *volume_server_pb.VolumeEcShardsDeleteRequest) (*volume_server_pb.VolumeEcShardsDeleteResponse, error) {
+	shardIds := make([]erasure_coding.ShardId, len(req.ShardIds))
+	for i, shardId := range req.ShardIds {
+		shardIds[i] = erasure_coding.ShardId(shardId)
+	}
+	if err := vs.store.DeleteEcShards(needle.VolumeId(req.VolumeId), req.Collection, shardIds); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeEcShardsDeleteResponse{}, nil
+}
+
+// VolumeEcShardsToVolume 把本机的 EC 卷还原成普通卷并挂载，见 topology.Topology.EcDecodeVolume
+func (vs *VolumeServer) VolumeEcShardsToVolume(ctx context.Context, req *volume_server_pb.VolumeEcShardsToVolumeRequest) (*volume_server_pb.VolumeEcShardsToVolumeResponse, error) {
+	if err := vs.store.EcShardsToVolume(needle.VolumeId(req.VolumeId), req.Collection); err != nil {
+		return nil, err
+	}
+	return &volume_server_pb.VolumeEcShardsToVolumeResponse{}, nil
+}
+
+// VolumeEcShardRead 读取本地分片的一段，供其他卷服务器读取或恢复它们缺少的分片。
+// 带 FileKey 时先检查 .ecx，needle 已删除则只返回 IsDeleted
+func (vs *VolumeServer) VolumeEcShardRead(req *volume_server_pb.VolumeEcShardReadRequest, stream volume_server_pb.VolumeServer_VolumeEcShardReadServer) error {
+	ecVolume, found := vs.store.FindEcVolume(needle.VolumeId(req.VolumeId))
+	if !found {
+		return fmt.Errorf("ec volume %d not found", req.VolumeId)
+	}
+	shard, found := ecVolume.FindEcVolumeShard(erasure_coding.ShardId(req.ShardId))
+	if !found {
+		return fmt.Errorf("ec shard %d.%d not found", req.VolumeId, req.ShardId)
+	}
+	if req.FileKey != 0 {
+		_, size, _ := ecVolume.FindNeedleFromEcx(types.NeedleId(req.FileKey))
+		if size.IsDeleted() {
+			return stream.Send(&volume_server_pb.VolumeEcShardReadResponse{IsDeleted: true})
+		}
+	}
+
+	buf := make([]byte, min(req.Size, BufferSizeLimit))
+	offset, stopOffset := req.Offset, req.Offset+req.Size
+	for offset < stopOffset {
+		n, readErr := shard.ReadAt(buf[:min(int64(len(buf)), stopOffset-offset)], offset)
+		if n > 0 {
+			if err := stream.Send(&volume_server_pb.VolumeEcShardReadResponse{Data: buf[:n]}); err != nil {
+				return err
+			}
+			offset += int64(n)
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	return nil
+}
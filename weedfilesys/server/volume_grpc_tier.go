@@ -0,0 +1,61 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"fmt"
+	"time"
+)
+
+// 搬迁期间每隔这么久汇报一次进度
+const tierReportInterval = time.Second
+
+// VolumeTierMoveDatToRemote 把只读卷的 .dat 上传到远程存储，之后从远程读取
+func (vs *VolumeServer) VolumeTierMoveDatToRemote(req *volume_server_pb.VolumeTierMoveDatToRemoteRequest, stream volume_server_pb.VolumeServer_VolumeTierMoveDatToRemoteServer) error {
+	if err := vs.checkTierVolume(req.VolumeId, req.Collection); err != nil {
+		return err
+	}
+	lastReport := time.Now()
+	err := vs.store.MoveVolumeDatToRemote(needle.VolumeId(req.VolumeId), req.DestinationBackendName, req.KeepLocalDatFile, func(processed int64, percentage float32) error {
+		if time.Since(lastReport) < tierReportInterval {
+			return nil
+		}
+		lastReport = time.Now()
+		return stream.Send(&volume_server_pb.VolumeTierMoveDatToRemoteResponse{Processed: processed, ProcessedPercentage: percentage})
+	})
+	if err != nil {
+		glog.Errorf("move volume %d to %s: %v", req.VolumeId, req.DestinationBackendName, err)
+	}
+	return err
+}
+
+// VolumeTierMoveDatFromRemote 把远程的 .dat 下载回本地，卷仍保持只读
+func (vs *VolumeServer) VolumeTierMoveDatFromRemote(req *volume_server_pb.VolumeTierMoveDatFromRemoteRequest, stream volume_server_pb.VolumeServer_VolumeTierMoveDatFromRemoteServer) error {
+	if err := vs.checkTierVolume(req.VolumeId, req.Collection); err != nil {
+		return err
+	}
+	lastReport := time.Now()
+	err := vs.store.MoveVolumeDatFromRemote(needle.VolumeId(req.VolumeId), req.KeepRemoteDatFile, func(processed int64, percentage float32) error {
+		if time.Since(lastReport) < tierReportInterval {
+			return nil
+		}
+		lastReport = time.Now()
+		return stream.Send(&volume_server_pb.VolumeTierMoveDatFromRemoteResponse{Processed: processed, ProcessedPercentage: percentage})
+	})
+	if err != nil {
+		glog.Errorf("move volume %d from remote: %v", req.VolumeId, err)
+	}
+	return err
+}
+
+func (vs *VolumeServer) checkTierVolume(volumeId uint32, collection string) error {
+	v := vs.store.GetVolume(needle.VolumeId(volumeId))
+	if v == nil {
+		return fmt.Errorf("volume %d not found", volumeId)
+	}
+	if v.Collection != collection {
+		return fmt.Errorf("volume %d collection %q, not %q", volumeId, v.Collection, collection)
+	}
+	return nil
+}
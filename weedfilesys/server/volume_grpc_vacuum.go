@@ -0,0 +1,64 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"context"
+)
+
+// 压缩的流程由 master 驱动：
+//  1. VacuumVolumeCheck 各副本返回垃圾比例，超过阈值才继续
+//  2. VacuumVolumeCompact 生成 .cpd/.cpx，期间卷继续接受写入
+//  3. VacuumVolumeCommit 补齐压缩期间追加的 needle 后替换 .dat/.idx
+//  4. 任何一个副本失败时 VacuumVolumeCleanup 删除 .cpd/.cpx
+
+// 压缩时每处理这么多字节汇报一次进度
+const compactReportInterval = 128 * 1024 * 1024
+
+func (vs *VolumeServer) VacuumVolumeCheck(ctx context.Context, req *volume_server_pb.VacuumVolumeCheckRequest) (*volume_server_pb.VacuumVolumeCheckResponse, error) {
+	garbageRatio, err := vs.store.CheckCompactVolume(needle.VolumeId(req.VolumeId))
+	if err != nil {
+		glog.V(3).Infof("check volume %d: %v", req.VolumeId, err)
+		return nil, err
+	}
+	return &volume_server_pb.VacuumVolumeCheckResponse{GarbageRatio: garbageRatio}, nil
+}
+
+// VacuumVolumeCompact 压缩期间定期返回已处理的字节数，master 据此判断压缩没有卡住
+func (vs *VolumeServer) VacuumVolumeCompact(req *volume_server_pb.VacuumVolumeCompactRequest, stream volume_server_pb.VolumeServer_VacuumVolumeCompactServer) error {
+	nextReport := int64(compactReportInterval)
+	var sendErr error
+	err := vs.store.CompactVolume(needle.VolumeId(req.VolumeId), req.Preallocate, vs.compactionBytePerSecond, func(processed int64) bool {
+		if processed < nextReport {
+			return true
+		}
+		nextReport = processed + compactReportInterval
+		if sendErr = stream.Send(&volume_server_pb.VacuumVolumeCompactResponse{ProcessedBytes: processed}); sendErr != nil {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		glog.Errorf("compact volume %d: %v", req.VolumeId, err)
+		return err
+	}
+	return sendErr
+}
+
+func (vs *VolumeServer) VacuumVolumeCommit(ctx context.Context, req *volume_server_pb.VacuumVolumeCommitRequest) (*volume_server_pb.VacuumVolumeCommitResponse, error) {
+	readOnly, volumeSize, err := vs.store.CommitCompactVolume(needle.VolumeId(req.VolumeId))
+	if err != nil {
+		glog.Errorf("commit volume %d: %v", req.VolumeId, err)
+		return nil, err
+	}
+	return &volume_server_pb.VacuumVolumeCommitResponse{IsReadOnly: readOnly, VolumeSize: uint64(volumeSize)}, nil
+}
+
+func (vs *VolumeServer) VacuumVolumeCleanup(ctx context.Context, req *volume_server_pb.VacuumVolumeCleanupRequest) (*volume_server_pb.VacuumVolumeCleanupResponse, error) {
+	if err := vs.store.CommitCleanupVolume(needle.VolumeId(req.VolumeId)); err != nil {
+		glog.Errorf("cleanup volume %d: %v", req.VolumeId, err)
+		return nil, err
+	}
+	return &volume_server_pb.VacuumVolumeCleanupResponse{}, nil
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// BufferSizeLimit CopyFile 等流式接口每条消息携带的最大数据量
+const BufferSizeLimit = 1024 * 1024 * 2
+
+// VolumeServer 卷服务器的 gRPC 接口，具体的操作都交给 Store；
+// 没有实现的接口由 UnimplementedVolumeServerServer 返回 Unimplemented
+type VolumeServer struct {
+	volume_server_pb.UnimplementedVolumeServerServer
+
+	store                   *storage.Store
+	grpcDialOption          grpc.DialOption // 访问其他卷服务器，比如复制 EC 分片
+	compactionBytePerSecond int64           // 压缩时的限速，0 表示不限速
+
+	tierLock          sync.Mutex
+	stopTierScheduler func() // 自动分层的定时任务，没有启动时为 nil
+}
+
+func NewVolumeServer(store *storage.Store, grpcDialOption grpc.DialOption) *VolumeServer {
+	return &VolumeServer{store: store, grpcDialOption: grpcDialOption}
+}
+
+// SetCompactionBytePerSecond 限制压缩的读写速度，避免影响正常读写
+func (vs *VolumeServer) SetCompactionBytePerSecond(bytesPerSecond int64) {
+	vs.compactionBytePerSecond = bytesPerSecond
+}
+
+// SetTierPolicy 按 policy 每隔 interval 把冷卷搬到远程存储，替换之前的策略；
+// BackendName 为空或 interval 不大于 0 时只停止自动分层
+func (vs *VolumeServer) SetTierPolicy(policy storage.TierPolicy, interval time.Duration) {
+	vs.tierLock.Lock()
+	defer vs.tierLock.Unlock()
+	if vs.stopTierScheduler != nil {
+		vs.stopTierScheduler()
+		vs.stopTierScheduler = nil
+	}
+	if policy.BackendName != "" && interval > 0 {
+		vs.stopTierScheduler = vs.store.StartTierScheduler(policy, interval)
+	}
+}
+
+// Shutdown 停止后台任务，卷的关闭由 Store 负责
+func (vs *VolumeServer) Shutdown() {
+	vs.SetTierPolicy(storage.TierPolicy{}, 0)
+}
+
+func (vs *VolumeServer) Store() *storage.Store {
+	return vs.store
+}
@@ -0,0 +1,14 @@
+package stats
+
+import "cayoyibackend/weedfilesys/pb/volume_server_pb"
+
+// NewDiskStatus 返回目录所在磁盘的容量和使用情况
+func NewDiskStatus(path string) (disk *volume_server_pb.DiskStatus) {
+	disk = &volume_server_pb.DiskStatus{Dir: path}
+	fillInDiskStatus(disk)
+	if disk.PercentUsed != 0 {
+		disk.PercentUsed = float32(int(disk.PercentUsed*100)) / 100
+		disk.PercentFree = float32(int(disk.PercentFree*100)) / 100
+	}
+	return
+}
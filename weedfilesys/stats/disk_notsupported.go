@@ -0,0 +1,10 @@
+//go:build windows || openbsd || netbsd || plan9 || solaris
+// +build windows openbsd netbsd plan9 solaris
+
+package stats
+
+import "cayoyibackend/weedfilesys/pb/volume_server_pb"
+
+func fillInDiskStatus(disk *volume_server_pb.DiskStatus) {
+	return
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !openbsd && !netbsd && !plan9 && !solaris
+// +build !windows,!openbsd,!netbsd,!plan9,!solaris
+
+package stats
+
+import (
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"syscall"
+)
+
+func fillInDiskStatus(disk *volume_server_pb.DiskStatus) {
+	fs := syscall.Statfs_t{}
+	err := syscall.Statfs(disk.Dir, &fs)
+	if err != nil {
+		return
+	}
+
+	// 普通用户可用的空间用 Bavail，Bfree 包含了 root 保留的部分
+	disk.All = fs.Blocks * uint64(fs.Bsize)
+	disk.Free = fs.Bavail * uint64(fs.Bsize)
+	disk.Used = disk.All - fs.Bfree*uint64(fs.Bsize)
+	if disk.All > 0 {
+		disk.PercentFree = float32((float64(disk.Free) / float64(disk.All)) * 100)
+		disk.PercentUsed = float32((float64(disk.Used) / float64(disk.All)) * 100)
+	}
+	return
+}
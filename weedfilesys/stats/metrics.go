@@ -89,10 +89,58 @@ var (
 			Name:      "handler_total",
 			Help:      "Counter of volume server handlers.",
 		}, []string{"type"})
+
+	// 卷服务器上各 collection 的卷数量，type 为 volume 或 ec_shards
+	VolumeServerVolumeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "volumeServer",
+			Name:      "volumes",
+			Help:      "Number of volumes or shards.",
+		}, []string{"collection", "type"})
+
+	VolumeServerReadOnlyVolumeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "volumeServer",
+			Name:      "read_only_volumes",
+			Help:      "Number of read only volumes.",
+		}, []string{"collection", "type"})
+
+	VolumeServerMaxVolumeCounter = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "volumeServer",
+			Name:      "max_volumes",
+			Help:      "Maximum number of volumes.",
+		})
+
+	VolumeServerDiskSizeGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "volumeServer",
+			Name:      "total_disk_size",
+			Help:      "Actual disk size used by volumes.",
+		}, []string{"collection", "type"})
+
+	// 数据目录的磁盘空间，type 为 all、used、free
+	VolumeServerResourceGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Subsystem: "volumeServer",
+			Name:      "resource",
+			Help:      "Resource usage",
+		}, []string{"name", "type"})
 )
 
 func init() {
 	Gather.MustRegister(MasterClientConnectCounter)
+	Gather.MustRegister(VolumeServerHandlerCounter)
+	Gather.MustRegister(VolumeServerVolumeGauge)
+	Gather.MustRegister(VolumeServerReadOnlyVolumeGauge)
+	Gather.MustRegister(VolumeServerMaxVolumeCounter)
+	Gather.MustRegister(VolumeServerDiskSizeGauge)
+	Gather.MustRegister(VolumeServerResourceGauge)
 }
 
 // 将采集到的指标数据（Gather 注册器里的指标）周期性地推送到 Prometheus PushGateway。
@@ -1,10 +1,10 @@
 ﻿package backend
 
 import (
-	"cayoyibackend/weedfilesys/glog"
 	"cayoyibackend/weedfilesys/pb/master_pb"
 	"cayoyibackend/weedfilesys/pb/volume_server_pb"
 	"cayoyibackend/weedfilesys/util"
+	"errors"
 	"io"
 	"os"
 	"strings"
@@ -59,63 +59,17 @@ var (
 )
 
 // 加载配置初始化存储实例
-// 从配置文件中读取 "storage.backend" 相关的配置节
-// 遍历各存储类型和存储实例配置
-// 跳过未启用的实例
-// 对每个实例调用对应工厂构建存储实例
-// 加入 BackendStorages 管理集合
-// 若实例ID是 "default"，则也设置 BackendStorages 以类型名为 key 的快捷访问
-func LoadConfiguration(config *util.ViperProxy) {
-
-	StorageBackendPrefix := "storage.backend"
-
-	for backendTypeName := range config.GetStringMap(StorageBackendPrefix) {
-		backendStorageFactory, found := BackendStorageFactories[StorageType(backendTypeName)]
-		if !found {
-			glog.Fatalf("backend storage type %s not found", backendTypeName)
-		}
-		for backendStorageId := range config.GetStringMap(StorageBackendPrefix + "." + backendTypeName) {
-			if !config.GetBool(StorageBackendPrefix + "." + backendTypeName + "." + backendStorageId + ".enabled") {
-				continue
-			}
-			if _, found := BackendStorages[backendTypeName+"."+backendStorageId]; found {
-				continue
-			}
-			backendStorage, buildErr := backendStorageFactory.BuildStorage(config,
-				StorageBackendPrefix+"."+backendTypeName+"."+backendStorageId+".", backendStorageId)
-			if buildErr != nil {
-				glog.Fatalf("fail to create backend storage %s.%s", backendTypeName, backendStorageId)
-			}
-			BackendStorages[backendTypeName+"."+backendStorageId] = backendStorage
-			if backendStorageId == "default" {
-				BackendStorages[backendTypeName] = backendStorage
-			}
-		}
-	}
-
+// 从配置文件中读取 "storage.backend" 相关的配置节，配置有误或创建失败时汇总所有错误返回，
+// 能创建的存储照常生效；重复调用时按最新的配置增删改，见 ApplyStorageBackends
+func LoadConfiguration(config *util.ViperProxy, inUse map[string]bool) error {
+	backends, parseErr := ParseConfiguration(config)
+	applyErr := ApplyStorageBackends(BackendSourceConfiguration, backends, inUse)
+	return errors.Join(parseErr, applyErr)
 }
 
 // 用于Volume Server 从Master接收到的远程存储配置来初始化
-func LoadFromPbStorageBackends(storageBackends []*master_pb.StorageBackend) {
-
-	for _, storageBackend := range storageBackends {
-		backendStorageFactory, found := BackendStorageFactories[StorageType(storageBackend.Type)]
-		if !found {
-			glog.Warningf("storage type %s not found", storageBackend.Type)
-			continue
-		}
-		if _, found := BackendStorages[storageBackend.Type+"."+storageBackend.Id]; found {
-			continue
-		}
-		backendStorage, buildErr := backendStorageFactory.BuildStorage(newProperties(storageBackend.Properties), "", storageBackend.Id)
-		if buildErr != nil {
-			glog.Fatalf("fail to create backend storage %s.%s", storageBackend.Type, storageBackend.Id)
-		}
-		BackendStorages[storageBackend.Type+"."+storageBackend.Id] = backendStorage
-		if storageBackend.Id == "default" {
-			BackendStorages[storageBackend.Type] = backendStorage
-		}
-	}
+func LoadFromPbStorageBackends(storageBackends []*master_pb.StorageBackend, inUse map[string]bool) error {
+	return ApplyStorageBackends(BackendSourceMaster, storageBackends, inUse)
 }
 
 // 配置属性的封装
@@ -140,7 +94,13 @@ func (p *Properties) GetString(key string) string {
 // 调用存储实例的 ToProperties 获取配置属性
 // 封装成 protobuf 对象返回
 func ToPbStorageBackends() (backends []*master_pb.StorageBackend) {
+	backendStoragesLock.RLock()
+	defer backendStoragesLock.RUnlock()
 	for sName, s := range BackendStorages {
+		// 只有类型名的是 default 的别名，跳过避免重复
+		if !strings.Contains(sName, ".") {
+			continue
+		}
 		sType, sId := BackendNameToTypeId(sName)
 		if sType == "" {
 			continue
@@ -0,0 +1,188 @@
+package backend
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// 远程存储可以来自本地配置文件（storage.backend.<type>.<id>），也可以由 master 下发。
+// 每次都按来源整体应用：新增的创建，属性变化的重建，不再出现的移除；
+// 仍被已挂载卷的 .vif 引用的存储不会移除。直接写入 BackendStorages 的实例不受管理，不会被移除
+
+const (
+	BackendSourceConfiguration = "configuration"
+	BackendSourceMaster        = "master"
+)
+
+type backendStorageConfig struct {
+	source     string
+	properties map[string]string
+}
+
+var (
+	backendStoragesLock sync.RWMutex
+	// 通过配置创建的实例，记录来源和创建时的属性
+	backendStorageConfigs = make(map[string]*backendStorageConfig)
+)
+
+// GetBackendStorage 按名称（type.id，id 为 default 时也可以只写 type）查找远程存储
+func GetBackendStorage(name string) (BackendStorage, bool) {
+	backendStoragesLock.RLock()
+	defer backendStoragesLock.RUnlock()
+	backendStorage, found := BackendStorages[name]
+	return backendStorage, found
+}
+
+// ParseConfiguration 读取 storage.backend 下启用的存储，一次返回所有配置错误
+func ParseConfiguration(config *util.ViperProxy) (backends []*master_pb.StorageBackend, err error) {
+	const storageBackendPrefix = "storage.backend"
+	var errs []error
+	for _, backendType := range sortedKeys(config.GetStringMap(storageBackendPrefix)) {
+		if _, found := BackendStorageFactories[StorageType(backendType)]; !found {
+			errs = append(errs, fmt.Errorf("%s.%s: unknown backend storage type", storageBackendPrefix, backendType))
+			continue
+		}
+		for _, id := range sortedKeys(config.GetStringMap(storageBackendPrefix + "." + backendType)) {
+			prefix := storageBackendPrefix + "." + backendType + "." + id
+			section, ok := config.Get(prefix).(map[string]interface{})
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: expect a table", prefix))
+				continue
+			}
+			if !config.GetBool(prefix + ".enabled") {
+				continue
+			}
+			properties := make(map[string]string)
+			for key, value := range section {
+				if key != "enabled" {
+					properties[key] = fmt.Sprint(value)
+				}
+			}
+			backends = append(backends, &master_pb.StorageBackend{Type: backendType, Id: id, Properties: properties})
+		}
+	}
+	return backends, errors.Join(errs...)
+}
+
+// ValidateStorageBackends 逐个试着创建，一次返回所有错误，不改动当前的存储
+func ValidateStorageBackends(backends []*master_pb.StorageBackend) error {
+	var errs []error
+	seen := make(map[string]bool)
+	for _, storageBackend := range backends {
+		name := storageBackend.Type + "." + storageBackend.Id
+		if seen[name] {
+			errs = append(errs, fmt.Errorf("%s: duplicated", name))
+			continue
+		}
+		seen[name] = true
+		if _, err := buildStorageBackend(storageBackend); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func buildStorageBackend(storageBackend *master_pb.StorageBackend) (BackendStorage, error) {
+	name := storageBackend.Type + "." + storageBackend.Id
+	if storageBackend.Type == "" || storageBackend.Id == "" {
+		return nil, fmt.Errorf("%s: backend type and id are required", name)
+	}
+	factory, found := BackendStorageFactories[StorageType(storageBackend.Type)]
+	if !found {
+		return nil, fmt.Errorf("%s: unknown backend storage type", name)
+	}
+	backendStorage, err := factory.BuildStorage(newProperties(storageBackend.Properties), "", storageBackend.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	return backendStorage, nil
+}
+
+// ApplyStorageBackends 把 source 来源的存储更新为 backends。
+// inUse 中的存储即使不再出现也保留；创建失败的存储保留旧的实例。返回所有错误
+func ApplyStorageBackends(source string, backends []*master_pb.StorageBackend, inUse map[string]bool) error {
+	var errs []error
+	desired := make(map[string]*master_pb.StorageBackend)
+	for _, storageBackend := range backends {
+		desired[storageBackend.Type+"."+storageBackend.Id] = storageBackend
+	}
+
+	// 先在锁外创建，创建可能要建立连接
+	built := make(map[string]BackendStorage)
+	backendStoragesLock.RLock()
+	for name, storageBackend := range desired {
+		if existing, found := backendStorageConfigs[name]; found && reflect.DeepEqual(existing.properties, storageBackend.Properties) {
+			continue
+		}
+		if _, found := BackendStorages[name]; found && backendStorageConfigs[name] == nil {
+			errs = append(errs, fmt.Errorf("%s: registered without configuration, not replaced", name))
+			continue
+		}
+		built[name] = nil
+	}
+	backendStoragesLock.RUnlock()
+	for name := range built {
+		backendStorage, err := buildStorageBackend(desired[name])
+		if err != nil {
+			errs = append(errs, err)
+			delete(built, name)
+			continue
+		}
+		built[name] = backendStorage
+	}
+
+	backendStoragesLock.Lock()
+	defer backendStoragesLock.Unlock()
+	for name, backendStorage := range built {
+		storageBackend := desired[name]
+		if existing, found := backendStorageConfigs[name]; found && existing.source != source {
+			glog.V(0).Infof("backend storage %s now configured by %s instead of %s", name, source, existing.source)
+		}
+		setBackendStorage(name, backendStorage)
+		backendStorageConfigs[name] = &backendStorageConfig{source: source, properties: storageBackend.Properties}
+		glog.V(0).Infof("backend storage %s is configured by %s", name, source)
+	}
+	for name, existing := range backendStorageConfigs {
+		if existing.source != source || desired[name] != nil {
+			continue
+		}
+		if inUse[name] {
+			errs = append(errs, fmt.Errorf("%s: still used by mounted volumes, not removed", name))
+			continue
+		}
+		removeBackendStorage(name)
+		delete(backendStorageConfigs, name)
+		glog.V(0).Infof("backend storage %s is removed", name)
+	}
+	return errors.Join(errs...)
+}
+
+// 调用方持有写锁；id 为 default 时同时登记只有类型名的别名
+func setBackendStorage(name string, backendStorage BackendStorage) {
+	BackendStorages[name] = backendStorage
+	if backendType, backendId := BackendNameToTypeId(name); backendId == "default" {
+		BackendStorages[backendType] = backendStorage
+	}
+}
+
+func removeBackendStorage(name string) {
+	delete(BackendStorages, name)
+	if backendType, backendId := BackendNameToTypeId(name); backendId == "default" {
+		delete(BackendStorages, backendType)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,147 @@
+package backend
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"github.com/spf13/viper"
+	"strings"
+	"testing"
+)
+
+// 测试用的存储类型，属性 fail 为 true 时创建失败
+type configTestFactory struct{}
+
+func (configTestFactory) StorageType() StorageType { return "configtest" }
+func (configTestFactory) BuildStorage(configuration StringProperties, configPrefix string, id string) (BackendStorage, error) {
+	if configuration.GetString(configPrefix+"fail") == "true" {
+		return nil, fmt.Errorf("cannot connect")
+	}
+	storage := newMemBackendStorage()
+	storage.objects["properties"] = []byte(configuration.GetString(configPrefix + "endpoint"))
+	return storage, nil
+}
+
+func init() {
+	BackendStorageFactories["configtest"] = configTestFactory{}
+}
+
+func parseTestConfiguration(t *testing.T, toml string) *util.ViperProxy {
+	v := viper.New()
+	v.SetConfigType("toml")
+	if err := v.ReadConfig(strings.NewReader(toml)); err != nil {
+		t.Fatal(err)
+	}
+	return &util.ViperProxy{Viper: v}
+}
+
+func endpointOf(t *testing.T, name string) string {
+	t.Helper()
+	backendStorage, found := GetBackendStorage(name)
+	if !found {
+		t.Fatalf("%s not found", name)
+	}
+	return string(backendStorage.(*memBackendStorage).objects["properties"])
+}
+
+func TestBackendConfigurationReportsAllErrors(t *testing.T) {
+	config := parseTestConfiguration(t, `
+[storage.backend.nosuch.default]
+enabled = true
+[storage.backend.configtest.good]
+enabled = true
+endpoint = "a"
+[storage.backend.configtest.bad]
+enabled = true
+fail = true
+[storage.backend.configtest.off]
+enabled = false
+fail = true
+`)
+	backends, err := ParseConfiguration(config)
+	if err == nil || !strings.Contains(err.Error(), "storage.backend.nosuch") {
+		t.Fatalf("parse error %v", err)
+	}
+	if len(backends) != 2 {
+		t.Fatalf("parsed %d backends", len(backends))
+	}
+	err = ValidateStorageBackends(backends)
+	if err == nil || !strings.Contains(err.Error(), "configtest.bad") || strings.Contains(err.Error(), "configtest.good") {
+		t.Fatalf("validate error %v", err)
+	}
+
+	// 能创建的照常生效
+	defer ApplyStorageBackends(BackendSourceConfiguration, nil, nil)
+	err = LoadConfiguration(config, nil)
+	if err == nil || !strings.Contains(err.Error(), "nosuch") || !strings.Contains(err.Error(), "configtest.bad") {
+		t.Fatalf("load error %v", err)
+	}
+	if endpointOf(t, "configtest.good") != "a" {
+		t.Fatalf("good backend not loaded")
+	}
+	if _, found := GetBackendStorage("configtest.bad"); found {
+		t.Fatalf("bad backend should not be loaded")
+	}
+}
+
+func TestApplyStorageBackends(t *testing.T) {
+	defer ApplyStorageBackends(BackendSourceConfiguration, nil, nil)
+	defer ApplyStorageBackends(BackendSourceMaster, nil, nil)
+	backend := func(id, endpoint string) *master_pb.StorageBackend {
+		return &master_pb.StorageBackend{Type: "configtest", Id: id, Properties: map[string]string{"endpoint": endpoint}}
+	}
+
+	if err := ApplyStorageBackends(BackendSourceConfiguration, []*master_pb.StorageBackend{backend("default", "a"), backend("cold", "b")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if endpointOf(t, "configtest") != "a" || endpointOf(t, "configtest.cold") != "b" {
+		t.Fatalf("default alias or backend missing")
+	}
+	original, _ := GetBackendStorage("configtest.cold")
+
+	// 属性没变的不重建，变了的替换，创建失败的保留旧实例
+	failing := backend("default", "c")
+	failing.Properties["fail"] = "true"
+	err := ApplyStorageBackends(BackendSourceConfiguration, []*master_pb.StorageBackend{failing, backend("cold", "b")}, nil)
+	if err == nil || !strings.Contains(err.Error(), "configtest.default") {
+		t.Fatalf("apply error %v", err)
+	}
+	if current, _ := GetBackendStorage("configtest.cold"); current != original {
+		t.Fatalf("unchanged backend rebuilt")
+	}
+	if endpointOf(t, "configtest.default") != "a" {
+		t.Fatalf("failed update should keep old backend")
+	}
+	if err = ApplyStorageBackends(BackendSourceConfiguration, []*master_pb.StorageBackend{backend("default", "c"), backend("cold", "b")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if endpointOf(t, "configtest") != "c" {
+		t.Fatalf("changed backend not replaced")
+	}
+
+	// 另一个来源不影响配置文件中的存储
+	if err = ApplyStorageBackends(BackendSourceMaster, []*master_pb.StorageBackend{backend("pushed", "d")}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = ApplyStorageBackends(BackendSourceMaster, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := GetBackendStorage("configtest.pushed"); found {
+		t.Fatalf("pushed backend should be removed")
+	}
+
+	// 仍被卷引用的存储不移除
+	err = ApplyStorageBackends(BackendSourceConfiguration, nil, map[string]bool{"configtest.cold": true})
+	if err == nil || !strings.Contains(err.Error(), "configtest.cold") {
+		t.Fatalf("apply error %v", err)
+	}
+	if _, found := GetBackendStorage("configtest.cold"); !found {
+		t.Fatalf("backend in use removed")
+	}
+	if _, found := GetBackendStorage("configtest"); found {
+		t.Fatalf("default alias should be removed")
+	}
+	if backends := ToPbStorageBackends(); len(backends) != 1 || backends[0].Id != "cold" {
+		t.Fatalf("pb backends %v", backends)
+	}
+}
@@ -0,0 +1,125 @@
+package backend
+
+import (
+	"io"
+	"os"
+	"runtime"
+	"sync"
+)
+
+// 大量小文件并发读取时，每个 needle 的读取都是一次单独的系统调用。
+// BatchReader 把一组读请求一次提交：Linux 上优先用 io_uring，不可用时退回固定数量协程的 pread 池
+
+// BatchReadRequest 批量读取中的一项，读满 Buf 或到达文件末尾时完成
+type BatchReadRequest struct {
+	File   io.ReaderAt // *os.File 和 *DiskFile 可以走 io_uring，其他实现由 pread 池调用 ReadAt
+	Offset int64
+	Buf    []byte
+	N      int   // 实际读到的字节数
+	Err    error // 未读满时为 io.EOF 或读取错误
+}
+
+type BatchReader interface {
+	// ReadBatch 提交全部请求并等待完成，结果写回每个请求的 N 和 Err
+	ReadBatch(requests []*BatchReadRequest)
+	Close() error
+}
+
+var (
+	defaultBatchReader     BatchReader
+	defaultBatchReaderOnce sync.Once
+)
+
+// DefaultBatchReader 进程内共享的 BatchReader，随进程存在，不需要关闭
+func DefaultBatchReader() BatchReader {
+	defaultBatchReaderOnce.Do(func() {
+		defaultBatchReader = NewBatchReader(0)
+	})
+	return defaultBatchReader
+}
+
+// 可以直接拿到文件描述符的请求才能交给内核批量读取
+func requestFile(file io.ReaderAt) *os.File {
+	switch f := file.(type) {
+	case *os.File:
+		return f
+	case *DiskFile:
+		return f.File
+	}
+	return nil
+}
+
+// 读满 Buf 时忽略 EOF，和 DiskFile.ReadAt 保持一致
+func finishRequest(req *BatchReadRequest, n int, err error) {
+	if err == io.EOF && n == len(req.Buf) {
+		err = nil
+	}
+	req.N, req.Err = n, err
+}
+
+type preadTask struct {
+	req  *BatchReadRequest
+	done *sync.WaitGroup
+}
+
+// preadPool 固定数量的协程各自调用 ReadAt，批内的请求并行执行
+type preadPool struct {
+	tasks     chan preadTask
+	closeLock sync.RWMutex
+	closed    bool
+}
+
+func newPreadPool(workers int) *preadPool {
+	if workers <= 0 {
+		workers = 4 * runtime.GOMAXPROCS(0)
+	}
+	p := &preadPool{tasks: make(chan preadTask, workers)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *preadPool) work() {
+	for task := range p.tasks {
+		n, err := task.req.File.ReadAt(task.req.Buf, task.req.Offset)
+		finishRequest(task.req, n, err)
+		task.done.Done()
+	}
+}
+
+func (p *preadPool) ReadBatch(requests []*BatchReadRequest) {
+	// 只有一项时没有并行的必要，直接在当前协程读
+	if len(requests) == 1 {
+		req := requests[0]
+		n, err := req.File.ReadAt(req.Buf, req.Offset)
+		finishRequest(req, n, err)
+		return
+	}
+	var done sync.WaitGroup
+	p.submit(requests, &done)
+	done.Wait()
+}
+
+func (p *preadPool) submit(requests []*BatchReadRequest, done *sync.WaitGroup) {
+	p.closeLock.RLock()
+	defer p.closeLock.RUnlock()
+	for _, req := range requests {
+		if p.closed {
+			finishRequest(req, 0, os.ErrClosed)
+			continue
+		}
+		done.Add(1)
+		p.tasks <- preadTask{req: req, done: done}
+	}
+}
+
+func (p *preadPool) Close() error {
+	p.closeLock.Lock()
+	defer p.closeLock.Unlock()
+	if !p.closed {
+		p.closed = true
+		close(p.tasks)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+//go:build linux
+// +build linux
+
+package backend
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// io_uring 的 ABI 定义，见 include/uapi/linux/io_uring.h
+const (
+	uringEntries = 256
+
+	uringOpRead          = 22
+	uringEnterGetEvents  = 1 << 0
+	uringOffSqRing       = 0
+	uringOffCqRing       = 0x8000000
+	uringOffSqes         = 0x10000000
+	uringSqeSize         = 64
+	uringCqeSize         = 16
+	uringParamsSize      = 120
+	uringSqRingArrayElem = 4
+)
+
+type uringSqRingOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringCqRingOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCpu, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  uringSqRingOffsets
+	cqOff                                                                  uringCqRingOffsets
+}
+
+type uringSqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type uringCqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// NewBatchReader 优先使用 io_uring，内核不支持或被禁用时退回 pread 池；workers 为 0 时按 CPU 数决定
+func NewBatchReader(workers int) BatchReader {
+	pool := newPreadPool(workers)
+	r, err := newUringReader(pool)
+	if err != nil {
+		glog.V(1).Infof("io_uring unavailable, batch reads use pread: %v", err)
+		return pool
+	}
+	return r
+}
+
+// 一个读请求在 io_uring 中的状态，短读时从 done 之后继续提交
+type uringTask struct {
+	req    *BatchReadRequest
+	fd     int32
+	read   int
+	wg     *sync.WaitGroup
+	file   *os.File       // 读取完成前保持文件对象存活
+	pinner runtime.Pinner // 内核写入期间固定缓冲区
+}
+
+// 结束请求，缓冲区交还给调用方
+func (task *uringTask) finish(n int, err error) {
+	task.pinner.Unpin()
+	finishRequest(task.req, n, err)
+	task.file = nil
+	task.wg.Done()
+}
+
+// uringReader 只有一个协程操作 ring：从 tasks 收集所有调用方的请求，一次提交，再收割完成事件。
+// 没有文件描述符的请求交给 pread 池
+type uringReader struct {
+	ringFd   int
+	sqRing   []byte
+	cqRing   []byte
+	sqes     []byte
+	params   uringParams
+	tasks    chan *uringTask
+	fallback *preadPool
+
+	closeLock sync.RWMutex
+	closed    bool
+	loopDone  chan struct{}
+}
+
+func newUringReader(fallback *preadPool) (r *uringReader, err error) {
+	r = &uringReader{fallback: fallback, tasks: make(chan *uringTask, uringEntries), loopDone: make(chan struct{})}
+	if unsafe.Sizeof(r.params) != uringParamsSize || unsafe.Sizeof(uringSqe{}) != uringSqeSize || unsafe.Sizeof(uringCqe{}) != uringCqeSize {
+		return nil, fmt.Errorf("unexpected io_uring struct layout")
+	}
+	fd, _, errno := unix.Syscall(unix.SYS_IO_URING_SETUP, uringEntries, uintptr(unsafe.Pointer(&r.params)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %v", errno)
+	}
+	r.ringFd = int(fd)
+	defer func() {
+		if err != nil {
+			r.unmap()
+			unix.Close(r.ringFd)
+		}
+	}()
+
+	p := &r.params
+	sqRingSize := int(p.sqOff.array + p.sqEntries*uringSqRingArrayElem)
+	cqRingSize := int(p.cqOff.cqes + p.cqEntries*uringCqeSize)
+	if r.sqRing, err = unix.Mmap(r.ringFd, uringOffSqRing, sqRingSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return nil, fmt.Errorf("mmap io_uring sq ring: %v", err)
+	}
+	if r.cqRing, err = unix.Mmap(r.ringFd, uringOffCqRing, cqRingSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return nil, fmt.Errorf("mmap io_uring cq ring: %v", err)
+	}
+	if r.sqes, err = unix.Mmap(r.ringFd, uringOffSqes, int(p.sqEntries*uringSqeSize), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE); err != nil {
+		return nil, fmt.Errorf("mmap io_uring sqes: %v", err)
+	}
+
+	go r.loop()
+	return r, nil
+}
+
+func (r *uringReader) unmap() {
+	for _, m := range [][]byte{r.sqRing, r.cqRing, r.sqes} {
+		if m != nil {
+			unix.Munmap(m)
+		}
+	}
+	r.sqRing, r.cqRing, r.sqes = nil, nil, nil
+}
+
+func ringUint32(ring []byte, offset uint32) *uint32 {
+	return (*uint32)(unsafe.Pointer(&ring[offset]))
+}
+
+func (r *uringReader) ReadBatch(requests []*BatchReadRequest) {
+	var wg sync.WaitGroup
+	var others []*BatchReadRequest
+
+	r.closeLock.RLock()
+	for _, req := range requests {
+		file := requestFile(req.File)
+		switch {
+		case r.closed:
+			finishRequest(req, 0, os.ErrClosed)
+		case len(req.Buf) == 0:
+			finishRequest(req, 0, nil)
+		case file == nil:
+			others = append(others, req)
+		default:
+			wg.Add(1)
+			r.tasks <- &uringTask{req: req, fd: int32(file.Fd()), wg: &wg, file: file}
+		}
+	}
+	r.closeLock.RUnlock()
+
+	if len(others) > 0 {
+		r.fallback.ReadBatch(others)
+	}
+	wg.Wait()
+}
+
+func (r *uringReader) Close() error {
+	r.closeLock.Lock()
+	if r.closed {
+		r.closeLock.Unlock()
+		return nil
+	}
+	r.closed = true
+	close(r.tasks)
+	r.closeLock.Unlock()
+
+	// 等正在执行的读取全部完成后才能释放 ring
+	<-r.loopDone
+	r.unmap()
+	r.fallback.Close()
+	return unix.Close(r.ringFd)
+}
+
+func (r *uringReader) loop() {
+	defer close(r.loopDone)
+	p := &r.params
+	sqHead, sqTail := ringUint32(r.sqRing, p.sqOff.head), ringUint32(r.sqRing, p.sqOff.tail)
+	sqMask := *ringUint32(r.sqRing, p.sqOff.ringMask)
+	cqHead, cqTail := ringUint32(r.cqRing, p.cqOff.head), ringUint32(r.cqRing, p.cqOff.tail)
+	cqMask := *ringUint32(r.cqRing, p.cqOff.ringMask)
+
+	inflight := make(map[uint64]*uringTask, p.sqEntries)
+	var pending []*uringTask
+	var nextId uint64
+	var unsubmitted []uint64 // 已放进 sq ring 但内核还没有接收的请求，按放入顺序
+	closing := false
+
+	receive := func(task *uringTask, ok bool) {
+		if !ok {
+			closing = true
+			return
+		}
+		pending = append(pending, task)
+	}
+
+	for {
+		if len(inflight) == 0 && len(pending) == 0 {
+			if closing {
+				return
+			}
+			task, ok := <-r.tasks
+			receive(task, ok)
+			continue
+		}
+
+		// 把此刻排队的请求都收进来，一起提交
+	drain:
+		for !closing && len(pending) < int(p.sqEntries) {
+			select {
+			case task, ok := <-r.tasks:
+				receive(task, ok)
+			default:
+				break drain
+			}
+		}
+
+		for len(pending) > 0 && len(inflight) < int(p.sqEntries) {
+			task := pending[0]
+			pending = pending[1:]
+			nextId++
+			tail := atomic.LoadUint32(sqTail)
+			if tail-atomic.LoadUint32(sqHead) >= p.sqEntries {
+				pending = append([]*uringTask{task}, pending...)
+				break
+			}
+			index := tail & sqMask
+			sqe := (*uringSqe)(unsafe.Pointer(&r.sqes[index*uringSqeSize]))
+			buf := task.req.Buf[task.read:]
+			task.pinner.Pin(&buf[0])
+			*sqe = uringSqe{
+				opcode:   uringOpRead,
+				fd:       task.fd,
+				off:      uint64(task.req.Offset + int64(task.read)),
+				addr:     uint64(uintptr(unsafe.Pointer(&buf[0]))),
+				len:      uint32(len(buf)),
+				userData: nextId,
+			}
+			*ringUint32(r.sqRing, p.sqOff.array+index*uringSqRingArrayElem) = index
+			atomic.StoreUint32(sqTail, tail+1)
+			inflight[nextId] = task
+			unsubmitted = append(unsubmitted, nextId)
+		}
+
+		minComplete := uintptr(0)
+		if len(inflight) > 0 {
+			minComplete = 1
+		}
+		submitted, _, errno := unix.Syscall6(unix.SYS_IO_URING_ENTER, uintptr(r.ringFd), uintptr(len(unsubmitted)), minComplete, uringEnterGetEvents, 0, 0)
+		if errno == 0 {
+			unsubmitted = unsubmitted[submitted:]
+		} else if errno != syscall.EINTR && errno != syscall.EAGAIN && errno != syscall.EBUSY {
+			// 只有内核没接收的请求可以直接报错；已接收的仍可能写入缓冲区，要等到收割完成事件才能结束
+			glog.Errorf("io_uring_enter: %v", errno)
+			atomic.StoreUint32(sqTail, atomic.LoadUint32(sqTail)-uint32(len(unsubmitted)))
+			for _, id := range unsubmitted {
+				inflight[id].finish(inflight[id].read, errno)
+				delete(inflight, id)
+			}
+			unsubmitted = unsubmitted[:0]
+			for _, task := range pending {
+				task.finish(task.read, errno)
+			}
+			pending = nil
+			// 避免 ring 持续出错时空转，完成事件仍会出现在 cq ring 中
+			time.Sleep(time.Millisecond)
+		}
+
+		head, tail := atomic.LoadUint32(cqHead), atomic.LoadUint32(cqTail)
+		for ; head != tail; head++ {
+			cqe := (*uringCqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes+(head&cqMask)*uringCqeSize]))
+			task, found := inflight[cqe.userData]
+			if !found {
+				continue
+			}
+			delete(inflight, cqe.userData)
+			switch {
+			case cqe.res < 0:
+				task.finish(task.read, syscall.Errno(-cqe.res))
+			case cqe.res == 0:
+				task.finish(task.read, io.EOF)
+			default:
+				task.read += int(cqe.res)
+				if task.read < len(task.req.Buf) {
+					// 短读，剩下的部分重新提交
+					task.pinner.Unpin()
+					pending = append(pending, task)
+					continue
+				}
+				task.finish(task.read, nil)
+			}
+		}
+		atomic.StoreUint32(cqHead, head)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package backend
+
+// NewBatchReader workers 为 0 时按 CPU 数决定协程数
+func NewBatchReader(workers int) BatchReader {
+	return newPreadPool(workers)
+}
@@ -0,0 +1,149 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func newBatchReadTestFile(t testing.TB, size int) (*os.File, []byte) {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	fileName := filepath.Join(t.TempDir(), "1.dat")
+	if err := os.WriteFile(fileName, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f, data
+}
+
+func batchReaders() map[string]func() BatchReader {
+	return map[string]func() BatchReader{
+		"default": func() BatchReader { return NewBatchReader(0) },
+		"pread":   func() BatchReader { return newPreadPool(0) },
+	}
+}
+
+func TestBatchReader(t *testing.T) {
+	f, data := newBatchReadTestFile(t, 1024*1024)
+	for name, newReader := range batchReaders() {
+		t.Run(name, func(t *testing.T) {
+			br := newReader()
+			r := rand.New(rand.NewSource(2))
+			var requests []*BatchReadRequest
+			for i := 0; i < 1000; i++ {
+				offset := r.Intn(len(data) - 4096)
+				var file io.ReaderAt = f
+				switch i % 3 {
+				case 1:
+					file = NewDiskFile(f)
+				case 2:
+					file = bytes.NewReader(data)
+				}
+				requests = append(requests, &BatchReadRequest{File: file, Offset: int64(offset), Buf: make([]byte, 1+r.Intn(4096))})
+			}
+			// 跨过文件末尾和空请求
+			tail := &BatchReadRequest{File: f, Offset: int64(len(data) - 10), Buf: make([]byte, 100)}
+			empty := &BatchReadRequest{File: f, Offset: 0}
+			requests = append(requests, tail, empty)
+
+			br.ReadBatch(requests)
+			for _, req := range requests[:1000] {
+				if req.Err != nil || req.N != len(req.Buf) || !bytes.Equal(req.Buf, data[req.Offset:req.Offset+int64(req.N)]) {
+					t.Fatalf("read %d bytes at %d: n %d %v", len(req.Buf), req.Offset, req.N, req.Err)
+				}
+			}
+			if tail.N != 10 || tail.Err != io.EOF || !bytes.Equal(tail.Buf[:10], data[len(data)-10:]) {
+				t.Fatalf("read across end: n %d %v", tail.N, tail.Err)
+			}
+			if empty.N != 0 || empty.Err != nil {
+				t.Fatalf("empty read: n %d %v", empty.N, empty.Err)
+			}
+
+			if err := br.Close(); err != nil {
+				t.Fatalf("close: %v", err)
+			}
+			closed := &BatchReadRequest{File: f, Buf: make([]byte, 10)}
+			br.ReadBatch([]*BatchReadRequest{closed, {File: f, Buf: make([]byte, 10)}})
+			if closed.Err != os.ErrClosed {
+				t.Fatalf("read after close: %v", closed.Err)
+			}
+		})
+	}
+}
+
+const (
+	benchReadSize    = 4096
+	benchConcurrency = 2048
+	benchBatchSize   = 16
+)
+
+// 2048 个协程同时发起小块读取，每个批次 16 项
+func benchmarkConcurrentSmallReads(b *testing.B, read func(f *os.File, requests []*BatchReadRequest)) {
+	f, data := newBatchReadTestFile(b, 64*1024*1024)
+	blocks := len(data) / benchReadSize
+	b.SetBytes(benchReadSize * benchBatchSize)
+	b.ResetTimer()
+
+	var next sync.WaitGroup
+	work := make(chan int)
+	for g := 0; g < benchConcurrency; g++ {
+		next.Add(1)
+		go func(seed int64) {
+			defer next.Done()
+			r := rand.New(rand.NewSource(seed))
+			requests := make([]*BatchReadRequest, benchBatchSize)
+			for i := range requests {
+				requests[i] = &BatchReadRequest{File: f, Buf: make([]byte, benchReadSize)}
+			}
+			for range work {
+				for _, req := range requests {
+					req.Offset = int64(r.Intn(blocks) * benchReadSize)
+				}
+				read(f, requests)
+				for _, req := range requests {
+					if req.Err != nil {
+						b.Error(req.Err)
+					}
+				}
+			}
+		}(int64(g))
+	}
+	for i := 0; i < b.N; i++ {
+		work <- i
+	}
+	close(work)
+	next.Wait()
+}
+
+func BenchmarkConcurrentSmallReadsReadAt(b *testing.B) {
+	benchmarkConcurrentSmallReads(b, func(f *os.File, requests []*BatchReadRequest) {
+		for _, req := range requests {
+			req.N, req.Err = f.ReadAt(req.Buf, req.Offset)
+		}
+	})
+}
+
+func BenchmarkConcurrentSmallReadsPread(b *testing.B) {
+	br := newPreadPool(0)
+	defer br.Close()
+	benchmarkConcurrentSmallReads(b, func(f *os.File, requests []*BatchReadRequest) {
+		br.ReadBatch(requests)
+	})
+}
+
+func BenchmarkConcurrentSmallReadsBatchReader(b *testing.B) {
+	br := NewBatchReader(0)
+	defer br.Close()
+	benchmarkConcurrentSmallReads(b, func(f *os.File, requests []*BatchReadRequest) {
+		br.ReadBatch(requests)
+	})
+}
@@ -0,0 +1,208 @@
+package backend
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	_ BackendStorageFile = &CachedRemoteFile{}
+)
+
+type RemoteCacheOption struct {
+	BlockSize       int64 // 回源时按块对齐读取
+	ReadAheadBlocks int   // 检测到连续顺序读取后预读的块数，0 表示不预读
+}
+
+var DefaultRemoteCacheOption = RemoteCacheOption{
+	BlockSize:       1024 * 1024,
+	ReadAheadBlocks: 4,
+}
+
+// 连续这么多次读取首尾相接才认为是顺序读，逐个读取 needle 时头部和数据体也是相接的，不能只看一次
+const sequentialReadThreshold = 2
+
+// CachedRemoteFile 包装只读的远程文件，读取按块对齐并经过 RemoteBlockCache；
+// 文件大小和修改时间在打开时取一次，之后不再访问远程
+type CachedRemoteFile struct {
+	remote  BackendStorageFile
+	cache   *RemoteBlockCache
+	option  RemoteCacheOption
+	fileKey string
+	size    int64
+	modTime time.Time
+
+	fetchLock sync.Mutex
+	fetching  map[int64]*blockFetch // 正在回源的块，同一个块只发一次请求
+	fetchWg   sync.WaitGroup
+
+	sequentialLock  sync.Mutex
+	lastReadEnd     int64
+	sequentialReads int
+}
+
+type blockFetch struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+func NewCachedRemoteFile(remote BackendStorageFile, cache *RemoteBlockCache, option RemoteCacheOption) (*CachedRemoteFile, error) {
+	if option.BlockSize <= 0 {
+		return nil, fmt.Errorf("invalid block size %d", option.BlockSize)
+	}
+	size, modTime, err := remote.GetStat()
+	if err != nil {
+		return nil, fmt.Errorf("stat remote file %s: %v", remote.Name(), err)
+	}
+	return &CachedRemoteFile{
+		remote:      remote,
+		cache:       cache,
+		option:      option,
+		fileKey:     remoteBlockFileKey(remote.Name(), size, modTime),
+		size:        size,
+		modTime:     modTime,
+		fetching:    make(map[int64]*blockFetch),
+		lastReadEnd: -1,
+	}, nil
+}
+
+func (f *CachedRemoteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if off >= f.size {
+		return 0, io.EOF
+	}
+	end := off + int64(len(p))
+	if end > f.size {
+		end = f.size
+	}
+	f.maybeReadAhead(off, end)
+
+	// 先把缺的块都发出去，跨多个块的读取只等最慢的一个
+	firstBlock, lastBlock := off/f.option.BlockSize, (end-1)/f.option.BlockSize
+	blocks := make([][]byte, lastBlock-firstBlock+1)
+	fetches := make([]*blockFetch, len(blocks))
+	for i := range blocks {
+		if data, found := f.cache.getBlock(remoteBlockKey(f.fileKey, firstBlock+int64(i))); found {
+			blocks[i] = data
+		} else {
+			fetches[i] = f.fetch(firstBlock + int64(i))
+		}
+	}
+	for i, fetch := range fetches {
+		if fetch == nil {
+			continue
+		}
+		<-fetch.done
+		if fetch.err != nil {
+			return 0, fetch.err
+		}
+		blocks[i] = fetch.data
+	}
+
+	for pos := off; pos < end; {
+		index := pos / f.option.BlockSize
+		copied := copy(p[pos-off:end-off], blocks[index-firstBlock][pos-index*f.option.BlockSize:])
+		pos += int64(copied)
+		n += copied
+	}
+	if n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// fetch 返回块的回源请求，已经在进行中的直接复用
+func (f *CachedRemoteFile) fetch(index int64) *blockFetch {
+	f.fetchLock.Lock()
+	defer f.fetchLock.Unlock()
+	if bf, found := f.fetching[index]; found {
+		return bf
+	}
+	bf := &blockFetch{done: make(chan struct{})}
+	f.fetching[index] = bf
+	f.fetchWg.Add(1)
+	go func() {
+		defer f.fetchWg.Done()
+		start := index * f.option.BlockSize
+		length := f.option.BlockSize
+		if start+length > f.size {
+			length = f.size - start
+		}
+		data := make([]byte, length)
+		n, err := f.remote.ReadAt(data, start)
+		if err == io.EOF && n == len(data) {
+			err = nil
+		}
+		if err == nil && n < len(data) {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			bf.err = fmt.Errorf("read remote file %s block %d: %v", f.remote.Name(), index, err)
+		} else {
+			bf.data = data
+			// 先放进缓存再移出 fetching，并发的读取总能看到其中一个
+			f.cache.putBlock(remoteBlockKey(f.fileKey, index), data)
+		}
+
+		f.fetchLock.Lock()
+		delete(f.fetching, index)
+		f.fetchLock.Unlock()
+		close(bf.done)
+	}()
+	return bf
+}
+
+// 连续的顺序读取之后，提前把后面几个块取回来
+func (f *CachedRemoteFile) maybeReadAhead(off, end int64) {
+	if f.option.ReadAheadBlocks <= 0 {
+		return
+	}
+	f.sequentialLock.Lock()
+	if off == f.lastReadEnd {
+		f.sequentialReads++
+	} else {
+		f.sequentialReads = 0
+	}
+	f.lastReadEnd = end
+	sequential := f.sequentialReads >= sequentialReadThreshold
+	f.sequentialLock.Unlock()
+	if !sequential {
+		return
+	}
+
+	lastBlock := (f.size - 1) / f.option.BlockSize
+	next := (end-1)/f.option.BlockSize + 1
+	for index := next; index < next+int64(f.option.ReadAheadBlocks) && index <= lastBlock; index++ {
+		if !f.cache.hasBlock(remoteBlockKey(f.fileKey, index)) {
+			f.fetch(index)
+		}
+	}
+}
+
+func (f *CachedRemoteFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, fmt.Errorf("remote file %s is read only", f.remote.Name())
+}
+
+func (f *CachedRemoteFile) Truncate(off int64) error {
+	return fmt.Errorf("remote file %s is read only", f.remote.Name())
+}
+
+// Close 等预读结束后关闭远程文件，缓存的块留给之后重新打开时使用
+func (f *CachedRemoteFile) Close() error {
+	f.fetchWg.Wait()
+	return f.remote.Close()
+}
+
+func (f *CachedRemoteFile) GetStat() (datSize int64, modTime time.Time, err error) {
+	return f.size, f.modTime, nil
+}
+
+func (f *CachedRemoteFile) Name() string {
+	return f.remote.Name()
+}
+
+func (f *CachedRemoteFile) Sync() error {
+	return nil
+}
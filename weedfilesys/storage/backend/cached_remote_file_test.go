@@ -0,0 +1,233 @@
+package backend
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 内存中的远程文件，统计回源次数
+type countingRemoteFile struct {
+	data    []byte
+	modTime int64 // 秒，0 时为固定的时间
+	reads   atomic.Int64
+	stats   atomic.Int64
+}
+
+func (f *countingRemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	f.reads.Add(1)
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+func (f *countingRemoteFile) WriteAt(p []byte, off int64) (int, error) { return 0, os.ErrPermission }
+func (f *countingRemoteFile) Truncate(off int64) error                 { return os.ErrPermission }
+func (f *countingRemoteFile) Close() error                             { return nil }
+func (f *countingRemoteFile) GetStat() (int64, time.Time, error) {
+	f.stats.Add(1)
+	return int64(len(f.data)), time.Unix(1700000000+f.modTime, 0), nil
+}
+func (f *countingRemoteFile) Name() string { return "remote/1.dat" }
+func (f *countingRemoteFile) Sync() error  { return nil }
+
+func newCountingRemoteFile(size int) *countingRemoteFile {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(data)
+	return &countingRemoteFile{data: data}
+}
+
+func TestCachedRemoteFileAlignedBlocks(t *testing.T) {
+	remote := newCountingRemoteFile(10*4096 + 100)
+	cache, _ := NewRemoteBlockCache("", 1024*1024, 0)
+	f, err := NewCachedRemoteFile(remote, cache, RemoteCacheOption{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 同一个块内的多次小读取只回源一次
+	buf := make([]byte, 16)
+	for off := int64(100); off < 4000; off += 300 {
+		if _, err = f.ReadAt(buf, off); err != nil || !bytes.Equal(buf, remote.data[off:off+16]) {
+			t.Fatalf("read at %d: %v", off, err)
+		}
+	}
+	if reads := remote.reads.Load(); reads != 1 {
+		t.Fatalf("%d remote reads, expected 1", reads)
+	}
+
+	// 跨块读取，以及文件末尾不足一块
+	buf = make([]byte, 3*4096)
+	if _, err = f.ReadAt(buf, 4000); err != nil || !bytes.Equal(buf, remote.data[4000:4000+3*4096]) {
+		t.Fatalf("read across blocks: %v", err)
+	}
+	n, err := f.ReadAt(buf, int64(len(remote.data))-50)
+	if n != 50 || err != io.EOF || !bytes.Equal(buf[:50], remote.data[len(remote.data)-50:]) {
+		t.Fatalf("read at end: %d %v", n, err)
+	}
+	if _, err = f.ReadAt(buf, int64(len(remote.data))); err != io.EOF {
+		t.Fatalf("read past end: %v", err)
+	}
+	if stats := remote.stats.Load(); stats != 1 {
+		t.Fatalf("%d remote stats, expected 1", stats)
+	}
+	if size, _, _ := f.GetStat(); size != int64(len(remote.data)) {
+		t.Fatalf("size %d", size)
+	}
+}
+
+func TestCachedRemoteFileReadAhead(t *testing.T) {
+	remote := newCountingRemoteFile(64 * 4096)
+	cache, _ := NewRemoteBlockCache("", 1024*1024, 0)
+	f, err := NewCachedRemoteFile(remote, cache, RemoteCacheOption{BlockSize: 4096, ReadAheadBlocks: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 随机读取不预读
+	buf := make([]byte, 100)
+	for _, off := range []int64{40000, 10000, 150000} {
+		f.ReadAt(buf, off)
+	}
+	f.fetchWg.Wait()
+	if reads := remote.reads.Load(); reads != 3 {
+		t.Fatalf("%d remote reads for random access, expected 3", reads)
+	}
+
+	// 连续读取之后，后面的块已经预读进缓存
+	for off := int64(0); off < 3*100; off += 100 {
+		f.ReadAt(buf, off)
+	}
+	f.fetchWg.Wait()
+	for index := int64(1); index <= 4; index++ {
+		if !cache.hasBlock(remoteBlockKey(f.fileKey, index)) {
+			t.Fatalf("block %d not read ahead", index)
+		}
+	}
+	before := remote.reads.Load()
+	buf = make([]byte, 4*4096)
+	if _, err = f.ReadAt(buf, 4096); err != nil || !bytes.Equal(buf, remote.data[4096:5*4096]) {
+		t.Fatalf("read prefetched blocks: %v", err)
+	}
+	if reads := remote.reads.Load(); reads != before {
+		t.Fatalf("%d remote reads for prefetched blocks", reads-before)
+	}
+	f.Close()
+}
+
+func TestRemoteBlockCacheDiskBound(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "stale.blk"), []byte("stale"), 0644)
+	os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("keep"), 0644)
+
+	// 内存只能放一个块，磁盘能放四个
+	cache, err := NewRemoteBlockCache(dir, 4096, 4*4096)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "stale.blk")); !os.IsNotExist(err) {
+		t.Fatalf("stale block not removed")
+	}
+	if _, err = os.Stat(filepath.Join(dir, "keep.txt")); err != nil {
+		t.Fatalf("unrelated file removed: %v", err)
+	}
+
+	remote := newCountingRemoteFile(16 * 4096)
+	f, err := NewCachedRemoteFile(remote, cache, RemoteCacheOption{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	buf := make([]byte, 10)
+	for index := int64(0); index < 8; index++ {
+		f.ReadAt(buf, index*4096)
+	}
+	blocks, _ := filepath.Glob(filepath.Join(dir, "*.blk"))
+	if len(blocks) != 4 {
+		t.Fatalf("%d blocks on disk, expected 4", len(blocks))
+	}
+
+	// 最近的块从磁盘命中，最早的块已被淘汰需要回源
+	before := remote.reads.Load()
+	if _, err = f.ReadAt(buf, 6*4096); err != nil || !bytes.Equal(buf, remote.data[6*4096:6*4096+10]) {
+		t.Fatalf("read disk cached block: %v", err)
+	}
+	if remote.reads.Load() != before {
+		t.Fatalf("disk cached block fetched from remote")
+	}
+	f.ReadAt(buf, 0)
+	if remote.reads.Load() != before+1 {
+		t.Fatalf("evicted block not fetched from remote")
+	}
+}
+
+// 同名的远程文件重新上传后，缓存中旧文件的块不能被读到
+func TestCachedRemoteFileReuploadedName(t *testing.T) {
+	cache, _ := NewRemoteBlockCache("", 1024*1024, 0)
+	old := newCountingRemoteFile(4 * 4096)
+	f, err := NewCachedRemoteFile(old, cache, RemoteCacheOption{BlockSize: 4096})
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4*4096)
+	if _, err = f.ReadAt(buf, 0); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	data := make([]byte, len(old.data))
+	rand.New(rand.NewSource(2)).Read(data)
+	for _, remote := range []*countingRemoteFile{
+		{data: data, modTime: 60},
+		{data: data[:3*4096]},
+	} {
+		f, err = NewCachedRemoteFile(remote, cache, RemoteCacheOption{BlockSize: 4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, _ := f.ReadAt(buf, 0)
+		if !bytes.Equal(buf[:n], remote.data) {
+			t.Fatalf("read stale blocks of size %d", len(remote.data))
+		}
+		f.Close()
+	}
+}
+
+func TestCachedRemoteFileConcurrentReads(t *testing.T) {
+	remote := newCountingRemoteFile(32 * 4096)
+	cache, _ := NewRemoteBlockCache(t.TempDir(), 8*4096, 16*4096)
+	f, err := NewCachedRemoteFile(remote, cache, RemoteCacheOption{BlockSize: 4096, ReadAheadBlocks: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var wg sync.WaitGroup
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			buf := make([]byte, 1000)
+			for i := 0; i < 200; i++ {
+				off := int64(r.Intn(len(remote.data) - len(buf)))
+				if _, err := f.ReadAt(buf, off); err != nil || !bytes.Equal(buf, remote.data[off:off+int64(len(buf))]) {
+					t.Errorf("read at %d: %v", off, err)
+					return
+				}
+			}
+		}(int64(g))
+	}
+	wg.Wait()
+}
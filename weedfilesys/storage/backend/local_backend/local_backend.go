@@ -0,0 +1,213 @@
+package local_backend
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// local 后端把分层的卷文件存到本机的另一个目录，例如便宜的 HDD 挂载点，也方便在没有网络时测试分层流程。
+// 配置示例：
+//
+//	[storage.backend.local.default]
+//	enabled = true
+//	dir = "/mnt/cold"
+
+func init() {
+	backend.BackendStorageFactories["local"] = &LocalBackendFactory{}
+}
+
+type LocalBackendFactory struct {
+}
+
+func (factory *LocalBackendFactory) StorageType() backend.StorageType {
+	return backend.StorageType("local")
+}
+func (factory *LocalBackendFactory) BuildStorage(configuration backend.StringProperties, configPrefix string, id string) (backend.BackendStorage, error) {
+	return newLocalBackendStorage(configuration, configPrefix, id)
+}
+
+type LocalBackendStorage struct {
+	id  string
+	dir string
+}
+
+func newLocalBackendStorage(configuration backend.StringProperties, configPrefix string, id string) (*LocalBackendStorage, error) {
+	s := &LocalBackendStorage{
+		id:  id,
+		dir: configuration.GetString(configPrefix + "dir"),
+	}
+	if s.dir == "" {
+		return nil, fmt.Errorf("local backend %s: dir is not set", id)
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, fmt.Errorf("local backend %s: %v", id, err)
+	}
+	glog.V(0).Infof("created backend storage local.%s in %s", s.id, s.dir)
+	return s, nil
+}
+
+func (s *LocalBackendStorage) ToProperties() map[string]string {
+	return map[string]string{"dir": s.dir}
+}
+
+// key 当作目录内的相对路径，不允许通过 .. 跳出目录
+func (s *LocalBackendStorage) filePath(key string) string {
+	return filepath.Join(s.dir, filepath.Clean("/"+key))
+}
+
+func (s *LocalBackendStorage) NewStorageFile(key string, tierInfo *volume_server_pb.VolumeInfo) backend.BackendStorageFile {
+	f := &LocalBackendStorageFile{key: key, tierInfo: tierInfo}
+	f.file, f.openErr = os.Open(s.filePath(key))
+	return f
+}
+
+// CopyFile 先写临时文件再改名，中途失败不会留下不完整的文件
+func (s *LocalBackendStorage) CopyFile(f *os.File, fn func(progressed int64, percentage float32) error) (key string, size int64, err error) {
+	randomUuid, _ := uuid.NewRandom()
+	key = randomUuid.String()
+	glog.V(1).Infof("copying dat file of %s to local.%s as %s", f.Name(), s.id, key)
+
+	stat, err := f.Stat()
+	if err != nil {
+		return "", 0, err
+	}
+	if size, err = copyFileWithProgress(s.filePath(key), io.NewSectionReader(f, 0, stat.Size()), stat.Size(), fn); err != nil {
+		return "", 0, err
+	}
+	return key, size, nil
+}
+
+func (s *LocalBackendStorage) DownloadFile(fileName string, key string, fn func(progressed int64, percentage float32) error) (size int64, err error) {
+	glog.V(1).Infof("download dat file of %s from local.%s as %s", fileName, s.id, key)
+
+	src, err := os.Open(s.filePath(key))
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+	stat, err := src.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return copyFileWithProgress(fileName, src, stat.Size(), fn)
+}
+
+func (s *LocalBackendStorage) DeleteFile(key string) error {
+	glog.V(1).Infof("delete dat file %s from local.%s", key, s.id)
+	err := os.Remove(s.filePath(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func copyFileWithProgress(dstFileName string, src io.Reader, total int64, fn func(progressed int64, percentage float32) error) (written int64, err error) {
+	tmpFileName := dstFileName + ".tmp"
+	dst, err := os.OpenFile(tmpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			os.Remove(tmpFileName)
+		}
+	}()
+
+	buf := make([]byte, 4*1024*1024)
+	for {
+		n, readErr := src.Read(buf)
+		if n > 0 {
+			if _, err = dst.Write(buf[:n]); err != nil {
+				return 0, err
+			}
+			written += int64(n)
+			percentage := float32(100)
+			if total > 0 {
+				percentage = float32(written*100) / float32(total)
+			}
+			if err = fn(written, percentage); err != nil {
+				return 0, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return 0, readErr
+		}
+	}
+	if err = dst.Sync(); err != nil {
+		return 0, err
+	}
+	if err = dst.Close(); err != nil {
+		return 0, err
+	}
+	return written, os.Rename(tmpFileName, dstFileName)
+}
+
+// LocalBackendStorageFile 只读，分层之后卷不再追加
+type LocalBackendStorageFile struct {
+	key      string
+	tierInfo *volume_server_pb.VolumeInfo
+	file     *os.File
+	openErr  error
+}
+
+func (f *LocalBackendStorageFile) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.openErr != nil {
+		return 0, f.openErr
+	}
+	n, err = f.file.ReadAt(p, off)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+	return
+}
+
+func (f *LocalBackendStorageFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, fmt.Errorf("local backend file %s is read only", f.key)
+}
+
+func (f *LocalBackendStorageFile) Truncate(off int64) error {
+	return fmt.Errorf("local backend file %s is read only", f.key)
+}
+
+func (f *LocalBackendStorageFile) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file, f.openErr = nil, os.ErrClosed
+	return err
+}
+
+// GetStat 以 .vif 中记录的大小为准，和 s3 后端一致
+func (f *LocalBackendStorageFile) GetStat() (datSize int64, modTime time.Time, err error) {
+	if f.openErr != nil {
+		return 0, time.Time{}, f.openErr
+	}
+	if files := f.tierInfo.GetFiles(); len(files) > 0 {
+		return int64(files[0].FileSize), time.Unix(int64(files[0].ModifiedTime), 0), nil
+	}
+	stat, err := f.file.Stat()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return stat.Size(), stat.ModTime(), nil
+}
+
+func (f *LocalBackendStorageFile) Name() string {
+	return f.key
+}
+
+func (f *LocalBackendStorageFile) Sync() error {
+	return nil
+}
@@ -0,0 +1,82 @@
+package local_backend
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/storage/backend/s3_backend/fake_s3"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalBackendCopyReadDownloadDelete(t *testing.T) {
+	if _, err := newLocalBackendStorage(fake_s3.Properties{}, "", "default"); err == nil {
+		t.Fatalf("expect error without dir")
+	}
+	remoteDir := t.TempDir()
+	storage, err := newLocalBackendStorage(fake_s3.Properties{"storage.dir": remoteDir}, "storage.", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	src := filepath.Join(dir, "1.dat")
+	if err = os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var progressed int64
+	fn := func(p int64, _ float32) error {
+		progressed = p
+		return nil
+	}
+	key, size, err := storage.CopyFile(f, fn)
+	if err != nil || size != int64(len(data)) || progressed != size {
+		t.Fatalf("copy: %v size %d progressed %d", err, size, progressed)
+	}
+	if entries, _ := os.ReadDir(remoteDir); len(entries) != 1 {
+		t.Fatalf("expect only the copied file, got %d entries", len(entries))
+	}
+
+	remote := storage.NewStorageFile(key, nil)
+	datSize, _, err := remote.GetStat()
+	if err != nil || datSize != size {
+		t.Fatalf("stat: %v size %d", err, datSize)
+	}
+	buf := make([]byte, 20)
+	if n, err := remote.ReadAt(buf, size-10); n != 10 || err != io.EOF || !bytes.Equal(buf[:10], data[len(data)-10:]) {
+		t.Fatalf("read at end: %d %v", n, err)
+	}
+	if _, err = remote.WriteAt(buf, 0); err == nil {
+		t.Fatalf("expect read only")
+	}
+	remote.Close()
+
+	dst := filepath.Join(dir, "2.dat")
+	if size, err = storage.DownloadFile(dst, key, fn); err != nil || size != int64(len(data)) {
+		t.Fatalf("download: %v size %d", err, size)
+	}
+	if downloaded, _ := os.ReadFile(dst); !bytes.Equal(downloaded, data) {
+		t.Fatalf("downloaded file mismatch")
+	}
+
+	// key 不能跳出存储目录
+	if got := storage.filePath("../../etc/passwd"); filepath.Dir(got) != filepath.Join(remoteDir, "etc") {
+		t.Fatalf("escaped path %s", got)
+	}
+	if err = storage.DeleteFile(key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if err = storage.DeleteFile(key); err != nil {
+		t.Fatalf("delete missing: %v", err)
+	}
+	if _, _, err = storage.NewStorageFile(key, nil).GetStat(); !os.IsNotExist(err) {
+		t.Fatalf("stat deleted: %v", err)
+	}
+}
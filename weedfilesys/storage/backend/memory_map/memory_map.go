@@ -3,6 +3,7 @@ package memory_map
 import (
 	"os"
 	"strconv"
+	"sync"
 )
 
 // 内存映射（memory mapping）文件写入的结构体定义及一个配置解析函数，主要用于对大文件进行高效读写（尤其是写入）
@@ -22,6 +23,7 @@ type MemoryMap struct {
 	write_map_views        []MemoryBuffer // 多个 memory buffer，用于映射视图（可多段）
 	max_length             uint64         // 最大可映射长度
 	End_of_file            int64          // 当前文件的结尾位置（可能小于 max_length）
+	lock                   sync.RWMutex   // 扩大映射时地址可能变化，读写期间持读锁，重新映射和关闭时持写锁
 }
 
 // input: "512"   => 输出: 512, nil
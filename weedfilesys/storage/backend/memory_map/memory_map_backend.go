@@ -6,60 +6,80 @@ import (
 )
 
 type MemoryMappedFile struct {
-	mm *MemoryMap
+	mm           *MemoryMap
+	fullFilePath string
+	modTime      time.Time
 }
 
 // 接收一个打开的文件 f，和最大映射大小（单位 MB）。
 // 初始化一个 MemoryMap 对象，并调用 CreateMemoryMap() 将文件内容映射到内存中。
-func NewMemoryMappedFile(f *os.File, memoryMapSizeMB uint32) *MemoryMappedFile {
+// 映射失败时 f 保持打开，由调用方决定是否退回普通文件
+func NewMemoryMappedFile(f *os.File, memoryMapSizeMB uint32) (*MemoryMappedFile, error) {
+	stat, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
 	mmf := &MemoryMappedFile{
-		mm: new(MemoryMap),
+		mm:           new(MemoryMap),
+		fullFilePath: f.Name(),
+		modTime:      stat.ModTime(),
+	}
+	if err = mmf.mm.CreateMemoryMap(f, 1024*1024*uint64(memoryMapSizeMB)); err != nil {
+		return nil, err
 	}
-	mmf.mm.CreateMemoryMap(f, 1024*1024*uint64(memoryMapSizeMB))
-	return mmf
+	return mmf, nil
 }
 
 // 在偏移 off 处读取 len(p) 个字节。
-// 调用底层 MemoryMap.ReadMemory() 从内存映射区读取数据。
-// 注意：存在一次 copy()，可以优化为 zero-copy（有待 TODO）。
+// 数据在持锁期间从映射区拷贝到 p，映射扩大导致地址变化时也不会读到失效的内存。
 func (mmf *MemoryMappedFile) ReadAt(p []byte, off int64) (n int, err error) {
-	readBytes, e := mmf.mm.ReadMemory(uint64(off), uint64(len(p)))
-	if e != nil {
-		return 0, e
-	}
-	copy(p, readBytes) // 把 mmap 读取的内容复制到目标缓冲区
-	return len(readBytes), nil
+	return mmf.mm.ReadMemoryAt(p, uint64(off))
 }
 
 // 将 p 中的字节数据写入映射文件的偏移位置 off。
-// 实际是修改了内存中映射的那一段空间，写入非常快（零拷贝）。
+// 实际是修改了内存中映射的那一段空间，超出文件末尾时先扩大文件和映射。
 func (mmf *MemoryMappedFile) WriteAt(p []byte, off int64) (n int, err error) {
-	mmf.mm.WriteMemory(uint64(off), uint64(len(p)), p)
+	if err = mmf.mm.WriteMemory(uint64(off), uint64(len(p)), p); err != nil {
+		return 0, err
+	}
+	mmf.touch()
 	return len(p), nil
 }
 
 func (mmf *MemoryMappedFile) Truncate(off int64) error {
+	if err := mmf.mm.TruncateMemory(uint64(off)); err != nil {
+		return err
+	}
+	mmf.touch()
 	return nil
 }
+
+// modTime 和 GetStat 一样在 mm.lock 下读写
+func (mmf *MemoryMappedFile) touch() {
+	mmf.mm.lock.Lock()
+	mmf.modTime = time.Now()
+	mmf.mm.lock.Unlock()
+}
+
 func (mmf *MemoryMappedFile) Close() error {
-	mmf.mm.DeleteFileAndMemoryMap()
-	return nil
+	return mmf.mm.CloseMemoryMap()
 }
 
 // 获取文件状态（大小、修改时间）。
 // 返回的大小为 End_of_file + 1，即当前写入结束位置。
 func (mmf *MemoryMappedFile) GetStat() (datSize int64, modTime time.Time, err error) {
-	stat, e := mmf.mm.File.Stat()
-	if e == nil {
-		return mmf.mm.End_of_file + 1, stat.ModTime(), nil
+	mmf.mm.lock.RLock()
+	defer mmf.mm.lock.RUnlock()
+	if len(mmf.mm.write_map_views) == 0 {
+		return 0, time.Time{}, os.ErrClosed
 	}
-	return 0, time.Time{}, err
+	return mmf.mm.End_of_file + 1, mmf.modTime, nil
 }
 
 func (mmf *MemoryMappedFile) Name() string {
-	return mmf.mm.File.Name()
+	return mmf.fullFilePath
 }
 
-func (mm *MemoryMappedFile) Sync() error {
-	return nil
+func (mmf *MemoryMappedFile) Sync() error {
+	return mmf.mm.SyncMemory()
 }
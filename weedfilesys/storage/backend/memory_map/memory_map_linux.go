@@ -0,0 +1,205 @@
+//go:build linux
+// +build linux
+
+package memory_map
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// 首次映射的最小长度，之后空间不够时按倍数扩大，最多到 max_length
+const minMapLength = 1024 * 1024
+
+var pageSize = uint64(os.Getpagesize())
+
+func alignToPage(n uint64) uint64 {
+	return (n + pageSize - 1) / pageSize * pageSize
+}
+
+// CreateMemoryMap 以 MAP_SHARED 映射整个文件，写入直接落到页缓存。
+// 文件本身只按实际写入的位置增长，映射区域单独按需扩大，超过 maxLength 的写入返回错误
+func (mMap *MemoryMap) CreateMemoryMap(file *os.File, maxLength uint64) error {
+	if maxLength == 0 {
+		return fmt.Errorf("memory map size of %s is 0", file.Name())
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", file.Name(), err)
+	}
+	if uint64(stat.Size()) > maxLength {
+		return fmt.Errorf("%s size %d exceeds memory map size %d", file.Name(), stat.Size(), maxLength)
+	}
+
+	mMap.lock.Lock()
+	defer mMap.lock.Unlock()
+	mMap.File = file
+	mMap.max_length = maxLength
+	mMap.End_of_file = stat.Size() - 1
+	return mMap.growMapping(uint64(stat.Size()))
+}
+
+// 只用一个视图覆盖 [0, aligned_length)，调用方需持有写锁
+func (mMap *MemoryMap) growMapping(size uint64) error {
+	if size > mMap.max_length {
+		return fmt.Errorf("%s: offset %d exceeds memory map size %d", mMap.File.Name(), size, mMap.max_length)
+	}
+	var current uint64
+	if len(mMap.write_map_views) > 0 {
+		current = mMap.write_map_views[0].aligned_length
+		if size <= current {
+			return nil
+		}
+	}
+
+	length := uint64(minMapLength)
+	if current > 0 {
+		length = current
+	}
+	for length < size {
+		length *= 2
+	}
+	length = alignToPage(length)
+	if limit := alignToPage(mMap.max_length); length > limit {
+		length = limit
+	}
+
+	var data []byte
+	var err error
+	if current == 0 {
+		data, err = unix.Mmap(int(mMap.File.Fd()), 0, int(length), unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	} else {
+		// 地址可能移动，已有的数据不受影响
+		data, err = unix.Mremap(mMap.write_map_views[0].Buffer, int(length), unix.MREMAP_MAYMOVE)
+	}
+	if err != nil {
+		return fmt.Errorf("map %s to %d bytes: %v", mMap.File.Name(), length, err)
+	}
+	mMap.write_map_views = []MemoryBuffer{{
+		aligned_length: length,
+		length:         size,
+		Buffer:         data,
+	}}
+	return nil
+}
+
+// 文件先用 ftruncate 扩到写入的末尾，否则访问文件末尾之后的映射页会触发 SIGBUS
+func (mMap *MemoryMap) extendFile(end uint64) error {
+	if int64(end) <= mMap.End_of_file+1 {
+		return nil
+	}
+	if err := mMap.growMapping(end); err != nil {
+		return err
+	}
+	if err := mMap.File.Truncate(int64(end)); err != nil {
+		return fmt.Errorf("extend %s to %d: %v", mMap.File.Name(), end, err)
+	}
+	mMap.End_of_file = int64(end) - 1
+	mMap.write_map_views[0].length = end
+	return nil
+}
+
+func (mMap *MemoryMap) WriteMemory(offset uint64, length uint64, data []byte) error {
+	end := offset + length
+
+	// 常见情况是覆盖已有区域，持读锁即可，卷的写入本身已经串行
+	mMap.lock.RLock()
+	if len(mMap.write_map_views) == 0 {
+		mMap.lock.RUnlock()
+		return os.ErrClosed
+	}
+	if int64(end) <= mMap.End_of_file+1 {
+		copy(mMap.write_map_views[0].Buffer[offset:end], data[:length])
+		mMap.lock.RUnlock()
+		return nil
+	}
+	mMap.lock.RUnlock()
+
+	mMap.lock.Lock()
+	defer mMap.lock.Unlock()
+	if len(mMap.write_map_views) == 0 {
+		return os.ErrClosed
+	}
+	if err := mMap.extendFile(end); err != nil {
+		return err
+	}
+	copy(mMap.write_map_views[0].Buffer[offset:end], data[:length])
+	return nil
+}
+
+// ReadMemoryAt 在读锁内把数据拷贝出来，避免调用方持有的切片因重新映射而失效
+func (mMap *MemoryMap) ReadMemoryAt(p []byte, offset uint64) (int, error) {
+	mMap.lock.RLock()
+	defer mMap.lock.RUnlock()
+	if len(mMap.write_map_views) == 0 {
+		return 0, os.ErrClosed
+	}
+	fileSize := uint64(mMap.End_of_file + 1)
+	if offset >= fileSize {
+		return 0, io.EOF
+	}
+	n := copy(p, mMap.write_map_views[0].Buffer[offset:fileSize])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// TruncateMemory 缩小时映射区域保持不变，读取按文件大小截止
+func (mMap *MemoryMap) TruncateMemory(size uint64) error {
+	mMap.lock.Lock()
+	defer mMap.lock.Unlock()
+	if len(mMap.write_map_views) == 0 {
+		return os.ErrClosed
+	}
+	if int64(size) > mMap.End_of_file+1 {
+		return mMap.extendFile(size)
+	}
+	if err := mMap.File.Truncate(int64(size)); err != nil {
+		return fmt.Errorf("truncate %s to %d: %v", mMap.File.Name(), size, err)
+	}
+	mMap.End_of_file = int64(size) - 1
+	mMap.write_map_views[0].length = size
+	return nil
+}
+
+// SyncMemory msync 刷写脏页，文件大小的变化在 inode 上，再 fsync 一次
+func (mMap *MemoryMap) SyncMemory() error {
+	mMap.lock.RLock()
+	defer mMap.lock.RUnlock()
+	if len(mMap.write_map_views) == 0 {
+		return os.ErrClosed
+	}
+	return mMap.syncLocked()
+}
+
+func (mMap *MemoryMap) syncLocked() error {
+	view := mMap.write_map_views[0]
+	if size := alignToPage(uint64(mMap.End_of_file + 1)); size > 0 {
+		if err := unix.Msync(view.Buffer[:size], unix.MS_SYNC); err != nil {
+			return fmt.Errorf("msync %s: %v", mMap.File.Name(), err)
+		}
+	}
+	return mMap.File.Sync()
+}
+
+// CloseMemoryMap 先刷盘再解除映射，最后关闭文件；重复关闭直接返回
+func (mMap *MemoryMap) CloseMemoryMap() error {
+	mMap.lock.Lock()
+	defer mMap.lock.Unlock()
+	if len(mMap.write_map_views) == 0 {
+		return nil
+	}
+	err := mMap.syncLocked()
+	if unmapErr := unix.Munmap(mMap.write_map_views[0].Buffer); err == nil {
+		err = unmapErr
+	}
+	mMap.write_map_views = nil
+	if closeErr := mMap.File.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package memory_map_test
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/backend/memory_map"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func openMemoryMappedFile(t testing.TB, fileName string, sizeMB uint32) *memory_map.MemoryMappedFile {
+	f, err := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mmf, err := memory_map.NewMemoryMappedFile(f, sizeMB)
+	if err != nil {
+		f.Close()
+		t.Fatalf("memory map %s: %v", fileName, err)
+	}
+	return mmf
+}
+
+func TestMemoryMappedFileGrowSyncAndReopen(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "1.dat")
+	mmf := openMemoryMappedFile(t, fileName, 8)
+
+	// 超过首次映射的 1MB，触发重新映射
+	block := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+	var offset int64
+	for offset < 3*1024*1024 {
+		if _, err := mmf.WriteAt(block, offset); err != nil {
+			t.Fatalf("write at %d: %v", offset, err)
+		}
+		offset += int64(len(block))
+	}
+	if size, _, err := mmf.GetStat(); err != nil || size != offset {
+		t.Fatalf("size %d, expected %d: %v", size, offset, err)
+	}
+	buf := make([]byte, 16)
+	if _, err := mmf.ReadAt(buf, 1024*1024+16); err != nil || string(buf) != "0123456789abcdef" {
+		t.Fatalf("read %q: %v", buf, err)
+	}
+	if n, err := mmf.ReadAt(buf, offset-8); n != 8 || err != io.EOF {
+		t.Fatalf("read past end: %d %v", n, err)
+	}
+	if _, err := mmf.WriteAt(block, 8*1024*1024); err == nil {
+		t.Fatalf("write beyond memory map size succeeded")
+	}
+	if err := mmf.Truncate(offset - 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := mmf.Sync(); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if err := mmf.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if err := mmf.Close(); err != nil {
+		t.Fatalf("close twice: %v", err)
+	}
+	if _, err := mmf.ReadAt(buf, 0); err != os.ErrClosed {
+		t.Fatalf("read after close: %v", err)
+	}
+
+	stat, err := os.Stat(fileName)
+	if err != nil || stat.Size() != offset-100 {
+		t.Fatalf("file size after close %d, expected %d: %v", stat.Size(), offset-100, err)
+	}
+	mmf = openMemoryMappedFile(t, fileName, 8)
+	defer mmf.Close()
+	if _, err = mmf.ReadAt(buf, 2*1024*1024); err != nil || string(buf) != "0123456789abcdef" {
+		t.Fatalf("read after reopen %q: %v", buf, err)
+	}
+}
+
+func TestCreateVolumeFileWithMemoryMap(t *testing.T) {
+	dir := t.TempDir()
+	f, err := backend.CreateVolumeFile(filepath.Join(dir, "1.dat"), 0, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*memory_map.MemoryMappedFile); !ok {
+		t.Fatalf("got %T, expected memory mapped file", f)
+	}
+	f, err = backend.CreateVolumeFile(filepath.Join(dir, "2.dat"), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(*backend.DiskFile); !ok {
+		t.Fatalf("got %T, expected disk file", f)
+	}
+}
+
+const (
+	benchNeedleSize  = 1024
+	benchNeedleCount = 16 * 1024
+)
+
+func benchmarkSmallNeedleRead(b *testing.B, memoryMapSizeMB uint32) {
+	f, err := backend.CreateVolumeFile(filepath.Join(b.TempDir(), "1.dat"), 0, memoryMapSizeMB)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	needle := bytes.Repeat([]byte{'x'}, benchNeedleSize)
+	for i := 0; i < benchNeedleCount; i++ {
+		if _, err = f.WriteAt(needle, int64(i*benchNeedleSize)); err != nil {
+			b.Fatal(err)
+		}
+	}
+	buf := make([]byte, benchNeedleSize)
+	r := rand.New(rand.NewSource(1))
+	b.SetBytes(benchNeedleSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err = f.ReadAt(buf, int64(r.Intn(benchNeedleCount)*benchNeedleSize)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSmallNeedleReadMemoryMap(b *testing.B) {
+	benchmarkSmallNeedleRead(b, 32)
+}
+
+func BenchmarkSmallNeedleReadDiskFile(b *testing.B) {
+	benchmarkSmallNeedleRead(b, 0)
+}
@@ -1,5 +1,5 @@
-//go:build !windows
-// +build !windows
+//go:build !windows && !linux
+// +build !windows,!linux
 
 package memory_map
 
@@ -8,18 +8,28 @@ import (
 	"os"
 )
 
-func (mMap *MemoryMap) CreateMemoryMap(file *os.File, maxLength uint64) {
+var errNotImplemented = fmt.Errorf("Memory Map not implemented for this platform")
+
+func (mMap *MemoryMap) CreateMemoryMap(file *os.File, maxLength uint64) error {
+	return errNotImplemented
 }
 
-func (mMap *MemoryMap) WriteMemory(offset uint64, length uint64, data []byte) {
+func (mMap *MemoryMap) WriteMemory(offset uint64, length uint64, data []byte) error {
+	return errNotImplemented
+}
 
+func (mMap *MemoryMap) ReadMemoryAt(p []byte, offset uint64) (int, error) {
+	return 0, errNotImplemented
 }
 
-func (mMap *MemoryMap) ReadMemory(offset uint64, length uint64) ([]byte, error) {
-	dataSlice := []byte{}
-	return dataSlice, fmt.Errorf("Memory Map not implemented for this platform")
+func (mMap *MemoryMap) TruncateMemory(size uint64) error {
+	return errNotImplemented
 }
 
-func (mBuffer *MemoryMap) DeleteFileAndMemoryMap() {
+func (mMap *MemoryMap) SyncMemory() error {
+	return errNotImplemented
+}
 
+func (mMap *MemoryMap) CloseMemoryMap() error {
+	return errNotImplemented
 }
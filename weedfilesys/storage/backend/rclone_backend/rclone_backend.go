@@ -19,6 +19,7 @@ import (
 	"github.com/rclone/rclone/fs/object"
 	"io"
 	"os"
+	"sync"
 	"text/template"
 	"time"
 )
@@ -246,12 +247,27 @@ type RcloneBackendStorageFile struct {
 	backendStorage *RcloneBackendStorage
 	key            string
 	tierInfo       *volume_server_pb.VolumeInfo
+	objectLock     sync.Mutex
+	object         fs.Object // 第一次读取时查找，之后复用
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) ReadAt(p []byte, off int64) (n int, err error) {
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) getObject(ctx context.Context) (fs.Object, error) {
+	rcloneBackendStorageFile.objectLock.Lock()
+	defer rcloneBackendStorageFile.objectLock.Unlock()
+	if rcloneBackendStorageFile.object == nil {
+		obj, err := rcloneBackendStorageFile.backendStorage.fs.NewObject(ctx, rcloneBackendStorageFile.key)
+		if err != nil {
+			return nil, err
+		}
+		rcloneBackendStorageFile.object = obj
+	}
+	return rcloneBackendStorageFile.object, nil
+}
+
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) ReadAt(p []byte, off int64) (n int, err error) {
 	ctx := context.TODO()
 
-	obj, err := rcloneBackendStorageFile.backendStorage.fs.NewObject(ctx, rcloneBackendStorageFile.key)
+	obj, err := rcloneBackendStorageFile.getObject(ctx)
 	if err != nil {
 		return 0, err
 	}
@@ -259,33 +275,28 @@ func (rcloneBackendStorageFile RcloneBackendStorageFile) ReadAt(p []byte, off in
 	opt := fs.RangeOption{Start: off, End: off + int64(len(p)) - 1}
 
 	rc, err := obj.Open(ctx, &opt)
-	defer func(rc io.ReadCloser) {
-		err := rc.Close()
-		if err != nil {
-			return
-		}
-	}(rc)
-
 	if err != nil {
 		return 0, err
 	}
+	defer rc.Close()
 
 	return io.ReadFull(rc, p)
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) WriteAt(p []byte, off int64) (n int, err error) {
-	panic("not implemented")
+// 远程文件不能原地修改，追加由 backend.WriteBackRemoteFile 暂存后作为新文件上传
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) WriteAt(p []byte, off int64) (n int, err error) {
+	return 0, fmt.Errorf("rclone file %s is read only", rcloneBackendStorageFile.key)
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) Truncate(off int64) error {
-	panic("not implemented")
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) Truncate(off int64) error {
+	return fmt.Errorf("rclone file %s is read only", rcloneBackendStorageFile.key)
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) Close() error {
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) Close() error {
 	return nil
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) GetStat() (datSize int64, modTime time.Time, err error) {
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) GetStat() (datSize int64, modTime time.Time, err error) {
 	files := rcloneBackendStorageFile.tierInfo.GetFiles()
 
 	if len(files) == 0 {
@@ -299,10 +310,10 @@ func (rcloneBackendStorageFile RcloneBackendStorageFile) GetStat() (datSize int6
 	return
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) Name() string {
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) Name() string {
 	return rcloneBackendStorageFile.key
 }
 
-func (rcloneBackendStorageFile RcloneBackendStorageFile) Sync() error {
+func (rcloneBackendStorageFile *RcloneBackendStorageFile) Sync() error {
 	return nil
 }
@@ -0,0 +1,203 @@
+package backend
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 远程分层存储每次 ReadAt 都是一次 ranged GET，逐个读取 needle 的头部和数据体时往返次数太多。
+// RemoteBlockCache 按固定大小的块缓存远程文件内容：先查内存，再查本地磁盘目录，都没有时才回源。
+// 同一个缓存可以被多个远程文件共享，内存和磁盘各自按总字节数做 LRU 淘汰
+
+type RemoteBlockCache struct {
+	dir            string // 本地磁盘缓存目录，为空时只用内存
+	maxMemoryBytes int64
+	maxDiskBytes   int64
+
+	lock   sync.Mutex
+	memory *blockLru
+	disk   *blockLru
+}
+
+// DefaultRemoteBlockCache 分层卷共享的缓存，默认只用内存，卷服务器启动时可以换成带磁盘目录的缓存
+var DefaultRemoteBlockCache = &RemoteBlockCache{
+	maxMemoryBytes: 64 * 1024 * 1024,
+	memory:         newBlockLru(),
+	disk:           newBlockLru(),
+}
+
+// NewRemoteBlockCache dir 为空或 maxDiskBytes 为 0 时不使用磁盘缓存
+func NewRemoteBlockCache(dir string, maxMemoryBytes, maxDiskBytes int64) (*RemoteBlockCache, error) {
+	c := &RemoteBlockCache{
+		dir:            dir,
+		maxMemoryBytes: maxMemoryBytes,
+		maxDiskBytes:   maxDiskBytes,
+		memory:         newBlockLru(),
+		disk:           newBlockLru(),
+	}
+	if dir == "" || maxDiskBytes <= 0 {
+		c.dir, c.maxDiskBytes = "", 0
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("create remote block cache %s: %v", dir, err)
+	}
+	// 上次进程留下的块没有记录在 LRU 中，无法计入容量，直接清掉；目录中的其他文件不动
+	leftovers, err := filepath.Glob(filepath.Join(dir, "*.blk"))
+	if err != nil {
+		return nil, fmt.Errorf("list remote block cache %s: %v", dir, err)
+	}
+	for _, leftover := range leftovers {
+		os.Remove(leftover)
+	}
+	return c, nil
+}
+
+// 远程文件名可能包含 /，统一换成定长的哈希作为前缀；
+// 同名文件被重新上传后大小或修改时间不同，不会读到旧内容的块
+func remoteBlockFileKey(name string, size int64, modTime time.Time) string {
+	sum := sha1.Sum([]byte(name + "_" + strconv.FormatInt(size, 10) + "_" + strconv.FormatInt(modTime.UnixNano(), 10)))
+	return hex.EncodeToString(sum[:])
+}
+
+func remoteBlockKey(fileKey string, blockIndex int64) string {
+	return fileKey + "_" + strconv.FormatInt(blockIndex, 10)
+}
+
+func (c *RemoteBlockCache) blockFileName(key string) string {
+	return filepath.Join(c.dir, key+".blk")
+}
+
+// getBlock 先查内存再查磁盘，磁盘命中时放回内存
+func (c *RemoteBlockCache) getBlock(key string) ([]byte, bool) {
+	c.lock.Lock()
+	if data, found := c.memory.get(key); found {
+		c.lock.Unlock()
+		return data, true
+	}
+	_, onDisk := c.disk.get(key)
+	c.lock.Unlock()
+	if !onDisk {
+		return nil, false
+	}
+
+	data, err := os.ReadFile(c.blockFileName(key))
+	if err != nil {
+		c.lock.Lock()
+		c.disk.remove(key)
+		c.lock.Unlock()
+		return nil, false
+	}
+	c.putMemory(key, data)
+	return data, true
+}
+
+// putBlock 回源得到的块同时放进内存和磁盘
+func (c *RemoteBlockCache) putBlock(key string, data []byte) {
+	c.putMemory(key, data)
+	if c.dir == "" || int64(len(data)) > c.maxDiskBytes {
+		return
+	}
+	if err := os.WriteFile(c.blockFileName(key), data, 0644); err != nil {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disk.put(key, nil, int64(len(data)))
+	for c.disk.bytes > c.maxDiskBytes {
+		evicted, ok := c.disk.removeOldest()
+		if !ok {
+			break
+		}
+		os.Remove(c.blockFileName(evicted))
+	}
+}
+
+func (c *RemoteBlockCache) putMemory(key string, data []byte) {
+	if int64(len(data)) > c.maxMemoryBytes {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.memory.put(key, data, int64(len(data)))
+	for c.memory.bytes > c.maxMemoryBytes {
+		if _, ok := c.memory.removeOldest(); !ok {
+			break
+		}
+	}
+}
+
+func (c *RemoteBlockCache) hasBlock(key string) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, inMemory := c.memory.index[key]
+	_, onDisk := c.disk.index[key]
+	return inMemory || onDisk
+}
+
+type blockLruEntry struct {
+	key  string
+	data []byte
+	size int64
+}
+
+// 按字节数计量的 LRU，调用方负责加锁
+type blockLru struct {
+	entries *list.List
+	index   map[string]*list.Element
+	bytes   int64
+}
+
+func newBlockLru() *blockLru {
+	return &blockLru{entries: list.New(), index: make(map[string]*list.Element)}
+}
+
+func (l *blockLru) get(key string) ([]byte, bool) {
+	element, found := l.index[key]
+	if !found {
+		return nil, false
+	}
+	l.entries.MoveToFront(element)
+	return element.Value.(*blockLruEntry).data, true
+}
+
+func (l *blockLru) put(key string, data []byte, size int64) {
+	if element, found := l.index[key]; found {
+		entry := element.Value.(*blockLruEntry)
+		l.bytes += size - entry.size
+		entry.data, entry.size = data, size
+		l.entries.MoveToFront(element)
+		return
+	}
+	l.index[key] = l.entries.PushFront(&blockLruEntry{key: key, data: data, size: size})
+	l.bytes += size
+}
+
+func (l *blockLru) remove(key string) {
+	if element, found := l.index[key]; found {
+		l.removeElement(element)
+	}
+}
+
+func (l *blockLru) removeOldest() (string, bool) {
+	element := l.entries.Back()
+	if element == nil {
+		return "", false
+	}
+	l.removeElement(element)
+	return element.Value.(*blockLruEntry).key, true
+}
+
+func (l *blockLru) removeElement(element *list.Element) {
+	entry := element.Value.(*blockLruEntry)
+	l.entries.Remove(element)
+	delete(l.index, entry.key)
+	l.bytes -= entry.size
+}
@@ -0,0 +1,251 @@
+package fake_s3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Server 进程内的 S3 替身，供测试覆盖分层存储和远程读取，不需要网络和真实凭证。
+// 只支持路径风格（/bucket/key）的 HEAD、GET（含 Range）、PUT、DELETE 和分片上传，不校验签名，bucket 不需要提前创建
+type Server struct {
+	URL string
+
+	server    *httptest.Server
+	lock      sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int][]byte
+	uploadSeq int64
+	gets      atomic.Int64
+}
+
+// NewServer 启动一个监听本地随机端口的 S3 替身，用完调用 Close
+func NewServer() *Server {
+	s := &Server{
+		objects: make(map[string][]byte),
+		uploads: make(map[string]map[int][]byte),
+	}
+	s.server = httptest.NewServer(s)
+	s.URL = s.server.URL
+	return s
+}
+
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Properties 测试用的后端配置，实现 backend.StringProperties
+type Properties map[string]string
+
+func (p Properties) GetString(key string) string {
+	return p[key]
+}
+
+// Properties 指向这个替身的 s3 后端配置
+func (s *Server) Properties(bucket string) Properties {
+	return Properties{
+		"aws_access_key_id":     "fake",
+		"aws_secret_access_key": "fake",
+		"region":                "us-east-1",
+		"bucket":                bucket,
+		"endpoint":              s.URL,
+		"force_path_style":      "true",
+	}
+}
+
+// Gets 收到的 GET 请求数，用来检查远程读取的往返次数
+func (s *Server) Gets() int64 {
+	return s.gets.Load()
+}
+
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.objects[objectPath(bucket, key)] = data
+}
+
+func (s *Server) GetObject(bucket, key string) ([]byte, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, found := s.objects[objectPath(bucket, key)]
+	return data, found
+}
+
+// Keys 按字典序返回 bucket 中的所有对象
+func (s *Server) Keys(bucket string) (keys []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	prefix := "/" + bucket + "/"
+	for path := range s.objects {
+		if strings.HasPrefix(path, prefix) {
+			keys = append(keys, strings.TrimPrefix(path, prefix))
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func objectPath(bucket, key string) string {
+	return "/" + bucket + "/" + strings.TrimPrefix(key, "/")
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path, query := r.URL.Path, r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, path)
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, query.Get("uploadId"), query.Get("partNumber"))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, path, query.Get("uploadId"))
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.lock.Lock()
+		delete(s.uploads, query.Get("uploadId"))
+		s.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		s.lock.Lock()
+		s.objects[path] = data
+		s.lock.Unlock()
+		w.Header().Set("ETag", `"fake"`)
+	case r.Method == http.MethodDelete:
+		s.lock.Lock()
+		delete(s.objects, path)
+		s.lock.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodHead || r.Method == http.MethodGet:
+		s.getObject(w, r, path)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, path string) {
+	if r.Method == http.MethodGet {
+		s.gets.Add(1)
+	}
+	s.lock.Lock()
+	data, found := s.objects[path]
+	s.lock.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+
+	start, end := int64(0), int64(len(data))-1
+	status := http.StatusOK
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" {
+		if _, err := fmt.Sscanf(strings.TrimPrefix(rangeHeader, "bytes="), "%d-%d", &start, &end); err != nil || start >= int64(len(data)) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		if end >= int64(len(data)) {
+			end = int64(len(data)) - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		status = http.StatusPartialContent
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(end-start+1, 10))
+	w.Header().Set("ETag", `"fake"`)
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		w.Write(data[start : end+1])
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int `xml:"PartNumber"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	ETag    string   `xml:"ETag"`
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, path string) {
+	s.lock.Lock()
+	s.uploadSeq++
+	uploadId := strconv.FormatInt(s.uploadSeq, 10)
+	s.uploads[uploadId] = make(map[int][]byte)
+	s.lock.Unlock()
+	writeXml(w, initiateMultipartUploadResult{UploadId: uploadId})
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, uploadId, partNumber string) {
+	number, err := strconv.Atoi(partNumber)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	parts, found := s.uploads[uploadId]
+	if !found {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	parts[number] = data
+	w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, number))
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, path, uploadId string) {
+	var request completeMultipartUpload
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	parts, found := s.uploads[uploadId]
+	if !found {
+		writeError(w, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	var data []byte
+	for _, part := range request.Parts {
+		partData, found := parts[part.PartNumber]
+		if !found {
+			writeError(w, http.StatusBadRequest, "InvalidPart")
+			return
+		}
+		data = append(data, partData...)
+	}
+	delete(s.uploads, uploadId)
+	s.objects[path] = data
+	writeXml(w, completeMultipartUploadResult{ETag: `"fake"`})
+}
+
+func writeXml(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	xml.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code></Error>", code)
+}
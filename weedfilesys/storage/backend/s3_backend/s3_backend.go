@@ -185,12 +185,13 @@ func (s3backendStorageFile S3BackendStorageFile) ReadAt(p []byte, off int64) (n
 	return
 }
 
+// 对象不能原地修改，追加由 backend.WriteBackRemoteFile 暂存后作为新对象上传
 func (s3backendStorageFile S3BackendStorageFile) WriteAt(p []byte, off int64) (n int, err error) {
-	panic("not implemented")
+	return 0, fmt.Errorf("s3 object %s is read only", s3backendStorageFile.key)
 }
 
 func (s3backendStorageFile S3BackendStorageFile) Truncate(off int64) error {
-	panic("not implemented")
+	return fmt.Errorf("s3 object %s is read only", s3backendStorageFile.key)
 }
 
 func (s3backendStorageFile S3BackendStorageFile) Close() error {
@@ -0,0 +1,111 @@
+package s3_backend
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/backend/s3_backend/fake_s3"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestS3RemoteFileWithBlockCache(t *testing.T) {
+	fake := fake_s3.NewServer()
+	defer fake.Close()
+
+	storage, err := newS3BackendStorage(fake.Properties("tier"), "", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 300*1024)
+	rand.New(rand.NewSource(1)).Read(data)
+	fake.PutObject("tier", "volumes/1.dat", data)
+	tierInfo := &volume_server_pb.VolumeInfo{Files: []*volume_server_pb.RemoteFile{{Key: "volumes/1.dat", FileSize: uint64(len(data))}}}
+	remote := storage.NewStorageFile("/volumes/1.dat", tierInfo)
+
+	cache, _ := backend.NewRemoteBlockCache(t.TempDir(), 1024*1024, 1024*1024)
+	f, err := backend.NewCachedRemoteFile(remote, cache, backend.RemoteCacheOption{BlockSize: 64 * 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// 逐个读取 needle 头部和数据体，整个文件只需要按块回源
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 200; i++ {
+		off := int64(r.Intn(len(data) - 4096))
+		header, body := make([]byte, 16), make([]byte, 1+r.Intn(4000))
+		if _, err = f.ReadAt(header, off); err != nil {
+			t.Fatalf("read header at %d: %v", off, err)
+		}
+		if _, err = f.ReadAt(body, off+16); err != nil {
+			t.Fatalf("read body at %d: %v", off+16, err)
+		}
+		if !bytes.Equal(header, data[off:off+16]) || !bytes.Equal(body, data[off+16:off+16+int64(len(body))]) {
+			t.Fatalf("data mismatch at %d", off)
+		}
+	}
+	if gets := fake.Gets(); gets > 5 {
+		t.Fatalf("%d ranged GETs for 5 blocks", gets)
+	}
+
+	buf := make([]byte, 100)
+	n, err := f.ReadAt(buf, int64(len(data))-10)
+	if n != 10 || err != io.EOF || !bytes.Equal(buf[:10], data[len(data)-10:]) {
+		t.Fatalf("read at end: %d %v", n, err)
+	}
+}
+
+func TestS3CopyDownloadDelete(t *testing.T) {
+	fake := fake_s3.NewServer()
+	defer fake.Close()
+	storage, err := newS3BackendStorage(fake.Properties("tier"), "", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	data := make([]byte, 100*1024)
+	rand.New(rand.NewSource(3)).Read(data)
+	src := filepath.Join(dir, "1.dat")
+	if err = os.WriteFile(src, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var progressed int64
+	fn := func(p int64, _ float32) error {
+		progressed = p
+		return nil
+	}
+	key, size, err := storage.CopyFile(f, fn)
+	if err != nil || size != int64(len(data)) || progressed != size {
+		t.Fatalf("copy: %v size %d progressed %d", err, size, progressed)
+	}
+	if uploaded, found := fake.GetObject("tier", key); !found || !bytes.Equal(uploaded, data) {
+		t.Fatalf("uploaded object mismatch")
+	}
+
+	dst := filepath.Join(dir, "2.dat")
+	if size, err = storage.DownloadFile(dst, key, fn); err != nil || size != int64(len(data)) {
+		t.Fatalf("download: %v size %d", err, size)
+	}
+	if downloaded, _ := os.ReadFile(dst); !bytes.Equal(downloaded, data) {
+		t.Fatalf("downloaded file mismatch")
+	}
+
+	if err = storage.DeleteFile(key); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if keys := fake.Keys("tier"); len(keys) != 0 {
+		t.Fatalf("objects left %v", keys)
+	}
+}
@@ -69,7 +69,7 @@ func createSession(awsAccessKeyId, awsSecretAccessKey, region, endpoint string,
 	// 创建s3客户端并缓存
 	t := s3.New(sess)
 
-	s3Sessions[region] = t
+	s3Sessions[cacheKey] = t
 
 	return t, nil
 
@@ -5,10 +5,12 @@ package backend
 
 import (
 	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/backend/memory_map"
 	"os"
 	"syscall"
 )
 
+// memoryMapSizeMB 大于 0 时用 mmap 读写，适合小而热的卷；映射失败退回普通文件
 func CreateVolumeFile(fileName string, preallocate int64, memoryMapSizeMB uint32) (BackendStorageFile, error) {
 	file, e := os.OpenFile(fileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if e != nil {
@@ -18,5 +20,17 @@ func CreateVolumeFile(fileName string, preallocate int64, memoryMapSizeMB uint32
 		syscall.Fallocate(int(file.Fd()), 1, 0, preallocate)
 		glog.V(1).Infof("Preallocated %d bytes disk space for %s", preallocate, fileName)
 	}
-	return NewDiskFile(file), nil
+	return OpenVolumeFile(file, memoryMapSizeMB), nil
+}
+
+// OpenVolumeFile 包装已打开的可写 .dat，memoryMapSizeMB 大于 0 时用 mmap；映射失败（比如文件超过映射大小）退回普通文件
+func OpenVolumeFile(file *os.File, memoryMapSizeMB uint32) BackendStorageFile {
+	if memoryMapSizeMB > 0 {
+		mmf, err := memory_map.NewMemoryMappedFile(file, memoryMapSizeMB)
+		if err == nil {
+			return mmf
+		}
+		glog.Warningf("memory map %s: %v, fall back to disk file", file.Name(), err)
+	}
+	return NewDiskFile(file)
 }
@@ -0,0 +1,583 @@
+package backend
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	_ BackendStorageFile = &WriteBackRemoteFile{}
+)
+
+// 远程对象不能原地修改，分层卷的追加先写到本地暂存文件，攒够之后作为一个新对象上传。
+// 远程 .dat 由多个首尾相接的段组成，每段对应 .vif 中的一个 RemoteFile，Offset 是它在 .dat 中的起始位置。
+// 暂存文件开头的 8 字节记录暂存数据在 .dat 中的起始偏移：上传后 .vif 已保存但暂存文件还没清理时崩溃，
+// 重新打开时据此跳过已经上传的部分。
+// 写入超过阈值时在后台上传，不阻塞读写；上传完成后由下一次写入、Flush 或 Close 记录到 .vif，
+// 这些调用方持有卷的写锁，onFlush 可以直接保存 .vif
+
+const stagingHeaderSize = 8
+
+type WriteBackOption struct {
+	Cache          *RemoteBlockCache // 为空时使用 DefaultRemoteBlockCache
+	CacheOption    RemoteCacheOption
+	FlushThreshold int64 // 暂存超过这个大小时在写入后开始后台上传，0 表示只在 Flush 和 Close 时上传
+}
+
+var DefaultWriteBackOption = WriteBackOption{
+	CacheOption:    DefaultRemoteCacheOption,
+	FlushThreshold: 8 * 1024 * 1024,
+}
+
+// 暂存数据的一次上传，上传的是当时暂存数据的前 size 字节
+type stagingUpload struct {
+	size    int64
+	done    chan struct{}
+	key     string // 上传完成后有效
+	err     error
+	stale   bool           // 上传期间这部分数据被改写或截断，结果作废，持 f.lock 读写
+	storage BackendStorage // 上传用的实例，作废时从这里删除 // 上传期间这部分数据被改写或截断，结果作废，持 f.lock 读写
+}
+
+type remoteSegment struct {
+	offset int64
+	size   int64
+	file   BackendStorageFile
+}
+
+// WriteBackRemoteFile 远程段加本地暂存文件组成的可追加文件。
+// onFlush 在每次上传新段后调用，负责把新的段列表保存到 .vif，返回错误时新段作废。
+// 远程存储按名称在使用时查找，配置热加载替换实例后，读取和上传改用新的实例
+type WriteBackRemoteFile struct {
+	storageName     string
+	option          WriteBackOption
+	onFlush         func(files []*volume_server_pb.RemoteFile) error
+	stagingFileName string
+
+	lock       sync.RWMutex
+	storage    BackendStorage // 打开各段所用的实例
+	files      []*volume_server_pb.RemoteFile
+	segments   []remoteSegment
+	remoteSize int64
+	modTime    time.Time
+	staging    *os.File // 没有暂存数据时可能为空
+	stagedSize int64
+	uploading  *stagingUpload // 正在上传或上传完还没有记录到 .vif
+	closed     bool
+}
+
+func NewWriteBackRemoteFile(storageName string, files []*volume_server_pb.RemoteFile, stagingFileName string, option WriteBackOption, onFlush func(files []*volume_server_pb.RemoteFile) error) (*WriteBackRemoteFile, error) {
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no remote files")
+	}
+	storage, found := GetBackendStorage(storageName)
+	if !found {
+		return nil, fmt.Errorf("remote storage %s not configured", storageName)
+	}
+	if option.Cache == nil {
+		option.Cache = DefaultRemoteBlockCache
+	}
+	f := &WriteBackRemoteFile{
+		storageName:     storageName,
+		storage:         storage,
+		option:          option,
+		onFlush:         onFlush,
+		stagingFileName: stagingFileName,
+	}
+	for _, remoteFile := range files {
+		if err := f.addSegment(remoteFile); err != nil {
+			f.closeSegments()
+			return nil, err
+		}
+	}
+	if err := f.recoverStaging(); err != nil {
+		f.closeSegments()
+		return nil, fmt.Errorf("recover staging %s: %v", stagingFileName, err)
+	}
+	return f, nil
+}
+
+func (f *WriteBackRemoteFile) addSegment(remoteFile *volume_server_pb.RemoteFile) error {
+	file, err := f.openSegment(remoteFile)
+	if err != nil {
+		return err
+	}
+	f.appendSegment(remoteFile, file)
+	return nil
+}
+
+// 每段单独作为一个远程文件打开，GetStat 取 tierInfo 中的第一个文件
+func (f *WriteBackRemoteFile) openSegment(remoteFile *volume_server_pb.RemoteFile) (BackendStorageFile, error) {
+	if int64(remoteFile.Offset) != f.remoteSize {
+		return nil, fmt.Errorf("remote file %s at offset %d, expected %d", remoteFile.Key, remoteFile.Offset, f.remoteSize)
+	}
+	return f.openRemoteFile(f.storage, remoteFile)
+}
+
+func (f *WriteBackRemoteFile) openRemoteFile(storage BackendStorage, remoteFile *volume_server_pb.RemoteFile) (BackendStorageFile, error) {
+	remote := storage.NewStorageFile(remoteFile.Key, &volume_server_pb.VolumeInfo{Files: []*volume_server_pb.RemoteFile{remoteFile}})
+	cached, err := NewCachedRemoteFile(remote, f.option.Cache, f.option.CacheOption)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return cached, nil
+}
+
+func (f *WriteBackRemoteFile) appendSegment(remoteFile *volume_server_pb.RemoteFile, file BackendStorageFile) {
+	f.segments = append(f.segments, remoteSegment{offset: f.remoteSize, size: int64(remoteFile.FileSize), file: file})
+	f.files = append(f.files, remoteFile)
+	f.remoteSize += int64(remoteFile.FileSize)
+	if modTime := time.Unix(int64(remoteFile.ModifiedTime), 0); modTime.After(f.modTime) {
+		f.modTime = modTime
+	}
+}
+
+// 调用方持有 f.lock 的读锁或写锁
+func (f *WriteBackRemoteFile) storageReplaced() bool {
+	storage, found := GetBackendStorage(f.storageName)
+	return found && storage != f.storage
+}
+
+// 存储实例被替换后用新实例重新打开各段，块缓存按 key 共用；失败时继续用旧实例。调用方持有 f.lock
+func (f *WriteBackRemoteFile) useCurrentStorage() {
+	storage, found := GetBackendStorage(f.storageName)
+	if !found || storage == f.storage {
+		return
+	}
+	segments := make([]remoteSegment, 0, len(f.segments))
+	for i, remoteFile := range f.files {
+		file, err := f.openRemoteFile(storage, remoteFile)
+		if err != nil {
+			for _, segment := range segments {
+				segment.file.Close()
+			}
+			glog.Warningf("%s: reopen %s on reconfigured storage %s: %v", f.stagingFileName, remoteFile.Key, f.storageName, err)
+			return
+		}
+		segments = append(segments, remoteSegment{offset: f.segments[i].offset, size: f.segments[i].size, file: file})
+	}
+	f.closeSegments()
+	f.segments, f.storage = segments, storage
+	glog.V(0).Infof("%s: switch to reconfigured storage %s", f.stagingFileName, f.storageName)
+}
+
+func (f *WriteBackRemoteFile) closeSegments() {
+	for _, segment := range f.segments {
+		segment.file.Close()
+	}
+	f.segments = nil
+}
+
+// 打开上次留下的暂存文件，丢掉已经上传过的前缀
+func (f *WriteBackRemoteFile) recoverStaging() error {
+	staging, err := os.OpenFile(f.stagingFileName, os.O_RDWR, 0644)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	stat, err := staging.Stat()
+	if err != nil {
+		staging.Close()
+		return err
+	}
+	header := make([]byte, stagingHeaderSize)
+	if stat.Size() < stagingHeaderSize {
+		// 头部都没写完，不会有数据
+		staging.Close()
+		return os.Remove(f.stagingFileName)
+	}
+	if _, err = staging.ReadAt(header, 0); err != nil {
+		staging.Close()
+		return err
+	}
+	base, stagedSize := int64(util.BytesToUint64(header)), stat.Size()-stagingHeaderSize
+	if base > f.remoteSize {
+		staging.Close()
+		return fmt.Errorf("staged data starts at %d beyond remote size %d", base, f.remoteSize)
+	}
+	f.staging = staging
+	if flushed := f.remoteSize - base; flushed > 0 {
+		if flushed > stagedSize {
+			flushed = stagedSize
+		}
+		glog.V(0).Infof("%s: skip %d bytes already flushed to remote", f.stagingFileName, flushed)
+		if err = f.rewriteStaging(base+flushed, stagedSize-flushed, flushed); err != nil {
+			return err
+		}
+	} else {
+		f.stagedSize = stagedSize
+	}
+	if f.stagedSize == 0 {
+		f.staging.Close()
+		f.staging = nil
+		return os.Remove(f.stagingFileName)
+	}
+	glog.V(0).Infof("%s: recovered %d staged bytes at %d", f.stagingFileName, f.stagedSize, f.remoteSize)
+	return nil
+}
+
+// 只保留暂存文件中 [skip, skip+remaining) 的数据，起始偏移改为 base
+func (f *WriteBackRemoteFile) rewriteStaging(base, remaining, skip int64) error {
+	tmpFileName := f.stagingFileName + ".tmp"
+	tmp, err := os.OpenFile(tmpFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, stagingHeaderSize)
+	util.Uint64toBytes(header, uint64(base))
+	if _, err = tmp.Write(header); err == nil {
+		_, err = io.Copy(tmp, io.NewSectionReader(f.staging, stagingHeaderSize+skip, remaining))
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpFileName, f.stagingFileName)
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpFileName)
+		return err
+	}
+	f.staging.Close()
+	f.staging, f.stagedSize = tmp, remaining
+	return nil
+}
+
+func (f *WriteBackRemoteFile) ensureStaging() error {
+	if f.staging != nil {
+		return nil
+	}
+	staging, err := os.OpenFile(f.stagingFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, stagingHeaderSize)
+	util.Uint64toBytes(header, uint64(f.remoteSize))
+	if _, err = staging.WriteAt(header, 0); err != nil {
+		staging.Close()
+		os.Remove(f.stagingFileName)
+		return err
+	}
+	f.staging = staging
+	return nil
+}
+
+func (f *WriteBackRemoteFile) ReadAt(p []byte, off int64) (n int, err error) {
+	f.lock.RLock()
+	if !f.closed && f.storageReplaced() {
+		f.lock.RUnlock()
+		f.lock.Lock()
+		if !f.closed {
+			f.useCurrentStorage()
+		}
+		f.lock.Unlock()
+		f.lock.RLock()
+	}
+	defer f.lock.RUnlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	for n < len(p) {
+		pos := off + int64(n)
+		var m int
+		if pos < f.remoteSize {
+			i := sort.Search(len(f.segments), func(i int) bool {
+				return f.segments[i].offset+f.segments[i].size > pos
+			})
+			segment := f.segments[i]
+			want := p[n:]
+			if rest := segment.offset + segment.size - pos; int64(len(want)) > rest {
+				want = want[:rest]
+			}
+			m, err = segment.file.ReadAt(want, pos-segment.offset)
+			if err == io.EOF && m == len(want) {
+				err = nil
+			}
+		} else if pos < f.remoteSize+f.stagedSize {
+			want := p[n:]
+			if rest := f.remoteSize + f.stagedSize - pos; int64(len(want)) > rest {
+				want = want[:rest]
+			}
+			m, err = f.staging.ReadAt(want, stagingHeaderSize+pos-f.remoteSize)
+			if err == io.EOF && m == len(want) {
+				err = nil
+			}
+		} else {
+			return n, io.EOF
+		}
+		n += m
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteAt 只能写在已上传部分之后，并且不能留下空洞
+func (f *WriteBackRemoteFile) WriteAt(p []byte, off int64) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if off < f.remoteSize {
+		return 0, fmt.Errorf("write at %d: data before %d is already on remote storage", off, f.remoteSize)
+	}
+	if off > f.remoteSize+f.stagedSize {
+		return 0, fmt.Errorf("write at %d beyond end %d", off, f.remoteSize+f.stagedSize)
+	}
+	f.invalidateUpload(off)
+	if err = f.ensureStaging(); err != nil {
+		return 0, err
+	}
+	if n, err = f.staging.WriteAt(p, stagingHeaderSize+off-f.remoteSize); err != nil {
+		return n, err
+	}
+	if end := off - f.remoteSize + int64(n); end > f.stagedSize {
+		f.stagedSize = end
+	}
+	f.modTime = time.Now()
+
+	// 写入已经落在本地暂存文件，上传失败只影响上传时机
+	if f.uploading != nil {
+		select {
+		case <-f.uploading.done:
+			if commitErr := f.commitUpload(); commitErr != nil {
+				glog.Warningf("flush %s: %v", f.stagingFileName, commitErr)
+			}
+		default:
+		}
+	}
+	if f.uploading == nil && f.option.FlushThreshold > 0 && f.stagedSize >= f.option.FlushThreshold {
+		if uploadErr := f.startUpload(); uploadErr != nil {
+			glog.Warningf("flush %s: %v", f.stagingFileName, uploadErr)
+		}
+	}
+	return n, nil
+}
+
+// 正在上传的部分从 off 开始被改写或截断时，上传结果作废
+func (f *WriteBackRemoteFile) invalidateUpload(off int64) {
+	if f.uploading != nil && off < f.remoteSize+f.uploading.size {
+		f.uploading.stale = true
+	}
+}
+
+func (f *WriteBackRemoteFile) Truncate(off int64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	if off < f.remoteSize {
+		return fmt.Errorf("truncate to %d: data before %d is already on remote storage", off, f.remoteSize)
+	}
+	if off >= f.remoteSize+f.stagedSize {
+		return nil
+	}
+	f.invalidateUpload(off)
+	if err := f.staging.Truncate(stagingHeaderSize + off - f.remoteSize); err != nil {
+		return err
+	}
+	f.stagedSize = off - f.remoteSize
+	f.modTime = time.Now()
+	return nil
+}
+
+// Flush 把暂存的数据上传为新的一段，等上传完成并记录到 .vif 后返回
+func (f *WriteBackRemoteFile) Flush() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return os.ErrClosed
+	}
+	return f.flush()
+}
+
+// 调用方持有 f.lock，等待上传期间释放，读取不受影响
+func (f *WriteBackRemoteFile) flush() error {
+	for f.uploading != nil || f.stagedSize > 0 {
+		if f.uploading == nil {
+			if err := f.startUpload(); err != nil {
+				return err
+			}
+		}
+		upload := f.uploading
+		f.lock.Unlock()
+		<-upload.done
+		f.lock.Lock()
+		if f.uploading != upload {
+			// 等待期间已被写入记录
+			continue
+		}
+		if err := f.commitUpload(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 持锁把暂存数据复制到单独的文件，在后台上传；CopyFile 上传整个文件，复制时去掉头部
+func (f *WriteBackRemoteFile) startUpload() error {
+	f.useCurrentStorage()
+	if err := f.staging.Sync(); err != nil {
+		return err
+	}
+	uploadFileName := f.stagingFileName + ".upload"
+	uploadFile, err := os.OpenFile(uploadFileName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(uploadFile, io.NewSectionReader(f.staging, stagingHeaderSize, f.stagedSize)); err == nil {
+		_, err = uploadFile.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		uploadFile.Close()
+		os.Remove(uploadFileName)
+		return err
+	}
+
+	upload := &stagingUpload{size: f.stagedSize, done: make(chan struct{}), storage: f.storage}
+	f.uploading = upload
+	go func() {
+		defer close(upload.done)
+		defer os.Remove(uploadFileName)
+		defer uploadFile.Close()
+		key, size, err := upload.storage.CopyFile(uploadFile, func(progressed int64, percentage float32) error {
+			return nil
+		})
+		if err == nil && size != upload.size {
+			upload.storage.DeleteFile(key)
+			err = fmt.Errorf("uploaded %d bytes, expected %d", size, upload.size)
+		}
+		upload.key, upload.err = key, err
+	}()
+	return nil
+}
+
+// 把上传完成的段记录到 .vif，并从暂存文件中去掉已上传的部分；调用方持有 f.lock，上传已经结束
+func (f *WriteBackRemoteFile) commitUpload() error {
+	upload := f.uploading
+	f.uploading = nil
+	if upload.err != nil {
+		return upload.err
+	}
+	if upload.stale {
+		upload.storage.DeleteFile(upload.key)
+		return fmt.Errorf("staged data changed during upload")
+	}
+
+	first := f.files[0]
+	remoteFile := &volume_server_pb.RemoteFile{
+		BackendType:  first.BackendType,
+		BackendId:    first.BackendId,
+		Key:          upload.key,
+		Offset:       uint64(f.remoteSize),
+		FileSize:     uint64(upload.size),
+		ModifiedTime: uint64(time.Now().Unix()),
+		Extension:    first.Extension,
+	}
+	segment, err := f.openSegment(remoteFile)
+	if err != nil {
+		upload.storage.DeleteFile(upload.key)
+		return fmt.Errorf("open flushed segment %s: %v", upload.key, err)
+	}
+	files := append(append([]*volume_server_pb.RemoteFile(nil), f.files...), remoteFile)
+	if err = f.onFlush(files); err != nil {
+		segment.Close()
+		upload.storage.DeleteFile(upload.key)
+		return fmt.Errorf("save remote files: %v", err)
+	}
+	f.appendSegment(remoteFile, segment)
+
+	// .vif 已经记录新段，这之后崩溃时头部中的旧偏移会让恢复时跳过已上传的部分
+	if err = f.rewriteStaging(f.remoteSize, f.stagedSize-upload.size, upload.size); err != nil {
+		return err
+	}
+	glog.V(1).Infof("flushed %d bytes of %s as %s at %d", upload.size, f.stagingFileName, upload.key, remoteFile.Offset)
+	return nil
+}
+
+// Close 先尝试上传暂存数据，失败时保留暂存文件，下次打开时恢复
+func (f *WriteBackRemoteFile) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	var err error
+	if f.staging != nil {
+		if err = f.flush(); err != nil {
+			glog.Warningf("flush %s on close, keep %d staged bytes: %v", f.stagingFileName, f.stagedSize, err)
+		}
+		f.staging.Close()
+		if f.stagedSize == 0 {
+			os.Remove(f.stagingFileName)
+		}
+		f.staging = nil
+	}
+	f.closeSegments()
+	return err
+}
+
+func (f *WriteBackRemoteFile) GetStat() (datSize int64, modTime time.Time, err error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if f.closed {
+		return 0, time.Time{}, os.ErrClosed
+	}
+	return f.remoteSize + f.stagedSize, f.modTime, nil
+}
+
+func (f *WriteBackRemoteFile) Name() string {
+	return f.stagingFileName
+}
+
+// Sync 只保证暂存数据落盘，不触发上传
+func (f *WriteBackRemoteFile) Sync() error {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	if f.staging == nil {
+		return nil
+	}
+	return f.staging.Sync()
+}
+
+// DiscardStaging 丢弃还没有上传的数据，用于卷被删除或者已经搬回本地
+func (f *WriteBackRemoteFile) DiscardStaging() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if upload := f.uploading; upload != nil {
+		f.uploading = nil
+		go func() {
+			if <-upload.done; upload.err == nil {
+				upload.storage.DeleteFile(upload.key)
+			}
+		}()
+	}
+	if f.staging == nil {
+		return nil
+	}
+	f.staging.Close()
+	f.staging, f.stagedSize = nil, 0
+	return os.Remove(f.stagingFileName)
+}
+
+// StagedSize 还没有上传的字节数
+func (f *WriteBackRemoteFile) StagedSize() int64 {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.stagedSize
+}
@@ -0,0 +1,390 @@
+package backend
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 内存中的远程存储，key 按上传顺序编号
+type memBackendStorage struct {
+	lock    sync.Mutex
+	objects map[string][]byte
+	seq     int
+	failing bool
+	block   chan struct{} // 不为空时上传等它关闭后才完成
+}
+
+func newMemBackendStorage() *memBackendStorage {
+	return &memBackendStorage{objects: make(map[string][]byte)}
+}
+
+func (s *memBackendStorage) ToProperties() map[string]string { return nil }
+
+func (s *memBackendStorage) NewStorageFile(key string, tierInfo *volume_server_pb.VolumeInfo) BackendStorageFile {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return &memRemoteFile{key: key, data: s.objects[key], tierInfo: tierInfo}
+}
+
+func (s *memBackendStorage) CopyFile(f *os.File, fn func(progressed int64, percentage float32) error) (string, int64, error) {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return "", 0, err
+	}
+	s.lock.Lock()
+	block := s.block
+	s.lock.Unlock()
+	if block != nil {
+		<-block
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.failing {
+		return "", 0, fmt.Errorf("remote unavailable")
+	}
+	s.seq++
+	key := fmt.Sprintf("segment-%d", s.seq)
+	s.objects[key] = data
+	return key, int64(len(data)), nil
+}
+
+// 之后的上传等返回的函数被调用后才完成
+func (s *memBackendStorage) blockUploads() (release func()) {
+	block := make(chan struct{})
+	s.lock.Lock()
+	s.block = block
+	s.lock.Unlock()
+	return func() { close(block) }
+}
+
+func (s *memBackendStorage) DownloadFile(fileName string, key string, fn func(progressed int64, percentage float32) error) (int64, error) {
+	s.lock.Lock()
+	data := s.objects[key]
+	s.lock.Unlock()
+	return int64(len(data)), os.WriteFile(fileName, data, 0644)
+}
+
+func (s *memBackendStorage) DeleteFile(key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.objects, key)
+	return nil
+}
+
+type memRemoteFile struct {
+	key      string
+	data     []byte
+	tierInfo *volume_server_pb.VolumeInfo
+}
+
+func (f *memRemoteFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(f.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, f.data[off:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+func (f *memRemoteFile) WriteAt(p []byte, off int64) (int, error) { return 0, os.ErrPermission }
+func (f *memRemoteFile) Truncate(off int64) error                 { return os.ErrPermission }
+func (f *memRemoteFile) Close() error                             { return nil }
+func (f *memRemoteFile) GetStat() (int64, time.Time, error) {
+	file := f.tierInfo.GetFiles()[0]
+	return int64(file.FileSize), time.Unix(int64(file.ModifiedTime), 0), nil
+}
+func (f *memRemoteFile) Name() string { return f.key }
+func (f *memRemoteFile) Sync() error  { return nil }
+
+// 按 onFlush 保存的段列表模拟 .vif
+type savedRemoteFiles struct {
+	files []*volume_server_pb.RemoteFile
+	fail  bool
+}
+
+func (s *savedRemoteFiles) save(files []*volume_server_pb.RemoteFile) error {
+	if s.fail {
+		return fmt.Errorf("disk full")
+	}
+	s.files = files
+	return nil
+}
+
+// 按实例地址命名注册，同一个实例重复注册得到同一个名称
+func registerMemBackendStorage(t *testing.T, storage *memBackendStorage) string {
+	name := fmt.Sprintf("mem.%p", storage)
+	backendStoragesLock.Lock()
+	defer backendStoragesLock.Unlock()
+	if _, found := BackendStorages[name]; !found {
+		BackendStorages[name] = storage
+		t.Cleanup(func() {
+			backendStoragesLock.Lock()
+			defer backendStoragesLock.Unlock()
+			delete(BackendStorages, name)
+		})
+	}
+	return name
+}
+
+func openWriteBackFile(t *testing.T, storage *memBackendStorage, saved *savedRemoteFiles, stagingFileName string, threshold int64) *WriteBackRemoteFile {
+	storageName := registerMemBackendStorage(t, storage)
+	cache, _ := NewRemoteBlockCache("", 1024*1024, 0)
+	f, err := NewWriteBackRemoteFile(storageName, saved.files, stagingFileName, WriteBackOption{
+		Cache:          cache,
+		CacheOption:    RemoteCacheOption{BlockSize: 64},
+		FlushThreshold: threshold,
+	}, saved.save)
+	if err != nil {
+		t.Fatalf("open write back file: %v", err)
+	}
+	return f
+}
+
+func checkFileContent(t *testing.T, f BackendStorageFile, expected []byte) {
+	t.Helper()
+	if size, _, err := f.GetStat(); err != nil || size != int64(len(expected)) {
+		t.Fatalf("size %d %v, expected %d", size, err, len(expected))
+	}
+	buf := make([]byte, len(expected)+10)
+	n, err := f.ReadAt(buf, 0)
+	if n != len(expected) || err != io.EOF || !bytes.Equal(buf[:n], expected) {
+		t.Fatalf("read %d %v, content mismatch", n, err)
+	}
+	// 跨段的小读取
+	for off := 0; off+7 <= len(expected); off += 5 {
+		if n, err = f.ReadAt(buf[:7], int64(off)); err != nil || !bytes.Equal(buf[:7], expected[off:off+7]) {
+			t.Fatalf("read at %d: %d %v", off, n, err)
+		}
+	}
+}
+
+func TestWriteBackRemoteFileAppendFlushReopen(t *testing.T) {
+	storage := newMemBackendStorage()
+	storage.objects["base"] = bytes.Repeat([]byte("b"), 100)
+	saved := &savedRemoteFiles{files: []*volume_server_pb.RemoteFile{{BackendType: "mem", Key: "base", FileSize: 100}}}
+	stagingFileName := filepath.Join(t.TempDir(), "1.stg")
+
+	f := openWriteBackFile(t, storage, saved, stagingFileName, 50)
+	expected := append([]byte(nil), storage.objects["base"]...)
+	if _, err := f.WriteAt([]byte("x"), 10); err == nil {
+		t.Fatalf("expect overwrite of remote data rejected")
+	}
+	if _, err := f.WriteAt([]byte("x"), 101); err == nil {
+		t.Fatalf("expect gap rejected")
+	}
+
+	// 不到阈值时留在暂存文件中
+	for i := 0; i < 4; i++ {
+		chunk := bytes.Repeat([]byte{byte('0' + i)}, 10)
+		if _, err := f.WriteAt(chunk, int64(len(expected))); err != nil {
+			t.Fatalf("append %d: %v", i, err)
+		}
+		expected = append(expected, chunk...)
+	}
+	if f.StagedSize() != 40 || len(saved.files) != 1 {
+		t.Fatalf("staged %d, files %d", f.StagedSize(), len(saved.files))
+	}
+	checkFileContent(t, f, expected)
+
+	// 写入失败时截断回去
+	if err := f.Truncate(130); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	expected = expected[:130]
+	if err := f.Truncate(50); err == nil {
+		t.Fatalf("expect truncate into remote data rejected")
+	}
+
+	// 超过阈值在后台上传为新的一段，Flush 等上传完成，偏移接在后面
+	chunk := bytes.Repeat([]byte("z"), 25)
+	if _, err := f.WriteAt(chunk, 130); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, chunk...)
+	if err := f.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if f.StagedSize() != 0 || len(saved.files) != 2 || saved.files[1].Offset != 100 || saved.files[1].FileSize != 55 {
+		t.Fatalf("staged %d, files %v", f.StagedSize(), saved.files)
+	}
+	checkFileContent(t, f, expected)
+
+	// 关闭时上传剩余的暂存数据
+	if _, err := f.WriteAt([]byte("tail"), int64(len(expected))); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, "tail"...)
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if len(saved.files) != 3 {
+		t.Fatalf("files %d after close", len(saved.files))
+	}
+	if _, err := os.Stat(stagingFileName); !os.IsNotExist(err) {
+		t.Fatalf("staging file should be removed: %v", err)
+	}
+
+	f = openWriteBackFile(t, storage, saved, stagingFileName, 0)
+	defer f.Close()
+	checkFileContent(t, f, expected)
+}
+
+func TestWriteBackRemoteFileRecoverStaging(t *testing.T) {
+	storage := newMemBackendStorage()
+	storage.objects["base"] = bytes.Repeat([]byte("b"), 16)
+	saved := &savedRemoteFiles{files: []*volume_server_pb.RemoteFile{{Key: "base", FileSize: 16}}}
+	stagingFileName := filepath.Join(t.TempDir(), "1.stg")
+
+	// 远程不可用时暂存数据保留在本地，下次打开时恢复
+	f := openWriteBackFile(t, storage, saved, stagingFileName, 0)
+	if _, err := f.WriteAt([]byte("0123456789"), 16); err != nil {
+		t.Fatal(err)
+	}
+	storage.failing = true
+	if err := f.Close(); err == nil {
+		t.Fatalf("expect flush error on close")
+	}
+	storage.failing = false
+	expected := append(bytes.Repeat([]byte("b"), 16), "0123456789"...)
+
+	f = openWriteBackFile(t, storage, saved, stagingFileName, 0)
+	if f.StagedSize() != 10 {
+		t.Fatalf("recovered %d staged bytes", f.StagedSize())
+	}
+	checkFileContent(t, f, expected)
+
+	// .vif 保存失败时新段作废
+	saved.fail = true
+	if err := f.Flush(); err == nil {
+		t.Fatalf("expect flush error")
+	}
+	saved.fail = false
+	if len(storage.objects) != 1 || f.StagedSize() != 10 {
+		t.Fatalf("objects %d, staged %d", len(storage.objects), f.StagedSize())
+	}
+
+	// 模拟上传并保存 .vif 之后、清理暂存文件之前崩溃：暂存文件的头部仍指向旧偏移，内容包含已上传的部分
+	if err := f.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	f.DiscardStaging()
+	f.Close()
+	stale := make([]byte, stagingHeaderSize)
+	util.Uint64toBytes(stale, 16)
+	stale = append(stale, "0123456789abc"...)
+	if err := os.WriteFile(stagingFileName, stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, "abc"...)
+
+	f = openWriteBackFile(t, storage, saved, stagingFileName, 0)
+	defer f.Close()
+	if f.StagedSize() != 3 {
+		t.Fatalf("staged %d after skipping flushed prefix", f.StagedSize())
+	}
+	checkFileContent(t, f, expected)
+}
+
+// 上传在后台进行，期间读写不被阻塞，上传期间追加的数据留在暂存文件中
+func TestWriteBackRemoteFileBackgroundUpload(t *testing.T) {
+	storage := newMemBackendStorage()
+	storage.objects["base"] = bytes.Repeat([]byte("b"), 10)
+	saved := &savedRemoteFiles{files: []*volume_server_pb.RemoteFile{{Key: "base", FileSize: 10}}}
+	stagingFileName := filepath.Join(t.TempDir(), "1.stg")
+	f := openWriteBackFile(t, storage, saved, stagingFileName, 20)
+	expected := append([]byte(nil), storage.objects["base"]...)
+	write := func(data string) {
+		t.Helper()
+		if _, err := f.WriteAt([]byte(data), int64(len(expected))); err != nil {
+			t.Fatalf("write %q: %v", data, err)
+		}
+		expected = append(expected, data...)
+	}
+
+	release := storage.blockUploads()
+	write("0123456789abcdefghijklmn")
+	write("during")
+	checkFileContent(t, f, expected)
+	if len(saved.files) != 1 {
+		t.Fatalf("files %d before upload finished", len(saved.files))
+	}
+
+	release()
+	if err := f.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(saved.files) != 3 || saved.files[1].FileSize != 24 || saved.files[2].Offset != 34 || f.StagedSize() != 0 {
+		t.Fatalf("staged %d, files %v", f.StagedSize(), saved.files)
+	}
+	checkFileContent(t, f, expected)
+
+	// 上传期间被截断的部分作废，数据仍留在暂存文件中
+	release = storage.blockUploads()
+	write("0123456789abcdefghijklmn")
+	if err := f.Truncate(int64(len(expected) - 4)); err != nil {
+		t.Fatal(err)
+	}
+	expected = expected[:len(expected)-4]
+	release()
+	if err := f.Flush(); err == nil {
+		t.Fatalf("expect stale upload rejected")
+	}
+	if len(saved.files) != 3 || f.StagedSize() != 20 {
+		t.Fatalf("staged %d, files %d", f.StagedSize(), len(saved.files))
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+
+	f = openWriteBackFile(t, storage, saved, stagingFileName, 0)
+	defer f.Close()
+	checkFileContent(t, f, expected)
+}
+
+// 配置热加载替换实例后，已打开的文件改用新的实例读取和上传
+func TestWriteBackRemoteFileStorageReplaced(t *testing.T) {
+	storage := newMemBackendStorage()
+	storage.objects["base"] = bytes.Repeat([]byte("b"), 10)
+	saved := &savedRemoteFiles{files: []*volume_server_pb.RemoteFile{{Key: "base", FileSize: 10}}}
+	f := openWriteBackFile(t, storage, saved, filepath.Join(t.TempDir(), "1.stg"), 0)
+	defer f.Close()
+	if _, err := f.WriteAt([]byte("0123456789"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+
+	// 新实例的数据可以区分；旧实例不再可用
+	replaced := newMemBackendStorage()
+	replaced.seq = 10
+	replaced.objects["base"] = bytes.Repeat([]byte("r"), 10)
+	replaced.objects["segment-1"] = []byte("ABCDEFGHIJ")
+	storage.lock.Lock()
+	storage.failing = true
+	storage.lock.Unlock()
+	backendStoragesLock.Lock()
+	BackendStorages[fmt.Sprintf("mem.%p", storage)] = replaced
+	backendStoragesLock.Unlock()
+
+	checkFileContent(t, f, []byte("rrrrrrrrrrABCDEFGHIJ"))
+	if _, err := f.WriteAt([]byte("klmno"), 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Flush(); err != nil {
+		t.Fatalf("flush after replace: %v", err)
+	}
+	if len(saved.files) != 3 || saved.files[2].Key != "segment-11" || string(replaced.objects["segment-11"]) != "klmno" {
+		t.Fatalf("files %v", saved.files)
+	}
+	checkFileContent(t, f, []byte("rrrrrrrrrrABCDEFGHIJklmno"))
+}
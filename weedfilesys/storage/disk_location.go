@@ -0,0 +1,455 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/stats"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/storage/volume_info"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 检查磁盘剩余空间的间隔
+const diskSpaceCheckInterval = time.Minute
+
+// DiskLocation 一个数据目录，目录下的卷和 EC 分片都归它管理
+type DiskLocation struct {
+	Directory              string
+	IdxDirectory           string // .idx 等索引文件可以放在单独的（更快的）磁盘上
+	DiskType               types.DiskType
+	MaxVolumeCount         int32 // 0 表示根据磁盘空间自动计算，见 Store.MaybeAdjustVolumeMax
+	OriginalMaxVolumeCount int32
+	MinFreeSpace           util.MinFreeSpace
+
+	volumes     map[needle.VolumeId]*Volume
+	volumesLock sync.RWMutex
+
+	ecVolumes     map[needle.VolumeId]*erasure_coding.EcVolume
+	ecVolumesLock sync.RWMutex
+
+	isDiskSpaceLow bool
+	closeCh        chan struct{}
+}
+
+func NewDiskLocation(dir string, maxVolumeCount int32, minFreeSpace util.MinFreeSpace, idxDir string, diskType types.DiskType) *DiskLocation {
+	dir = util.ResolvePath(dir)
+	if idxDir == "" {
+		idxDir = dir
+	} else {
+		idxDir = util.ResolvePath(idxDir)
+	}
+	location := &DiskLocation{
+		Directory:              dir,
+		IdxDirectory:           idxDir,
+		DiskType:               diskType,
+		MaxVolumeCount:         maxVolumeCount,
+		OriginalMaxVolumeCount: maxVolumeCount,
+		MinFreeSpace:           minFreeSpace,
+	}
+	location.volumes = make(map[needle.VolumeId]*Volume)
+	location.ecVolumes = make(map[needle.VolumeId]*erasure_coding.EcVolume)
+	location.closeCh = make(chan struct{})
+	go location.CheckDiskSpace()
+	return location
+}
+
+// 文件名格式为 collection_id.ext 或 id.ext
+func volumeIdFromFileName(filename string) (needle.VolumeId, string, error) {
+	if isValidVolume(filename) {
+		base := filename[:len(filename)-4]
+		collection, volumeId, err := parseCollectionVolumeId(base)
+		return volumeId, collection, err
+	}
+	return 0, "", fmt.Errorf("file is not a volume: %s", filename)
+}
+
+func parseCollectionVolumeId(base string) (collection string, vid needle.VolumeId, err error) {
+	i := strings.LastIndex(base, "_")
+	if i > 0 {
+		collection, base = base[0:i], base[i+1:]
+	}
+	vol, err := needle.NewVolumeId(base)
+	return collection, vol, err
+}
+
+func isValidVolume(basename string) bool {
+	return strings.HasSuffix(basename, ".idx") || strings.HasSuffix(basename, ".vif")
+}
+
+func getValidVolumeName(basename string) string {
+	if isValidVolume(basename) {
+		return basename[:len(basename)-4]
+	}
+	return ""
+}
+
+func (l *DiskLocation) loadExistingVolume(dirEntry os.DirEntry, needleMapKind NeedleMapKind) bool {
+	basename := dirEntry.Name()
+	if dirEntry.IsDir() {
+		return false
+	}
+	volumeName := getValidVolumeName(basename)
+	if volumeName == "" {
+		return false
+	}
+
+	// .idx 和 .dat 必须都在，只有 .idx 说明 .dat 已经被删除或者迁移走了；
+	// 分层到远程的卷本地没有 .dat，由 .vif 中的远程文件代替
+	if !util.FileExists(filepath.Join(l.Directory, volumeName+".dat")) {
+		if _, hasRemoteFile, _, _ := volume_info.MaybeLoadVolumeInfo(filepath.Join(l.Directory, volumeName+".vif")); !hasRemoteFile {
+			glog.Warningf("volume %s is missing .dat file", volumeName)
+			return false
+		}
+	}
+
+	// 压缩过程中崩溃留下的临时文件
+	if util.FileExists(filepath.Join(l.Directory, volumeName+".cpd")) || util.FileExists(filepath.Join(l.IdxDirectory, volumeName+".cpx")) {
+		glog.Warningf("volume %s has unfinished compaction files", volumeName)
+	}
+
+	vid, collection, err := volumeIdFromFileName(basename)
+	if err != nil {
+		glog.Warningf("get volume id failed, %s, err : %s", volumeName, err)
+		return false
+	}
+
+	// 同一个卷同时有 .idx 和 .vif 时只加载一次
+	l.volumesLock.RLock()
+	_, found := l.volumes[vid]
+	l.volumesLock.RUnlock()
+	if found {
+		glog.V(1).Infof("loaded volume, %v", vid)
+		return true
+	}
+
+	v, e := NewVolume(l.Directory, l.IdxDirectory, collection, vid, needleMapKind, nil, nil, 0, 0)
+	if e != nil {
+		glog.V(0).Infof("new volume %s error %s", volumeName, e)
+		return false
+	}
+
+	l.SetVolume(vid, v)
+
+	size, _, _ := v.FileStat()
+	glog.V(0).Infof("data file %s, replication=%s v=%d size=%d ttl=%s needleMap=%s",
+		l.Directory+"/"+volumeName+".dat", v.ReplicaPlacement, v.Version(), size, v.Ttl.String(), needleMapKind)
+	return true
+}
+
+// 用多个协程并发加载目录下的卷，卷多的时候能明显缩短启动时间
+func (l *DiskLocation) concurrentLoadingVolumes(needleMapKind NeedleMapKind, concurrency int) {
+	task_queue := make(chan os.DirEntry, 10*concurrency)
+	go func() {
+		foundVolumeNames := make(map[string]bool)
+		if dirEntries, err := os.ReadDir(l.Directory); err == nil {
+			for _, entry := range dirEntries {
+				volumeName := getValidVolumeName(entry.Name())
+				if volumeName == "" {
+					continue
+				}
+				if _, found := foundVolumeNames[volumeName]; !found {
+					foundVolumeNames[volumeName] = true
+					task_queue <- entry
+				}
+			}
+		}
+		close(task_queue)
+	}()
+
+	var wg sync.WaitGroup
+	for workerNum := 0; workerNum < concurrency; workerNum++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fi := range task_queue {
+				_ = l.loadExistingVolume(fi, needleMapKind)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (l *DiskLocation) loadExistingVolumes(needleMapKind NeedleMapKind, concurrency int) {
+	if concurrency <= 0 {
+		concurrency = runtime.NumCPU()
+	}
+	l.concurrentLoadingVolumes(needleMapKind, concurrency)
+	glog.V(0).Infof("Store started on dir: %s with %d volumes max %d", l.Directory, len(l.volumes), l.MaxVolumeCount)
+
+	l.loadAllEcShards()
+	glog.V(0).Infof("Store started on dir: %s with %d ec shards", l.Directory, len(l.ecVolumes))
+}
+
+func (l *DiskLocation) DeleteCollectionFromDiskLocation(collection string) (e error) {
+	l.volumesLock.Lock()
+	delVolsMap := l.unmountVolumeByCollection(collection)
+	l.volumesLock.Unlock()
+
+	l.ecVolumesLock.Lock()
+	delEcVolsMap := l.unmountEcVolumeByCollection(collection)
+	l.ecVolumesLock.Unlock()
+
+	errChain := make(chan error, 2)
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		for _, v := range delVolsMap {
+			if err := v.Destroy(); err != nil {
+				errChain <- err
+			}
+		}
+		wg.Done()
+	}()
+
+	go func() {
+		for _, v := range delEcVolsMap {
+			v.Destroy()
+		}
+		wg.Done()
+	}()
+
+	go func() {
+		wg.Wait()
+		close(errChain)
+	}()
+
+	errBuilder := strings.Builder{}
+	for err := range errChain {
+		errBuilder.WriteString(err.Error())
+		errBuilder.WriteString("; ")
+	}
+	if errBuilder.Len() > 0 {
+		e = fmt.Errorf("%s", errBuilder.String())
+	}
+
+	return
+}
+
+func (l *DiskLocation) deleteVolumeById(vid needle.VolumeId) (found bool, e error) {
+	v, ok := l.volumes[vid]
+	if !ok {
+		return
+	}
+	e = v.Destroy()
+	if e != nil {
+		return
+	}
+	found = true
+	delete(l.volumes, vid)
+	return
+}
+
+// takeVolumes 从目录中摘下仍然挂载着的卷，由调用方在锁外销毁；正在压缩的卷跳过
+func (l *DiskLocation) takeVolumes(volumes []*Volume) (taken []*Volume) {
+	if len(volumes) == 0 {
+		return nil
+	}
+	l.volumesLock.Lock()
+	defer l.volumesLock.Unlock()
+	for _, v := range volumes {
+		if l.volumes[v.Id] == v && !v.isCompacting {
+			delete(l.volumes, v.Id)
+			taken = append(taken, v)
+		}
+	}
+	return
+}
+
+// LoadVolume 加载目录下已存在的卷，用于卷从其他节点复制过来之后挂载
+func (l *DiskLocation) LoadVolume(vid needle.VolumeId, needleMapKind NeedleMapKind) bool {
+	if fileInfo, found := l.LocateVolume(vid); found {
+		return l.loadExistingVolume(fileInfo, needleMapKind)
+	}
+	return false
+}
+
+var ErrVolumeNotFound = fmt.Errorf("volume not found")
+
+func (l *DiskLocation) DeleteVolume(vid needle.VolumeId) error {
+	l.volumesLock.Lock()
+	defer l.volumesLock.Unlock()
+
+	_, ok := l.volumes[vid]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+	_, err := l.deleteVolumeById(vid)
+	return err
+}
+
+// UnloadVolume 只关闭卷，不删除文件
+func (l *DiskLocation) UnloadVolume(vid needle.VolumeId) error {
+	l.volumesLock.Lock()
+	defer l.volumesLock.Unlock()
+
+	v, ok := l.volumes[vid]
+	if !ok {
+		return ErrVolumeNotFound
+	}
+	v.Close()
+	delete(l.volumes, vid)
+	return nil
+}
+
+func (l *DiskLocation) unmountVolumeByCollection(collectionName string) map[needle.VolumeId]*Volume {
+	deltaVols := make(map[needle.VolumeId]*Volume, 0)
+	for k, v := range l.volumes {
+		if v.Collection == collectionName && !v.isCompacting {
+			deltaVols[k] = v
+		}
+	}
+
+	for k := range deltaVols {
+		delete(l.volumes, k)
+	}
+	return deltaVols
+}
+
+func (l *DiskLocation) SetVolume(vid needle.VolumeId, volume *Volume) {
+	l.volumesLock.Lock()
+	defer l.volumesLock.Unlock()
+
+	l.volumes[vid] = volume
+	volume.location = l
+	volume.dataFileAccessLock.Lock()
+	volume.diskSpaceLow = l.isDiskSpaceLow
+	volume.dataFileAccessLock.Unlock()
+}
+
+func (l *DiskLocation) FindVolume(vid needle.VolumeId) (*Volume, bool) {
+	l.volumesLock.RLock()
+	defer l.volumesLock.RUnlock()
+
+	v, ok := l.volumes[vid]
+	return v, ok
+}
+
+func (l *DiskLocation) VolumesLen() int {
+	l.volumesLock.RLock()
+	defer l.volumesLock.RUnlock()
+
+	return len(l.volumes)
+}
+
+// VolumeIds 按卷 id 升序返回
+func (l *DiskLocation) VolumeIds() (ids []needle.VolumeId) {
+	l.volumesLock.RLock()
+	for vid := range l.volumes {
+		ids = append(ids, vid)
+	}
+	l.volumesLock.RUnlock()
+	sortVolumeIds(ids)
+	return
+}
+
+func (l *DiskLocation) Close() {
+	l.volumesLock.Lock()
+	for _, v := range l.volumes {
+		v.Close()
+	}
+	l.volumesLock.Unlock()
+
+	l.ecVolumesLock.Lock()
+	for _, ecVolume := range l.ecVolumes {
+		ecVolume.Close()
+	}
+	l.ecVolumesLock.Unlock()
+
+	close(l.closeCh)
+	return
+}
+
+func (l *DiskLocation) LocateVolume(vid needle.VolumeId) (os.DirEntry, bool) {
+	if dirEntries, err := os.ReadDir(l.Directory); err == nil {
+		for _, entry := range dirEntries {
+			volId, _, err := volumeIdFromFileName(entry.Name())
+			if vid == volId && err == nil {
+				return entry, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+func (l *DiskLocation) UnUsedSpace(volumeSizeLimit uint64) (unUsedSpace uint64) {
+	l.volumesLock.RLock()
+	defer l.volumesLock.RUnlock()
+
+	for _, vol := range l.volumes {
+		if vol.IsReadOnly() {
+			continue
+		}
+		datSize, idxSize, _ := vol.FileStat()
+		unUsedSpaceVolume := int64(volumeSizeLimit) - int64(datSize+idxSize)
+		glog.V(4).Infof("Volume stats for %d: volumeSizeLimit=%d, datSize=%d idxSize=%d unused=%d", vol.Id, volumeSizeLimit, datSize, idxSize, unUsedSpaceVolume)
+		if unUsedSpaceVolume >= 0 {
+			unUsedSpace += uint64(unUsedSpaceVolume)
+		}
+	}
+
+	return
+}
+
+// IsDiskSpaceLow 剩余空间不足时只允许读和删除，不再分配新卷，已有的卷也不再接受写入
+func (l *DiskLocation) IsDiskSpaceLow() bool {
+	l.volumesLock.RLock()
+	defer l.volumesLock.RUnlock()
+	return l.isDiskSpaceLow
+}
+
+// CheckDiskSpace 定期检查剩余空间，状态变化时记录日志
+func (l *DiskLocation) CheckDiskSpace() {
+	l.checkDiskSpace()
+	ticker := time.NewTicker(diskSpaceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.closeCh:
+			return
+		case <-ticker.C:
+			l.checkDiskSpace()
+		}
+	}
+}
+
+func (l *DiskLocation) checkDiskSpace() {
+	if dir, e := filepath.Abs(l.Directory); e == nil {
+		s := stats.NewDiskStatus(dir)
+		stats.VolumeServerResourceGauge.WithLabelValues(l.Directory, "all").Set(float64(s.All))
+		stats.VolumeServerResourceGauge.WithLabelValues(l.Directory, "used").Set(float64(s.Used))
+		stats.VolumeServerResourceGauge.WithLabelValues(l.Directory, "free").Set(float64(s.Free))
+
+		isLow, desc := l.MinFreeSpace.IsLow(s.Free, s.PercentFree)
+		l.volumesLock.Lock()
+		changed := isLow != l.isDiskSpaceLow
+		l.isDiskSpaceLow = isLow
+		// 每次都同步到所有卷，期间新挂载或重新加载的卷也能跟上
+		for _, v := range l.volumes {
+			v.dataFileAccessLock.Lock()
+			v.diskSpaceLow = isLow
+			v.dataFileAccessLock.Unlock()
+		}
+		l.volumesLock.Unlock()
+		if changed {
+			logLevel := glog.Level(4)
+			if isLow {
+				logLevel = glog.Level(0)
+			}
+			glog.V(logLevel).Infof("dir %s %s", dir, desc)
+		}
+	}
+}
+
+func (l *DiskLocation) String() string {
+	return l.Directory + "(" + l.DiskType.ReadableString() + ")" + "[" + strconv.Itoa(int(l.MaxVolumeCount)) + "]"
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+var ecShardExtRegex = regexp.MustCompile(`\.ec[0-9][0-9]`)
+
+func (l *DiskLocation) FindEcVolume(vid needle.VolumeId) (*erasure_coding.EcVolume, bool) {
+	l.ecVolumesLock.RLock()
+	defer l.ecVolumesLock.RUnlock()
+
+	ecVolume, ok := l.ecVolumes[vid]
+	if ok {
+		return ecVolume, true
+	}
+	return nil, false
+}
+
+func (l *DiskLocation) DestroyEcVolume(vid needle.VolumeId) {
+	l.ecVolumesLock.Lock()
+	defer l.ecVolumesLock.Unlock()
+
+	ecVolume, found := l.ecVolumes[vid]
+	if found {
+		ecVolume.Destroy()
+		delete(l.ecVolumes, vid)
+	}
+}
+
+func (l *DiskLocation) FindEcShard(vid needle.VolumeId, shardId erasure_coding.ShardId) (*erasure_coding.EcVolumeShard, bool) {
+	l.ecVolumesLock.RLock()
+	defer l.ecVolumesLock.RUnlock()
+
+	ecVolume, ok := l.ecVolumes[vid]
+	if !ok {
+		return nil, false
+	}
+	for _, ecShard := range ecVolume.Shards {
+		if ecShard.ShardId == shardId {
+			return ecShard, true
+		}
+	}
+	return nil, false
+}
+
+// LoadEcShard 挂载一个分片，同一个卷的第一个分片挂载时打开 .ecx/.ecj
+func (l *DiskLocation) LoadEcShard(collection string, vid needle.VolumeId, shardId erasure_coding.ShardId) (*erasure_coding.EcVolume, error) {
+	ecVolumeShard, err := erasure_coding.NewEcVolumeShard(l.DiskType, l.Directory, collection, vid, shardId)
+	if err != nil {
+		if err == os.ErrNotExist {
+			return nil, os.ErrNotExist
+		}
+		return nil, fmt.Errorf("failed to create ec shard %d.%d: %v", vid, shardId, err)
+	}
+	l.ecVolumesLock.Lock()
+	defer l.ecVolumesLock.Unlock()
+	ecVolume, found := l.ecVolumes[vid]
+	if !found {
+		ecVolume, err = erasure_coding.NewEcVolume(l.DiskType, l.Directory, l.IdxDirectory, collection, vid)
+		if err != nil {
+			ecVolumeShard.Close()
+			return nil, fmt.Errorf("failed to create ec volume %d: %v", vid, err)
+		}
+		l.ecVolumes[vid] = ecVolume
+	}
+	if int(shardId) >= ecVolume.Scheme.TotalShards() {
+		ecVolumeShard.Close()
+		if len(ecVolume.Shards) == 0 {
+			delete(l.ecVolumes, vid)
+			ecVolume.Close()
+		}
+		return nil, fmt.Errorf("ec shard %d.%d is out of scheme %s", vid, shardId, ecVolume.Scheme)
+	}
+	ecVolume.AddEcVolumeShard(ecVolumeShard)
+
+	return ecVolume, nil
+}
+
+// UnloadEcShard 卸载一个分片，最后一个分片卸载后关闭整个 EC 卷
+func (l *DiskLocation) UnloadEcShard(vid needle.VolumeId, shardId erasure_coding.ShardId) bool {
+	l.ecVolumesLock.Lock()
+	defer l.ecVolumesLock.Unlock()
+
+	ecVolume, found := l.ecVolumes[vid]
+	if !found {
+		return false
+	}
+	if _, deleted := ecVolume.DeleteEcVolumeShard(shardId); deleted {
+		if len(ecVolume.Shards) == 0 {
+			delete(l.ecVolumes, vid)
+			ecVolume.Close()
+		}
+		return true
+	}
+
+	return true
+}
+
+func (l *DiskLocation) loadEcShards(shards []string, collection string, vid needle.VolumeId) (err error) {
+	for _, shard := range shards {
+		shardId, err := strconv.ParseInt(path.Ext(shard)[3:], 10, 64)
+		if err != nil {
+			return fmt.Errorf("failed to parse ec shard name %v: %w", shard, err)
+		}
+
+		_, err = l.LoadEcShard(collection, vid, erasure_coding.ShardId(shardId))
+		if err != nil {
+			return fmt.Errorf("failed to load ec shard %v: %w", shard, err)
+		}
+	}
+
+	return nil
+}
+
+// 目录下的文件按名字排序后，同一个卷的 .ec00~.ec13 排在 .ecx 前面，
+// 遇到 .ecx 时把之前收集到的分片一起挂载
+func (l *DiskLocation) loadAllEcShards() (err error) {
+	dirEntries, err := os.ReadDir(l.Directory)
+	if err != nil {
+		return fmt.Errorf("load all ec shards in dir %s: %v", l.Directory, err)
+	}
+	if l.IdxDirectory != l.Directory {
+		indexDirEntries, err := os.ReadDir(l.IdxDirectory)
+		if err != nil {
+			return fmt.Errorf("load all ec shards in dir %s: %v", l.IdxDirectory, err)
+		}
+		dirEntries = append(dirEntries, indexDirEntries...)
+	}
+	slices.SortFunc(dirEntries, func(a, b os.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	var sameVolumeShards []string
+	var prevVolumeId needle.VolumeId
+	for _, fileInfo := range dirEntries {
+		if fileInfo.IsDir() {
+			continue
+		}
+		ext := path.Ext(fileInfo.Name())
+		name := fileInfo.Name()
+		baseName := name[:len(name)-len(ext)]
+
+		collection, volumeId, err := parseCollectionVolumeId(baseName)
+		if err != nil {
+			continue
+		}
+
+		info, err := fileInfo.Info()
+		if err != nil {
+			continue
+		}
+		// 空文件一般是复制失败留下的
+		if ecShardExtRegex.MatchString(ext) && info.Size() > 0 {
+			if prevVolumeId == 0 || volumeId == prevVolumeId {
+				sameVolumeShards = append(sameVolumeShards, fileInfo.Name())
+			} else {
+				sameVolumeShards = []string{fileInfo.Name()}
+			}
+			prevVolumeId = volumeId
+			continue
+		}
+
+		if ext == ".ecx" && volumeId == prevVolumeId {
+			if err = l.loadEcShards(sameVolumeShards, collection, volumeId); err != nil {
+				return fmt.Errorf("loadEcShards collection:%v volumeId:%d : %v", collection, volumeId, err)
+			}
+			prevVolumeId = volumeId
+			continue
+		}
+	}
+	return nil
+}
+
+func (l *DiskLocation) deleteEcVolumeById(vid needle.VolumeId) (e error) {
+	ecVolume, ok := l.ecVolumes[vid]
+	if !ok {
+		return
+	}
+	ecVolume.Destroy()
+	delete(l.ecVolumes, vid)
+	return
+}
+
+func (l *DiskLocation) unmountEcVolumeByCollection(collectionName string) map[needle.VolumeId]*erasure_coding.EcVolume {
+	deltaVols := make(map[needle.VolumeId]*erasure_coding.EcVolume, 0)
+	for k, v := range l.ecVolumes {
+		if v.Collection == collectionName {
+			deltaVols[k] = v
+		}
+	}
+
+	for k := range deltaVols {
+		delete(l.ecVolumes, k)
+	}
+	return deltaVols
+}
+
+// 删除分片文件；不再有该卷的分片时删除 .ecx 和 .ecj，原卷也不在时再删除 .vif
+func (l *DiskLocation) deleteEcShardFiles(collection string, vid needle.VolumeId, shardIds []erasure_coding.ShardId) error {
+	dataBaseFileName := erasure_coding.EcShardFileName(collection, l.Directory, int(vid))
+	indexBaseFileName := erasure_coding.EcShardFileName(collection, l.IdxDirectory, int(vid))
+	for _, shardId := range shardIds {
+		if err := os.Remove(dataBaseFileName + erasure_coding.ToExt(int(shardId))); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	for i := 0; i < erasure_coding.MaxShardCount; i++ {
+		if util.FileExists(dataBaseFileName + erasure_coding.ToExt(i)) {
+			return nil
+		}
+	}
+	os.Remove(indexBaseFileName + ".ecx")
+	os.Remove(indexBaseFileName + ".ecj")
+	if _, found := l.FindVolume(vid); !found {
+		os.Remove(dataBaseFileName + ".vif")
+	}
+	return nil
+}
+
+func (l *DiskLocation) EcShardCount() int {
+	l.ecVolumesLock.RLock()
+	defer l.ecVolumesLock.RUnlock()
+
+	shardCount := 0
+	for _, ecVolume := range l.ecVolumes {
+		shardCount += len(ecVolume.Shards)
+	}
+	return shardCount
+}
+
+// 卸载已过期的 EC 卷并删除文件
+func (l *DiskLocation) destroyExpiredEcVolumes() (deleted []*erasure_coding.EcVolume) {
+	l.ecVolumesLock.Lock()
+	defer l.ecVolumesLock.Unlock()
+
+	for vid, ecVolume := range l.ecVolumes {
+		if ecVolume.IsTimeToDestroy() {
+			glog.V(0).Infof("ec volume %d is expired, destroying", vid)
+			deleted = append(deleted, ecVolume)
+			ecVolume.Destroy()
+			delete(l.ecVolumes, vid)
+		}
+	}
+	return
+}
@@ -7,6 +7,7 @@ import (
 	"cayoyibackend/weedfilesys/storage/needle_map"
 	"cayoyibackend/weedfilesys/storage/super_block"
 	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
 	"fmt"
 	"io"
 	"os"
@@ -166,8 +167,8 @@ func iterateEcjFile(baseFileName string, processNeedleFn func(key types.NeedleId
 
 }
 
-// WriteDatFile generates .dat from .ec00 ~ .ec09 files
-func WriteDatFile(baseFileName string, datFileSize int64, shardFileNames []string) error {
+// WriteDatFile generates .dat from the data shard files, shardFileNames has at least dataShards names
+func WriteDatFile(baseFileName string, dataShards int, datFileSize int64, shardFileNames []string) error {
 
 	datFile, openErr := os.OpenFile(baseFileName+".dat", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if openErr != nil {
@@ -175,25 +176,25 @@ func WriteDatFile(baseFileName string, datFileSize int64, shardFileNames []strin
 	}
 	defer datFile.Close()
 
-	inputFiles := make([]*os.File, DataShardsCount)
+	inputFiles := make([]*os.File, dataShards)
 
 	defer func() {
-		for shardId := 0; shardId < DataShardsCount; shardId++ {
+		for shardId := 0; shardId < dataShards; shardId++ {
 			if inputFiles[shardId] != nil {
 				inputFiles[shardId].Close()
 			}
 		}
 	}()
 
-	for shardId := 0; shardId < DataShardsCount; shardId++ {
+	for shardId := 0; shardId < dataShards; shardId++ {
 		inputFiles[shardId], openErr = os.OpenFile(shardFileNames[shardId], os.O_RDONLY, 0)
 		if openErr != nil {
 			return openErr
 		}
 	}
 
-	for datFileSize >= DataShardsCount*ErasureCodingLargeBlockSize {
-		for shardId := 0; shardId < DataShardsCount; shardId++ {
+	for datFileSize >= int64(dataShards)*ErasureCodingLargeBlockSize {
+		for shardId := 0; shardId < dataShards; shardId++ {
 			w, err := io.CopyN(datFile, inputFiles[shardId], ErasureCodingLargeBlockSize)
 			if w != ErasureCodingLargeBlockSize {
 				return fmt.Errorf("copy %s large block on shardId %d: %v", baseFileName, shardId, err)
@@ -203,7 +204,7 @@ func WriteDatFile(baseFileName string, datFileSize int64, shardFileNames []strin
 	}
 
 	for datFileSize > 0 {
-		for shardId := 0; shardId < DataShardsCount; shardId++ {
+		for shardId := 0; shardId < dataShards; shardId++ {
 			toRead := min(datFileSize, ErasureCodingSmallBlockSize)
 			w, err := io.CopyN(datFile, inputFiles[shardId], toRead)
 			if w != toRead {
@@ -2,9 +2,8 @@ package erasure_coding
 
 import (
 	"cayoyibackend/weedfilesys/glog"
-	"cayoyibackend/weedfilesys/storage/idx"
 	"cayoyibackend/weedfilesys/storage/needle_map"
-	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
 	"fmt"
 	"io"
 	"os"
@@ -32,6 +31,7 @@ import (
 //
 //.ecx 和 .ecj 可用于未来恢复 .idx 文件
 
+// 默认的 10+4 方案，也用于卷名额和分片名额的换算：一个卷名额可以放 DataShardsCount 个分片
 const (
 	DataShardsCount             = 10
 	ParityShardsCount           = 4
@@ -71,20 +71,23 @@ func WriteSortedFileFromIdx(baseFileName string, ext string) (e error) {
 	return nil
 }
 
-// WriteEcFiles generates .ec00 ~ .ec13 files
-func WriteEcFiles(baseFileName string) error {
-	return generateEcFiles(baseFileName, 256*1024, ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize)
+// WriteEcFiles generates .ec00 ~ .ecNN files of the scheme
+func WriteEcFiles(baseFileName string, scheme EcScheme) error {
+	return generateEcFiles(baseFileName, scheme, 256*1024, ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize)
 }
 
-func RebuildEcFiles(baseFileName string) ([]uint32, error) {
-	return generateMissingEcFiles(baseFileName, 256*1024, ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize)
+func RebuildEcFiles(baseFileName string, scheme EcScheme) ([]uint32, error) {
+	return generateMissingEcFiles(baseFileName, scheme, 256*1024, ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize)
 }
 
 func ToExt(ecIndex int) string {
 	return fmt.Sprintf(".ec%02d", ecIndex)
 }
 
-func generateEcFiles(baseFileName string, bufferSize int, largeBlockSize int64, smallBlockSize int64) error {
+func generateEcFiles(baseFileName string, scheme EcScheme, bufferSize int, largeBlockSize int64, smallBlockSize int64) error {
+	if err := scheme.Validate(); err != nil {
+		return err
+	}
 	file, err := os.OpenFile(baseFileName+".dat", os.O_RDONLY, 0)
 	if err != nil {
 		return fmt.Errorf("failed to open dat file: %w", err)
@@ -96,20 +99,24 @@ func generateEcFiles(baseFileName string, bufferSize int, largeBlockSize int64,
 		return fmt.Errorf("failed to stat dat file: %w", err)
 	}
 
-	glog.V(0).Infof("encodeDatFile %s.dat size:%d", baseFileName, fi.Size())
-	err = encodeDatFile(fi.Size(), baseFileName, bufferSize, largeBlockSize, file, smallBlockSize)
+	glog.V(0).Infof("encodeDatFile %s.dat size:%d scheme:%s", baseFileName, fi.Size(), scheme)
+	err = encodeDatFile(fi.Size(), baseFileName, scheme, bufferSize, largeBlockSize, file, smallBlockSize)
 	if err != nil {
 		return fmt.Errorf("encodeDatFile: %w", err)
 	}
 	return nil
 }
 
-func generateMissingEcFiles(baseFileName string, bufferSize int, largeBlockSize int64, smallBlockSize int64) (generatedShardIds []uint32, err error) {
+func generateMissingEcFiles(baseFileName string, scheme EcScheme, bufferSize int, largeBlockSize int64, smallBlockSize int64) (generatedShardIds []uint32, err error) {
+	if err = scheme.Validate(); err != nil {
+		return nil, err
+	}
 
-	shardHasData := make([]bool, TotalShardsCount)
-	inputFiles := make([]*os.File, TotalShardsCount)
-	outputFiles := make([]*os.File, TotalShardsCount)
-	for shardId := 0; shardId < TotalShardsCount; shardId++ {
+	totalShards := scheme.TotalShards()
+	shardHasData := make([]bool, totalShards)
+	inputFiles := make([]*os.File, totalShards)
+	outputFiles := make([]*os.File, totalShards)
+	for shardId := 0; shardId < totalShards; shardId++ {
 		shardFileName := baseFileName + ToExt(shardId)
 		if util.FileExists(shardFileName) {
 			shardHasData[shardId] = true
@@ -128,14 +135,18 @@ func generateMissingEcFiles(baseFileName string, bufferSize int, largeBlockSize
 		}
 	}
 
-	err = rebuildEcFiles(shardHasData, inputFiles, outputFiles)
+	err = rebuildEcFiles(scheme, shardHasData, inputFiles, outputFiles)
 	if err != nil {
+		// 删除只写了一部分的分片，以免下次被当作完整的分片
+		for _, shardId := range generatedShardIds {
+			os.Remove(baseFileName + ToExt(int(shardId)))
+		}
 		return nil, fmt.Errorf("rebuildEcFiles: %w", err)
 	}
 	return
 }
 
-func encodeData(file *os.File, enc reedsolomon.Encoder, startOffset, blockSize int64, buffers [][]byte, outputs []*os.File) error {
+func encodeData(file *os.File, enc reedsolomon.Encoder, dataShards int, startOffset, blockSize int64, buffers [][]byte, outputs []*os.File) error {
 
 	bufferSize := int64(len(buffers[0]))
 	if bufferSize == 0 {
@@ -148,7 +159,7 @@ func encodeData(file *os.File, enc reedsolomon.Encoder, startOffset, blockSize i
 	}
 
 	for b := int64(0); b < batchCount; b++ {
-		err := encodeDataOneBatch(file, enc, startOffset+b*bufferSize, blockSize, buffers, outputs)
+		err := encodeDataOneBatch(file, enc, dataShards, startOffset+b*bufferSize, blockSize, buffers, outputs)
 		if err != nil {
 			return err
 		}
@@ -157,8 +168,8 @@ func encodeData(file *os.File, enc reedsolomon.Encoder, startOffset, blockSize i
 	return nil
 }
 
-func openEcFiles(baseFileName string, forRead bool) (files []*os.File, err error) {
-	for i := 0; i < TotalShardsCount; i++ {
+func openEcFiles(baseFileName string, totalShards int, forRead bool) (files []*os.File, err error) {
+	for i := 0; i < totalShards; i++ {
 		fname := baseFileName + ToExt(i)
 		openOption := os.O_TRUNC | os.O_CREATE | os.O_WRONLY
 		if forRead {
@@ -181,10 +192,10 @@ func closeEcFiles(files []*os.File) {
 	}
 }
 
-func encodeDataOneBatch(file *os.File, enc reedsolomon.Encoder, startOffset, blockSize int64, buffers [][]byte, outputs []*os.File) error {
+func encodeDataOneBatch(file *os.File, enc reedsolomon.Encoder, dataShards int, startOffset, blockSize int64, buffers [][]byte, outputs []*os.File) error {
 
 	// read data into buffers
-	for i := 0; i < DataShardsCount; i++ {
+	for i := 0; i < dataShards; i++ {
 		n, err := file.ReadAt(buffers[i], startOffset+blockSize*int64(i))
 		if err != nil {
 			if err != io.EOF {
@@ -203,7 +214,7 @@ func encodeDataOneBatch(file *os.File, enc reedsolomon.Encoder, startOffset, blo
 		return err
 	}
 
-	for i := 0; i < TotalShardsCount; i++ {
+	for i := range outputs {
 		_, err := outputs[i].Write(buffers[i])
 		if err != nil {
 			return err
@@ -213,53 +224,54 @@ func encodeDataOneBatch(file *os.File, enc reedsolomon.Encoder, startOffset, blo
 	return nil
 }
 
-func encodeDatFile(remainingSize int64, baseFileName string, bufferSize int, largeBlockSize int64, file *os.File, smallBlockSize int64) error {
+func encodeDatFile(remainingSize int64, baseFileName string, scheme EcScheme, bufferSize int, largeBlockSize int64, file *os.File, smallBlockSize int64) error {
 
 	var processedSize int64
 
-	enc, err := reedsolomon.New(DataShardsCount, ParityShardsCount)
+	enc, err := reedsolomon.New(scheme.DataShards, scheme.ParityShards)
 	if err != nil {
 		return fmt.Errorf("failed to create encoder: %w", err)
 	}
 
-	buffers := make([][]byte, TotalShardsCount)
+	buffers := make([][]byte, scheme.TotalShards())
 	for i := range buffers {
 		buffers[i] = make([]byte, bufferSize)
 	}
 
-	outputs, err := openEcFiles(baseFileName, false)
+	outputs, err := openEcFiles(baseFileName, scheme.TotalShards(), false)
 	defer closeEcFiles(outputs)
 	if err != nil {
 		return fmt.Errorf("failed to open ec files %s: %v", baseFileName, err)
 	}
 
-	for remainingSize > largeBlockSize*DataShardsCount {
-		err = encodeData(file, enc, processedSize, largeBlockSize, buffers, outputs)
+	dataShards := int64(scheme.DataShards)
+	for remainingSize > largeBlockSize*dataShards {
+		err = encodeData(file, enc, scheme.DataShards, processedSize, largeBlockSize, buffers, outputs)
 		if err != nil {
 			return fmt.Errorf("failed to encode large chunk data: %w", err)
 		}
-		remainingSize -= largeBlockSize * DataShardsCount
-		processedSize += largeBlockSize * DataShardsCount
+		remainingSize -= largeBlockSize * dataShards
+		processedSize += largeBlockSize * dataShards
 	}
 	for remainingSize > 0 {
-		err = encodeData(file, enc, processedSize, smallBlockSize, buffers, outputs)
+		err = encodeData(file, enc, scheme.DataShards, processedSize, smallBlockSize, buffers, outputs)
 		if err != nil {
 			return fmt.Errorf("failed to encode small chunk data: %w", err)
 		}
-		remainingSize -= smallBlockSize * DataShardsCount
-		processedSize += smallBlockSize * DataShardsCount
+		remainingSize -= smallBlockSize * dataShards
+		processedSize += smallBlockSize * dataShards
 	}
 	return nil
 }
 
-func rebuildEcFiles(shardHasData []bool, inputFiles []*os.File, outputFiles []*os.File) error {
+func rebuildEcFiles(scheme EcScheme, shardHasData []bool, inputFiles []*os.File, outputFiles []*os.File) error {
 
-	enc, err := reedsolomon.New(DataShardsCount, ParityShardsCount)
+	enc, err := reedsolomon.New(scheme.DataShards, scheme.ParityShards)
 	if err != nil {
 		return fmt.Errorf("failed to create encoder: %w", err)
 	}
 
-	buffers := make([][]byte, TotalShardsCount)
+	buffers := make([][]byte, scheme.TotalShards())
 	for i := range buffers {
 		if shardHasData[i] {
 			buffers[i] = make([]byte, ErasureCodingSmallBlockSize)
@@ -271,7 +283,7 @@ func rebuildEcFiles(shardHasData []bool, inputFiles []*os.File, outputFiles []*o
 	for {
 
 		// read the input data from files
-		for i := 0; i < TotalShardsCount; i++ {
+		for i := range buffers {
 			if shardHasData[i] {
 				n, _ := inputFiles[i].ReadAt(buffers[i], startOffset)
 				if n == 0 {
@@ -295,7 +307,7 @@ func rebuildEcFiles(shardHasData []bool, inputFiles []*os.File, outputFiles []*o
 		}
 
 		// write the data to output files
-		for i := 0; i < TotalShardsCount; i++ {
+		for i := range buffers {
 			if !shardHasData[i] {
 				n, _ := outputFiles[i].WriteAt(buffers[i][:inputBufferDataSize], startOffset)
 				if inputBufferDataSize != n {
@@ -309,20 +321,9 @@ func rebuildEcFiles(shardHasData []bool, inputFiles []*os.File, outputFiles []*o
 }
 
 func readNeedleMap(baseFileName string) (*needle_map.MemDb, error) {
-	indexFile, err := os.OpenFile(baseFileName+".idx", os.O_RDONLY, 0644)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read Volume Index %s.idx: %v", baseFileName, err)
-	}
-	defer indexFile.Close()
-
 	cm := needle_map.NewMemDb()
-	err = idx.WalkIndexFile(indexFile, 0, func(key types.NeedleId, offset types.Offset, size types.Size) error {
-		if !offset.IsZero() && !size.IsDeleted() {
-			cm.Set(key, offset, size)
-		} else {
-			cm.Delete(key)
-		}
-		return nil
-	})
-	return cm, err
+	if err := cm.LoadFromIdx(baseFileName + ".idx"); err != nil {
+		return cm, fmt.Errorf("cannot read Volume Index %s.idx: %v", baseFileName, err)
+	}
+	return cm, nil
 }
@@ -0,0 +1,86 @@
+package erasure_coding
+
+import (
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	"cayoyibackend/weedfilesys/storage/types"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// .idx 中的记录是追加顺序，包含覆盖写和删除；生成的 .ecx 只保留每个 key 的最终状态并按 key 升序
+func TestWriteSortedFileFromIdx(t *testing.T) {
+	baseFileName := filepath.Join(t.TempDir(), "1")
+
+	type entry struct {
+		key    types.NeedleId
+		offset types.Offset
+		size   types.Size
+	}
+	entries := []entry{
+		{9, types.Uint32ToOffset(1), 100},
+		{3, types.Uint32ToOffset(2), 100},
+		{7, types.Uint32ToOffset(3), 100},
+		{3, types.Uint32ToOffset(4), 200}, // 覆盖
+		{1, types.Uint32ToOffset(5), 100},
+		{7, types.Uint32ToOffset(6), types.TombstoneFileSize}, // 删除
+		{5, types.Uint32ToOffset(0), 100},                     // offset 为 0 视为删除
+	}
+	idxFile, err := os.Create(baseFileName + ".idx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		idxFile.Write(needle_map.ToBytes(e.key, e.offset, e.size))
+	}
+	idxFile.Close()
+
+	if err = WriteSortedFileFromIdx(baseFileName, ".ecx"); err != nil {
+		t.Fatalf("WriteSortedFileFromIdx: %v", err)
+	}
+
+	ecxFile, err := os.Open(baseFileName + ".ecx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ecxFile.Close()
+
+	expected := []entry{{1, types.Uint32ToOffset(5), 100}, {3, types.Uint32ToOffset(4), 200}, {9, types.Uint32ToOffset(1), 100}}
+	var got []entry
+	err = idx.WalkIndexFile(ecxFile, 0, func(key types.NeedleId, offset types.Offset, size types.Size) error {
+		got = append(got, entry{key, offset, size})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("walk ecx: %v", err)
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expect %v, got %v", expected, got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("entry %d: expect %v, got %v", i, expected[i], got[i])
+		}
+	}
+
+	// .ecx 有序，可以直接二分查找
+	stat, _ := ecxFile.Stat()
+	offset, size, err := SearchNeedleFromSortedIndex(ecxFile, stat.Size(), 3, nil)
+	if err != nil || size != 200 || offset != types.Uint32ToOffset(4) {
+		t.Fatalf("search key 3: offset %v size %d err %v", offset, size, err)
+	}
+	if _, _, err = SearchNeedleFromSortedIndex(ecxFile, stat.Size(), 7, nil); err != NotFoundError {
+		t.Fatalf("search deleted key 7: %v", err)
+	}
+
+	// 再从 .ecx 加载回 MemDb，内容一致
+	db := needle_map.NewMemDb()
+	defer db.Close()
+	if err = db.LoadFromIdx(baseFileName + ".ecx"); err != nil {
+		t.Fatalf("load ecx: %v", err)
+	}
+	if db.Len() != len(expected) {
+		t.Fatalf("expect %d entries, got %d", len(expected), db.Len())
+	}
+}
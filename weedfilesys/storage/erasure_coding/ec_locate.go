@@ -26,10 +26,11 @@ type Interval struct {
 	Size                types.Size
 	IsLargeBlock        bool // whether the block is a large block or a small block
 	LargeBlockRowsCount int
+	DataShards          int // 每行的数据分片数，由 EC 方案决定
 }
 
-func LocateData(largeBlockLength, smallBlockLength int64, shardDatSize int64, offset int64, size types.Size) (intervals []Interval) {
-	blockIndex, isLargeBlock, nLargeBlockRows, innerBlockOffset := locateOffset(largeBlockLength, smallBlockLength, shardDatSize, offset)
+func LocateData(largeBlockLength, smallBlockLength int64, dataShards int, shardDatSize int64, offset int64, size types.Size) (intervals []Interval) {
+	blockIndex, isLargeBlock, nLargeBlockRows, innerBlockOffset := locateOffset(largeBlockLength, smallBlockLength, dataShards, shardDatSize, offset)
 
 	for size > 0 {
 		interval := Interval{
@@ -37,6 +38,7 @@ func LocateData(largeBlockLength, smallBlockLength int64, shardDatSize int64, of
 			InnerBlockOffset:    innerBlockOffset,
 			IsLargeBlock:        isLargeBlock,
 			LargeBlockRowsCount: int(nLargeBlockRows),
+			DataShards:          dataShards,
 		}
 
 		blockRemaining := largeBlockLength - innerBlockOffset
@@ -54,7 +56,7 @@ func LocateData(largeBlockLength, smallBlockLength int64, shardDatSize int64, of
 
 		size -= interval.Size
 		blockIndex += 1
-		if isLargeBlock && blockIndex == interval.LargeBlockRowsCount*DataShardsCount {
+		if isLargeBlock && blockIndex == interval.LargeBlockRowsCount*dataShards {
 			isLargeBlock = false
 			blockIndex = 0
 		}
@@ -86,8 +88,8 @@ func LocateData(largeBlockLength, smallBlockLength int64, shardDatSize int64, of
 // Block 编号（行）	ec00	ec01	ec02	...	ec09	ec10	ec11	ec12	ec13
 // Block 0（第1行）	D0	D1	D2	...	D9	P0	P1	P2	P3
 // Block 1（第2行）	D0	D1	D2	...	D9	P0
-func locateOffset(largeBlockLength, smallBlockLength int64, shardDatSize int64, offset int64) (blockIndex int, isLargeBlock bool, nLargeBlockRows int64, innerBlockOffset int64) {
-	largeRowSize := largeBlockLength * DataShardsCount
+func locateOffset(largeBlockLength, smallBlockLength int64, dataShards int, shardDatSize int64, offset int64) (blockIndex int, isLargeBlock bool, nLargeBlockRows int64, innerBlockOffset int64) {
+	largeRowSize := largeBlockLength * int64(dataShards)
 	nLargeBlockRows = (shardDatSize - 1) / largeBlockLength
 
 	// if offset is within the large block area
@@ -111,12 +113,12 @@ func locateOffsetWithinBlocks(blockLength int64, offset int64) (blockIndex int,
 
 func (interval Interval) ToShardIdAndOffset(largeBlockSize, smallBlockSize int64) (ShardId, int64) {
 	ecFileOffset := interval.InnerBlockOffset
-	rowIndex := interval.BlockIndex / DataShardsCount
+	rowIndex := interval.BlockIndex / interval.DataShards
 	if interval.IsLargeBlock {
 		ecFileOffset += int64(rowIndex) * largeBlockSize
 	} else {
 		ecFileOffset += int64(interval.LargeBlockRowsCount)*largeBlockSize + int64(rowIndex)*smallBlockSize
 	}
-	ecFileIndex := interval.BlockIndex % DataShardsCount
+	ecFileIndex := interval.BlockIndex % interval.DataShards
 	return ShardId(ecFileIndex), ecFileOffset
 }
@@ -0,0 +1,88 @@
+package erasure_coding
+
+import (
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ShardBits 用 32 位表示分片，一个方案的分片总数不能超过它
+const MaxShardCount = 32
+
+// EcScheme 纠删码方案：.dat 按行切成 DataShards 个数据分片，再计算 ParityShards 个校验分片，
+// 任意 DataShards 个分片就能恢复数据。分片 0 ~ DataShards-1 是数据分片，其余是校验分片。
+// 方案写在 .vif 中，跟着分片一起复制，没有记录的旧 EC 卷使用默认的 10+4
+type EcScheme struct {
+	DataShards   int
+	ParityShards int
+}
+
+var DefaultEcScheme = EcScheme{DataShards: DataShardsCount, ParityShards: ParityShardsCount}
+
+// ParseEcScheme 解析 "6+3" 这样的写法
+func ParseEcScheme(s string) (EcScheme, error) {
+	data, parity, found := strings.Cut(strings.TrimSpace(s), "+")
+	if !found {
+		return EcScheme{}, fmt.Errorf("invalid ec scheme %q, expect data+parity like 6+3", s)
+	}
+	dataShards, err := strconv.Atoi(strings.TrimSpace(data))
+	if err != nil {
+		return EcScheme{}, fmt.Errorf("invalid ec scheme %q: %v", s, err)
+	}
+	parityShards, err := strconv.Atoi(strings.TrimSpace(parity))
+	if err != nil {
+		return EcScheme{}, fmt.Errorf("invalid ec scheme %q: %v", s, err)
+	}
+	scheme := EcScheme{DataShards: dataShards, ParityShards: parityShards}
+	return scheme, scheme.Validate()
+}
+
+func (s EcScheme) Validate() error {
+	if s.DataShards <= 0 || s.ParityShards <= 0 {
+		return fmt.Errorf("ec scheme %s needs at least 1 data shard and 1 parity shard", s)
+	}
+	if s.TotalShards() > MaxShardCount {
+		return fmt.Errorf("ec scheme %s has more than %d shards", s, MaxShardCount)
+	}
+	return nil
+}
+
+func (s EcScheme) String() string {
+	return fmt.Sprintf("%d+%d", s.DataShards, s.ParityShards)
+}
+
+func (s EcScheme) TotalShards() int {
+	return s.DataShards + s.ParityShards
+}
+
+// ShardIds 返回该方案的全部分片
+func (s EcScheme) ShardIds() []ShardId {
+	shardIds := make([]ShardId, s.TotalShards())
+	for i := range shardIds {
+		shardIds[i] = ShardId(i)
+	}
+	return shardIds
+}
+
+func (s EcScheme) ToEcShardConfig() *volume_server_pb.EcShardConfig {
+	return &volume_server_pb.EcShardConfig{DataShards: uint32(s.DataShards), ParityShards: uint32(s.ParityShards)}
+}
+
+// EcSchemeFromConfig 没有配置时使用默认方案
+func EcSchemeFromConfig(config *volume_server_pb.EcShardConfig) (EcScheme, error) {
+	if config == nil || config.DataShards == 0 && config.ParityShards == 0 {
+		return DefaultEcScheme, nil
+	}
+	scheme := EcScheme{DataShards: int(config.DataShards), ParityShards: int(config.ParityShards)}
+	return scheme, scheme.Validate()
+}
+
+// EcSchemeFromMessage 从心跳中的分片信息取方案，旧版本的卷服务器不上报，使用默认方案
+func EcSchemeFromMessage(dataShards, parityShards uint32) EcScheme {
+	scheme, err := EcSchemeFromConfig(&volume_server_pb.EcShardConfig{DataShards: dataShards, ParityShards: parityShards})
+	if err != nil {
+		return DefaultEcScheme
+	}
+	return scheme
+}
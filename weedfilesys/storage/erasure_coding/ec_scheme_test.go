@@ -0,0 +1,126 @@
+package erasure_coding
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testEcSchemes = []EcScheme{{DataShards: 4, ParityShards: 2}, {DataShards: 6, ParityShards: 3}, DefaultEcScheme}
+
+func TestParseEcScheme(t *testing.T) {
+	scheme, err := ParseEcScheme(" 6 + 3 ")
+	if err != nil || scheme != (EcScheme{DataShards: 6, ParityShards: 3}) || scheme.TotalShards() != 9 {
+		t.Fatalf("parse 6+3: %v %v", scheme, err)
+	}
+	for _, s := range []string{"6", "a+3", "0+2", "4+0", "30+3"} {
+		if _, err = ParseEcScheme(s); err == nil {
+			t.Fatalf("expect %q rejected", s)
+		}
+	}
+	if scheme, err = EcSchemeFromConfig(nil); err != nil || scheme != DefaultEcScheme {
+		t.Fatalf("missing config should be %s, got %s %v", DefaultEcScheme, scheme, err)
+	}
+}
+
+func TestEcFilesAcrossSchemes(t *testing.T) {
+	for _, scheme := range testEcSchemes {
+		t.Run(scheme.String(), func(t *testing.T) {
+			testEcFilesWithScheme(t, scheme)
+		})
+	}
+}
+
+func readShardFiles(t *testing.T, baseFileName string, scheme EcScheme) [][]byte {
+	shards := make([][]byte, scheme.TotalShards())
+	for i := range shards {
+		data, err := os.ReadFile(baseFileName + ToExt(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		shards[i] = data
+	}
+	return shards
+}
+
+func testEcFilesWithScheme(t *testing.T, scheme EcScheme) {
+	const largeBlockSize, smallBlockSize = 10000, 100
+	dir := t.TempDir()
+	baseFileName := filepath.Join(dir, "1")
+	// 两行大块，剩下的不足一行，落在小块中
+	dat := make([]byte, 2*scheme.DataShards*largeBlockSize+5555)
+	rand.New(rand.NewSource(int64(scheme.DataShards))).Read(dat)
+	if err := os.WriteFile(baseFileName+".dat", dat, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := generateEcFiles(baseFileName, scheme, smallBlockSize, largeBlockSize, smallBlockSize); err != nil {
+		t.Fatal(err)
+	}
+	if util.FileExists(baseFileName + ToExt(scheme.TotalShards())) {
+		t.Fatalf("unexpected shard %d", scheme.TotalShards())
+	}
+	shards := readShardFiles(t, baseFileName, scheme)
+
+	// 定位到的区间拼起来与 .dat 中的内容一致
+	r := rand.New(rand.NewSource(1))
+	shardDatSize := int64(len(dat) / scheme.DataShards)
+	for i := 0; i < 200; i++ {
+		offset := r.Int63n(int64(len(dat)) - 1)
+		size := types.Size(1 + r.Int63n(min(3000, int64(len(dat))-offset)))
+		var got []byte
+		for _, interval := range LocateData(largeBlockSize, smallBlockSize, scheme.DataShards, shardDatSize, offset, size) {
+			shardId, shardOffset := interval.ToShardIdAndOffset(largeBlockSize, smallBlockSize)
+			if int(shardId) >= scheme.DataShards {
+				t.Fatalf("located parity shard %d", shardId)
+			}
+			got = append(got, shards[shardId][shardOffset:shardOffset+int64(interval.Size)]...)
+		}
+		if !bytes.Equal(got, dat[offset:offset+int64(size)]) {
+			t.Fatalf("locate offset %d size %d mismatch", offset, size)
+		}
+	}
+
+	// 丢失 ParityShards 个分片时可以重建，再多丢一个就不行
+	for i := 0; i < scheme.ParityShards; i++ {
+		os.Remove(baseFileName + ToExt(i*2))
+	}
+	generated, err := generateMissingEcFiles(baseFileName, scheme, smallBlockSize, largeBlockSize, smallBlockSize)
+	if err != nil || len(generated) != scheme.ParityShards {
+		t.Fatalf("rebuild %v: %v", generated, err)
+	}
+	for i, shard := range readShardFiles(t, baseFileName, scheme) {
+		if !bytes.Equal(shard, shards[i]) {
+			t.Fatalf("rebuilt shard %d mismatch", i)
+		}
+	}
+	for i := 0; i <= scheme.ParityShards; i++ {
+		os.Remove(baseFileName + ToExt(i))
+	}
+	if _, err = generateMissingEcFiles(baseFileName, scheme, smallBlockSize, largeBlockSize, smallBlockSize); err == nil {
+		t.Fatalf("expect rebuild failure with %d shards lost", scheme.ParityShards+1)
+	}
+	if util.FileExists(baseFileName + ToExt(0)) {
+		t.Fatalf("partially rebuilt shard should be removed")
+	}
+
+	// 按实际的块大小编码，再用数据分片还原 .dat
+	if err = WriteEcFiles(baseFileName, scheme); err != nil {
+		t.Fatal(err)
+	}
+	var shardFileNames []string
+	for i := 0; i < scheme.DataShards; i++ {
+		shardFileNames = append(shardFileNames, baseFileName+ToExt(i))
+	}
+	decodedFileName := filepath.Join(dir, "decoded")
+	if err = WriteDatFile(decodedFileName, scheme.DataShards, int64(len(dat)), shardFileNames); err != nil {
+		t.Fatal(err)
+	}
+	if decoded, _ := os.ReadFile(decodedFileName + ".dat"); !bytes.Equal(decoded, dat) {
+		t.Fatalf("decoded dat mismatch")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"path"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 type ShardId uint8
@@ -22,6 +23,8 @@ type EcVolumeShard struct {
 	ecdFile     *os.File
 	ecdFileSize int64
 	DiskType    types.DiskType
+
+	ecdFileAccessLock sync.RWMutex // 读取期间持读锁，关闭时持写锁，避免文件描述符在读取中被关闭后复用
 }
 
 func NewEcVolumeShard(diskType types.DiskType, dirname string, collection string, id needle.VolumeId, shardId ShardId) (v *EcVolumeShard, e error) {
@@ -88,6 +91,8 @@ func EcShardBaseFileName(collection string, id int) (baseFileName string) {
 }
 
 func (shard *EcVolumeShard) Close() {
+	shard.ecdFileAccessLock.Lock()
+	defer shard.ecdFileAccessLock.Unlock()
 	if shard.ecdFile != nil {
 		_ = shard.ecdFile.Close()
 		shard.ecdFile = nil
@@ -100,7 +105,11 @@ func (shard *EcVolumeShard) Destroy() {
 }
 
 func (shard *EcVolumeShard) ReadAt(buf []byte, offset int64) (int, error) {
-
+	shard.ecdFileAccessLock.RLock()
+	defer shard.ecdFileAccessLock.RUnlock()
+	if shard.ecdFile == nil {
+		return 0, os.ErrClosed
+	}
 	n, err := shard.ecdFile.ReadAt(buf, offset)
 	if err == io.EOF && n == len(buf) {
 		err = nil
@@ -8,6 +8,7 @@ import (
 	"cayoyibackend/weedfilesys/storage/idx"
 	"cayoyibackend/weedfilesys/storage/needle"
 	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/storage/volume_info"
 	"errors"
 	"fmt"
 	"math"
@@ -55,7 +56,8 @@ type EcVolume struct {
 	ecjFileAccessLock         sync.Mutex
 	diskType                  types.DiskType
 	datFileSize               int64
-	ExpireAtSec               uint64 //ec volume destroy time, calculated from the ec volume was created
+	ExpireAtSec               uint64   //ec volume destroy time, calculated from the ec volume was created
+	Scheme                    EcScheme // 从 .vif 读取，没有记录时为默认的 10+4
 }
 
 func NewEcVolume(diskType types.DiskType, dir string, dirIdx string, collection string, vid needle.VolumeId) (ev *EcVolume, err error) {
@@ -83,10 +85,15 @@ func NewEcVolume(diskType types.DiskType, dir string, dirIdx string, collection
 
 	// read volume info
 	ev.Version = needle.Version3
+	ev.Scheme = DefaultEcScheme
 	if volumeInfo, _, found, _ := volume_info.MaybeLoadVolumeInfo(dataBaseFileName + ".vif"); found {
 		ev.Version = needle.Version(volumeInfo.Version)
 		ev.datFileSize = volumeInfo.DatFileSize
 		ev.ExpireAtSec = volumeInfo.ExpireAtSec
+		if ev.Scheme, err = EcSchemeFromConfig(volumeInfo.EcShardConfig); err != nil {
+			ev.Close()
+			return nil, fmt.Errorf("ec volume %d in %s.vif: %v", vid, dataBaseFileName, err)
+		}
 	} else {
 		glog.Warningf("vif file not found,volumeId:%d, filename:%s", vid, dataBaseFileName)
 		volume_info.SaveVolumeInfo(dataBaseFileName+".vif", &volume_server_pb.VolumeInfo{Version: uint32(ev.Version)})
@@ -217,10 +224,12 @@ func (ev *EcVolume) ToVolumeEcShardInformationMessage() (messages []*master_pb.V
 	for _, s := range ev.Shards {
 		if s.VolumeId != prevVolumeId {
 			m = &master_pb.VolumeEcShardInformationMessage{
-				Id:          uint32(s.VolumeId),
-				Collection:  s.Collection,
-				DiskType:    string(ev.diskType),
-				ExpireAtSec: ev.ExpireAtSec,
+				Id:           uint32(s.VolumeId),
+				Collection:   s.Collection,
+				DiskType:     string(ev.diskType),
+				ExpireAtSec:  ev.ExpireAtSec,
+				DataShards:   uint32(ev.Scheme.DataShards),
+				ParityShards: uint32(ev.Scheme.ParityShards),
 			}
 			messages = append(messages, m)
 		}
@@ -244,18 +253,20 @@ func (ev *EcVolume) LocateEcShardNeedle(needleId types.NeedleId, version needle.
 }
 
 func (ev *EcVolume) LocateEcShardNeedleInterval(version needle.Version, offset int64, size types.Size) (intervals []Interval) {
-	shard := ev.Shards[0]
-	// Usually shard will be padded to round of ErasureCodingSmallBlockSize.
-	// So in most cases, if shardSize equals to n * ErasureCodingLargeBlockSize,
-	// the data would be in small blocks.
-	shardSize := shard.ecdFileSize - 1
+	var shardSize int64
 	if ev.datFileSize > 0 {
 		// To get the correct LargeBlockRowsCount
 		// use datFileSize to calculate the shardSize to match the EC encoding logic.
-		shardSize = ev.datFileSize / DataShardsCount
+		shardSize = ev.datFileSize / int64(ev.Scheme.DataShards)
+	} else if len(ev.Shards) > 0 {
+		// Usually shard will be padded to round of ErasureCodingSmallBlockSize.
+		// So in most cases, if shardSize equals to n * ErasureCodingLargeBlockSize,
+		// the data would be in small blocks.
+		shardSize = ev.Shards[0].ecdFileSize - 1
 	}
 	// calculate the locations in the ec shards
-	intervals = LocateData(ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize, shardSize, offset, types.Size(needle.GetActualSize(size, version)))
+	// size 已经是包含头部和尾部的实际大小
+	intervals = LocateData(ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize, ev.Scheme.DataShards, shardSize, offset, size)
 
 	return
 }
@@ -2,6 +2,7 @@ package erasure_coding
 
 import (
 	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
 	"fmt"
 	"io"
 	"os"
@@ -65,7 +65,7 @@ func (ecInfo *EcVolumeInfo) ToVolumeEcShardInformationMessage() (ret *master_pb.
 	}
 }
 
-type ShardBits uint32 // use bits to indicate the shard id, use 32 bits just for possible future extension
+type ShardBits uint32 // use bits to indicate the shard id, at most MaxShardCount shards
 
 func (b ShardBits) AddShardId(id ShardId) ShardBits {
 	return b | (1 << id)
@@ -80,7 +80,7 @@ func (b ShardBits) HasShardId(id ShardId) bool {
 }
 
 func (b ShardBits) ShardIds() (ret []ShardId) {
-	for i := ShardId(0); i < TotalShardsCount; i++ {
+	for i := ShardId(0); i < MaxShardCount; i++ {
 		if b.HasShardId(i) {
 			ret = append(ret, i)
 		}
@@ -89,7 +89,7 @@ func (b ShardBits) ShardIds() (ret []ShardId) {
 }
 
 func (b ShardBits) ToUint32Slice() (ret []uint32) {
-	for i := uint32(0); i < TotalShardsCount; i++ {
+	for i := uint32(0); i < MaxShardCount; i++ {
 		if b.HasShardId(ShardId(i)) {
 			ret = append(ret, i)
 		}
@@ -112,8 +112,8 @@ func (b ShardBits) Plus(other ShardBits) ShardBits {
 	return b | other
 }
 
-func (b ShardBits) MinusParityShards() ShardBits {
-	for i := DataShardsCount; i < TotalShardsCount; i++ {
+func (b ShardBits) MinusParityShards(scheme EcScheme) ShardBits {
+	for i := scheme.DataShards; i < MaxShardCount; i++ {
 		b = b.RemoveShardId(ShardId(i))
 	}
 	return b
@@ -0,0 +1,50 @@
+package erasure_coding
+
+import (
+	"cayoyibackend/weedfilesys/storage/backend"
+	"fmt"
+	"os"
+)
+
+// ReadEcShardIntervals 把 needle 落在各个分片上的区间一次提交读取，按区间顺序拼成原始数据。
+// 只读本地挂载的分片，缺少分片时在读取之前返回错误
+func (ev *EcVolume) ReadEcShardIntervals(br backend.BatchReader, intervals []Interval) ([]byte, error) {
+	var total int
+	for _, interval := range intervals {
+		total += int(interval.Size)
+	}
+	data := make([]byte, total)
+	requests := make([]*backend.BatchReadRequest, len(intervals))
+	// 读取完成前持有各分片的读锁，分片在此期间不会被关闭
+	locked := make(map[ShardId]*EcVolumeShard)
+	defer func() {
+		for _, shard := range locked {
+			shard.ecdFileAccessLock.RUnlock()
+		}
+	}()
+	var start int
+	for i, interval := range intervals {
+		shardId, offset := interval.ToShardIdAndOffset(ErasureCodingLargeBlockSize, ErasureCodingSmallBlockSize)
+		shard, found := locked[shardId]
+		if !found {
+			if shard, found = ev.FindEcVolumeShard(shardId); !found {
+				return nil, fmt.Errorf("ec volume %d shard %d not found locally", ev.VolumeId, shardId)
+			}
+			shard.ecdFileAccessLock.RLock()
+			locked[shardId] = shard
+		}
+		if shard.ecdFile == nil {
+			return nil, fmt.Errorf("ec volume %d shard %d: %v", ev.VolumeId, shardId, os.ErrClosed)
+		}
+		end := start + int(interval.Size)
+		requests[i] = &backend.BatchReadRequest{File: shard.ecdFile, Offset: offset, Buf: data[start:end]}
+		start = end
+	}
+	br.ReadBatch(requests)
+	for i, req := range requests {
+		if req.Err != nil {
+			return nil, fmt.Errorf("read ec volume %d interval %d at %d: %v", ev.VolumeId, i, req.Offset, req.Err)
+		}
+	}
+	return data, nil
+}
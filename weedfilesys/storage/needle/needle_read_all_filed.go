@@ -57,6 +57,30 @@ func ReadNeedleBlob(r backend.BackendStorageFile, offset int64, size Size, versi
 	return dataSlice, err
 }
 
+// NeedleBlobRequest 批量读取中的一个 needle，Blob 为读到的原始二进制数据
+type NeedleBlobRequest struct {
+	Offset int64
+	Size   Size
+	Blob   []byte
+	Err    error
+}
+
+// ReadNeedleBlobs 把多个 needle 的读取一次交给 br，结果写回每个请求
+func ReadNeedleBlobs(br backend.BatchReader, r backend.BackendStorageFile, requests []*NeedleBlobRequest, version Version) {
+	reads := make([]*backend.BatchReadRequest, len(requests))
+	for i, req := range requests {
+		req.Blob = make([]byte, int(GetActualSize(req.Size, version)))
+		reads[i] = &backend.BatchReadRequest{File: r, Offset: req.Offset, Buf: req.Blob}
+	}
+	br.ReadBatch(reads)
+	for i, read := range reads {
+		if requests[i].Err = read.Err; read.Err != nil {
+			fileSize, _, _ := r.GetStat()
+			glog.Errorf("%s read %d dataSize %d offset %d fileSize %d: %v", r.Name(), read.N, len(read.Buf), read.Offset, fileSize, read.Err)
+		}
+	}
+}
+
 // ReadBytes 从上面读到的二进制数据中解析 Needle 数据，且仅保证 n.Id 已设置
 func (n *Needle) ReadBytes(bytes []byte, offset int64, size Size, version Version) (err error) {
 	n.ParseNeedleHeader(bytes) // 先解析 Needle 头部
@@ -1 +1,132 @@
-﻿package storage
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// NeedleMapKind 卷索引的实现方式
+type NeedleMapKind int
+
+const (
+	NeedleMapInMemory   NeedleMapKind = iota // 全部在内存的 CompactMap，最快，内存随文件数增长
+	NeedleMapLevelDb                         // 嵌入式 LevelDB，内存占用小，适合文件数很多的卷
+	NeedleMapSortedFile                      // 有序的 .sdx 文件加二分查找，只在内存中保存新写入的部分
+)
+
+func (kind NeedleMapKind) String() string {
+	switch kind {
+	case NeedleMapInMemory:
+		return "memory"
+	case NeedleMapLevelDb:
+		return "leveldb"
+	case NeedleMapSortedFile:
+		return "sortedFile"
+	}
+	return fmt.Sprintf("NeedleMapKind(%d)", int(kind))
+}
+
+// ParseNeedleMapKind 解析配置中的索引类型，空字符串为 memory
+func ParseNeedleMapKind(s string) (NeedleMapKind, error) {
+	switch strings.ToLower(s) {
+	case "", "memory":
+		return NeedleMapInMemory, nil
+	case "leveldb":
+		return NeedleMapLevelDb, nil
+	case "sortedfile", "sorted_file", "sdx":
+		return NeedleMapSortedFile, nil
+	}
+	return NeedleMapInMemory, fmt.Errorf("unknown needle map kind %q", s)
+}
+
+// NeedleMapper 卷的索引，内存中保存 NeedleId -> (offset, size)，每次修改同时追加到 .idx 文件
+// .idx 只追加不修改，删除也是追加一条 size 为 TombstoneFileSize 的记录，
+// 重启时按顺序重放 .idx 就能恢复出最终的索引
+type NeedleMapper interface {
+	Put(key NeedleId, offset Offset, size Size) error
+	Get(key NeedleId) (element *needle_map.NeedleValue, ok bool)
+	Delete(key NeedleId, offset Offset) error
+	Close()
+	Destroy() error
+	ContentSize() uint64
+	DeletedSize() uint64
+	FileCount() int
+	DeletedCount() int
+	MaxFileKey() NeedleId
+	IndexFileSize() uint64
+	Sync() error
+	ReadIndexEntry(n int64) (key NeedleId, offset Offset, size Size, err error)
+}
+
+// 各种 NeedleMapper 共用的 .idx 文件读写和统计
+type baseNeedleMapper struct {
+	mapMetric
+
+	indexFile           *os.File
+	indexFileAccessLock sync.Mutex
+	indexFileOffset     int64
+}
+
+func (nm *baseNeedleMapper) IndexFileSize() uint64 {
+	stat, err := nm.indexFile.Stat()
+	if err == nil {
+		return uint64(stat.Size())
+	}
+	return 0
+}
+
+// 追加一条索引记录，写入位置自己维护，不依赖文件以 O_APPEND 打开
+func (nm *baseNeedleMapper) appendToIndexFile(key NeedleId, offset Offset, size Size) error {
+	bytes := needle_map.ToBytes(key, offset, size)
+
+	nm.indexFileAccessLock.Lock()
+	defer nm.indexFileAccessLock.Unlock()
+
+	written, err := nm.indexFile.WriteAt(bytes, nm.indexFileOffset)
+	if err == nil {
+		nm.indexFileOffset += int64(written)
+	}
+	return err
+}
+
+func (nm *baseNeedleMapper) Sync() error {
+	return nm.indexFile.Sync()
+}
+
+// ReadIndexEntry 读取 .idx 中第 n 条记录
+func (nm *baseNeedleMapper) ReadIndexEntry(n int64) (key NeedleId, offset Offset, size Size, err error) {
+	bytes := make([]byte, NeedleMapEntrySize)
+	var readCount int
+	if readCount, err = nm.indexFile.ReadAt(bytes, n*NeedleMapEntrySize); err != nil {
+		if err == io.EOF && readCount == NeedleMapEntrySize {
+			err = nil
+		}
+		if err != nil {
+			return
+		}
+	}
+	key, offset, size = idx.IdxFileEntry(bytes)
+	return
+}
+
+// 写了一半的记录（进程在写 .idx 时崩溃）会导致之后所有记录错位，加载前截掉
+func truncateTornIndexEntry(indexFile *os.File) (int64, error) {
+	stat, err := indexFile.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat %s: %v", indexFile.Name(), err)
+	}
+	size := stat.Size()
+	if remainder := size % NeedleMapEntrySize; remainder != 0 {
+		size -= remainder
+		if err = indexFile.Truncate(size); err != nil {
+			return 0, fmt.Errorf("truncate %s to %d: %v", indexFile.Name(), size, err)
+		}
+	}
+	return size, nil
+}
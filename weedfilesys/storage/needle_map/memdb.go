@@ -0,0 +1,150 @@
+package needle_map
+
+import (
+	"bufio"
+	"cayoyibackend/weedfilesys/storage/idx"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"github.com/google/btree"
+	"io"
+	"os"
+	"sync"
+)
+
+// MemDb 按 needle id 有序保存索引，用于 EC 编码时生成有序的 .ecx，以及把 .idx 整理成有序文件
+// 只保存有效的记录，删除直接从树中移除
+type MemDb struct {
+	sync.RWMutex
+	tree *btree.BTree
+}
+
+func NewMemDb() *MemDb {
+	return &MemDb{
+		tree: btree.New(32),
+	}
+}
+
+func (cm *MemDb) Set(key NeedleId, offset Offset, size Size) error {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cm.tree.ReplaceOrInsert(NeedleValue{Key: key, Offset: offset, Size: size})
+	return nil
+}
+
+func (cm *MemDb) Delete(key NeedleId) error {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cm.tree.Delete(NeedleValue{Key: key})
+	return nil
+}
+
+func (cm *MemDb) Get(key NeedleId) (*NeedleValue, bool) {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	item := cm.tree.Get(NeedleValue{Key: key})
+	if item == nil {
+		return nil, false
+	}
+	nv := item.(NeedleValue)
+	return &nv, true
+}
+
+// AscendingVisit 按 key 升序遍历，visit 返回错误时停止
+func (cm *MemDb) AscendingVisit(visit func(NeedleValue) error) (ret error) {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	cm.tree.Ascend(func(item btree.Item) bool {
+		if ret = visit(item.(NeedleValue)); ret != nil {
+			return false
+		}
+		return true
+	})
+	return
+}
+
+// DescendingVisit 按 key 降序遍历，visit 返回错误时停止
+func (cm *MemDb) DescendingVisit(visit func(NeedleValue) error) (ret error) {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	cm.tree.Descend(func(item btree.Item) bool {
+		if ret = visit(item.(NeedleValue)); ret != nil {
+			return false
+		}
+		return true
+	})
+	return
+}
+
+func (cm *MemDb) Len() int {
+	cm.RLock()
+	defer cm.RUnlock()
+
+	return cm.tree.Len()
+}
+
+// SaveToIdx 按 key 升序写出有序的索引文件，已删除的记录不写入
+func (cm *MemDb) SaveToIdx(idxName string) (ret error) {
+	idxFile, err := os.OpenFile(idxName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := idxFile.Close(); err != nil && ret == nil {
+			ret = err
+		}
+	}()
+
+	w := bufio.NewWriter(idxFile)
+	ret = cm.AscendingVisit(func(value NeedleValue) error {
+		if value.Offset.IsZero() || value.Size.IsDeleted() {
+			return nil
+		}
+		_, err := w.Write(value.ToBytes())
+		return err
+	})
+	if ret != nil {
+		return fmt.Errorf("save %s: %w", idxName, ret)
+	}
+	if ret = w.Flush(); ret != nil {
+		return fmt.Errorf("flush %s: %w", idxName, ret)
+	}
+	return idxFile.Sync()
+}
+
+// LoadFromIdx 按顺序重放 .idx 文件，后面的记录覆盖前面的，删除记录会移除对应的 key
+func (cm *MemDb) LoadFromIdx(idxName string) (ret error) {
+	idxFile, err := os.OpenFile(idxName, os.O_RDONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer idxFile.Close()
+
+	return cm.LoadFromReaderAt(idxFile)
+}
+
+func (cm *MemDb) LoadFromReaderAt(readerAt io.ReaderAt) error {
+	return cm.LoadFilterFromReaderAt(readerAt, true, true)
+}
+
+// LoadFilterFromReaderAt isFilterOffsetZero 为 true 时 offset 为 0 的记录视为删除，
+// isFilterDeleted 为 true 时 size 为删除标记的记录视为删除，否则原样保存
+func (cm *MemDb) LoadFilterFromReaderAt(readerAt io.ReaderAt, isFilterOffsetZero bool, isFilterDeleted bool) error {
+	return idx.WalkIndexFile(readerAt, 0, func(key NeedleId, offset Offset, size Size) error {
+		if (isFilterOffsetZero && offset.IsZero()) || (isFilterDeleted && size.IsDeleted()) {
+			return cm.Delete(key)
+		}
+		return cm.Set(key, offset, size)
+	})
+}
+
+func (cm *MemDb) Close() {
+	cm.Lock()
+	defer cm.Unlock()
+
+	cm.tree = nil
+}
@@ -0,0 +1,73 @@
+package needle_map
+
+import (
+	. "cayoyibackend/weedfilesys/storage/types"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemDbSetGetDelete(t *testing.T) {
+	db := NewMemDb()
+	defer db.Close()
+
+	for _, key := range []NeedleId{5, 1, 3} {
+		db.Set(key, Uint32ToOffset(uint32(key)), Size(key*10))
+	}
+	db.Set(3, Uint32ToOffset(33), 330)
+	db.Delete(1)
+
+	if _, found := db.Get(1); found {
+		t.Fatalf("key 1 should be deleted")
+	}
+	nv, found := db.Get(3)
+	if !found || nv.Size != 330 || nv.Offset != Uint32ToOffset(33) {
+		t.Fatalf("unexpected value for key 3: %+v", nv)
+	}
+
+	var keys []NeedleId
+	db.AscendingVisit(func(value NeedleValue) error {
+		keys = append(keys, value.Key)
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != 3 || keys[1] != 5 {
+		t.Fatalf("unexpected ascending keys %v", keys)
+	}
+	keys = keys[:0]
+	db.DescendingVisit(func(value NeedleValue) error {
+		keys = append(keys, value.Key)
+		return nil
+	})
+	if len(keys) != 2 || keys[0] != 5 || keys[1] != 3 {
+		t.Fatalf("unexpected descending keys %v", keys)
+	}
+}
+
+func TestMemDbSaveAndLoadIdx(t *testing.T) {
+	idxName := filepath.Join(t.TempDir(), "1.idx")
+
+	db := NewMemDb()
+	for i := 100; i > 0; i-- {
+		db.Set(NeedleId(i), Uint32ToOffset(uint32(i)), Size(i))
+	}
+	db.Delete(50)
+	if err := db.SaveToIdx(idxName); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+	db.Close()
+
+	loaded := NewMemDb()
+	defer loaded.Close()
+	if err := loaded.LoadFromIdx(idxName); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if loaded.Len() != 99 {
+		t.Fatalf("expect 99 entries, got %d", loaded.Len())
+	}
+	if _, found := loaded.Get(50); found {
+		t.Fatalf("deleted key should not be saved")
+	}
+	nv, found := loaded.Get(77)
+	if !found || nv.Size != 77 {
+		t.Fatalf("unexpected value for key 77: %+v", nv)
+	}
+}
@@ -0,0 +1,185 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// 水位保存在 LevelDB 中，key 长度和 needle id 不同，不会冲突。
+// 每条记录和水位在同一个 batch 中原子写入，重启时只重放 LevelDB 还没有的记录，统计不会重复计算
+var levelDbWatermarkKey = []byte("idx_entry_watermark")
+
+// LevelDbNeedleMap 索引保存在 .ldb 目录的 LevelDB 中，内存占用不随文件数增长
+type LevelDbNeedleMap struct {
+	baseNeedleMapper
+	dbFileName string
+	db         *leveldb.DB
+}
+
+func NewLevelDbNeedleMap(dbFileName string, indexFile *os.File, opts *opt.Options) (m *LevelDbNeedleMap, err error) {
+	indexSize, err := truncateTornIndexEntry(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	m = &LevelDbNeedleMap{dbFileName: dbFileName}
+	m.indexFile = indexFile
+	m.indexFileOffset = indexSize
+
+	if m.db, err = leveldb.OpenFile(dbFileName, opts); err != nil {
+		return nil, fmt.Errorf("open leveldb %s: %v", dbFileName, err)
+	}
+
+	watermark, err := m.loadWatermark()
+	if err != nil || !watermark.validFor(indexFile, indexSize) {
+		// 水位无效说明 .idx 被替换过或者 LevelDB 是旧的，整个重建
+		glog.V(0).Infof("rebuilding leveldb %s from %s", dbFileName, indexFile.Name())
+		m.db.Close()
+		if err = os.RemoveAll(dbFileName); err != nil {
+			return nil, err
+		}
+		if m.db, err = leveldb.OpenFile(dbFileName, opts); err != nil {
+			return nil, fmt.Errorf("open leveldb %s: %v", dbFileName, err)
+		}
+		watermark = &needleMapWatermark{}
+	}
+	m.restore(watermark.Metric)
+
+	glog.V(1).Infof("loading leveldb %s from watermark %d of %d entries", dbFileName, watermark.Entries, indexSize/NeedleMapEntrySize)
+	entries := watermark.Entries
+	err = idx.WalkIndexFile(indexFile, watermark.Entries, func(key NeedleId, offset Offset, size Size) error {
+		entries++
+		if !offset.IsZero() && size.IsValid() {
+			return m.put(key, offset, size, entries)
+		}
+		return m.delete(key, entries, needle_map.ToBytes(key, offset, size))
+	})
+	if err != nil {
+		m.db.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *LevelDbNeedleMap) loadWatermark() (*needleMapWatermark, error) {
+	data, err := m.db.Get(levelDbWatermarkKey, nil)
+	if errors.Is(err, leveldb.ErrNotFound) {
+		return &needleMapWatermark{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	watermark := &needleMapWatermark{}
+	if err = json.Unmarshal(data, watermark); err != nil {
+		return nil, err
+	}
+	return watermark, nil
+}
+
+func (m *LevelDbNeedleMap) Get(key NeedleId) (element *needle_map.NeedleValue, ok bool) {
+	bytes := make([]byte, NeedleIdSize)
+	NeedleIdToBytes(bytes[0:NeedleIdSize], key)
+	data, err := m.db.Get(bytes, nil)
+	if err != nil || len(data) != OffsetSize+SizeSize {
+		return nil, false
+	}
+	offset := BytesToOffset(data[0:OffsetSize])
+	size := BytesToSize(data[OffsetSize : OffsetSize+SizeSize])
+	return &needle_map.NeedleValue{Key: key, Offset: offset, Size: size}, true
+}
+
+// write 把记录和应用到第 entries 条 .idx 记录之后的水位原子写入，lastEntry 为这条 .idx 记录
+func (m *LevelDbNeedleMap) write(batch *leveldb.Batch, entries uint64, lastEntry []byte) error {
+	data, err := json.Marshal(&needleMapWatermark{Entries: entries, LastEntry: lastEntry, Metric: m.snapshot()})
+	if err != nil {
+		return err
+	}
+	batch.Put(levelDbWatermarkKey, data)
+	return m.db.Write(batch, nil)
+}
+
+// 只更新 LevelDB 和统计，不写 .idx，entries 为 .idx 中截止到这条记录的记录数
+func (m *LevelDbNeedleMap) put(key NeedleId, offset Offset, size Size, entries uint64) error {
+	var oldSize Size
+	if oldNeedle, ok := m.Get(key); ok && oldNeedle.Size.IsValid() {
+		oldSize = oldNeedle.Size
+	}
+	m.logPut(key, oldSize, size)
+
+	bytes := make([]byte, NeedleIdSize+OffsetSize+SizeSize)
+	NeedleIdToBytes(bytes[0:NeedleIdSize], key)
+	OffsetToBytes(bytes[NeedleIdSize:NeedleIdSize+OffsetSize], offset)
+	SizeToBytes(bytes[NeedleIdSize+OffsetSize:NeedleIdSize+OffsetSize+SizeSize], size)
+	batch := new(leveldb.Batch)
+	batch.Put(bytes[0:NeedleIdSize], bytes[NeedleIdSize:])
+	return m.write(batch, entries, needle_map.ToBytes(key, offset, size))
+}
+
+// lastEntry 为这条删除在 .idx 中的记录，没有可删除的文件时也推进水位
+func (m *LevelDbNeedleMap) delete(key NeedleId, entries uint64, lastEntry []byte) error {
+	batch := new(leveldb.Batch)
+	oldNeedle, found := m.Get(key)
+	if !found || !oldNeedle.Size.IsValid() {
+		return m.write(batch, entries, lastEntry)
+	}
+	m.logDelete(oldNeedle.Size)
+
+	// 保留删除后的记录，size 取负，和 CompactMap 的行为一致
+	bytes := make([]byte, NeedleIdSize+OffsetSize+SizeSize)
+	NeedleIdToBytes(bytes[0:NeedleIdSize], key)
+	OffsetToBytes(bytes[NeedleIdSize:NeedleIdSize+OffsetSize], oldNeedle.Offset)
+	SizeToBytes(bytes[NeedleIdSize+OffsetSize:NeedleIdSize+OffsetSize+SizeSize], -oldNeedle.Size)
+	batch.Put(bytes[0:NeedleIdSize], bytes[NeedleIdSize:])
+	return m.write(batch, entries, lastEntry)
+}
+
+func (m *LevelDbNeedleMap) Put(key NeedleId, offset Offset, size Size) error {
+	if err := m.appendToIndexFile(key, offset, size); err != nil {
+		return fmt.Errorf("cannot write to indexfile %s: %v", m.indexFile.Name(), err)
+	}
+	return m.put(key, offset, size, m.indexEntries())
+}
+
+func (m *LevelDbNeedleMap) Delete(key NeedleId, offset Offset) error {
+	if err := m.appendToIndexFile(key, offset, TombstoneFileSize); err != nil {
+		return err
+	}
+	return m.delete(key, m.indexEntries(), needle_map.ToBytes(key, offset, TombstoneFileSize))
+}
+
+func (m *LevelDbNeedleMap) indexEntries() uint64 {
+	m.indexFileAccessLock.Lock()
+	defer m.indexFileAccessLock.Unlock()
+	return uint64(m.indexFileOffset / NeedleMapEntrySize)
+}
+
+func (m *LevelDbNeedleMap) Close() {
+	if m.indexFile == nil {
+		return
+	}
+	indexFileName := m.indexFile.Name()
+	if err := m.indexFile.Sync(); err != nil {
+		glog.Warningf("sync file %s failed: %v", indexFileName, err)
+	}
+	if m.db != nil {
+		if err := m.db.Close(); err != nil {
+			glog.Warningf("close levelDB failed: %v", err)
+		}
+		m.db = nil
+	}
+	_ = m.indexFile.Close()
+}
+
+func (m *LevelDbNeedleMap) Destroy() error {
+	m.Close()
+	os.Remove(m.indexFile.Name())
+	return os.RemoveAll(m.dbFileName)
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"os"
+)
+
+// NeedleMap 索引全部放在内存的 CompactMap 中
+type NeedleMap struct {
+	baseNeedleMapper
+	m needle_map.NeedleValueMap
+
+	// 关闭时把索引保存为有序快照（.sdx）和水位（.sdm），下次加载时只需重放水位之后的 .idx
+	snapshotFileName  string
+	watermarkFileName string
+}
+
+func NewCompactNeedleMap(file *os.File) *NeedleMap {
+	nm := &NeedleMap{
+		m: needle_map.NewCompactMap(),
+	}
+	nm.indexFile = file
+	stat, err := file.Stat()
+	if err != nil {
+		glog.Fatalf("stat file %s: %v", file.Name(), err)
+	}
+	nm.indexFileOffset = stat.Size()
+	return nm
+}
+
+// LoadCompactNeedleMap 重放 .idx 文件恢复内存索引
+func LoadCompactNeedleMap(file *os.File) (*NeedleMap, error) {
+	if _, err := truncateTornIndexEntry(file); err != nil {
+		return nil, err
+	}
+	nm := NewCompactNeedleMap(file)
+	return doLoading(file, nm)
+}
+
+// LoadCompactNeedleMapWithSnapshot 优先从快照加载，快照不存在或水位失效时退回到完整重放 .idx
+func LoadCompactNeedleMapWithSnapshot(indexBaseFileName string, file *os.File) (*NeedleMap, error) {
+	indexSize, err := truncateTornIndexEntry(file)
+	if err != nil {
+		return nil, err
+	}
+	nm := NewCompactNeedleMap(file)
+	nm.snapshotFileName = indexBaseFileName + ".sdx"
+	nm.watermarkFileName = indexBaseFileName + ".sdm"
+
+	watermark, err := loadNeedleMapWatermark(nm.watermarkFileName)
+	if err != nil || !watermark.validFor(file, indexSize) {
+		return doLoading(file, nm)
+	}
+	snapshot, err := openSortedIndexFile(nm.snapshotFileName)
+	if err != nil {
+		glog.Warningf("open snapshot %s: %v", nm.snapshotFileName, err)
+		return doLoading(file, nm)
+	}
+	defer snapshot.close()
+	err = snapshot.ascendingVisit(func(value needle_map.NeedleValue) error {
+		nm.m.Set(value.Key, value.Offset, value.Size)
+		return nil
+	})
+	if err != nil {
+		glog.Warningf("load snapshot %s: %v", nm.snapshotFileName, err)
+		nm.m = needle_map.NewCompactMap()
+		return doLoading(file, nm)
+	}
+	nm.restore(watermark.Metric)
+	glog.V(1).Infof("loaded snapshot %s with watermark %d of %d entries", nm.snapshotFileName, watermark.Entries, indexSize/NeedleMapEntrySize)
+	return doLoadingFrom(file, nm, watermark.Entries)
+}
+
+func doLoading(file *os.File, nm *NeedleMap) (*NeedleMap, error) {
+	return doLoadingFrom(file, nm, 0)
+}
+
+func doLoadingFrom(file *os.File, nm *NeedleMap, startFrom uint64) (*NeedleMap, error) {
+	e := idx.WalkIndexFile(file, startFrom, func(key NeedleId, offset Offset, size Size) error {
+		nm.MaybeSetMaxFileKey(key)
+		if !offset.IsZero() && size.IsValid() {
+			nm.FileCounter++
+			nm.FileByteCounter = nm.FileByteCounter + uint64(size)
+			oldOffset, oldSize := nm.m.Set(key, offset, size)
+			if !oldOffset.IsZero() && oldSize.IsValid() {
+				nm.DeletionCounter++
+				nm.DeletionByteCounter = nm.DeletionByteCounter + uint64(oldSize)
+			}
+		} else {
+			oldSize := nm.m.Delete(key)
+			if oldSize > 0 {
+				nm.DeletionCounter++
+				nm.DeletionByteCounter = nm.DeletionByteCounter + uint64(oldSize)
+			}
+		}
+		return nil
+	})
+	glog.V(1).Infof("max file key: %d for file: %s", nm.MaxFileKey(), file.Name())
+	return nm, e
+}
+
+func (nm *NeedleMap) Put(key NeedleId, offset Offset, size Size) error {
+	_, oldSize := nm.m.Set(key, offset, size)
+	nm.logPut(key, oldSize, size)
+	return nm.appendToIndexFile(key, offset, size)
+}
+
+func (nm *NeedleMap) Get(key NeedleId) (element *needle_map.NeedleValue, ok bool) {
+	element, ok = nm.m.Get(key)
+	return
+}
+
+// Delete offset 为删除标记 needle 在 .dat 中的位置
+func (nm *NeedleMap) Delete(key NeedleId, offset Offset) error {
+	deletedBytes := nm.m.Delete(key)
+	nm.logDelete(deletedBytes)
+	return nm.appendToIndexFile(key, offset, TombstoneFileSize)
+}
+
+func (nm *NeedleMap) Close() {
+	if nm.indexFile == nil {
+		return
+	}
+	indexFileName := nm.indexFile.Name()
+	if err := nm.indexFile.Sync(); err != nil {
+		glog.Warningf("sync file %s failed: %v", indexFileName, err)
+	}
+	if nm.snapshotFileName != "" {
+		if err := nm.saveSnapshot(); err != nil {
+			glog.Warningf("save snapshot %s: %v", nm.snapshotFileName, err)
+		}
+	}
+	_ = nm.indexFile.Close()
+}
+
+// 先删除旧水位再写快照，中途失败时没有水位，下次加载会完整重放 .idx
+func (nm *NeedleMap) saveSnapshot() error {
+	os.Remove(nm.watermarkFileName)
+	if err := writeSortedIndexFile(nm.snapshotFileName, nm.m.AscendingVisit); err != nil {
+		return err
+	}
+	watermark, err := newNeedleMapWatermark(nm.indexFile, uint64(nm.indexFileOffset/NeedleMapEntrySize), &nm.mapMetric)
+	if err != nil {
+		return err
+	}
+	return saveNeedleMapWatermark(nm.watermarkFileName, watermark)
+}
+
+func (nm *NeedleMap) Destroy() error {
+	nm.Close()
+	if nm.snapshotFileName != "" {
+		os.Remove(nm.snapshotFileName)
+		os.Remove(nm.watermarkFileName)
+	}
+	return os.Remove(nm.indexFile.Name())
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	. "cayoyibackend/weedfilesys/storage/types"
+	"sync/atomic"
+)
+
+// 卷内文件数、删除数以及对应的字节数，用于统计和判断是否需要压缩
+type mapMetric struct {
+	DeletionCounter     uint32 `json:"DeletionCounter"`
+	FileCounter         uint32 `json:"FileCounter"`
+	DeletionByteCounter uint64 `json:"DeletionByteCounter"`
+	FileByteCounter     uint64 `json:"FileByteCounter"`
+	MaximumFileKey      uint64 `json:"MaxFileKey"`
+}
+
+// 新增或覆盖一个文件，覆盖时旧文件计入删除
+func (mm *mapMetric) logPut(key NeedleId, oldSize Size, newSize Size) {
+	mm.MaybeSetMaxFileKey(key)
+	atomic.AddUint32(&mm.FileCounter, 1)
+	atomic.AddUint64(&mm.FileByteCounter, uint64(newSize))
+	if oldSize > 0 && oldSize.IsValid() {
+		mm.logDelete(oldSize)
+	}
+}
+
+func (mm *mapMetric) logDelete(deletedByteCount Size) {
+	if deletedByteCount <= 0 {
+		return
+	}
+	atomic.AddUint32(&mm.DeletionCounter, 1)
+	atomic.AddUint64(&mm.DeletionByteCounter, uint64(deletedByteCount))
+}
+
+func (mm *mapMetric) ContentSize() uint64 {
+	return atomic.LoadUint64(&mm.FileByteCounter)
+}
+
+func (mm *mapMetric) DeletedSize() uint64 {
+	return atomic.LoadUint64(&mm.DeletionByteCounter)
+}
+
+func (mm *mapMetric) FileCount() int {
+	return int(atomic.LoadUint32(&mm.FileCounter))
+}
+
+func (mm *mapMetric) DeletedCount() int {
+	return int(atomic.LoadUint32(&mm.DeletionCounter))
+}
+
+func (mm *mapMetric) MaxFileKey() NeedleId {
+	return Uint64ToNeedleId(atomic.LoadUint64(&mm.MaximumFileKey))
+}
+
+func (mm *mapMetric) MaybeSetMaxFileKey(key NeedleId) {
+	for {
+		current := atomic.LoadUint64(&mm.MaximumFileKey)
+		if NeedleIdToUint64(key) <= current {
+			return
+		}
+		if atomic.CompareAndSwapUint64(&mm.MaximumFileKey, current, NeedleIdToUint64(key)) {
+			return
+		}
+	}
+}
+
+// 取一份一致的拷贝，用于持久化
+func (mm *mapMetric) snapshot() mapMetric {
+	return mapMetric{
+		DeletionCounter:     atomic.LoadUint32(&mm.DeletionCounter),
+		FileCounter:         atomic.LoadUint32(&mm.FileCounter),
+		DeletionByteCounter: atomic.LoadUint64(&mm.DeletionByteCounter),
+		FileByteCounter:     atomic.LoadUint64(&mm.FileByteCounter),
+		MaximumFileKey:      atomic.LoadUint64(&mm.MaximumFileKey),
+	}
+}
+
+func (mm *mapMetric) restore(from mapMetric) {
+	atomic.StoreUint32(&mm.DeletionCounter, from.DeletionCounter)
+	atomic.StoreUint32(&mm.FileCounter, from.FileCounter)
+	atomic.StoreUint64(&mm.DeletionByteCounter, from.DeletionByteCounter)
+	atomic.StoreUint64(&mm.FileByteCounter, from.FileByteCounter)
+	atomic.StoreUint64(&mm.MaximumFileKey, from.MaximumFileKey)
+}
@@ -0,0 +1,339 @@
+package storage
+
+import (
+	"bufio"
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+const (
+	// .sdx 每页的记录数，内存中只保存每页第一条记录的 key
+	sortedIndexPageEntries = 256
+	// 新写入的记录超过这个数量就合并到 .sdx
+	sortedFileMaxDeltaEntries = 64 * 1024
+)
+
+// sortedIndexFile 按 key 升序排列的 .sdx 文件，格式和 .idx 一致，但每个 key 只有一条有效记录
+type sortedIndexFile struct {
+	file     *os.File
+	entries  int64
+	pageKeys []NeedleId
+}
+
+func openSortedIndexFile(fileName string) (*sortedIndexFile, error) {
+	file, err := os.OpenFile(fileName, os.O_RDONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if stat.Size()%NeedleMapEntrySize != 0 {
+		file.Close()
+		return nil, fmt.Errorf("sorted index %s size %d is not a multiple of %d", fileName, stat.Size(), NeedleMapEntrySize)
+	}
+
+	s := &sortedIndexFile{file: file, entries: stat.Size() / NeedleMapEntrySize}
+	key := make([]byte, NeedleIdSize)
+	for i := int64(0); i < s.entries; i += sortedIndexPageEntries {
+		if _, err = file.ReadAt(key, i*NeedleMapEntrySize); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("read %s entry %d: %v", fileName, i, err)
+		}
+		s.pageKeys = append(s.pageKeys, BytesToNeedleId(key))
+	}
+	return s, nil
+}
+
+// get 先在页索引中找到 key 所在的页，再读出这一页用 FirstInvalidIndex 二分查找
+func (s *sortedIndexFile) get(key NeedleId) (*needle_map.NeedleValue, bool, error) {
+	page := sort.Search(len(s.pageKeys), func(i int) bool {
+		return s.pageKeys[i] > key
+	}) - 1
+	if page < 0 {
+		return nil, false, nil
+	}
+
+	start := int64(page) * sortedIndexPageEntries
+	count := min(int64(sortedIndexPageEntries), s.entries-start)
+	pageBytes := make([]byte, count*NeedleMapEntrySize)
+	if n, err := s.file.ReadAt(pageBytes, start*NeedleMapEntrySize); err != nil && !(err == io.EOF && n == len(pageBytes)) {
+		return nil, false, fmt.Errorf("read %s page %d: %v", s.file.Name(), page, err)
+	}
+
+	i, err := idx.FirstInvalidIndex(pageBytes, func(k NeedleId, offset Offset, size Size) (bool, error) {
+		return k <= key, nil
+	})
+	if err != nil || i == 0 {
+		return nil, false, err
+	}
+	k, offset, size := idx.IdxFileEntry(pageBytes[(i-1)*NeedleMapEntrySize : i*NeedleMapEntrySize])
+	if k != key {
+		return nil, false, nil
+	}
+	return &needle_map.NeedleValue{Key: k, Offset: offset, Size: size}, true, nil
+}
+
+func (s *sortedIndexFile) ascendingVisit(visit func(needle_map.NeedleValue) error) error {
+	return idx.WalkIndexFile(s.file, 0, func(key NeedleId, offset Offset, size Size) error {
+		return visit(needle_map.NeedleValue{Key: key, Offset: offset, Size: size})
+	})
+}
+
+func (s *sortedIndexFile) close() {
+	if s != nil && s.file != nil {
+		_ = s.file.Close()
+	}
+}
+
+// writeSortedIndexFile 写临时文件后改名，写入过程中崩溃不会破坏原来的 .sdx
+func writeSortedIndexFile(fileName string, ascendingVisit func(visit func(needle_map.NeedleValue) error) error) error {
+	tmpFileName := fileName + ".tmp"
+	file, err := os.OpenFile(tmpFileName, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	// 删除的记录也保留，读取时才能区分“已删除”和“不存在”
+	err = ascendingVisit(func(value needle_map.NeedleValue) error {
+		if value.Offset.IsZero() {
+			return nil
+		}
+		_, writeErr := w.Write(value.ToBytes())
+		return writeErr
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpFileName)
+		return fmt.Errorf("write %s: %v", fileName, err)
+	}
+	return os.Rename(tmpFileName, fileName)
+}
+
+// SortedFileNeedleMap 大部分索引在有序的 .sdx 文件中，水位之后新写入的记录放在内存的 delta 中，
+// delta 中删除用 TombstoneFileSize 表示，用于遮住 .sdx 中的旧记录
+type SortedFileNeedleMap struct {
+	baseNeedleMapper
+
+	sdxFileName       string
+	watermarkFileName string
+
+	sortedLock sync.RWMutex
+	sorted     *sortedIndexFile
+	delta      *needle_map.MemDb
+}
+
+// NewSortedFileNeedleMap 水位有效时打开已有的 .sdx 并重放水位之后的 .idx，否则从 .idx 重建 .sdx
+func NewSortedFileNeedleMap(indexBaseFileName string, indexFile *os.File) (m *SortedFileNeedleMap, err error) {
+	indexSize, err := truncateTornIndexEntry(indexFile)
+	if err != nil {
+		return nil, err
+	}
+	m = &SortedFileNeedleMap{
+		sdxFileName:       indexBaseFileName + ".sdx",
+		watermarkFileName: indexBaseFileName + ".sdm",
+		delta:             needle_map.NewMemDb(),
+	}
+	m.indexFile = indexFile
+	m.indexFileOffset = indexSize
+
+	watermark, loadErr := loadNeedleMapWatermark(m.watermarkFileName)
+	if loadErr == nil && watermark.validFor(indexFile, indexSize) {
+		if m.sorted, err = openSortedIndexFile(m.sdxFileName); err == nil {
+			m.restore(watermark.Metric)
+			glog.V(1).Infof("open %s with watermark %d of %d entries", m.sdxFileName, watermark.Entries, indexSize/NeedleMapEntrySize)
+			err = idx.WalkIndexFile(indexFile, watermark.Entries, m.replay)
+			return m, err
+		}
+		glog.Warningf("open %s: %v, rebuilding", m.sdxFileName, err)
+	}
+
+	if err = m.rebuild(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// 整个 .idx 读入内存排序后写出 .sdx，只在没有可用的 .sdx 时发生
+func (m *SortedFileNeedleMap) rebuild() error {
+	glog.V(0).Infof("generating %s from %s", m.sdxFileName, m.indexFile.Name())
+	nm := &NeedleMap{m: needle_map.NewCompactMap()}
+	if _, err := doLoading(m.indexFile, nm); err != nil {
+		return err
+	}
+	if err := writeSortedIndexFile(m.sdxFileName, nm.m.AscendingVisit); err != nil {
+		return err
+	}
+	m.restore(nm.mapMetric.snapshot())
+	m.sorted.close()
+	sorted, err := openSortedIndexFile(m.sdxFileName)
+	if err != nil {
+		return err
+	}
+	m.sorted = sorted
+	return m.saveWatermark()
+}
+
+func (m *SortedFileNeedleMap) saveWatermark() error {
+	watermark, err := newNeedleMapWatermark(m.indexFile, uint64(m.indexFileOffset/NeedleMapEntrySize), &m.mapMetric)
+	if err != nil {
+		return err
+	}
+	return saveNeedleMapWatermark(m.watermarkFileName, watermark)
+}
+
+func (m *SortedFileNeedleMap) replay(key NeedleId, offset Offset, size Size) error {
+	if !offset.IsZero() && size.IsValid() {
+		oldSize := m.existingSize(key)
+		m.delta.Set(key, offset, size)
+		m.logPut(key, oldSize, size)
+	} else {
+		oldSize := m.existingSize(key)
+		m.delta.Set(key, offset, TombstoneFileSize)
+		m.logDelete(oldSize)
+	}
+	return nil
+}
+
+func (m *SortedFileNeedleMap) existingSize(key NeedleId) Size {
+	if nv, ok := m.Get(key); ok && nv.Size.IsValid() {
+		return nv.Size
+	}
+	return 0
+}
+
+func (m *SortedFileNeedleMap) Get(key NeedleId) (element *needle_map.NeedleValue, ok bool) {
+	if element, ok = m.delta.Get(key); ok {
+		return
+	}
+	m.sortedLock.RLock()
+	defer m.sortedLock.RUnlock()
+	element, ok, err := m.sorted.get(key)
+	if err != nil {
+		glog.Errorf("get %d from %s: %v", key, m.sdxFileName, err)
+	}
+	return element, ok
+}
+
+func (m *SortedFileNeedleMap) Put(key NeedleId, offset Offset, size Size) error {
+	oldSize := m.existingSize(key)
+	m.delta.Set(key, offset, size)
+	m.logPut(key, oldSize, size)
+	if err := m.appendToIndexFile(key, offset, size); err != nil {
+		return err
+	}
+	return m.maybeMerge()
+}
+
+func (m *SortedFileNeedleMap) Delete(key NeedleId, offset Offset) error {
+	oldSize := m.existingSize(key)
+	m.delta.Set(key, offset, TombstoneFileSize)
+	m.logDelete(oldSize)
+	if err := m.appendToIndexFile(key, offset, TombstoneFileSize); err != nil {
+		return err
+	}
+	return m.maybeMerge()
+}
+
+func (m *SortedFileNeedleMap) maybeMerge() error {
+	if m.delta.Len() < sortedFileMaxDeltaEntries {
+		return nil
+	}
+	return m.merge()
+}
+
+// merge 把 delta 合并进 .sdx，同时推进水位
+func (m *SortedFileNeedleMap) merge() error {
+	if m.delta.Len() == 0 {
+		return nil
+	}
+	// 合并前 .idx 必须落盘，否则水位可能指向还没写到磁盘的记录
+	if err := m.indexFile.Sync(); err != nil {
+		return err
+	}
+
+	var pending []needle_map.NeedleValue
+	m.delta.AscendingVisit(func(value needle_map.NeedleValue) error {
+		pending = append(pending, value)
+		return nil
+	})
+
+	m.sortedLock.Lock()
+	defer m.sortedLock.Unlock()
+
+	err := writeSortedIndexFile(m.sdxFileName, func(visit func(needle_map.NeedleValue) error) error {
+		err := m.sorted.ascendingVisit(func(value needle_map.NeedleValue) error {
+			for len(pending) > 0 && pending[0].Key < value.Key {
+				if err := visit(pending[0]); err != nil {
+					return err
+				}
+				pending = pending[1:]
+			}
+			if len(pending) > 0 && pending[0].Key == value.Key {
+				value = pending[0]
+				pending = pending[1:]
+			}
+			return visit(value)
+		})
+		if err != nil {
+			return err
+		}
+		for _, value := range pending {
+			if err = visit(value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	m.sorted.close()
+	if m.sorted, err = openSortedIndexFile(m.sdxFileName); err != nil {
+		return err
+	}
+	m.delta.Close()
+	m.delta = needle_map.NewMemDb()
+	return m.saveWatermark()
+}
+
+func (m *SortedFileNeedleMap) Close() {
+	if m.indexFile == nil {
+		return
+	}
+	if err := m.merge(); err != nil && !errors.Is(err, os.ErrPermission) {
+		glog.Warningf("merge %s: %v", m.sdxFileName, err)
+	}
+	m.sorted.close()
+	m.delta.Close()
+	if err := m.indexFile.Sync(); err != nil {
+		glog.Warningf("sync file %s failed: %v", m.indexFile.Name(), err)
+	}
+	_ = m.indexFile.Close()
+}
+
+func (m *SortedFileNeedleMap) Destroy() error {
+	m.Close()
+	os.Remove(m.sdxFileName)
+	os.Remove(m.watermarkFileName)
+	return os.Remove(m.indexFile.Name())
+}
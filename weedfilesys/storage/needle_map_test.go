@@ -0,0 +1,166 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+func openTestNeedleMap(t *testing.T, kind NeedleMapKind, baseFileName string) NeedleMapper {
+	indexFile, err := os.OpenFile(baseFileName+".idx", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nm NeedleMapper
+	switch kind {
+	case NeedleMapInMemory:
+		nm, err = LoadCompactNeedleMapWithSnapshot(baseFileName, indexFile)
+	case NeedleMapLevelDb:
+		nm, err = NewLevelDbNeedleMap(baseFileName+".ldb", indexFile, &opt.Options{})
+	case NeedleMapSortedFile:
+		nm, err = NewSortedFileNeedleMap(baseFileName, indexFile)
+	}
+	if err != nil {
+		t.Fatalf("open %s needle map: %v", kind, err)
+	}
+	return nm
+}
+
+func appendTestIndexEntry(t *testing.T, baseFileName string, key NeedleId, offset uint32, size Size) {
+	f, err := os.OpenFile(baseFileName+".idx", os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.Write(needle_map.ToBytes(key, Uint32ToOffset(offset), size)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectNeedle(t *testing.T, nm NeedleMapper, key NeedleId, offset uint32, size Size) {
+	t.Helper()
+	nv, ok := nm.Get(key)
+	if !ok || nv.Offset != Uint32ToOffset(offset) || nv.Size != size {
+		t.Fatalf("key %d: expect offset %d size %d, got %+v found %v", key, offset, size, nv, ok)
+	}
+}
+
+func expectDeleted(t *testing.T, nm NeedleMapper, key NeedleId) {
+	t.Helper()
+	if nv, ok := nm.Get(key); ok && !nv.Size.IsDeleted() {
+		t.Fatalf("key %d: expect deleted, got %+v", key, nv)
+	}
+}
+
+func TestNeedleMapKindsReloadFromWatermark(t *testing.T) {
+	const count = 1000 // 超过一页，覆盖 .sdx 的分页查找
+	for _, kind := range testNeedleMapKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			baseFileName := filepath.Join(t.TempDir(), "1")
+
+			nm := openTestNeedleMap(t, kind, baseFileName)
+			for i := 1; i <= count; i++ {
+				if err := nm.Put(NeedleId(i*2), Uint32ToOffset(uint32(i)), Size(i)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			nm.Put(10, Uint32ToOffset(5000), 55)
+			nm.Delete(20, Uint32ToOffset(5001))
+			nm.Close()
+
+			// 关闭之后直接追加到 .idx，模拟水位之后还有记录
+			appendTestIndexEntry(t, baseFileName, 3, 6000, 33)
+			appendTestIndexEntry(t, baseFileName, 30, 6001, TombstoneFileSize)
+
+			nm = openTestNeedleMap(t, kind, baseFileName)
+			expectNeedle(t, nm, 2, 1, 1)
+			expectNeedle(t, nm, 10, 5000, 55)
+			expectNeedle(t, nm, 3, 6000, 33)
+			expectNeedle(t, nm, NeedleId(count*2), count, count)
+			expectDeleted(t, nm, 20)
+			expectDeleted(t, nm, 30)
+			if _, ok := nm.Get(5); ok {
+				t.Fatalf("key 5 should not exist")
+			}
+			if nm.FileCount() != count+2 || nm.DeletedCount() != 3 {
+				t.Fatalf("unexpected metrics: files %d deleted %d", nm.FileCount(), nm.DeletedCount())
+			}
+			if nm.MaxFileKey() != NeedleId(count*2) {
+				t.Fatalf("unexpected max file key %d", nm.MaxFileKey())
+			}
+			nm.Close()
+		})
+	}
+}
+
+// 没有正常关闭时重放 .idx，已经写入 LevelDB 的记录不能重复计入统计
+func TestLevelDbNeedleMapReloadAfterCrash(t *testing.T) {
+	baseFileName := filepath.Join(t.TempDir(), "1")
+
+	nm := openTestNeedleMap(t, NeedleMapLevelDb, baseFileName).(*LevelDbNeedleMap)
+	for i := 1; i <= 10; i++ {
+		if err := nm.Put(NeedleId(i), Uint32ToOffset(uint32(i)), 10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	nm.Put(1, Uint32ToOffset(11), 20)
+	nm.Delete(2, Uint32ToOffset(12))
+	// 模拟进程崩溃，不走 Close
+	nm.db.Close()
+	nm.indexFile.Close()
+
+	// 崩溃前 .idx 已经追加但还没写入 LevelDB 的记录
+	appendTestIndexEntry(t, baseFileName, 3, 13, 30)
+
+	nm = openTestNeedleMap(t, NeedleMapLevelDb, baseFileName).(*LevelDbNeedleMap)
+	defer nm.Close()
+	expectNeedle(t, nm, 1, 11, 20)
+	expectNeedle(t, nm, 3, 13, 30)
+	expectDeleted(t, nm, 2)
+	if nm.FileCount() != 12 || nm.DeletedCount() != 3 || nm.DeletedSize() != 30 || nm.ContentSize() != 150 {
+		t.Fatalf("unexpected metrics: files %d deleted %d deleted size %d content size %d",
+			nm.FileCount(), nm.DeletedCount(), nm.DeletedSize(), nm.ContentSize())
+	}
+}
+
+func TestNeedleMapWatermarkInvalidatedByNewIndex(t *testing.T) {
+	for _, kind := range testNeedleMapKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			baseFileName := filepath.Join(t.TempDir(), "1")
+
+			nm := openTestNeedleMap(t, kind, baseFileName)
+			nm.Put(1, Uint32ToOffset(1), 10)
+			nm.Put(2, Uint32ToOffset(2), 20)
+			nm.Close()
+
+			// 压缩之后 .idx 被整体替换，记录数相同但内容不同
+			os.Remove(baseFileName + ".idx")
+			appendTestIndexEntry(t, baseFileName, 7, 1, 70)
+			appendTestIndexEntry(t, baseFileName, 8, 2, 80)
+
+			nm = openTestNeedleMap(t, kind, baseFileName)
+			defer nm.Close()
+			expectNeedle(t, nm, 7, 1, 70)
+			expectNeedle(t, nm, 8, 2, 80)
+			if _, ok := nm.Get(1); ok {
+				t.Fatalf("stale key 1 should not exist")
+			}
+		})
+	}
+}
+
+func TestParseNeedleMapKind(t *testing.T) {
+	for _, kind := range testNeedleMapKinds {
+		parsed, err := ParseNeedleMapKind(kind.String())
+		if err != nil || parsed != kind {
+			t.Fatalf("parse %s: %v %v", kind, parsed, err)
+		}
+	}
+	if _, err := ParseNeedleMapKind("btree"); err == nil {
+		t.Fatalf("expect error for unknown kind")
+	}
+}
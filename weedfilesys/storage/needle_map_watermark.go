@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"bytes"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// needleMapWatermark 记录索引已经应用到 .idx 的第几条记录，重启时只需要重放水位之后的部分。
+// LastEntry 保存水位前最后一条记录的原始字节，.idx 被整体替换（比如压缩之后）时能发现水位已失效
+type needleMapWatermark struct {
+	Entries   uint64    `json:"entries"`
+	LastEntry []byte    `json:"lastEntry"`
+	Metric    mapMetric `json:"metric"`
+}
+
+func newNeedleMapWatermark(indexFile *os.File, entries uint64, metric *mapMetric) (*needleMapWatermark, error) {
+	w := &needleMapWatermark{Entries: entries}
+	if metric != nil {
+		w.Metric = metric.snapshot()
+	}
+	if entries > 0 {
+		lastEntry, err := readIndexEntryBytes(indexFile, int64(entries)-1)
+		if err != nil {
+			return nil, err
+		}
+		w.LastEntry = lastEntry
+	}
+	return w, nil
+}
+
+// validFor 水位不能超过 .idx 的记录数，且水位前最后一条记录必须一致
+func (w *needleMapWatermark) validFor(indexFile *os.File, indexSize int64) bool {
+	if w == nil || int64(w.Entries)*NeedleMapEntrySize > indexSize {
+		return false
+	}
+	if w.Entries == 0 {
+		return true
+	}
+	lastEntry, err := readIndexEntryBytes(indexFile, int64(w.Entries)-1)
+	if err != nil {
+		return false
+	}
+	return bytes.Equal(lastEntry, w.LastEntry)
+}
+
+func readIndexEntryBytes(indexFile *os.File, n int64) ([]byte, error) {
+	entry := make([]byte, NeedleMapEntrySize)
+	if _, err := indexFile.ReadAt(entry, n*NeedleMapEntrySize); err != nil {
+		return nil, fmt.Errorf("read %s entry %d: %v", indexFile.Name(), n, err)
+	}
+	return entry, nil
+}
+
+func loadNeedleMapWatermark(fileName string) (*needleMapWatermark, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	w := &needleMapWatermark{}
+	if err = json.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("unmarshal %s: %v", fileName, err)
+	}
+	return w, nil
+}
+
+// 先写临时文件再改名，保证水位文件要么是旧的要么是新的
+func saveNeedleMapWatermark(fileName string, w *needleMapWatermark) error {
+	data, err := json.Marshal(w)
+	if err != nil {
+		return err
+	}
+	tmpFileName := fileName + ".tmp"
+	if err = os.WriteFile(tmpFileName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, fileName)
+}
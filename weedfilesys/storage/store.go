@@ -0,0 +1,475 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/stats"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+const (
+	// 每个目录加载卷时的并发数，0 表示使用 CPU 核数
+	defaultConcurrentLoading = 0
+	// TTL 卷过期后最多再保留多少分钟才删除
+	maxTtlVolumeRemovalDelay = 10
+)
+
+// Store 卷服务器上所有数据目录的集合，负责卷的分配、读写路由以及生成心跳
+type Store struct {
+	MasterAddress   string
+	grpcDialOption  grpc.DialOption // 访问 master 和其他卷服务器，比如读取远程的 EC 分片
+	volumeSizeLimit uint64          // master 下发的卷大小上限
+	Ip              string
+	Port            int
+	GrpcPort        int
+	PublicUrl       string
+	Locations       []*DiskLocation
+	NeedleMapKind   NeedleMapKind
+	dataCenter      string // 由 master 下发
+	rack            string // 由 master 下发
+	isStopping      bool
+
+	// 心跳增量：新增或删除的卷和分片，由心跳协程取走上报
+	NewVolumesChan      chan master_pb.VolumeShortInformationMessage
+	DeletedVolumesChan  chan master_pb.VolumeShortInformationMessage
+	NewEcShardsChan     chan master_pb.VolumeEcShardInformationMessage
+	DeletedEcShardsChan chan master_pb.VolumeEcShardInformationMessage
+}
+
+func (s *Store) String() (str string) {
+	str = fmt.Sprintf("Ip:%s, Port:%d, PublicUrl:%s, dataCenter:%s, rack:%s, master:%s, volumeSizeLimit:%d",
+		s.Ip, s.Port, s.PublicUrl, s.dataCenter, s.rack, s.MasterAddress, s.GetVolumeSizeLimit())
+	return
+}
+
+// NewStore 每个目录对应一个 DiskLocation，各目录并发加载已有的卷和 EC 分片
+func NewStore(grpcDialOption grpc.DialOption, port int, grpcPort int, ip, publicUrl string, dirnames []string, maxVolumeCounts []int32,
+	minFreeSpaces []util.MinFreeSpace, idxFolder string, needleMapKind NeedleMapKind, diskTypes []DiskType) (s *Store) {
+	s = &Store{grpcDialOption: grpcDialOption, Port: port, GrpcPort: grpcPort, Ip: ip, PublicUrl: publicUrl, NeedleMapKind: needleMapKind}
+
+	var wg sync.WaitGroup
+	for i := 0; i < len(dirnames); i++ {
+		location := NewDiskLocation(dirnames[i], maxVolumeCounts[i], minFreeSpaces[i], idxFolder, diskTypes[i])
+		s.Locations = append(s.Locations, location)
+		stats.VolumeServerMaxVolumeCounter.Add(float64(maxVolumeCounts[i]))
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			location.loadExistingVolumes(needleMapKind, defaultConcurrentLoading)
+		}()
+	}
+	wg.Wait()
+
+	s.NewVolumesChan = make(chan master_pb.VolumeShortInformationMessage, 3)
+	s.DeletedVolumesChan = make(chan master_pb.VolumeShortInformationMessage, 3)
+
+	s.NewEcShardsChan = make(chan master_pb.VolumeEcShardInformationMessage, 3)
+	s.DeletedEcShardsChan = make(chan master_pb.VolumeEcShardInformationMessage, 3)
+
+	return
+}
+
+// AddVolume 在空闲的目录上创建新卷
+func (s *Store) AddVolume(volumeId needle.VolumeId, collection string, replicaPlacement string, ttlString string, preallocate int64, memoryMapMaxSizeMb uint32, diskType DiskType) error {
+	rt, e := super_block.NewReplicaPlacementFromString(replicaPlacement)
+	if e != nil {
+		return e
+	}
+	ttl, e := needle.ReadTTL(ttlString)
+	if e != nil {
+		return e
+	}
+	e = s.addVolume(volumeId, collection, rt, ttl, preallocate, memoryMapMaxSizeMb, diskType)
+	return e
+}
+
+func (s *Store) DeleteCollection(collection string) (e error) {
+	for _, location := range s.Locations {
+		e = location.DeleteCollectionFromDiskLocation(collection)
+		if e != nil {
+			return
+		}
+		// let the heartbeat send the list of volumes, instead of sending the deleted volume ids to DeletedVolumesChan
+	}
+	return
+}
+
+func (s *Store) findVolume(vid needle.VolumeId) *Volume {
+	for _, location := range s.Locations {
+		if v, found := location.FindVolume(vid); found {
+			return v
+		}
+	}
+	return nil
+}
+
+// FindFreeLocation 在指定磁盘类型的目录中选择空闲名额最多的一个，剩余空间不足的目录不参与分配
+func (s *Store) FindFreeLocation(diskType DiskType) (ret *DiskLocation) {
+	max := int32(0)
+	for _, location := range s.Locations {
+		if diskType != location.DiskType {
+			continue
+		}
+		if location.IsDiskSpaceLow() {
+			continue
+		}
+		currentFreeCount := location.MaxVolumeCount - int32(location.VolumesLen())
+		currentFreeCount *= erasure_coding.DataShardsCount
+		currentFreeCount -= int32(location.EcShardCount())
+		currentFreeCount /= erasure_coding.DataShardsCount
+		if currentFreeCount > max {
+			max = currentFreeCount
+			ret = location
+		}
+	}
+	return ret
+}
+
+func (s *Store) addVolume(vid needle.VolumeId, collection string, replicaPlacement *super_block.ReplicaPlacement, ttl *needle.TTL, preallocate int64, memoryMapMaxSizeMb uint32, diskType DiskType) error {
+	if s.findVolume(vid) != nil {
+		return fmt.Errorf("Volume Id %d already exists!", vid)
+	}
+	if location := s.FindFreeLocation(diskType); location != nil {
+		glog.V(0).Infof("In dir %s adds volume:%v collection:%s replicaPlacement:%v ttl:%v",
+			location.Directory, vid, collection, replicaPlacement, ttl)
+		if volume, err := NewVolume(location.Directory, location.IdxDirectory, collection, vid, s.NeedleMapKind, replicaPlacement, ttl, preallocate, memoryMapMaxSizeMb); err == nil {
+			location.SetVolume(vid, volume)
+			glog.V(0).Infof("add volume %d", vid)
+			s.NewVolumesChan <- master_pb.VolumeShortInformationMessage{
+				Id:               uint32(vid),
+				Collection:       collection,
+				ReplicaPlacement: uint32(replicaPlacement.Byte()),
+				Version:          uint32(volume.Version()),
+				Ttl:              ttl.ToUint32(),
+				DiskType:         string(diskType),
+			}
+			return nil
+		} else {
+			return err
+		}
+	}
+	return fmt.Errorf("No more free space left")
+}
+
+// CollectHeartbeat 汇总所有目录的卷信息，同时更新卷数量和磁盘占用的监控指标
+func (s *Store) CollectHeartbeat() *master_pb.Heartbeat {
+	var volumeMessages []*master_pb.VolumeInformationMessage
+	maxVolumeCounts := make(map[string]uint32)
+	var maxFileKey NeedleId
+	collectionVolumeSize := make(map[string]int64)
+	collectionVolumeDeletedBytes := make(map[string]int64)
+	collectionVolumeReadOnlyCount := make(map[string]map[string]uint8)
+	for _, location := range s.Locations {
+		var expiredVolumes []*Volume
+		maxVolumeCounts[string(location.DiskType)] += uint32(location.MaxVolumeCount)
+		location.volumesLock.RLock()
+		for _, v := range location.volumes {
+			curMaxFileKey, volumeMessage := v.ToVolumeInformationMessage()
+			if volumeMessage == nil {
+				continue
+			}
+			if maxFileKey < curMaxFileKey {
+				maxFileKey = curMaxFileKey
+			}
+			// 过期的 TTL 卷不再上报，master 随之不再分配和查找它
+			if !v.expired(volumeMessage.Size, s.GetVolumeSizeLimit()) {
+				volumeMessages = append(volumeMessages, volumeMessage)
+			} else if v.expiredLongEnough(maxTtlVolumeRemovalDelay) {
+				expiredVolumes = append(expiredVolumes, v)
+			} else {
+				glog.V(0).Infof("volume %d is expired", v.Id)
+			}
+
+			collectionVolumeSize[v.Collection] += int64(volumeMessage.Size)
+			collectionVolumeDeletedBytes[v.Collection] += int64(volumeMessage.DeletedByteCount)
+			if _, exist := collectionVolumeReadOnlyCount[v.Collection]; !exist {
+				collectionVolumeReadOnlyCount[v.Collection] = map[string]uint8{
+					stats.IsReadOnly:       0,
+					stats.NoWriteOrDelete:  0,
+					stats.NoWriteCanDelete: 0,
+					stats.IsDiskSpaceLow:   0,
+				}
+			}
+			if v.IsReadOnly() {
+				collectionVolumeReadOnlyCount[v.Collection][stats.IsReadOnly] += 1
+				if v.noWriteOrDelete {
+					collectionVolumeReadOnlyCount[v.Collection][stats.NoWriteOrDelete] += 1
+				}
+				if v.noWriteCanDelete {
+					collectionVolumeReadOnlyCount[v.Collection][stats.NoWriteCanDelete] += 1
+				}
+				if location.isDiskSpaceLow {
+					collectionVolumeReadOnlyCount[v.Collection][stats.IsDiskSpaceLow] += 1
+				}
+			}
+		}
+		location.volumesLock.RUnlock()
+
+		// 删除文件甚至远程的段比较慢，只在锁内摘下卷，锁外销毁，不阻塞这个目录上的卷查找
+		for _, v := range location.takeVolumes(expiredVolumes) {
+			if err := v.Destroy(); err != nil {
+				glog.Warningf("delete expired volume %d: %v", v.Id, err)
+			} else {
+				glog.V(0).Infof("expired volume %d is deleted", v.Id)
+			}
+		}
+	}
+
+	for col, size := range collectionVolumeSize {
+		stats.VolumeServerDiskSizeGauge.WithLabelValues(col, "normal").Set(float64(size))
+	}
+	for col, deletedBytes := range collectionVolumeDeletedBytes {
+		stats.VolumeServerDiskSizeGauge.WithLabelValues(col, "deleted_bytes").Set(float64(deletedBytes))
+	}
+	for col, types := range collectionVolumeReadOnlyCount {
+		for t, count := range types {
+			stats.VolumeServerReadOnlyVolumeGauge.WithLabelValues(col, t).Set(float64(count))
+		}
+	}
+
+	return &master_pb.Heartbeat{
+		Ip:              s.Ip,
+		Port:            uint32(s.Port),
+		GrpcPort:        uint32(s.GrpcPort),
+		PublicUrl:       s.PublicUrl,
+		MaxVolumeCounts: maxVolumeCounts,
+		MaxFileKey:      NeedleIdToUint64(maxFileKey),
+		DataCenter:      s.dataCenter,
+		Rack:            s.rack,
+		Volumes:         volumeMessages,
+		HasNoVolumes:    len(volumeMessages) == 0,
+	}
+}
+
+func (s *Store) SetStopping() {
+	s.isStopping = true
+	for _, location := range s.Locations {
+		location.volumesLock.Lock()
+		for _, v := range location.volumes {
+			v.dataFileAccessLock.Lock()
+			v.noWriteOrDelete = true
+			v.dataFileAccessLock.Unlock()
+		}
+		location.volumesLock.Unlock()
+	}
+}
+
+func (s *Store) Close() {
+	for _, location := range s.Locations {
+		location.Close()
+	}
+}
+
+func (s *Store) WriteVolumeNeedle(i needle.VolumeId, n *needle.Needle) (isUnchanged bool, err error) {
+	if v := s.findVolume(i); v != nil {
+		if v.IsReadOnly() {
+			err = fmt.Errorf("volume %d is read only", i)
+			return
+		}
+		_, _, isUnchanged, err = v.WriteNeedle(n)
+		return
+	}
+	glog.V(0).Infoln("volume", i, "not found!")
+	err = fmt.Errorf("volume %d not found on %s:%d", i, s.Ip, s.Port)
+	return
+}
+
+func (s *Store) DeleteVolumeNeedle(i needle.VolumeId, n *needle.Needle) (Size, error) {
+	if v := s.findVolume(i); v != nil {
+		if v.noWriteOrDelete {
+			return 0, fmt.Errorf("volume %d is read only", i)
+		}
+		return v.DeleteNeedle(n)
+	}
+	return 0, fmt.Errorf("volume %d not found on %s:%d", i, s.Ip, s.Port)
+}
+
+func (s *Store) ReadVolumeNeedle(i needle.VolumeId, n *needle.Needle) (int, error) {
+	if v := s.findVolume(i); v != nil {
+		return v.ReadNeedle(n)
+	}
+	return 0, fmt.Errorf("volume %d not found", i)
+}
+
+func (s *Store) GetVolume(i needle.VolumeId) *Volume {
+	return s.findVolume(i)
+}
+
+func (s *Store) HasVolume(i needle.VolumeId) bool {
+	v := s.findVolume(i)
+	return v != nil
+}
+
+// MarkVolumeReadonly persist 为 true 时只读标记保存到 .vif，重启后保持；远程卷总是保存
+func (s *Store) MarkVolumeReadonly(i needle.VolumeId, persist bool) error {
+	v := s.findVolume(i)
+	if v == nil {
+		return fmt.Errorf("volume %d not found", i)
+	}
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if persist || v.hasRemoteFile {
+		return v.markPersistentReadOnly(true)
+	}
+	v.noWriteOrDelete = true
+	return nil
+}
+
+func (s *Store) MarkVolumeWritable(i needle.VolumeId) error {
+	v := s.findVolume(i)
+	if v == nil {
+		return fmt.Errorf("volume %d not found", i)
+	}
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if v.hasRemoteFile || v.volumeInfo.ReadOnly {
+		return v.markPersistentReadOnly(false)
+	}
+	v.noWriteOrDelete = false
+	return nil
+}
+
+// MountVolume 挂载目录下已有的卷文件
+func (s *Store) MountVolume(i needle.VolumeId) error {
+	for _, location := range s.Locations {
+		if found := location.LoadVolume(i, s.NeedleMapKind); found {
+			glog.V(0).Infof("mount volume %d", i)
+			v := s.findVolume(i)
+			s.NewVolumesChan <- master_pb.VolumeShortInformationMessage{
+				Id:               uint32(v.Id),
+				Collection:       v.Collection,
+				ReplicaPlacement: uint32(v.ReplicaPlacement.Byte()),
+				Version:          uint32(v.Version()),
+				Ttl:              v.Ttl.ToUint32(),
+				DiskType:         string(v.location.DiskType),
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("volume %d not found on disk", i)
+}
+
+func (s *Store) UnmountVolume(i needle.VolumeId) error {
+	v := s.findVolume(i)
+	if v == nil {
+		return nil
+	}
+	message := master_pb.VolumeShortInformationMessage{
+		Id:               uint32(v.Id),
+		Collection:       v.Collection,
+		ReplicaPlacement: uint32(v.ReplicaPlacement.Byte()),
+		Version:          uint32(v.Version()),
+		Ttl:              v.Ttl.ToUint32(),
+		DiskType:         string(v.location.DiskType),
+	}
+
+	for _, location := range s.Locations {
+		err := location.UnloadVolume(i)
+		if err == nil {
+			glog.V(0).Infof("UnmountVolume %d", i)
+			s.DeletedVolumesChan <- message
+			return nil
+		} else if err == ErrVolumeNotFound {
+			continue
+		}
+	}
+
+	return fmt.Errorf("volume %d not found on disk", i)
+}
+
+func (s *Store) DeleteVolume(i needle.VolumeId) error {
+	v := s.findVolume(i)
+	if v == nil {
+		return fmt.Errorf("delete volume %d not found on disk", i)
+	}
+	message := master_pb.VolumeShortInformationMessage{
+		Id:               uint32(v.Id),
+		Collection:       v.Collection,
+		ReplicaPlacement: uint32(v.ReplicaPlacement.Byte()),
+		Version:          uint32(v.Version()),
+		Ttl:              v.Ttl.ToUint32(),
+		DiskType:         string(v.location.DiskType),
+	}
+	for _, location := range s.Locations {
+		err := location.DeleteVolume(i)
+		if err == nil {
+			glog.V(0).Infof("DeleteVolume %d", i)
+			s.DeletedVolumesChan <- message
+			return nil
+		} else if err == ErrVolumeNotFound {
+			continue
+		} else {
+			glog.Errorf("DeleteVolume %d: %v", i, err)
+		}
+	}
+
+	return fmt.Errorf("volume %d not found on disk", i)
+}
+
+func (s *Store) SetDataCenter(dataCenter string) {
+	s.dataCenter = dataCenter
+}
+
+func (s *Store) SetRack(rack string) {
+	s.rack = rack
+}
+
+func (s *Store) GetDataCenter() string {
+	return s.dataCenter
+}
+
+func (s *Store) GetRack() string {
+	return s.rack
+}
+
+func (s *Store) SetVolumeSizeLimit(x uint64) {
+	atomic.StoreUint64(&s.volumeSizeLimit, x)
+}
+
+func (s *Store) GetVolumeSizeLimit() uint64 {
+	return atomic.LoadUint64(&s.volumeSizeLimit)
+}
+
+// MaybeAdjustVolumeMax 未配置最大卷数的目录，按剩余空间能容纳多少个满卷来计算，
+// 需要先从 master 拿到卷大小上限，返回是否有目录的最大卷数发生了变化
+func (s *Store) MaybeAdjustVolumeMax() (hasChanges bool) {
+	volumeSizeLimit := s.GetVolumeSizeLimit()
+	if volumeSizeLimit == 0 {
+		return
+	}
+	var newMaxVolumeCount int32
+	for _, diskLocation := range s.Locations {
+		if diskLocation.OriginalMaxVolumeCount == 0 {
+			currentMaxVolumeCount := atomic.LoadInt32(&diskLocation.MaxVolumeCount)
+			diskStatus := stats.NewDiskStatus(diskLocation.Directory)
+			unusedSpace := diskLocation.UnUsedSpace(volumeSizeLimit)
+			unclaimedSpaces := int64(diskStatus.Free) - int64(unusedSpace)
+			volCount := diskLocation.VolumesLen()
+			ecShardCount := diskLocation.EcShardCount()
+			maxVolumeCount := int32(volCount) + int32((ecShardCount+erasure_coding.DataShardsCount)/erasure_coding.DataShardsCount)
+			if unclaimedSpaces > int64(volumeSizeLimit) {
+				maxVolumeCount += int32(uint64(unclaimedSpaces)/volumeSizeLimit) - 1
+			}
+			atomic.StoreInt32(&diskLocation.MaxVolumeCount, maxVolumeCount)
+			newMaxVolumeCount = newMaxVolumeCount + maxVolumeCount
+			glog.V(4).Infof("disk %s max %d unclaimedSpace:%dMB, unused:%dMB volumeSizeLimit:%dMB",
+				diskLocation.Directory, maxVolumeCount, unclaimedSpaces/1024/1024, unusedSpace/1024/1024, volumeSizeLimit/1024/1024)
+			hasChanges = hasChanges || currentMaxVolumeCount != atomic.LoadInt32(&diskLocation.MaxVolumeCount)
+		} else {
+			newMaxVolumeCount = newMaxVolumeCount + diskLocation.OriginalMaxVolumeCount
+		}
+	}
+	stats.VolumeServerMaxVolumeCounter.Set(float64(newMaxVolumeCount))
+	return
+}
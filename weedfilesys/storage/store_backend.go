@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/util"
+	"time"
+)
+
+// RemoteStorageNamesInUse 已挂载卷的 .vif 中引用的远程存储，这些存储不能在重新加载配置时移除
+func (s *Store) RemoteStorageNamesInUse() map[string]bool {
+	inUse := make(map[string]bool)
+	for _, location := range s.Locations {
+		location.volumesLock.RLock()
+		for _, v := range location.volumes {
+			if storageName, _ := v.RemoteStorageNameKey(); storageName != "" {
+				inUse[storageName] = true
+			}
+		}
+		location.volumesLock.RUnlock()
+	}
+	return inUse
+}
+
+// LoadStorageBackends 按配置文件中的 storage.backend 更新远程存储，返回所有配置错误
+func (s *Store) LoadStorageBackends(config *util.ViperProxy) error {
+	return backend.LoadConfiguration(config, s.RemoteStorageNamesInUse())
+}
+
+// UpdateStorageBackends 按 master 心跳响应中下发的远程存储更新
+func (s *Store) UpdateStorageBackends(storageBackends []*master_pb.StorageBackend) error {
+	return backend.LoadFromPbStorageBackends(storageBackends, s.RemoteStorageNamesInUse())
+}
+
+// WatchStorageBackendConfiguration 配置文件修改后重新加载远程存储，返回的函数用于停止
+func (s *Store) WatchStorageBackendConfiguration(configFileName string, interval time.Duration) (stop func()) {
+	return util.WatchConfiguration(configFileName, interval, func(config *util.ViperProxy) {
+		if err := s.LoadStorageBackends(config); err != nil {
+			glog.Errorf("reload storage backends from %s: %v", configFileName, err)
+		}
+	})
+}
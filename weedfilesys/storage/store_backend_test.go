@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"strings"
+	"testing"
+)
+
+func TestStoreKeepsStorageBackendsInUse(t *testing.T) {
+	s := newTestStore(t, []string{t.TempDir()}, []int32{8}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	defer backend.ApplyStorageBackends(backend.BackendSourceMaster, nil, nil)
+
+	storageBackends := []*master_pb.StorageBackend{
+		{Type: "local", Id: "cold", Properties: map[string]string{"dir": t.TempDir()}},
+		{Type: "local", Id: "spare", Properties: map[string]string{"dir": t.TempDir()}},
+	}
+	if err := s.UpdateStorageBackends(storageBackends); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	fid := writeAgedFile(t, s.GetVolume(1), 1, 0)
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveVolumeDatToRemote(1, "local.cold", false, nil); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if inUse := s.RemoteStorageNamesInUse(); len(inUse) != 1 || !inUse["local.cold"] {
+		t.Fatalf("in use %v", inUse)
+	}
+
+	// master 不再下发时，未使用的移除，仍被卷引用的保留，卷照常可读
+	err := s.UpdateStorageBackends(nil)
+	if err == nil || !strings.Contains(err.Error(), "local.cold") {
+		t.Fatalf("update error %v", err)
+	}
+	if _, found := backend.GetBackendStorage("local.spare"); found {
+		t.Fatalf("unused backend should be removed")
+	}
+	if _, found := backend.GetBackendStorage("local.cold"); !found {
+		t.Fatalf("backend in use removed")
+	}
+	if _, err = s.GetVolume(needle.VolumeId(1)).ReadFile(fid); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+}
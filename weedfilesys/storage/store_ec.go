@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/stats"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// CollectErasureCodingHeartbeat 汇总所有目录的 EC 分片信息。
+// 已过期的 EC 卷在这里销毁，全量心跳中不再包含它们，master 据此移除，不需要再发删除增量
+func (s *Store) CollectErasureCodingHeartbeat() *master_pb.Heartbeat {
+	var ecShardMessages []*master_pb.VolumeEcShardInformationMessage
+	collectionEcShardSize := make(map[string]int64)
+	for _, location := range s.Locations {
+		location.destroyExpiredEcVolumes()
+
+		location.ecVolumesLock.RLock()
+		for _, ecShards := range location.ecVolumes {
+			ecShardMessages = append(ecShardMessages, ecShards.ToVolumeEcShardInformationMessage()...)
+
+			for _, ecShard := range ecShards.Shards {
+				collectionEcShardSize[ecShards.Collection] += ecShard.Size()
+			}
+		}
+		location.ecVolumesLock.RUnlock()
+	}
+
+	for col, size := range collectionEcShardSize {
+		stats.VolumeServerDiskSizeGauge.WithLabelValues(col, "ec").Set(float64(size))
+	}
+
+	return &master_pb.Heartbeat{
+		EcShards:      ecShardMessages,
+		HasNoEcShards: len(ecShardMessages) == 0,
+	}
+}
+
+// MountEcShards 在分片文件所在的目录挂载分片，并通知 master
+func (s *Store) MountEcShards(collection string, vid needle.VolumeId, shardId erasure_coding.ShardId) error {
+	for _, location := range s.Locations {
+		if ecVolume, err := location.LoadEcShard(collection, vid, shardId); err == nil {
+			glog.V(0).Infof("MountEcShards %d.%d", vid, shardId)
+
+			var shardBits erasure_coding.ShardBits
+
+			// 心跳协程没有及时取走时丢弃增量，分片的最新状态随下一次完整心跳上报
+			select {
+			case s.NewEcShardsChan <- master_pb.VolumeEcShardInformationMessage{
+				Id:          uint32(vid),
+				Collection:  collection,
+				EcIndexBits: uint32(shardBits.AddShardId(shardId)),
+				DiskType:    string(location.DiskType),
+				ExpireAtSec: ecVolume.ExpireAtSec,
+			}:
+			default:
+			}
+			return nil
+		} else if err == os.ErrNotExist {
+			continue
+		} else {
+			return fmt.Errorf("%s load ec shard %d.%d: %v", location.Directory, vid, shardId, err)
+		}
+	}
+
+	return fmt.Errorf("MountEcShards %d.%d not found on disk", vid, shardId)
+}
+
+func (s *Store) UnmountEcShards(vid needle.VolumeId, shardId erasure_coding.ShardId) error {
+	ecShard, found := s.findEcShard(vid, shardId)
+	if !found {
+		return nil
+	}
+
+	var shardBits erasure_coding.ShardBits
+	message := master_pb.VolumeEcShardInformationMessage{
+		Id:          uint32(vid),
+		Collection:  ecShard.Collection,
+		EcIndexBits: uint32(shardBits.AddShardId(shardId)),
+		DiskType:    string(ecShard.DiskType),
+	}
+
+	for _, location := range s.Locations {
+		if deleted := location.UnloadEcShard(vid, shardId); deleted {
+			glog.V(0).Infof("UnmountEcShards %d.%d", vid, shardId)
+			select {
+			case s.DeletedEcShardsChan <- message:
+			default:
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("UnmountEcShards %d.%d not found on disk", vid, shardId)
+}
+
+// ReadEcShardNeedle 读取 EC 卷中的 needle，n.Cookie 非 0 时校验 cookie。
+// 本地缺少的分片从其他卷服务器读取，读不到时用其余分片恢复
+func (s *Store) ReadEcShardNeedle(vid needle.VolumeId, n *needle.Needle) (int, error) {
+	ecVolume, found := s.FindEcVolume(vid)
+	if !found {
+		return 0, fmt.Errorf("ec volume %d not found", vid)
+	}
+	offset, size, intervals, err := ecVolume.LocateEcShardNeedle(n.Id, ecVolume.Version)
+	switch {
+	case errors.Is(err, erasure_coding.NotFoundError):
+		return 0, ErrorNotFound
+	case err != nil:
+		return 0, err
+	case size.IsDeleted():
+		return 0, ErrorDeleted
+	}
+	data, err := s.readEcShardIntervals(ecVolume, n.Id, intervals)
+	if err != nil {
+		return 0, err
+	}
+	cookie := n.Cookie
+	if err = n.ReadBytes(data, offset.ToActualOffset(), size, ecVolume.Version); err != nil {
+		return 0, fmt.Errorf("parse ec volume %d needle %s: %v", vid, n.Id, err)
+	}
+	if err = checkReadNeedle(n, cookie, nil, time.Now()); err != nil {
+		return 0, err
+	}
+	return int(n.DataSize), nil
+}
+
+func (s *Store) findEcShard(vid needle.VolumeId, shardId erasure_coding.ShardId) (*erasure_coding.EcVolumeShard, bool) {
+	for _, location := range s.Locations {
+		if v, found := location.FindEcShard(vid, shardId); found {
+			return v, found
+		}
+	}
+	return nil, false
+}
+
+func (s *Store) FindEcVolume(vid needle.VolumeId) (*erasure_coding.EcVolume, bool) {
+	for _, location := range s.Locations {
+		if s, found := location.FindEcVolume(vid); found {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+// GenerateEcShards 在卷所在的目录按 scheme 生成全部分片和 .ecx，卷必须已经只读
+func (s *Store) GenerateEcShards(vid needle.VolumeId, collection string, scheme erasure_coding.EcScheme) error {
+	if err := scheme.Validate(); err != nil {
+		return err
+	}
+	v := s.findVolume(vid)
+	if v == nil {
+		return fmt.Errorf("volume %d not found", vid)
+	}
+	if v.Collection != collection {
+		return fmt.Errorf("volume %d collection %q, expected %q", vid, v.Collection, collection)
+	}
+	if _, found := s.FindEcVolume(vid); found {
+		return fmt.Errorf("ec volume %d is already mounted", vid)
+	}
+	datFileSize, err := v.generateEcShards(scheme)
+	if err != nil {
+		return err
+	}
+	if err = v.saveEcVolumeInfo(datFileSize, scheme); err != nil {
+		return err
+	}
+	glog.V(0).Infof("generated %s ec shards for volume %d, dat size %d", scheme, vid, datFileSize)
+	return nil
+}
+
+// RebuildEcShards 用本机目录中的分片重建缺少的分片，包括复制过来还没有挂载的分片，EC 卷必须已经挂载。
+// 重建出的分片不挂载，由调用方挂载或者复制到其他服务器
+func (s *Store) RebuildEcShards(vid needle.VolumeId, collection string) ([]uint32, error) {
+	ecVolume, found := s.FindEcVolume(vid)
+	if !found {
+		return nil, fmt.Errorf("ec volume %d not found", vid)
+	}
+	if ecVolume.Collection != collection {
+		return nil, fmt.Errorf("ec volume %d collection %q, expected %q", vid, ecVolume.Collection, collection)
+	}
+	rebuilt, err := erasure_coding.RebuildEcFiles(ecVolume.DataBaseFileName(), ecVolume.Scheme)
+	if err != nil {
+		return nil, fmt.Errorf("rebuild ec volume %d: %w", vid, err)
+	}
+	glog.V(0).Infof("rebuilt %s ec volume %d shards %v", ecVolume.Scheme, vid, rebuilt)
+	return rebuilt, nil
+}
+
+// EcShardsToVolume 把本机的 EC 卷还原成普通卷并挂载，本机的分片和 .ecx 等随之删除。
+// 本机需要有全部数据分片，或者至少 DataShards 个分片用于重建；其他服务器上的分片由调用方删除
+func (s *Store) EcShardsToVolume(vid needle.VolumeId, collection string) error {
+	if s.HasVolume(vid) {
+		return fmt.Errorf("volume %d already exists", vid)
+	}
+	var location *DiskLocation
+	var ecVolume *erasure_coding.EcVolume
+	for _, l := range s.Locations {
+		if v, found := l.FindEcVolume(vid); found {
+			location, ecVolume = l, v
+			break
+		}
+	}
+	if ecVolume == nil {
+		return fmt.Errorf("ec volume %d not found", vid)
+	}
+	if ecVolume.Collection != collection {
+		return fmt.Errorf("ec volume %d collection %q, expected %q", vid, ecVolume.Collection, collection)
+	}
+
+	// 卸载后 .ecx 和 .ecj 不再变化
+	var mounted []erasure_coding.ShardId
+	for _, shard := range ecVolume.Shards {
+		mounted = append(mounted, shard.ShardId)
+	}
+	for _, shardId := range mounted {
+		if err := s.UnmountEcShards(vid, shardId); err != nil {
+			return err
+		}
+	}
+	if _, err := DecodeEcVolume(location.Directory, location.IdxDirectory, collection, vid); err != nil {
+		for _, shardId := range mounted {
+			if mountErr := s.MountEcShards(collection, vid, shardId); mountErr != nil {
+				glog.Errorf("remount ec shard %d.%d: %v", vid, shardId, mountErr)
+			}
+		}
+		return err
+	}
+	return s.MountVolume(vid)
+}
+
+// DeleteEcShards 卸载并删除分片文件，目录下不再有该卷的分片时一并删除 .ecx 和 .ecj
+func (s *Store) DeleteEcShards(vid needle.VolumeId, collection string, shardIds []erasure_coding.ShardId) error {
+	for _, shardId := range shardIds {
+		if err := s.UnmountEcShards(vid, shardId); err != nil {
+			return err
+		}
+	}
+	for _, location := range s.Locations {
+		if err := location.deleteEcShardFiles(collection, vid, shardIds); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// EcShardLocation 复制过来的分片放在已有该卷分片的目录，没有时放在空闲名额最多的目录
+func (s *Store) EcShardLocation(collection string, vid needle.VolumeId, diskType types.DiskType) *DiskLocation {
+	for _, location := range s.Locations {
+		if _, found := location.FindEcVolume(vid); found {
+			return location
+		}
+		if util.FileExists(erasure_coding.EcShardFileName(collection, location.IdxDirectory, int(vid)) + ".ecx") {
+			return location
+		}
+	}
+	return s.FindFreeLocation(diskType)
+}
+
+// EcFileName 查找本机 EC 卷的文件：.ecx 和 .ecj 在索引目录，分片和 .vif 在数据目录
+func (s *Store) EcFileName(collection string, vid needle.VolumeId, ext string) (string, bool) {
+	for _, location := range s.Locations {
+		dir := location.Directory
+		if ext == ".ecx" || ext == ".ecj" {
+			dir = location.IdxDirectory
+		}
+		fileName := erasure_coding.EcShardFileName(collection, dir, int(vid)) + ext
+		if util.FileExists(fileName) {
+			return fileName, true
+		}
+	}
+	return "", false
+}
+
+func (s *Store) DestroyEcVolume(vid needle.VolumeId) {
+	for _, location := range s.Locations {
+		location.DestroyEcVolume(vid)
+	}
+}
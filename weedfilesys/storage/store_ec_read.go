@@ -0,0 +1,271 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/klauspost/reedsolomon"
+)
+
+// EC 卷的读取：needle 落在的区间都在本地时一次批量读取；否则逐个区间读取，
+// 先读本地分片，再按 master 返回的位置读远程分片（VolumeEcShardRead），
+// 都读不到时从其他任意 DataShards 个分片读出同一段，用 Reed-Solomon 只恢复这一段。
+// 分片位置缓存在 EcVolume.ShardLocations 中，按分片的齐全程度定期刷新，查询失败后同样等到过期再查，读取失败的位置随即移除
+
+// 分片位置缓存的有效期：分片不够恢复时很快重新查询，齐全时很少查询
+const (
+	ecShardLocationsRefreshNotEnough = 11 * time.Second
+	ecShardLocationsRefreshEnough    = 7 * time.Minute
+	ecShardLocationsRefreshComplete  = 37 * time.Minute
+)
+
+func (s *Store) readEcShardIntervals(ecVolume *erasure_coding.EcVolume, needleId types.NeedleId, intervals []erasure_coding.Interval) ([]byte, error) {
+	if data, err := ecVolume.ReadEcShardIntervals(backend.DefaultBatchReader(), intervals); err == nil {
+		return data, nil
+	} else {
+		glog.V(3).Infof("read ec volume %d needle %s locally: %v", ecVolume.VolumeId, needleId, err)
+	}
+
+	if err := s.cachedLookupEcShardLocations(ecVolume); err != nil {
+		glog.V(1).Infof("lookup ec volume %d shard locations: %v", ecVolume.VolumeId, err)
+	}
+	var data []byte
+	for _, interval := range intervals {
+		buf, err := s.readOneEcShardInterval(ecVolume, needleId, interval)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, buf...)
+	}
+	return data, nil
+}
+
+func (s *Store) readOneEcShardInterval(ecVolume *erasure_coding.EcVolume, needleId types.NeedleId, interval erasure_coding.Interval) ([]byte, error) {
+	shardId, offset := interval.ToShardIdAndOffset(erasure_coding.ErasureCodingLargeBlockSize, erasure_coding.ErasureCodingSmallBlockSize)
+	buf := make([]byte, interval.Size)
+	if shard, found := ecVolume.FindEcVolumeShard(shardId); found {
+		n, err := shard.ReadAt(buf, offset)
+		if err == nil && n == len(buf) {
+			return buf, nil
+		}
+		glog.Warningf("read ec shard %d.%d at %d: %v", ecVolume.VolumeId, shardId, offset, err)
+	}
+
+	isDeleted, err := s.readRemoteEcShard(ecVolume, needleId, shardId, buf, offset)
+	if err == nil {
+		if isDeleted {
+			return nil, ErrorDeleted
+		}
+		return buf, nil
+	}
+	glog.V(1).Infof("read ec shard %d.%d remotely: %v, try to recover", ecVolume.VolumeId, shardId, err)
+
+	if err = s.recoverOneEcShardInterval(ecVolume, needleId, shardId, buf, offset); err != nil {
+		return nil, fmt.Errorf("recover ec shard %d.%d at %d: %v", ecVolume.VolumeId, shardId, offset, err)
+	}
+	return buf, nil
+}
+
+// 依次尝试缓存中该分片的各个位置，读取失败的位置从缓存中移除
+func (s *Store) readRemoteEcShard(ecVolume *erasure_coding.EcVolume, needleId types.NeedleId, shardId erasure_coding.ShardId, buf []byte, offset int64) (isDeleted bool, err error) {
+	ecVolume.ShardLocationsLock.RLock()
+	sourceDataNodes := slices.Clone(ecVolume.ShardLocations[shardId])
+	ecVolume.ShardLocationsLock.RUnlock()
+
+	err = fmt.Errorf("no location for ec shard %d.%d", ecVolume.VolumeId, shardId)
+	self := s.serverAddress()
+	for _, sourceDataNode := range sourceDataNodes {
+		if sourceDataNode == self {
+			continue
+		}
+		if isDeleted, err = s.readRemoteEcShardInterval(sourceDataNode, needleId, ecVolume.VolumeId, shardId, buf, offset); err == nil {
+			return isDeleted, nil
+		}
+		glog.V(1).Infof("read ec shard %d.%d from %s: %v", ecVolume.VolumeId, shardId, sourceDataNode, err)
+		forgetEcShardLocation(ecVolume, shardId, sourceDataNode)
+	}
+	return false, err
+}
+
+func (s *Store) readRemoteEcShardInterval(sourceDataNode pb.ServerAddress, needleId types.NeedleId, vid needle.VolumeId, shardId erasure_coding.ShardId, buf []byte, offset int64) (isDeleted bool, err error) {
+	err = pb.WithVolumeServerClient(false, sourceDataNode, s.grpcDialOption, func(client volume_server_pb.VolumeServerClient) error {
+		stream, err := client.VolumeEcShardRead(context.Background(), &volume_server_pb.VolumeEcShardReadRequest{
+			VolumeId: uint32(vid),
+			ShardId:  uint32(shardId),
+			Offset:   offset,
+			Size:     int64(len(buf)),
+			FileKey:  uint64(needleId),
+		})
+		if err != nil {
+			return err
+		}
+		var n int
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if resp.IsDeleted {
+				isDeleted = true
+			}
+			n += copy(buf[n:], resp.Data)
+		}
+		if !isDeleted && n != len(buf) {
+			return fmt.Errorf("read %d bytes, expected %d", n, len(buf))
+		}
+		return nil
+	})
+	return
+}
+
+type ecShardRead struct {
+	shardId erasure_coding.ShardId
+	data    []byte // 读取失败时为空
+}
+
+// 从其他分片并发读取同一段，本地分片优先；同时只发出还差的数量的读取，失败时换下一个分片，
+// 凑够 DataShards 个后只恢复需要的分片
+func (s *Store) recoverOneEcShardInterval(ecVolume *erasure_coding.EcVolume, needleId types.NeedleId, shardIdToRecover erasure_coding.ShardId, buf []byte, offset int64) error {
+	scheme := ecVolume.Scheme
+	enc, err := reedsolomon.New(scheme.DataShards, scheme.ParityShards)
+	if err != nil {
+		return err
+	}
+
+	var candidates, remoteCandidates []erasure_coding.ShardId
+	for _, shardId := range scheme.ShardIds() {
+		if shardId == shardIdToRecover {
+			continue
+		}
+		if _, found := ecVolume.FindEcVolumeShard(shardId); found {
+			candidates = append(candidates, shardId)
+		} else {
+			remoteCandidates = append(remoteCandidates, shardId)
+		}
+	}
+	candidates = append(candidates, remoteCandidates...)
+
+	reads := make(chan ecShardRead, len(candidates))
+	readShard := func(shardId erasure_coding.ShardId) {
+		data := make([]byte, len(buf))
+		if shard, found := ecVolume.FindEcVolumeShard(shardId); found {
+			if n, err := shard.ReadAt(data, offset); err != nil || n != len(data) {
+				data = nil
+			}
+		} else if isDeleted, err := s.readRemoteEcShard(ecVolume, needleId, shardId, data, offset); err != nil || isDeleted {
+			data = nil
+		}
+		reads <- ecShardRead{shardId: shardId, data: data}
+	}
+
+	bufs := make([][]byte, scheme.TotalShards())
+	var available, inflight int
+	for next := 0; available < scheme.DataShards; {
+		for ; inflight < scheme.DataShards-available && next < len(candidates); next++ {
+			go readShard(candidates[next])
+			inflight++
+		}
+		if inflight == 0 {
+			break
+		}
+		read := <-reads
+		inflight--
+		if read.data != nil {
+			bufs[read.shardId] = read.data
+			available++
+		}
+	}
+	if available < scheme.DataShards {
+		return fmt.Errorf("only %d shards available, %s needs %d", available, scheme, scheme.DataShards)
+	}
+	required := make([]bool, len(bufs))
+	required[shardIdToRecover] = true
+	if err = enc.ReconstructSome(bufs, required); err != nil {
+		return err
+	}
+	copy(buf, bufs[shardIdToRecover])
+	return nil
+}
+
+// cachedLookupEcShardLocations 向 master 查询全部分片的位置，缓存未过期时直接返回
+func (s *Store) cachedLookupEcShardLocations(ecVolume *erasure_coding.EcVolume) error {
+	ecVolume.ShardLocationsLock.RLock()
+	shardCount, refreshTime := len(ecVolume.ShardLocations), ecVolume.ShardLocationsRefreshTime
+	ecVolume.ShardLocationsLock.RUnlock()
+
+	scheme := ecVolume.Scheme
+	ttl := ecShardLocationsRefreshNotEnough
+	switch {
+	case shardCount == scheme.TotalShards():
+		ttl = ecShardLocationsRefreshComplete
+	case shardCount >= scheme.DataShards:
+		ttl = ecShardLocationsRefreshEnough
+	}
+	if refreshTime.Add(ttl).After(time.Now()) {
+		return nil
+	}
+	if s.MasterAddress == "" {
+		return fmt.Errorf("master address is unknown")
+	}
+
+	glog.V(3).Infof("lookup and cache ec volume %d locations", ecVolume.VolumeId)
+	err := pb.WithMasterClient(false, pb.ServerAddress(s.MasterAddress), s.grpcDialOption, false, func(client master_pb.WeedfilesysClient) error {
+		resp, err := client.LookupEcVolume(context.Background(), &master_pb.LookupEcVolumeRequest{VolumeId: uint32(ecVolume.VolumeId)})
+		if err != nil {
+			return fmt.Errorf("lookup ec volume %d: %v", ecVolume.VolumeId, err)
+		}
+		shardLocations := make(map[erasure_coding.ShardId][]pb.ServerAddress)
+		for _, shardIdLocations := range resp.ShardIdLocations {
+			shardId := erasure_coding.ShardId(shardIdLocations.ShardId)
+			for _, location := range shardIdLocations.Locations {
+				shardLocations[shardId] = append(shardLocations[shardId], pb.NewServerAddressFromLocation(location))
+			}
+		}
+		ecVolume.ShardLocationsLock.Lock()
+		ecVolume.ShardLocations = shardLocations
+		ecVolume.ShardLocationsRefreshTime = time.Now()
+		ecVolume.ShardLocationsLock.Unlock()
+		return nil
+	})
+	if err != nil {
+		// 查询失败也按有效期退避，master 不可用时不在每次读取时重新查询，沿用旧的位置
+		ecVolume.ShardLocationsLock.Lock()
+		ecVolume.ShardLocationsRefreshTime = time.Now()
+		ecVolume.ShardLocationsLock.Unlock()
+	}
+	return err
+}
+
+func forgetEcShardLocation(ecVolume *erasure_coding.EcVolume, shardId erasure_coding.ShardId, sourceDataNode pb.ServerAddress) {
+	ecVolume.ShardLocationsLock.Lock()
+	defer ecVolume.ShardLocationsLock.Unlock()
+	locations := slices.DeleteFunc(ecVolume.ShardLocations[shardId], func(location pb.ServerAddress) bool {
+		return location == sourceDataNode
+	})
+	if len(locations) == 0 {
+		delete(ecVolume.ShardLocations, shardId)
+	} else {
+		ecVolume.ShardLocations[shardId] = locations
+	}
+}
+
+// 本服务器在 master 中的地址，读取远程分片时跳过自己
+func (s *Store) serverAddress() pb.ServerAddress {
+	return pb.NewServerAddressWithGrpcPort(net.JoinHostPort(s.Ip, strconv.Itoa(s.Port)), s.GrpcPort)
+}
@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/storage/volume_info"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreReadEcShardNeedle(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+
+	// 第二个文件超过 1MB 的小块，会跨多个分片
+	contents := [][]byte{[]byte("small"), bytes.Repeat([]byte("0123456789"), 150*1024), []byte("tail")}
+	for i, data := range contents {
+		n := newTestNeedle(string(data))
+		n.Id, n.Cookie = types.NeedleId(i+1), 9
+		if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.DeleteVolumeNeedle(1, &needle.Needle{Id: 3, Cookie: 9}); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	baseFileName := filepath.Join(dir, "1")
+	if err := erasure_coding.WriteEcFiles(baseFileName, erasure_coding.DefaultEcScheme); err != nil {
+		t.Fatalf("write ec files: %v", err)
+	}
+	if err := erasure_coding.WriteSortedFileFromIdx(baseFileName, ".ecx"); err != nil {
+		t.Fatalf("write ecx: %v", err)
+	}
+	os.Remove(baseFileName + ".dat")
+	os.Remove(baseFileName + ".idx")
+
+	s = newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if _, found := s.FindEcVolume(1); !found {
+		t.Fatalf("ec volume not loaded")
+	}
+	for i, data := range contents[:2] {
+		n := &needle.Needle{Id: types.NeedleId(i + 1), Cookie: 9}
+		if _, err := s.ReadEcShardNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+			t.Fatalf("read ec needle %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.ReadEcShardNeedle(1, &needle.Needle{Id: 1, Cookie: 8}); !errors.Is(err, ErrorCookieMismatch) {
+		t.Fatalf("read with wrong cookie: %v", err)
+	}
+	if _, err := s.ReadEcShardNeedle(1, &needle.Needle{Id: 3, Cookie: 9}); !errors.Is(err, ErrorDeleted) && !errors.Is(err, ErrorNotFound) {
+		t.Fatalf("read deleted: %v", err)
+	}
+	if _, err := s.ReadEcShardNeedle(1, &needle.Needle{Id: 4, Cookie: 9}); !errors.Is(err, ErrorNotFound) {
+		t.Fatalf("read missing: %v", err)
+	}
+}
+
+// 从本机全部分片读取时，EC 卷在删除原卷前后都能读出原来的内容
+func TestStoreGenerateAndDeleteEcShards(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if err := s.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	data := bytes.Repeat([]byte("ec"), 1000)
+	n := newTestNeedle(string(data))
+	n.Id, n.Cookie = 1, 7
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.GenerateEcShards(1, "col", erasure_coding.DefaultEcScheme); err == nil {
+		t.Fatalf("expect writable volume rejected")
+	}
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GenerateEcShards(1, "other", erasure_coding.DefaultEcScheme); err == nil {
+		t.Fatalf("expect collection mismatch rejected")
+	}
+	if err := s.GenerateEcShards(1, "col", erasure_coding.DefaultEcScheme); err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	for shardId := erasure_coding.ShardId(0); shardId < erasure_coding.TotalShardsCount; shardId++ {
+		if err := s.MountEcShards("col", 1, shardId); err != nil {
+			t.Fatalf("mount %d: %v", shardId, err)
+		}
+	}
+
+	// 删除原卷时 .vif 留给 EC 卷
+	if err := s.DeleteVolume(1); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	baseFileName := filepath.Join(dir, "col_1")
+	if util.FileExists(baseFileName+".dat") || !util.FileExists(baseFileName+".vif") {
+		t.Fatalf("expect .dat removed and .vif kept")
+	}
+	read := &needle.Needle{Id: 1, Cookie: 7}
+	if _, err := s.ReadEcShardNeedle(1, read); err != nil || !bytes.Equal(read.Data, data) {
+		t.Fatalf("read ec needle: %v", err)
+	}
+
+	// 删除部分分片时保留 .ecx，全部删除后 .ecx、.ecj、.vif 都删除
+	if err := s.DeleteEcShards(1, "col", []erasure_coding.ShardId{0, 1, 2, 3, 4, 5, 6}); err != nil {
+		t.Fatal(err)
+	}
+	if !util.FileExists(baseFileName+".ecx") || util.FileExists(baseFileName+".ec00") {
+		t.Fatalf("expect .ecx kept and .ec00 removed")
+	}
+	if err := s.DeleteEcShards(1, "col", []erasure_coding.ShardId{7, 8, 9, 10, 11, 12, 13}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatalf("files left: %v", entries)
+	}
+	if _, found := s.FindEcVolume(1); found {
+		t.Fatalf("ec volume should be unloaded")
+	}
+}
+
+// 4+2 方案只生成 6 个分片，方案写入 .vif 并随心跳上报，方案之外的分片不能挂载
+func TestStoreEcShardsWithScheme(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	// 第二个文件跨过 1MB 的小块
+	contents := [][]byte{[]byte("small"), bytes.Repeat([]byte("0123456789"), 150*1024)}
+	for i, data := range contents {
+		n := newTestNeedle(string(data))
+		n.Id, n.Cookie = types.NeedleId(i+1), 9
+		if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	if err := s.GenerateEcShards(1, "", erasure_coding.EcScheme{DataShards: 40, ParityShards: 2}); err == nil {
+		t.Fatalf("expect invalid scheme rejected")
+	}
+	if err := s.GenerateEcShards(1, "", scheme); err != nil {
+		t.Fatal(err)
+	}
+	baseFileName := filepath.Join(dir, "1")
+	if !util.FileExists(baseFileName+".ec05") || util.FileExists(baseFileName+".ec06") {
+		t.Fatalf("expect 6 shards")
+	}
+	volumeInfo, _, _, err := volume_info.MaybeLoadVolumeInfo(baseFileName + ".vif")
+	if err != nil || volumeInfo.EcShardConfig.GetDataShards() != 4 || volumeInfo.EcShardConfig.GetParityShards() != 2 {
+		t.Fatalf("vif %v: %v", volumeInfo, err)
+	}
+	for _, shardId := range scheme.ShardIds() {
+		if err = s.MountEcShards("", 1, shardId); err != nil {
+			t.Fatalf("mount %d: %v", shardId, err)
+		}
+	}
+	heartbeat := s.CollectErasureCodingHeartbeat()
+	if len(heartbeat.EcShards) != 1 || heartbeat.EcShards[0].DataShards != 4 || heartbeat.EcShards[0].ParityShards != 2 {
+		t.Fatalf("heartbeat %v", heartbeat.EcShards)
+	}
+
+	if err = s.DeleteVolume(1); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	for i, data := range contents {
+		n := &needle.Needle{Id: types.NeedleId(i + 1), Cookie: 9}
+		if _, err = s.ReadEcShardNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+			t.Fatalf("read %d: %v", i+1, err)
+		}
+	}
+
+	if err = os.WriteFile(baseFileName+".ec06", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.MountEcShards("", 1, 6); err == nil {
+		t.Fatalf("expect shard out of scheme rejected")
+	}
+}
+
+// 丢失一个数据分片、.ecj 记有删除时还原成普通卷，还原后可写并且 EC 文件全部删除
+func TestStoreEcShardsToVolume(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if err := s.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	contents := [][]byte{[]byte("small"), bytes.Repeat([]byte("0123456789"), 150*1024), []byte("deleted")}
+	for i, data := range contents {
+		n := newTestNeedle(string(data))
+		n.Id, n.Cookie = types.NeedleId(i+1), 9
+		if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	if err := s.GenerateEcShards(1, "col", scheme); err != nil {
+		t.Fatal(err)
+	}
+	for _, shardId := range scheme.ShardIds() {
+		if err := s.MountEcShards("col", 1, shardId); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.DeleteVolume(1); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	if err := s.EcShardsToVolume(1, "other"); err == nil {
+		t.Fatalf("expect collection mismatch rejected")
+	}
+
+	ecVolume, _ := s.FindEcVolume(1)
+	if err := ecVolume.DeleteNeedleFromEcx(3); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteEcShards(1, "col", []erasure_coding.ShardId{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EcShardsToVolume(1, "col"); err != nil {
+		t.Fatalf("to volume: %v", err)
+	}
+	drainStoreChans(s)
+
+	if _, found := s.FindEcVolume(1); found {
+		t.Fatalf("ec volume should be unloaded")
+	}
+	v := s.GetVolume(1)
+	if v == nil || v.IsReadOnly() {
+		t.Fatalf("expect writable volume mounted")
+	}
+	for i, data := range contents[:2] {
+		n := &needle.Needle{Id: types.NeedleId(i + 1), Cookie: 9}
+		if _, err := s.ReadVolumeNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+			t.Fatalf("read %d: %v", i+1, err)
+		}
+	}
+	if _, err := s.ReadVolumeNeedle(1, &needle.Needle{Id: 3, Cookie: 9}); err == nil {
+		t.Fatalf("expect deleted needle not found")
+	}
+	n := newTestNeedle("thawed")
+	n.Id, n.Cookie = 4, 9
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatalf("write after decode: %v", err)
+	}
+	baseFileName := filepath.Join(dir, "col_1")
+	for _, ext := range []string{".ec00", ".ec01", ".ec05", ".ecx", ".ecj", ".vif"} {
+		if util.FileExists(baseFileName + ext) {
+			t.Fatalf("%s should be removed", ext)
+		}
+	}
+}
+
+// 分片不够重建时还原失败，EC 文件保持原样
+func TestDecodeEcVolumeWithTooFewShards(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	n := newTestNeedle("data")
+	n.Id, n.Cookie = 1, 9
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	if err := s.GenerateEcShards(1, "", scheme); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+	baseFileName := filepath.Join(dir, "1")
+	os.Remove(baseFileName + ".dat")
+	os.Remove(baseFileName + ".idx")
+	os.Remove(baseFileName + ".ec00")
+	os.Remove(baseFileName + ".ec04")
+	os.Remove(baseFileName + ".ec05")
+
+	if _, err := DecodeEcVolume(dir, dir, "", 1); err == nil {
+		t.Fatalf("expect decode failure with 3 shards")
+	}
+	if util.FileExists(baseFileName+".dat") || util.FileExists(baseFileName+".idx") || !util.FileExists(baseFileName+".ecx") || !util.FileExists(baseFileName+".ec01") {
+		t.Fatalf("ec files should be kept intact")
+	}
+}
+
+// 还原失败时卸载又重新挂载多个分片，没有心跳协程消费增量也不会阻塞
+func TestStoreEcShardsToVolumeRemountOnFailure(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{2}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	n := newTestNeedle("data")
+	n.Id, n.Cookie = 1, 9
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	scheme := erasure_coding.DefaultEcScheme
+	if err := s.GenerateEcShards(1, "", scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteVolume(1); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	// 只留下 DataShards-1 个分片
+	mounted := scheme.DataShards - 1
+	for _, shardId := range scheme.ShardIds() {
+		if int(shardId) < mounted {
+			if err := s.MountEcShards("", 1, shardId); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.Remove(filepath.Join(dir, "1"+erasure_coding.ToExt(int(shardId)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.EcShardsToVolume(1, "") }()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatalf("expect decode with %d shards to fail", mounted)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("ec shards to volume blocked")
+	}
+	if ecVolume, found := s.FindEcVolume(1); !found || len(ecVolume.Shards) != mounted {
+		t.Fatalf("expect %d shards remounted", mounted)
+	}
+	read := &needle.Needle{Id: 1, Cookie: 9}
+	if _, err := s.ReadEcShardNeedle(1, read); err != nil || string(read.Data) != "data" {
+		t.Fatalf("read after failed decode: %q %v", read.Data, err)
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"testing"
+)
+
+func newTestStore(t *testing.T, dirs []string, maxVolumeCounts []int32, diskTypes []types.DiskType) *Store {
+	minFreeSpaces := make([]util.MinFreeSpace, len(dirs))
+	for i := range minFreeSpaces {
+		minFreeSpaces[i] = util.MinFreeSpace{Type: util.AsPercent, Percent: 0}
+	}
+	return NewStore(nil, 8080, 18080, "127.0.0.1", "127.0.0.1:8080", dirs, maxVolumeCounts, minFreeSpaces, "", NeedleMapInMemory, diskTypes)
+}
+
+// 消费心跳增量，避免通道写满后阻塞
+func drainStoreChans(s *Store) {
+	for {
+		select {
+		case <-s.NewVolumesChan:
+		case <-s.DeletedVolumesChan:
+		default:
+			return
+		}
+	}
+}
+
+func TestStoreAddVolumeAcrossLocations(t *testing.T) {
+	hdd, ssd := t.TempDir(), t.TempDir()
+	dirs := []string{hdd, ssd}
+	diskTypes := []types.DiskType{types.HardDriveType, types.SsdType}
+	s := newTestStore(t, dirs, []int32{2, 1}, diskTypes)
+
+	for vid := needle.VolumeId(1); vid <= 2; vid++ {
+		if err := s.AddVolume(vid, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+			t.Fatalf("add hdd volume %d: %v", vid, err)
+		}
+		drainStoreChans(s)
+	}
+	// hdd 目录已满
+	if err := s.AddVolume(3, "", "000", "", 0, 0, types.HardDriveType); err == nil {
+		t.Fatalf("expect hdd location full")
+	}
+	if err := s.AddVolume(3, "pics", "000", "", 0, 0, types.SsdType); err != nil {
+		t.Fatalf("add ssd volume: %v", err)
+	}
+	drainStoreChans(s)
+
+	n := newTestNeedle("hello")
+	n.Id, n.Cookie = 1, 1
+	if _, err := s.WriteVolumeNeedle(3, n); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	s.Close()
+
+	// 重新加载
+	s = newTestStore(t, dirs, []int32{2, 1}, diskTypes)
+	defer s.Close()
+	hb := s.CollectHeartbeat()
+	if len(hb.Volumes) != 3 {
+		t.Fatalf("expect 3 volumes, got %d", len(hb.Volumes))
+	}
+	if hb.MaxVolumeCounts[string(types.HardDriveType)] != 2 || hb.MaxVolumeCounts[string(types.SsdType)] != 1 {
+		t.Fatalf("unexpected max volume counts %v", hb.MaxVolumeCounts)
+	}
+	for _, v := range hb.Volumes {
+		if v.Id == 3 && (v.Collection != "pics" || v.DiskType != string(types.SsdType) || v.FileCount != 1) {
+			t.Fatalf("unexpected volume message %v", v)
+		}
+	}
+	read := &needle.Needle{Id: 1, Cookie: 1}
+	if _, err := s.ReadVolumeNeedle(3, read); err != nil || string(read.Data) != "hello" {
+		t.Fatalf("read after reload: %v %q", err, read.Data)
+	}
+	if ecHb := s.CollectErasureCodingHeartbeat(); !ecHb.HasNoEcShards {
+		t.Fatalf("expect no ec shards")
+	}
+}
+
+func TestStoreSkipsLowDiskSpaceLocation(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(nil, 8080, 18080, "127.0.0.1", "", []string{dir}, []int32{5},
+		[]util.MinFreeSpace{{Type: util.AsPercent, Percent: 100.1}}, "", NeedleMapInMemory, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	s.Locations[0].checkDiskSpace()
+
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err == nil {
+		t.Fatalf("expect no free location when disk space is low")
+	}
+}
+
+// 磁盘空间不足时已有的卷只允许读和删除，空间恢复后重新可写
+func TestStoreStopsWritesWhenDiskSpaceLow(t *testing.T) {
+	s := newTestStore(t, []string{t.TempDir()}, []int32{5}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	if err := s.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddVolume(2, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	// 因其他原因只允许删除的卷，空间恢复后仍然只读
+	v2 := s.findVolume(2)
+	v2.noWriteCanDelete = true
+	for id := types.NeedleId(1); id <= 2; id++ {
+		n := newTestNeedle("data")
+		n.Id, n.Cookie = id, 1
+		if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	location := s.Locations[0]
+	location.MinFreeSpace = util.MinFreeSpace{Type: util.AsPercent, Percent: 100.1}
+	location.checkDiskSpace()
+	n := newTestNeedle("more")
+	n.Id, n.Cookie = 3, 1
+	if _, err := s.WriteVolumeNeedle(1, n); err == nil {
+		t.Fatalf("expect write rejected when disk space is low")
+	}
+	if _, err := s.DeleteVolumeNeedle(1, &needle.Needle{Id: 2, Cookie: 1}); err != nil {
+		t.Fatalf("delete when disk space is low: %v", err)
+	}
+	if hb := s.CollectHeartbeat(); len(hb.Volumes) != 2 || !hb.Volumes[0].ReadOnly || !hb.Volumes[1].ReadOnly {
+		t.Fatalf("expect read only volumes in heartbeat: %v", hb.Volumes)
+	}
+
+	location.MinFreeSpace = util.MinFreeSpace{Type: util.AsPercent}
+	location.checkDiskSpace()
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatalf("write after disk space recovered: %v", err)
+	}
+	if !v2.IsReadOnly() {
+		t.Fatalf("volume 2 should stay read only after disk space recovered")
+	}
+}
+
+// 持久的只读标记重启后保持，非持久的重启后恢复可写
+func TestStoreMarkVolumeReadonlyPersist(t *testing.T) {
+	dirs := []string{t.TempDir()}
+	diskTypes := []types.DiskType{types.HardDriveType}
+	s := newTestStore(t, dirs, []int32{2}, diskTypes)
+	for vid := needle.VolumeId(1); vid <= 2; vid++ {
+		if err := s.AddVolume(vid, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+			t.Fatal(err)
+		}
+		drainStoreChans(s)
+	}
+	if err := s.MarkVolumeReadonly(1, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MarkVolumeReadonly(2, false); err != nil {
+		t.Fatal(err)
+	}
+	s.Close()
+
+	s = newTestStore(t, dirs, []int32{2}, diskTypes)
+	defer s.Close()
+	if !s.GetVolume(1).IsReadOnly() || s.GetVolume(2).IsReadOnly() {
+		t.Fatalf("read only after reload: %v %v", s.GetVolume(1).IsReadOnly(), s.GetVolume(2).IsReadOnly())
+	}
+	if err := s.MarkVolumeWritable(1); err != nil {
+		t.Fatal(err)
+	}
+	n := newTestNeedle("writable")
+	n.Id, n.Cookie = 1, 1
+	if _, err := s.WriteVolumeNeedle(1, n); err != nil {
+		t.Fatalf("write after mark writable: %v", err)
+	}
+}
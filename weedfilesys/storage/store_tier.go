@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TierPolicy 自动分层的挑选条件：只读、最后修改早于 MinAge、最近 MinIdle 内没有被读取的卷搬到 BackendName
+type TierPolicy struct {
+	BackendName string        // 远程存储名称，如 s3.default
+	MinAge      time.Duration // .dat 最后修改至今的最短时间
+	MinIdle     time.Duration // 最近一次读取至今的最短时间
+	MaxVolumes  int           // 每轮最多搬迁的卷数，0 表示不限
+	KeepLocal   bool          // 上传后保留本地 .dat
+}
+
+// MoveVolumeDatToRemote 供 VolumeTierMoveDatToRemote 接口和自动分层调用
+func (s *Store) MoveVolumeDatToRemote(vid needle.VolumeId, backendName string, keepLocalDatFile bool, fn func(progressed int64, percentage float32) error) error {
+	v := s.findVolume(vid)
+	if v == nil {
+		return fmt.Errorf("volume %d not found", vid)
+	}
+	return v.MoveDatToRemote(backendName, keepLocalDatFile, fn)
+}
+
+// MoveVolumeDatFromRemote 供 VolumeTierMoveDatFromRemote 接口调用
+func (s *Store) MoveVolumeDatFromRemote(vid needle.VolumeId, keepRemoteDatFile bool, fn func(progressed int64, percentage float32) error) error {
+	v := s.findVolume(vid)
+	if v == nil {
+		return fmt.Errorf("volume %d not found", vid)
+	}
+	return v.MoveDatFromRemote(keepRemoteDatFile, fn)
+}
+
+// PickVolumesToTier 按策略挑出可以搬到远程的卷，最久没有访问的排在前面
+func (s *Store) PickVolumesToTier(policy TierPolicy, now time.Time) []needle.VolumeId {
+	type candidate struct {
+		vid        needle.VolumeId
+		lastAccess time.Time
+	}
+	var candidates []candidate
+	for _, location := range s.Locations {
+		location.volumesLock.RLock()
+		for _, v := range location.volumes {
+			if lastAccess, ok := v.tierCandidate(policy, now); ok {
+				candidates = append(candidates, candidate{vid: v.Id, lastAccess: lastAccess})
+			}
+		}
+		location.volumesLock.RUnlock()
+	}
+
+	sort.Slice(candidates, func(i, j int) bool {
+		if !candidates[i].lastAccess.Equal(candidates[j].lastAccess) {
+			return candidates[i].lastAccess.Before(candidates[j].lastAccess)
+		}
+		return candidates[i].vid < candidates[j].vid
+	})
+	if policy.MaxVolumes > 0 && len(candidates) > policy.MaxVolumes {
+		candidates = candidates[:policy.MaxVolumes]
+	}
+	vids := make([]needle.VolumeId, 0, len(candidates))
+	for _, c := range candidates {
+		vids = append(vids, c.vid)
+	}
+	return vids
+}
+
+// 加载后没有读取过的卷以 .dat 的修改时间作为最近访问时间
+func (v *Volume) tierCandidate(policy TierPolicy, now time.Time) (lastAccess time.Time, ok bool) {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.checkTierable() != nil {
+		return time.Time{}, false
+	}
+	modifiedTime := time.Unix(int64(v.lastModifiedTsSeconds), 0)
+	if now.Sub(modifiedTime) < policy.MinAge {
+		return time.Time{}, false
+	}
+	lastAccess = v.LastAccessTime()
+	if lastAccess.Before(modifiedTime) {
+		lastAccess = modifiedTime
+	}
+	if now.Sub(lastAccess) < policy.MinIdle {
+		return time.Time{}, false
+	}
+	return lastAccess, true
+}
+
+// TierVolumes 按策略搬迁一轮，返回成功搬迁的卷；单个卷失败只记录日志
+func (s *Store) TierVolumes(policy TierPolicy) (moved []needle.VolumeId) {
+	for _, vid := range s.PickVolumesToTier(policy, time.Now()) {
+		if s.isStopping {
+			break
+		}
+		if err := s.MoveVolumeDatToRemote(vid, policy.BackendName, policy.KeepLocal, nil); err != nil {
+			glog.Warningf("tier volume %d to %s: %v", vid, policy.BackendName, err)
+			continue
+		}
+		moved = append(moved, vid)
+	}
+	return
+}
+
+// StartTierScheduler 每隔 interval 按策略搬迁一轮，返回的函数用于停止；卷服务器通过 SetTierPolicy 启动
+func (s *Store) StartTierScheduler(policy TierPolicy, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if moved := s.TierVolumes(policy); len(moved) > 0 {
+					glog.V(0).Infof("tiered volumes %v to %s", moved, policy.BackendName)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
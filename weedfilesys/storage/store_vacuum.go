@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/stats"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"fmt"
+)
+
+// CheckCompactVolume 返回卷的垃圾比例，由 master 决定是否需要压缩
+func (s *Store) CheckCompactVolume(volumeId needle.VolumeId) (float64, error) {
+	if v := s.findVolume(volumeId); v != nil {
+		garbageLevel := v.garbageLevel()
+		glog.V(3).Infof("volume %d garbage level: %f", volumeId, garbageLevel)
+		return garbageLevel, nil
+	}
+	return 0, fmt.Errorf("volume id %d is not found during check compact", volumeId)
+}
+
+func (s *Store) CompactVolume(vid needle.VolumeId, preallocate int64, compactionBytePerSecond int64, progressFn ProgressFunc) error {
+	if v := s.findVolume(vid); v != nil {
+		diskStatus := stats.NewDiskStatus(v.dir)
+		if int64(diskStatus.Free) < preallocate {
+			return fmt.Errorf("free space: %d bytes, not enough for %d bytes", diskStatus.Free, preallocate)
+		}
+		return v.Compact2(preallocate, compactionBytePerSecond, progressFn)
+	}
+	return fmt.Errorf("volume id %d is not found during compact", vid)
+}
+
+// CommitCompactVolume 返回提交前卷是否只读以及提交后 .dat 的大小
+func (s *Store) CommitCompactVolume(vid needle.VolumeId) (bool, int64, error) {
+	if s.isStopping {
+		return false, 0, fmt.Errorf("volume id %d skips compact because volume is stopping", vid)
+	}
+	if v := s.findVolume(vid); v != nil {
+		isReadOnly := v.IsReadOnly()
+		err := v.CommitCompact()
+		var volumeSize int64
+		if err == nil {
+			datSize, _, _ := v.FileStat()
+			volumeSize = int64(datSize)
+		}
+		return isReadOnly, volumeSize, err
+	}
+	return false, 0, fmt.Errorf("volume id %d is not found during commit compact", vid)
+}
+
+func (s *Store) CommitCleanupVolume(vid needle.VolumeId) error {
+	if v := s.findVolume(vid); v != nil {
+		return v.cleanupCompact()
+	}
+	return fmt.Errorf("volume id %d is not found during cleaning up", vid)
+}
@@ -0,0 +1,54 @@
+package super_block
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 超级块带 Extra 时，读回来的 Extra 和写入的一致
+func TestReadSuperBlockWithExtra(t *testing.T) {
+	replicaPlacement, _ := NewReplicaPlacementFromString("001")
+	ttl, _ := needle.ReadTTL("3d")
+	superBlock := SuperBlock{
+		Version:            needle.Version3,
+		ReplicaPlacement:   replicaPlacement,
+		Ttl:                ttl,
+		CompactionRevision: 2,
+		Extra: &master_pb.SuperBlockExtra{ErasureCoding: &master_pb.SuperBlockExtra_ErasureCoding{
+			Data:      10,
+			Parity:    4,
+			VolumeIds: []uint32{1, 2, 3},
+		}},
+	}
+
+	fileName := filepath.Join(t.TempDir(), "1.dat")
+	if err := os.WriteFile(fileName, superBlock.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datBackend := backend.NewDiskFile(f)
+	defer datBackend.Close()
+
+	read, err := ReadSuperBlock(datBackend)
+	if err != nil {
+		t.Fatalf("read super block: %v", err)
+	}
+	if read.Version != superBlock.Version || read.ReplicaPlacement.String() != "001" || read.Ttl.String() != "3d" || read.CompactionRevision != 2 {
+		t.Fatalf("read %+v", read)
+	}
+	erasureCoding := read.Extra.GetErasureCoding()
+	if read.ExtraSize == 0 || erasureCoding.GetData() != 10 || erasureCoding.GetParity() != 4 || !reflect.DeepEqual(erasureCoding.GetVolumeIds(), []uint32{1, 2, 3}) {
+		t.Fatalf("read extra %v", read.Extra)
+	}
+	if read.BlockSize() != SuperBlockSize+int(read.ExtraSize) {
+		t.Fatalf("block size %d", read.BlockSize())
+	}
+}
@@ -0,0 +1,332 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/storage/types"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Volume 一个卷由 .dat 和 .idx 两个文件组成
+// .dat：超级块 + 依次追加的 needle
+// .idx：needle id -> (offset, size)，加载时重放得到内存索引
+type Volume struct {
+	Id         needle.VolumeId
+	dir        string
+	dirIdx     string
+	Collection string
+
+	DataBackend   backend.BackendStorageFile
+	nm            NeedleMapper
+	needleMapKind NeedleMapKind
+
+	noWriteOrDelete  bool // 只读卷，比如磁盘文件没有写权限
+	noWriteCanDelete bool // 只允许删除
+	diskSpaceLow     bool // 所在磁盘空间不足，只允许读和删除，由 DiskLocation 维护
+
+	super_block.SuperBlock
+
+	// 写入和删除都是追加，需要串行；读取可以并发
+	dataFileAccessLock    sync.RWMutex
+	lastModifiedTsSeconds uint64 // .dat 最后修改时间
+	lastAppendAtNs        uint64 // 最后一次追加 needle 的时间戳，保证 AppendAtNs 单调递增
+
+	lastAccessTsSeconds int64 // 最近一次读取的时间，分层存储据此挑选冷卷，原子读写
+
+	volumeInfo         *volume_server_pb.VolumeInfo // .vif 中的内容
+	hasRemoteFile      bool                         // .dat 在远程存储上
+	memoryMapMaxSizeMb uint32                       // 大于 0 时 .dat 用 mmap 读写，记录在 .vif 中
+
+	isCompacting           bool
+	lastCompactIndexOffset uint64 // 压缩开始时 .idx 的大小，提交时从这里补齐
+	lastCompactRevision    uint16 // 压缩开始时的压缩版本号
+
+	location *DiskLocation
+}
+
+func NewVolume(dirname string, dirIdx string, collection string, id needle.VolumeId, needleMapKind NeedleMapKind, replicaPlacement *super_block.ReplicaPlacement, ttl *needle.TTL, preallocate int64, memoryMapMaxSizeMb uint32) (v *Volume, e error) {
+	v = &Volume{dir: dirname, dirIdx: dirIdx, Collection: collection, Id: id, needleMapKind: needleMapKind, memoryMapMaxSizeMb: memoryMapMaxSizeMb}
+	v.SuperBlock = super_block.SuperBlock{ReplicaPlacement: replicaPlacement, Ttl: ttl}
+	e = v.load(true, true, preallocate)
+	return
+}
+
+func (v *Volume) String() string {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	return fmt.Sprintf("Id:%v dir:%s dirIdx:%s Collection:%s dataFile:%v nm:%v noWrite:%v canDelete:%v",
+		v.Id, v.dir, v.dirIdx, v.Collection, v.DataBackend, v.nm, v.IsReadOnly(), v.noWriteCanDelete || v.diskSpaceLow)
+}
+
+// VolumeFileName 文件名不带扩展名，有 collection 时为 collection_id
+func VolumeFileName(dir string, collection string, id int) (fileName string) {
+	idString := strconv.Itoa(id)
+	if collection == "" {
+		fileName = path.Join(dir, idString)
+	} else {
+		fileName = path.Join(dir, collection+"_"+idString)
+	}
+	return
+}
+
+func (v *Volume) DataFileName() (fileName string) {
+	return VolumeFileName(v.dir, v.Collection, int(v.Id))
+}
+
+func (v *Volume) IndexFileName() (fileName string) {
+	return VolumeFileName(v.dirIdx, v.Collection, int(v.Id))
+}
+
+// FileName 根据扩展名返回 .dat 或 .idx 等文件的完整路径，索引类文件可能和数据文件不在同一个目录
+func (v *Volume) FileName(ext string) (fileName string) {
+	switch ext {
+	case ".idx", ".cpx", ".ldb", ".sdx", ".sdm":
+		return VolumeFileName(v.dirIdx, v.Collection, int(v.Id)) + ext
+	}
+	return VolumeFileName(v.dir, v.Collection, int(v.Id)) + ext
+}
+
+func (v *Volume) Version() needle.Version {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.SuperBlock.Initialized() {
+		return v.SuperBlock.Version
+	}
+	return needle.GetCurrentVersion()
+}
+
+func (v *Volume) FileStat() (datSize uint64, idxSize uint64, modTime time.Time) {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+
+	if v.DataBackend == nil {
+		return
+	}
+	datFileSize, modTime, e := v.DataBackend.GetStat()
+	if e == nil {
+		return uint64(datFileSize), v.nm.IndexFileSize(), modTime
+	}
+	glog.V(0).Infof("Failed to read file size %s %v", v.DataBackend.Name(), e)
+	return // -1 causes integer overflow and the volume to become unwritable.
+}
+
+func (v *Volume) ContentSize() uint64 {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return v.nm.ContentSize()
+}
+
+func (v *Volume) DeletedSize() uint64 {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return v.nm.DeletedSize()
+}
+
+func (v *Volume) FileCount() uint64 {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return uint64(v.nm.FileCount())
+}
+
+func (v *Volume) DeletedCount() uint64 {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return uint64(v.nm.DeletedCount())
+}
+
+func (v *Volume) MaxFileKey() NeedleId {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return v.nm.MaxFileKey()
+}
+
+func (v *Volume) IndexFileSize() uint64 {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	if v.nm == nil {
+		return 0
+	}
+	return v.nm.IndexFileSize()
+}
+
+func (v *Volume) NeedleMapKind() NeedleMapKind {
+	return v.needleMapKind
+}
+
+// LastAccessTime 最近一次读取的时间，加载之后还没有读取过时为零值
+func (v *Volume) LastAccessTime() time.Time {
+	if ts := atomic.LoadInt64(&v.lastAccessTsSeconds); ts > 0 {
+		return time.Unix(ts, 0)
+	}
+	return time.Time{}
+}
+
+func (v *Volume) IsReadOnly() bool {
+	return v.noWriteOrDelete || v.noWriteCanDelete || v.diskSpaceLow
+}
+
+// TTL 卷最后一次写入之后超过 TTL 即整体过期，不再上报给 master
+func (v *Volume) expired(contentSize uint64, volumeSizeLimit uint64) bool {
+	if volumeSizeLimit == 0 {
+		// 还没有收到 master 下发的配置
+		return false
+	}
+	if contentSize <= super_block.SuperBlockSize {
+		return false
+	}
+	if v.Ttl == nil || v.Ttl.Minutes() == 0 {
+		return false
+	}
+	livedMinutes := (time.Now().Unix() - int64(v.lastModifiedTsSeconds)) / 60
+	return int64(v.Ttl.Minutes()) < livedMinutes
+}
+
+// 过期之后再等 TTL 的 1/10（最多 maxDelayMinutes）才删除文件，给正在进行的读取留出时间
+func (v *Volume) expiredLongEnough(maxDelayMinutes uint32) bool {
+	if v.Ttl == nil || v.Ttl.Minutes() == 0 {
+		return false
+	}
+	removalDelay := v.Ttl.Minutes() / 10
+	if removalDelay > maxDelayMinutes {
+		removalDelay = maxDelayMinutes
+	}
+	return uint64(v.Ttl.Minutes()+removalDelay)*60+v.lastModifiedTsSeconds < uint64(time.Now().Unix())
+}
+
+// Close 先关索引再关数据文件，关闭前都会 sync
+func (v *Volume) Close() {
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+
+	v.doClose()
+}
+
+func (v *Volume) doClose() {
+	if v.nm != nil {
+		if err := v.nm.Sync(); err != nil {
+			glog.Warningf("Volume Close fail to sync volume idx %d", v.Id)
+		}
+		v.nm.Close()
+		v.nm = nil
+	}
+	if v.DataBackend != nil {
+		if err := v.DataBackend.Sync(); err != nil {
+			glog.Warningf("Volume Close fail to sync volume %d", v.Id)
+		}
+		_ = v.DataBackend.Close()
+		v.DataBackend = nil
+	}
+}
+
+// Destroy 关闭并删除卷的所有文件
+func (v *Volume) Destroy() (err error) {
+	if v.isCompacting {
+		return fmt.Errorf("volume %d is compacting", v.Id)
+	}
+	// 远程的段在关闭之后删除，暂存数据不必再上传
+	v.dataFileAccessLock.Lock()
+	v.discardRemoteStaging()
+	storageName, _ := v.remoteStorageNameKey()
+	var remoteFiles []*volume_server_pb.RemoteFile
+	if v.volumeInfo != nil {
+		remoteFiles = v.volumeInfo.Files
+	}
+	v.doClose()
+	v.dataFileAccessLock.Unlock()
+	if len(remoteFiles) > 0 {
+		if backendStorage, found := backend.GetBackendStorage(storageName); found {
+			for _, remoteFile := range remoteFiles {
+				if err = backendStorage.DeleteFile(remoteFile.Key); err != nil {
+					glog.Warningf("delete volume %d remote file %s %s: %v", v.Id, storageName, remoteFile.Key, err)
+				}
+			}
+		}
+	}
+	removeVolumeFiles(v.DataFileName())
+	removeVolumeFiles(v.IndexFileName())
+	// 本机还有同一个卷的 EC 分片时 .vif 留给 EC 卷
+	if !util.FileExists(v.IndexFileName() + ".ecx") {
+		os.Remove(v.FileName(".vif"))
+	}
+	return
+}
+
+func removeVolumeFiles(filename string) {
+	// volume data file
+	os.Remove(filename + ".dat")
+	// volume index file
+	os.Remove(filename + ".idx")
+	// needle map
+	os.RemoveAll(filename + ".ldb")
+	os.Remove(filename + ".sdx")
+	os.Remove(filename + ".sdm")
+	// compaction
+	os.Remove(filename + ".cpd")
+	os.Remove(filename + ".cpx")
+	os.Remove(filename + ".cpc")
+	// remote staging file
+	os.Remove(filename + ".stg")
+}
+
+func (v *Volume) DiskType() types.DiskType {
+	if v.location == nil {
+		return types.HardDriveType
+	}
+	return v.location.DiskType
+}
+
+// ToVolumeInformationMessage 心跳中上报给 master 的卷信息，同时返回卷内最大的 needle id
+func (v *Volume) ToVolumeInformationMessage() (types.NeedleId, *master_pb.VolumeInformationMessage) {
+	size, _, modTime := v.FileStat()
+
+	volumeInfo := &master_pb.VolumeInformationMessage{
+		Id:               uint32(v.Id),
+		Size:             size,
+		Collection:       v.Collection,
+		FileCount:        v.FileCount(),
+		DeleteCount:      v.DeletedCount(),
+		DeletedByteCount: v.DeletedSize(),
+		ReadOnly:         v.IsReadOnly(),
+		ReplicaPlacement: uint32(v.ReplicaPlacement.Byte()),
+		Version:          uint32(v.Version()),
+		Ttl:              v.Ttl.ToUint32(),
+		CompactRevision:  uint32(v.SuperBlock.CompactionRevision),
+		ModifiedAtSecond: modTime.Unix(),
+		DiskType:         string(v.DiskType()),
+	}
+	volumeInfo.RemoteStorageName, volumeInfo.RemoteStorageKey = v.RemoteStorageNameKey()
+
+	return v.MaxFileKey(), volumeInfo
+}
+
+func sortVolumeIds(ids []needle.VolumeId) {
+	slices.Sort(ids)
+}
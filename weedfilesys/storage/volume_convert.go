@@ -0,0 +1,274 @@
+package storage
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"io"
+	"os"
+)
+
+// 把 v1/v2 卷离线改写成 v3：按 .idx 的顺序重新编码每个文件的最新版本，补上单调递增的 AppendAtNs，
+// cookie、needle id 以及名字、mime、修改时间、TTL 等字段保持不变。
+// 新文件先写到 .cpd/.cpx，逐个校验之后沿用压缩的提交流程替换 .dat/.idx，中途崩溃时加载卷会自动收尾或回滚
+
+type ConvertReport struct {
+	FromVersion  needle.Version
+	ToVersion    needle.Version
+	NeedleCount  int // 转换的文件数
+	DeleteCount  int // 转换的删除标记数
+	DatSizeFrom  int64
+	DatSizeTo    int64
+	Converted    bool // 已经是目标版本时为 false
+	BackupSuffix string
+}
+
+// 转换过的一条记录，用于校验
+type convertedEntry struct {
+	key       NeedleId
+	oldOffset int64
+	oldSize   Size
+	newOffset int64
+	newSize   Size
+}
+
+// ConvertVolumeToVersion3 卷必须处于未加载状态；keepOriginal 为 true 时原 .dat/.idx 以 .v<版本号> 为后缀保留
+func ConvertVolumeToVersion3(dirname, dirIdx, collection string, id needle.VolumeId, keepOriginal bool) (*ConvertReport, error) {
+	v := &Volume{dir: dirname, dirIdx: dirIdx, Collection: collection, Id: id}
+	if err := v.recoverCompaction(); err != nil {
+		return nil, fmt.Errorf("recover volume %d: %v", id, err)
+	}
+	if err := v.maybeLoadVolumeInfo(); err != nil {
+		return nil, fmt.Errorf("load volume %d info: %v", id, err)
+	}
+
+	datFileName, idxFileName := v.FileName(".dat"), v.FileName(".idx")
+	datFile, err := os.Open(datFileName)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", datFileName, err)
+	}
+	oldDat := backend.NewDiskFile(datFile)
+	defer oldDat.Close()
+
+	oldSuperBlock, err := super_block.ReadSuperBlock(oldDat)
+	if err != nil {
+		return nil, fmt.Errorf("read super block %s: %v", datFileName, err)
+	}
+	report := &ConvertReport{FromVersion: oldSuperBlock.Version, ToVersion: needle.Version3}
+	datSize, modTime, err := oldDat.GetStat()
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %v", datFileName, err)
+	}
+	report.DatSizeFrom, report.DatSizeTo = datSize, datSize
+	if oldSuperBlock.Version >= needle.Version3 {
+		return report, nil
+	}
+
+	entries, err := convertNeedles(oldDat, oldSuperBlock, idxFileName, v.FileName(".cpd"), v.FileName(".cpx"), v.volumeInfo.MemoryMapMaxSizeMb, uint64(modTime.UnixNano()), report)
+	if err != nil {
+		v.removeCompactFiles()
+		return nil, err
+	}
+	if err = verifyConvertedNeedles(oldDat, oldSuperBlock.Version, v.FileName(".cpd"), entries); err != nil {
+		v.removeCompactFiles()
+		return nil, fmt.Errorf("verify converted volume %d: %v", id, err)
+	}
+	if keepOriginal {
+		report.BackupSuffix = fmt.Sprintf(".v%d", oldSuperBlock.Version)
+		if err = copyFile(datFileName, datFileName+report.BackupSuffix); err != nil {
+			v.removeCompactFiles()
+			return nil, err
+		}
+		if err = copyFile(idxFileName, idxFileName+report.BackupSuffix); err != nil {
+			v.removeCompactFiles()
+			return nil, err
+		}
+	}
+	if err = v.replaceWithCompactFiles(); err != nil {
+		return nil, fmt.Errorf("replace volume %d files: %v", id, err)
+	}
+	report.Converted = true
+	glog.V(0).Infof("converted volume %d from version %d to %d: %d needles, %d deletions",
+		id, report.FromVersion, report.ToVersion, report.NeedleCount, report.DeleteCount)
+	return report, nil
+}
+
+// 只转换每个 key 在 .idx 中的最后一条记录，被覆盖的旧版本和没有索引的数据随之丢弃
+func convertNeedles(oldDat backend.BackendStorageFile, oldSuperBlock super_block.SuperBlock, idxFileName, newDatFileName, newIdxFileName string,
+	memoryMapMaxSizeMb uint32, defaultAppendAtNs uint64, report *ConvertReport) (entries []convertedEntry, err error) {
+	idxFile, err := os.Open(idxFileName)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", idxFileName, err)
+	}
+	defer idxFile.Close()
+
+	// 每个 key 最后一条记录在 .idx 中的序号
+	lastEntry := make(map[NeedleId]uint64)
+	var entryCount uint64
+	err = idx.WalkIndexFile(idxFile, 0, func(key NeedleId, offset Offset, size Size) error {
+		lastEntry[key] = entryCount
+		entryCount++
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("walk %s: %v", idxFileName, err)
+	}
+
+	newSuperBlock := oldSuperBlock
+	newSuperBlock.Version = needle.Version3
+	newDat, err := backend.CreateVolumeFile(newDatFileName, 0, memoryMapMaxSizeMb)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %v", newDatFileName, err)
+	}
+	defer newDat.Close()
+	if _, err = newDat.WriteAt(newSuperBlock.Bytes(), 0); err != nil {
+		return nil, fmt.Errorf("write super block to %s: %v", newDatFileName, err)
+	}
+
+	newIdxFile, err := os.OpenFile(newIdxFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("create %s: %v", newIdxFileName, err)
+	}
+	defer newIdxFile.Close()
+
+	// 旧版本没有追加时间，用文件自带的修改时间，没有时用 .dat 的修改时间，并保证按 .idx 顺序单调递增
+	var lastAppendAtNs uint64
+	var entryIndex uint64
+	version := oldSuperBlock.Version
+	err = idx.WalkIndexFile(idxFile, 0, func(key NeedleId, offset Offset, size Size) error {
+		current := entryIndex
+		entryIndex++
+		if lastEntry[key] != current || offset.IsZero() {
+			return nil
+		}
+		readSize := size
+		if size.IsDeleted() {
+			readSize = 0
+		}
+		n := new(needle.Needle)
+		if err := n.ReadData(oldDat, offset.ToActualOffset(), readSize, version); err != nil {
+			return fmt.Errorf("read needle %d at %d: %v", key, offset.ToActualOffset(), err)
+		}
+		if n.Id != key {
+			return fmt.Errorf("needle at %d has id %d, expected %d", offset.ToActualOffset(), n.Id, key)
+		}
+
+		appendAtNs := defaultAppendAtNs
+		if n.HasLastModifiedDate() && n.LastModified > 0 {
+			appendAtNs = n.LastModified * 1e9
+		}
+		if appendAtNs <= lastAppendAtNs {
+			appendAtNs = lastAppendAtNs + 1
+		}
+		n.AppendAtNs, lastAppendAtNs = appendAtNs, appendAtNs
+
+		newOffset, _, _, err := n.Append(newDat, needle.Version3)
+		if err != nil {
+			return fmt.Errorf("append needle %d: %v", key, err)
+		}
+		entry := convertedEntry{key: key, oldOffset: offset.ToActualOffset(), oldSize: readSize, newOffset: int64(newOffset), newSize: n.Size}
+		idxSize := n.Size
+		if size.IsDeleted() {
+			idxSize = TombstoneFileSize
+			report.DeleteCount++
+		} else {
+			report.NeedleCount++
+		}
+		if _, err = newIdxFile.Write(needle_map.NeedleValue{Key: key, Offset: ToOffset(int64(newOffset)), Size: idxSize}.ToBytes()); err != nil {
+			return fmt.Errorf("write %s: %v", newIdxFileName, err)
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = newDat.Sync(); err != nil {
+		return nil, fmt.Errorf("sync %s: %v", newDatFileName, err)
+	}
+	if err = newIdxFile.Sync(); err != nil {
+		return nil, fmt.Errorf("sync %s: %v", newIdxFileName, err)
+	}
+	report.DatSizeTo, _, err = newDat.GetStat()
+	return entries, err
+}
+
+// 重新打开新的 .dat，逐个读回转换后的 needle，和原文件中的内容比较
+func verifyConvertedNeedles(oldDat backend.BackendStorageFile, oldVersion needle.Version, newDatFileName string, entries []convertedEntry) error {
+	newDatFile, err := os.Open(newDatFileName)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", newDatFileName, err)
+	}
+	newDat := backend.NewDiskFile(newDatFile)
+	defer newDat.Close()
+
+	var lastAppendAtNs uint64
+	for _, e := range entries {
+		oldNeedle, newNeedle := new(needle.Needle), new(needle.Needle)
+		if err = oldNeedle.ReadData(oldDat, e.oldOffset, e.oldSize, oldVersion); err != nil {
+			return fmt.Errorf("read original needle %d: %v", e.key, err)
+		}
+		if err = newNeedle.ReadData(newDat, e.newOffset, e.newSize, needle.Version3); err != nil {
+			return fmt.Errorf("read converted needle %d: %v", e.key, err)
+		}
+		if err = compareConvertedNeedle(oldNeedle, newNeedle); err != nil {
+			return fmt.Errorf("needle %d: %v", e.key, err)
+		}
+		if newNeedle.AppendAtNs <= lastAppendAtNs {
+			return fmt.Errorf("needle %d append time %d is not after %d", e.key, newNeedle.AppendAtNs, lastAppendAtNs)
+		}
+		lastAppendAtNs = newNeedle.AppendAtNs
+	}
+	return nil
+}
+
+func compareConvertedNeedle(a, b *needle.Needle) error {
+	switch {
+	case a.Id != b.Id:
+		return fmt.Errorf("id %d != %d", a.Id, b.Id)
+	case a.Cookie != b.Cookie:
+		return fmt.Errorf("cookie %x != %x", a.Cookie, b.Cookie)
+	case !bytes.Equal(a.Data, b.Data):
+		return fmt.Errorf("data differs")
+	case a.Checksum != b.Checksum:
+		return fmt.Errorf("checksum %x != %x", a.Checksum, b.Checksum)
+	case a.Flags != b.Flags:
+		return fmt.Errorf("flags %x != %x", a.Flags, b.Flags)
+	case !bytes.Equal(a.Name, b.Name) || !bytes.Equal(a.Mime, b.Mime) || !bytes.Equal(a.Pairs, b.Pairs):
+		return fmt.Errorf("name, mime or pairs differ")
+	case a.LastModified != b.LastModified:
+		return fmt.Errorf("last modified %d != %d", a.LastModified, b.LastModified)
+	case a.Ttl.String() != b.Ttl.String():
+		return fmt.Errorf("ttl %s != %s", a.Ttl, b.Ttl)
+	}
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", dst, err)
+	}
+	if _, err = io.Copy(out, in); err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("copy %s to %s: %v", src, dst, err)
+	}
+	return nil
+}
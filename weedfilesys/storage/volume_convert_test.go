@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// 先写入旧版本的超级块，加载后写入的 needle 都按这个版本编码
+func newTestVolumeWithVersion(t *testing.T, dir string, id needle.VolumeId, version needle.Version) *Volume {
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	sb := super_block.SuperBlock{Version: version, ReplicaPlacement: rp, Ttl: needle.EMPTY_TTL}
+	if err := os.WriteFile(VolumeFileName(dir, "", int(id))+".dat", sb.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	v, err := NewVolume(dir, dir, "", id, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	if v.SuperBlock.Version != version {
+		t.Fatalf("volume version %d, expected %d", v.SuperBlock.Version, version)
+	}
+	return v
+}
+
+func TestConvertVolumeToVersion3(t *testing.T) {
+	for _, version := range []needle.Version{needle.Version1, needle.Version2} {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			testConvertVolumeToVersion3(t, version)
+		})
+	}
+}
+
+func testConvertVolumeToVersion3(t *testing.T, version needle.Version) {
+	dir := t.TempDir()
+	v := newTestVolumeWithVersion(t, dir, 1, version)
+
+	lastModified := uint64(time.Now().Add(-time.Hour).Unix())
+	write := func(key uint64, data string) {
+		n := newTestNeedle(data)
+		if version == needle.Version2 {
+			n.Name, n.Mime = []byte("file.txt"), []byte("text/plain")
+			n.SetHasName()
+			n.SetHasMime()
+			n.LastModified = lastModified
+			n.SetHasLastModifiedDate()
+		}
+		if _, _, err := v.WriteFile(needle.NewFileId(1, key, uint32(key)*7), n); err != nil {
+			t.Fatalf("write %d: %v", key, err)
+		}
+	}
+	for key := uint64(1); key <= 5; key++ {
+		write(key, "old data")
+	}
+	write(2, "new data")
+	if _, err := v.DeleteFile(needle.NewFileId(1, 3, 21)); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	v.Close()
+
+	report, err := ConvertVolumeToVersion3(dir, dir, "", 1, true)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if !report.Converted || report.NeedleCount != 4 || report.DeleteCount != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if !util.FileExists(v.FileName(".dat") + report.BackupSuffix) {
+		t.Fatalf("original .dat not kept")
+	}
+
+	v, err = NewVolume(dir, dir, "", 1, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	if v.SuperBlock.Version != needle.Version3 {
+		t.Fatalf("converted version %d", v.SuperBlock.Version)
+	}
+	for key := uint64(1); key <= 5; key++ {
+		n, err := v.ReadFile(needle.NewFileId(1, key, uint32(key)*7))
+		if key == 3 {
+			// 转换后只剩删除标记，读取时可能是已删除或不存在
+			if !errors.Is(err, ErrorDeleted) && !errors.Is(err, ErrorNotFound) {
+				t.Fatalf("read deleted: %v", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("read %d: %v", key, err)
+		}
+		expected := "old data"
+		if key == 2 {
+			expected = "new data"
+		}
+		if string(n.Data) != expected || n.AppendAtNs == 0 {
+			t.Fatalf("needle %d data %q appendAtNs %d", key, n.Data, n.AppendAtNs)
+		}
+		if version == needle.Version2 && (string(n.Name) != "file.txt" || n.LastModified != lastModified) {
+			t.Fatalf("needle %d name %q lastModified %d", key, n.Name, n.LastModified)
+		}
+	}
+
+	// 已经是 v3 的卷不再转换
+	v.Close()
+	if report, err = ConvertVolumeToVersion3(dir, dir, "", 1, false); err != nil || report.Converted {
+		t.Fatalf("convert v3 volume: %+v %v", report, err)
+	}
+}
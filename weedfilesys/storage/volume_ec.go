@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"fmt"
+	"os"
+)
+
+// 在线 EC 转换时卷服务器上的步骤：只读卷在本地按 EC 方案生成 .ec00~.ecNN 和 .ecx，原 .dat 的大小和方案写入 .vif，
+// 分片复制到其他服务器并挂载、验证之后，删除本地不再需要的分片，最后删除原卷。
+// 原卷和 EC 卷共用同一个 .vif，原卷删除时如果还有 .ecx 则保留 .vif
+
+// 生成期间持有读锁，卷只读，.dat 和 .idx 不会变化
+func (v *Volume) generateEcShards(scheme erasure_coding.EcScheme) (datFileSize int64, err error) {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	switch {
+	case v.DataBackend == nil:
+		return 0, fmt.Errorf("volume %d is not loaded", v.Id)
+	case v.hasRemoteFile:
+		return 0, fmt.Errorf("volume %d is on remote storage", v.Id)
+	case !v.noWriteOrDelete:
+		return 0, fmt.Errorf("volume %d is writable, mark it read only first", v.Id)
+	case v.isCompacting:
+		return 0, fmt.Errorf("volume %d is compacting", v.Id)
+	}
+	if datFileSize, _, err = v.DataBackend.GetStat(); err != nil {
+		return 0, err
+	}
+	if err = v.DataBackend.Sync(); err != nil {
+		return 0, err
+	}
+	if err = erasure_coding.WriteEcFiles(v.DataFileName(), scheme); err != nil {
+		v.removeEcShardFiles()
+		return 0, fmt.Errorf("generate ec shards for volume %d: %v", v.Id, err)
+	}
+	if err = erasure_coding.WriteSortedFileFromIdx(v.IndexFileName(), ".ecx"); err != nil {
+		v.removeEcShardFiles()
+		return 0, fmt.Errorf("generate ecx for volume %d: %v", v.Id, err)
+	}
+	return datFileSize, nil
+}
+
+// 记录 EC 卷需要的信息：原 .dat 大小和方案用于定位分片中的数据，TTL 卷的过期时间沿用原卷的最后修改时间
+func (v *Volume) saveEcVolumeInfo(datFileSize int64, scheme erasure_coding.EcScheme) error {
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if size, _, err := v.DataBackend.GetStat(); err != nil || size != datFileSize {
+		v.removeEcShardFiles()
+		return fmt.Errorf("volume %d changed while generating ec shards", v.Id)
+	}
+	v.volumeInfo.DatFileSize = datFileSize
+	v.volumeInfo.EcShardConfig = scheme.ToEcShardConfig()
+	v.volumeInfo.ExpireAtSec = 0
+	if v.Ttl != nil && v.Ttl.Minutes() > 0 {
+		v.volumeInfo.ExpireAtSec = v.lastModifiedTsSeconds + uint64(v.Ttl.Minutes())*60
+	}
+	if err := v.saveVolumeInfo(); err != nil {
+		v.removeEcShardFiles()
+		return err
+	}
+	return nil
+}
+
+func (v *Volume) removeEcShardFiles() {
+	for i := 0; i < erasure_coding.MaxShardCount; i++ {
+		os.Remove(v.DataFileName() + erasure_coding.ToExt(i))
+	}
+	os.Remove(v.IndexFileName() + ".ecx")
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/volume_info"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+)
+
+// 把 EC 卷还原成普通卷：数据分片按行拼回 .dat，.ecx 加上 .ecj 中的删除写成 .idx，然后删除全部 EC 文件。
+// 缺少数据分片时先用其余分片重建；.dat 的大小取自 .vif，旧版本没有记录时按 .ecx 中最靠后的文件计算。
+// 还原出的卷不沿用 EC 卷的 .vif，加载后可写
+
+type EcDecodeReport struct {
+	Scheme      erasure_coding.EcScheme
+	DatFileSize int64
+	Rebuilt     []uint32 // 为了还原而重建的分片
+}
+
+// DecodeEcVolume 分片必须处于未挂载状态；失败时删除写了一半的 .dat、.idx 和重建出的分片，EC 文件保持原样
+func DecodeEcVolume(dirname, dirIdx, collection string, id needle.VolumeId) (*EcDecodeReport, error) {
+	dataBaseFileName := erasure_coding.EcShardFileName(collection, dirname, int(id))
+	indexBaseFileName := erasure_coding.EcShardFileName(collection, dirIdx, int(id))
+	if !util.FileExists(indexBaseFileName + ".ecx") {
+		return nil, fmt.Errorf("ec volume %d has no %s.ecx", id, indexBaseFileName)
+	}
+	if util.FileExists(dataBaseFileName + ".dat") {
+		return nil, fmt.Errorf("volume %d already has %s.dat", id, dataBaseFileName)
+	}
+
+	report := &EcDecodeReport{Scheme: erasure_coding.DefaultEcScheme}
+	volumeInfo, _, found, err := volume_info.MaybeLoadVolumeInfo(dataBaseFileName + ".vif")
+	if err != nil {
+		return nil, fmt.Errorf("load %s.vif: %v", dataBaseFileName, err)
+	}
+	if found {
+		if report.Scheme, err = erasure_coding.EcSchemeFromConfig(volumeInfo.EcShardConfig); err != nil {
+			return nil, fmt.Errorf("ec volume %d: %v", id, err)
+		}
+		report.DatFileSize = volumeInfo.DatFileSize
+	}
+
+	var shardFileNames []string
+	hasAllDataShards := true
+	for i := 0; i < report.Scheme.DataShards; i++ {
+		shardFileNames = append(shardFileNames, dataBaseFileName+erasure_coding.ToExt(i))
+		hasAllDataShards = hasAllDataShards && util.FileExists(shardFileNames[i])
+	}
+	if !hasAllDataShards {
+		if report.Rebuilt, err = erasure_coding.RebuildEcFiles(dataBaseFileName, report.Scheme); err != nil {
+			return nil, fmt.Errorf("rebuild data shards of ec volume %d: %v", id, err)
+		}
+	}
+
+	if report.DatFileSize == 0 {
+		report.DatFileSize, err = erasure_coding.FindDatFileSize(dataBaseFileName, indexBaseFileName)
+	}
+	if err == nil {
+		err = erasure_coding.WriteDatFile(dataBaseFileName, report.Scheme.DataShards, report.DatFileSize, shardFileNames)
+	}
+	if err == nil {
+		err = erasure_coding.WriteIdxFileFromEcIndex(indexBaseFileName)
+	}
+	if err != nil {
+		os.Remove(dataBaseFileName + ".dat")
+		os.Remove(indexBaseFileName + ".idx")
+		for _, shardId := range report.Rebuilt {
+			os.Remove(dataBaseFileName + erasure_coding.ToExt(int(shardId)))
+		}
+		return nil, fmt.Errorf("decode ec volume %d: %v", id, err)
+	}
+
+	for i := 0; i < erasure_coding.MaxShardCount; i++ {
+		os.Remove(dataBaseFileName + erasure_coding.ToExt(i))
+	}
+	os.Remove(indexBaseFileName + ".ecx")
+	os.Remove(indexBaseFileName + ".ecj")
+	os.Remove(dataBaseFileName + ".vif")
+	glog.V(0).Infof("decoded %s ec volume %d into %s.dat, size %d, rebuilt shards %v", report.Scheme, id, dataBaseFileName, report.DatFileSize, report.Rebuilt)
+	return report, nil
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"bufio"
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"os"
+)
+
+// fsck 只能在卷没有被加载时运行，否则扫描期间的追加写会被误判为不完整的尾部
+
+type FsckIssueType string
+
+const (
+	FsckOrphan  FsckIssueType = "orphan"  // .dat 中有完整的 needle，但 .idx 没有记录指向它
+	FsckMissing FsckIssueType = "missing" // .idx 记录指向的位置没有 needle
+	FsckCorrupt FsckIssueType = "corrupt" // 校验和错误，或者和 .idx 记录的 id、大小不一致
+)
+
+type FsckIssue struct {
+	Type   FsckIssueType
+	Key    NeedleId
+	Offset int64
+	Size   Size
+	Detail string
+}
+
+func (i FsckIssue) String() string {
+	return fmt.Sprintf("%s needle %s offset %d size %d: %s", i.Type, i.Key, i.Offset, i.Size, i.Detail)
+}
+
+type FsckOption struct {
+	TruncateTornTail bool // 截掉 .dat 尾部不完整的 needle 以及 .idx 尾部不足一条的记录
+	RebuildIndex     bool // 丢弃现有 .idx，按 .dat 中的 needle 顺序重新生成
+	Force            bool // 损坏在文件中间时也执行修复，其后 .idx 记录指向的 needle 会丢失
+}
+
+type FsckReport struct {
+	Version           needle.Version
+	DatFileSize       int64
+	IdxFileSize       int64
+	NeedleCount       int    // .dat 中扫描到的 needle 数，不含删除标记
+	TombstoneCount    int    // .dat 中扫描到的删除标记数
+	IndexEntries      int    // .idx 中的记录数
+	LastAppendAtNs    uint64 // .dat 中最大的 AppendAtNs，仅 v3 有
+	TornTailOffset    int64  // .dat 尾部不完整 needle 的起始位置，-1 表示没有
+	TornIndexBytes    int64  // .idx 尾部不足一条记录的字节数
+	UnreadableOffset  int64  // 读不出 needle 头部的位置，之后的数据没有扫描，-1 表示没有
+	IndexedBeyondScan int    // .idx 中指向扫描终止位置及之后的记录数，大于 0 说明损坏在文件中间而不是尾部写了一半
+	Issues            []FsckIssue
+	Repaired          []string // 实际执行的修复操作
+}
+
+func (r *FsckReport) Count(t FsckIssueType) (count int) {
+	for _, issue := range r.Issues {
+		if issue.Type == t {
+			count++
+		}
+	}
+	return
+}
+
+func (r *FsckReport) Healthy() bool {
+	return len(r.Issues) == 0 && r.TornTailOffset < 0 && r.TornIndexBytes == 0
+}
+
+// MidFileCorruption 截断或重建索引会丢掉损坏位置之后仍被 .idx 引用的 needle
+func (r *FsckReport) MidFileCorruption() bool {
+	return r.IndexedBeyondScan > 0
+}
+
+// 扫描终止的位置，-1 表示扫描到了文件末尾
+func (r *FsckReport) scanEndOffset() int64 {
+	if r.TornTailOffset >= 0 {
+		return r.TornTailOffset
+	}
+	return r.UnreadableOffset
+}
+
+func (r *FsckReport) addIssue(t FsckIssueType, key NeedleId, offset int64, size Size, format string, args ...interface{}) {
+	r.Issues = append(r.Issues, FsckIssue{Type: t, Key: key, Offset: offset, Size: size, Detail: fmt.Sprintf(format, args...)})
+}
+
+// .dat 中扫描到的一个 needle
+type scannedNeedle struct {
+	key       NeedleId
+	offset    int64
+	size      Size
+	tombstone bool
+	err       error
+}
+
+// FsckVolume 顺序扫描 .dat 校验每个 needle，再和 .idx 交叉检查，按 option 修复
+func FsckVolume(dirname, dirIdx, collection string, id needle.VolumeId, option FsckOption) (*FsckReport, error) {
+	datFileName := VolumeFileName(dirname, collection, int(id)) + ".dat"
+	idxFileName := VolumeFileName(dirIdx, collection, int(id)) + ".idx"
+	repair := option.TruncateTornTail || option.RebuildIndex
+
+	flag := os.O_RDONLY
+	if repair {
+		flag = os.O_RDWR
+	}
+	datFile, err := os.OpenFile(datFileName, flag, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %v", datFileName, err)
+	}
+	datBackend := backend.NewDiskFile(datFile)
+	defer datBackend.Close()
+
+	superBlock, err := super_block.ReadSuperBlock(datBackend)
+	if err != nil {
+		return nil, fmt.Errorf("read super block %s: %v", datFileName, err)
+	}
+	report := &FsckReport{Version: superBlock.Version, TornTailOffset: -1, UnreadableOffset: -1}
+	if report.DatFileSize, _, err = datBackend.GetStat(); err != nil {
+		return nil, fmt.Errorf("stat %s: %v", datFileName, err)
+	}
+
+	scanned, err := scanVolumeData(datBackend, superBlock, report)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkIndexAgainstData(idxFileName, scanned, report); err != nil {
+		return nil, err
+	}
+	if repair && report.MidFileCorruption() && !option.Force {
+		return report, fmt.Errorf("%d index entries of %s point at or beyond offset %d, the data is corrupt in the middle of the file and repairing would drop them",
+			report.IndexedBeyondScan, idxFileName, report.scanEndOffset())
+	}
+
+	if option.TruncateTornTail {
+		if report.TornTailOffset >= 0 {
+			if err = datBackend.Truncate(report.TornTailOffset); err != nil {
+				return report, fmt.Errorf("truncate %s: %v", datFileName, err)
+			}
+			report.Repaired = append(report.Repaired, fmt.Sprintf("truncated %s from %d to %d", datFileName, report.DatFileSize, report.TornTailOffset))
+		}
+		if report.TornIndexBytes > 0 && !option.RebuildIndex {
+			healthySize := report.IdxFileSize - report.TornIndexBytes
+			if err = os.Truncate(idxFileName, healthySize); err != nil {
+				return report, fmt.Errorf("truncate %s: %v", idxFileName, err)
+			}
+			report.Repaired = append(report.Repaired, fmt.Sprintf("truncated %s from %d to %d", idxFileName, report.IdxFileSize, healthySize))
+		}
+	}
+	if option.RebuildIndex {
+		if err = rebuildIndexFromData(idxFileName, scanned); err != nil {
+			return report, err
+		}
+		report.Repaired = append(report.Repaired, fmt.Sprintf("rebuilt %s with %d entries", idxFileName, report.NeedleCount+report.TombstoneCount))
+	}
+	if len(report.Repaired) > 0 {
+		// 派生的索引都是按旧 .idx 生成的，下次加载时重建
+		removeDerivedIndexFiles(VolumeFileName(dirIdx, collection, int(id)))
+		if err = datBackend.Sync(); err != nil {
+			return report, fmt.Errorf("sync %s: %v", datFileName, err)
+		}
+	}
+	return report, nil
+}
+
+// 从超级块之后逐个读取 needle 头部和数据体，遇到超出文件末尾的 needle 即认为是不完整的尾部。
+// 头部里的大小损坏时也会表现为超出文件末尾，由 checkIndexAgainstData 根据 .idx 区分
+func scanVolumeData(datBackend backend.BackendStorageFile, superBlock super_block.SuperBlock, report *FsckReport) (scanned []*scannedNeedle, err error) {
+	version := superBlock.Version
+	offset := int64(superBlock.BlockSize())
+	for offset < report.DatFileSize {
+		if offset+NeedleHeaderSize > report.DatFileSize {
+			report.TornTailOffset = offset
+			break
+		}
+		n, _, bodyLength, e := needle.ReadNeedleHeader(datBackend, version, offset)
+		if e != nil {
+			report.UnreadableOffset = offset
+			report.addIssue(FsckCorrupt, 0, offset, 0, "read needle header: %v", e)
+			glog.Warningf("%s: read needle header at %d: %v", datBackend.Name(), offset, e)
+			break
+		}
+		if n.Size < 0 || offset+NeedleHeaderSize+bodyLength > report.DatFileSize {
+			report.TornTailOffset = offset
+			break
+		}
+		s := &scannedNeedle{key: n.Id, offset: offset, size: n.Size, tombstone: n.Size == 0}
+		if _, s.err = n.ReadNeedleBody(datBackend, version, offset+NeedleHeaderSize, bodyLength); s.err != nil {
+			report.addIssue(FsckCorrupt, n.Id, offset, n.Size, "%v", s.err)
+		} else if s.tombstone {
+			report.TombstoneCount++
+		} else {
+			report.NeedleCount++
+		}
+		if n.AppendAtNs > report.LastAppendAtNs {
+			report.LastAppendAtNs = n.AppendAtNs
+		}
+		scanned = append(scanned, s)
+		offset += NeedleHeaderSize + bodyLength
+	}
+	if report.TornTailOffset >= 0 {
+		glog.Warningf("%s has torn tail at %d, file size %d", datBackend.Name(), report.TornTailOffset, report.DatFileSize)
+	}
+	return scanned, nil
+}
+
+// 每个 key 只检查 .idx 中的最后一条记录；.dat 中没有任何 .idx 记录指向的 needle 视为孤儿
+func checkIndexAgainstData(idxFileName string, scanned []*scannedNeedle, report *FsckReport) error {
+	idxFile, err := os.Open(idxFileName)
+	if err != nil {
+		if os.IsNotExist(err) {
+			for _, s := range scanned {
+				if s.err == nil {
+					report.addIssue(FsckOrphan, s.key, s.offset, s.size, "index file %s does not exist", idxFileName)
+				}
+			}
+			return nil
+		}
+		return fmt.Errorf("open %s: %v", idxFileName, err)
+	}
+	defer idxFile.Close()
+	stat, err := idxFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", idxFileName, err)
+	}
+	report.IdxFileSize = stat.Size()
+	report.TornIndexBytes = report.IdxFileSize % NeedleMapEntrySize
+
+	byOffset := make(map[int64]*scannedNeedle, len(scanned))
+	for _, s := range scanned {
+		byOffset[s.offset] = s
+	}
+
+	referenced := make(map[int64]bool)
+	latest := needle_map.NewMemDb()
+	defer latest.Close()
+	scanEnd := report.scanEndOffset()
+	err = idx.WalkIndexFile(idxFile, 0, func(key NeedleId, offset Offset, size Size) error {
+		report.IndexEntries++
+		if !offset.IsZero() {
+			referenced[offset.ToActualOffset()] = true
+			if scanEnd >= 0 && offset.ToActualOffset() >= scanEnd {
+				report.IndexedBeyondScan++
+			}
+		}
+		return latest.Set(key, offset, size)
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s: %v", idxFileName, err)
+	}
+
+	err = latest.AscendingVisit(func(value needle_map.NeedleValue) error {
+		if value.Offset.IsZero() {
+			return nil
+		}
+		actualOffset := value.Offset.ToActualOffset()
+		s, found := byOffset[actualOffset]
+		switch {
+		case !found:
+			report.addIssue(FsckMissing, value.Key, actualOffset, value.Size, "no needle at this offset")
+		case s.key != value.Key:
+			report.addIssue(FsckCorrupt, value.Key, actualOffset, value.Size, "needle at this offset has id %s", s.key)
+		case value.Size.IsDeleted() && !s.tombstone:
+			report.addIssue(FsckCorrupt, value.Key, actualOffset, value.Size, "deletion points to needle of size %d", s.size)
+		case value.Size.IsValid() && s.size != value.Size:
+			report.addIssue(FsckCorrupt, value.Key, actualOffset, value.Size, "needle at this offset has size %d", s.size)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range scanned {
+		if s.err == nil && !referenced[s.offset] {
+			detail := "not referenced by index"
+			if s.tombstone {
+				detail = "deletion marker not referenced by index"
+			}
+			report.addIssue(FsckOrphan, s.key, s.offset, s.size, "%s", detail)
+		}
+	}
+	return nil
+}
+
+// 按 .dat 中的顺序重新生成 .idx，删除标记记为 TombstoneFileSize，损坏的 needle 跳过
+func rebuildIndexFromData(idxFileName string, scanned []*scannedNeedle) error {
+	tmpFileName := idxFileName + ".tmp"
+	f, err := os.OpenFile(tmpFileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", tmpFileName, err)
+	}
+	w := bufio.NewWriter(f)
+	for _, s := range scanned {
+		if s.err != nil {
+			continue
+		}
+		size := s.size
+		if s.tombstone {
+			size = TombstoneFileSize
+		}
+		if _, err = w.Write(needle_map.NeedleValue{Key: s.key, Offset: ToOffset(s.offset), Size: size}.ToBytes()); err != nil {
+			f.Close()
+			return fmt.Errorf("write %s: %v", tmpFileName, err)
+		}
+	}
+	if err = w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %v", tmpFileName, err)
+	}
+	if err = os.Rename(tmpFileName, idxFileName); err != nil {
+		return fmt.Errorf("rename %s: %v", tmpFileName, err)
+	}
+	return nil
+}
+
+func removeDerivedIndexFiles(indexBaseFileName string) {
+	os.RemoveAll(indexBaseFileName + ".ldb")
+	os.Remove(indexBaseFileName + ".sdx")
+	os.Remove(indexBaseFileName + ".sdm")
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestFsckHealthyVolume(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 1, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	for key := uint64(1); key <= 3; key++ {
+		if _, _, err = v.WriteFile(needle.NewFileId(1, key, 1), newTestNeedle("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err = v.DeleteFile(needle.NewFileId(1, 2, 1)); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	report, err := FsckVolume(dir, dir, "", 1, FsckOption{})
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	if !report.Healthy() {
+		t.Fatalf("unexpected issues: %v", report.Issues)
+	}
+	if report.NeedleCount != 3 || report.TombstoneCount != 1 || report.IndexEntries != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
+
+func TestFsckDetectsAndRepairs(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 2, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	var offsets []uint64
+	for key := uint64(1); key <= 3; key++ {
+		n := newTestNeedle("hello fsck")
+		n.Id, n.Cookie = NeedleId(key), 1
+		offset, _, _, err := v.WriteNeedle(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, offset)
+	}
+	datFileName, idxFileName := v.FileName(".dat"), v.FileName(".idx")
+	v.Close()
+
+	// 只保留第一条索引记录，模拟 .idx 丢失了后两次写入
+	if err = os.Truncate(idxFileName, NeedleMapEntrySize); err != nil {
+		t.Fatal(err)
+	}
+	// 破坏第一个 needle 的数据
+	f, err := os.OpenFile(datFileName, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte("X"), int64(offsets[0])+NeedleHeaderSize+4); err != nil {
+		t.Fatal(err)
+	}
+	// .dat 尾部写了一半的 needle
+	stat, _ := f.Stat()
+	if _, err = f.WriteAt(make([]byte, NeedleHeaderSize+3), stat.Size()); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	report, err := FsckVolume(dir, dir, "", 2, FsckOption{})
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	if report.Count(FsckCorrupt) != 1 || report.Count(FsckOrphan) != 2 || report.Count(FsckMissing) != 0 {
+		t.Fatalf("unexpected issues: %v", report.Issues)
+	}
+	if report.TornTailOffset != stat.Size() {
+		t.Fatalf("torn tail at %d, expected %d", report.TornTailOffset, stat.Size())
+	}
+
+	report, err = FsckVolume(dir, dir, "", 2, FsckOption{TruncateTornTail: true, RebuildIndex: true})
+	if err != nil {
+		t.Fatalf("fsck repair: %v", err)
+	}
+	if len(report.Repaired) != 2 {
+		t.Fatalf("unexpected repairs: %v", report.Repaired)
+	}
+
+	// 修复后只剩下无法恢复的损坏 needle
+	report, err = FsckVolume(dir, dir, "", 2, FsckOption{})
+	if err != nil {
+		t.Fatalf("fsck after repair: %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Type != FsckCorrupt || report.TornTailOffset >= 0 {
+		t.Fatalf("unexpected issues after repair: %v", report.Issues)
+	}
+
+	v, err = NewVolume(dir, dir, "", 2, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	for key := uint64(2); key <= 3; key++ {
+		n, err := v.ReadFile(needle.NewFileId(2, key, 1))
+		if err != nil || string(n.Data) != "hello fsck" {
+			t.Fatalf("read %d after repair: %v", key, err)
+		}
+	}
+	if _, err = v.ReadFile(needle.NewFileId(2, 1, 1)); err == nil {
+		t.Fatalf("corrupt needle readable after repair")
+	}
+}
+
+func writeFsckTestVolume(t *testing.T, dir string, id needle.VolumeId, count int) (offsets []int64, datFileName string) {
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", id, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	defer v.Close()
+	for key := 1; key <= count; key++ {
+		n := newTestNeedle("hello fsck")
+		n.Id, n.Cookie = NeedleId(key), 1
+		offset, _, _, err := v.WriteNeedle(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets = append(offsets, int64(offset))
+	}
+	return offsets, v.FileName(".dat")
+}
+
+// 文件中间 needle 头部的大小损坏，看起来和尾部写了一半一样，但 .idx 还有记录指向之后的 needle
+func TestFsckRefusesToTruncateMidFileCorruption(t *testing.T) {
+	dir := t.TempDir()
+	offsets, datFileName := writeFsckTestVolume(t, dir, 3, 3)
+	f, err := os.OpenFile(datFileName, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteAt([]byte{0x7f, 0xff, 0xff, 0xff}, offsets[0]+CookieSize+NeedleIdSize); err != nil {
+		t.Fatal(err)
+	}
+	stat, _ := f.Stat()
+	f.Close()
+
+	report, err := FsckVolume(dir, dir, "", 3, FsckOption{})
+	if err != nil {
+		t.Fatalf("fsck: %v", err)
+	}
+	if report.TornTailOffset != offsets[0] || !report.MidFileCorruption() || report.IndexedBeyondScan != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	for _, option := range []FsckOption{{TruncateTornTail: true}, {RebuildIndex: true}} {
+		if _, err = FsckVolume(dir, dir, "", 3, option); err == nil {
+			t.Fatalf("%+v should refuse to repair mid-file corruption", option)
+		}
+	}
+	if after, _ := os.Stat(datFileName); after.Size() != stat.Size() {
+		t.Fatalf(".dat truncated to %d without force", after.Size())
+	}
+
+	report, err = FsckVolume(dir, dir, "", 3, FsckOption{TruncateTornTail: true, Force: true})
+	if err != nil {
+		t.Fatalf("fsck with force: %v", err)
+	}
+	if after, _ := os.Stat(datFileName); after.Size() != offsets[0] || len(report.Repaired) != 1 {
+		t.Fatalf(".dat size %d after forced truncate, repaired %v", after.Size(), report.Repaired)
+	}
+}
+
+// 从 failAt 开始读取失败
+type failingReadFile struct {
+	backend.BackendStorageFile
+	failAt int64
+}
+
+func (f failingReadFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.failAt {
+		return 0, errors.New("input/output error")
+	}
+	return f.BackendStorageFile.ReadAt(p, off)
+}
+
+func TestFsckRecordsUnreadableNeedleHeader(t *testing.T) {
+	dir := t.TempDir()
+	offsets, datFileName := writeFsckTestVolume(t, dir, 4, 2)
+	datFile, err := os.Open(datFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	datBackend := backend.NewDiskFile(datFile)
+	defer datBackend.Close()
+	superBlock, err := super_block.ReadSuperBlock(datBackend)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report := &FsckReport{TornTailOffset: -1, UnreadableOffset: -1}
+	report.DatFileSize, _, _ = datBackend.GetStat()
+	scanned, err := scanVolumeData(failingReadFile{datBackend, offsets[1]}, superBlock, report)
+	if err != nil {
+		t.Fatalf("scan: %v", err)
+	}
+	if len(scanned) != 1 || report.NeedleCount != 1 || report.UnreadableOffset != offsets[1] || report.Count(FsckCorrupt) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+}
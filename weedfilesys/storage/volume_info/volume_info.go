@@ -0,0 +1,79 @@
+package volume_info
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// .vif 文件以 json 格式保存卷的 VolumeInfo：版本、原始 .dat 大小、过期时间、远程存储中的文件等
+
+// MaybeLoadVolumeInfo 加载 .vif 文件，文件不存在时 hasVolumeInfoFile 为 false 且不返回错误
+func MaybeLoadVolumeInfo(fileName string) (volumeInfo *volume_server_pb.VolumeInfo, hasRemoteFile bool, hasVolumeInfoFile bool, err error) {
+	volumeInfo = &volume_server_pb.VolumeInfo{}
+
+	glog.V(1).Infof("maybeLoadVolumeInfo checks %s", fileName)
+	if exists, canRead, _, _, _ := util.CheckFile(fileName); !exists || !canRead {
+		if !exists {
+			return
+		}
+		hasVolumeInfoFile = true
+		err = fmt.Errorf("cannot read %s", fileName)
+		return
+	}
+	hasVolumeInfoFile = true
+
+	glog.V(1).Infof("maybeLoadVolumeInfo reads %s", fileName)
+	fileData, readErr := os.ReadFile(fileName)
+	if readErr != nil {
+		glog.Warningf("fail to read %s : %v", fileName, readErr)
+		err = fmt.Errorf("fail to read %s : %v", fileName, readErr)
+		return
+	}
+	// 空文件当作没有配置
+	if len(fileData) == 0 {
+		return
+	}
+
+	glog.V(1).Infof("maybeLoadVolumeInfo Unmarshal volume info %v", fileName)
+	if err = protojson.Unmarshal(fileData, volumeInfo); err != nil {
+		glog.Warningf("unmarshal error: %v fileData: %s", err, fileData)
+		err = fmt.Errorf("unmarshal error: %v", err)
+		return
+	}
+
+	if len(volumeInfo.GetFiles()) == 0 {
+		return
+	}
+	hasRemoteFile = true
+	return
+}
+
+// SaveVolumeInfo 先写临时文件再改名，避免写一半时崩溃留下损坏的 .vif
+func SaveVolumeInfo(fileName string, volumeInfo *volume_server_pb.VolumeInfo) error {
+	if exists, _, canWrite, _, _ := util.CheckFile(fileName); exists && !canWrite {
+		return fmt.Errorf("failed to check %s not writable", fileName)
+	}
+
+	m := protojson.MarshalOptions{
+		EmitUnpopulated: true,
+		Indent:          "  ",
+	}
+	data, err := m.Marshal(volumeInfo)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %v", fileName, err)
+	}
+
+	tmpFileName := fileName + ".tmp"
+	if err = os.WriteFile(tmpFileName, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmpFileName, err)
+	}
+	if err = os.Rename(tmpFileName, fileName); err != nil {
+		return fmt.Errorf("failed to rename %s: %v", tmpFileName, err)
+	}
+	return nil
+}
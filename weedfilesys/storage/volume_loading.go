@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// 加载 .idx 时最多往回检查多少条记录
+const maxIndexEntriesToCheck = 10
+
+// 加载卷：打开或创建 .dat，读写超级块，检查并重放 .idx
+func (v *Volume) load(alsoLoadIndex bool, createDatIfMissing bool, preallocate int64) (err error) {
+	alreadyHasSuperBlock := false
+
+	if v.DataBackend != nil {
+		return fmt.Errorf("volume %d already loaded", v.Id)
+	}
+
+	if err = v.recoverCompaction(); err != nil {
+		return fmt.Errorf("recover volume %d compaction: %v", v.Id, err)
+	}
+
+	if err = v.maybeLoadVolumeInfo(); err != nil {
+		return fmt.Errorf("load volume %d info: %v", v.Id, err)
+	}
+	if v.memoryMapMaxSizeMb == 0 {
+		v.memoryMapMaxSizeMb = v.volumeInfo.MemoryMapMaxSizeMb
+	}
+
+	dataFileName := v.FileName(".dat")
+	if v.hasRemoteFile {
+		if err = v.loadRemoteFile(); err != nil {
+			return err
+		}
+		_, modifiedTime, _ := v.DataBackend.GetStat()
+		v.lastModifiedTsSeconds = uint64(modifiedTime.Unix())
+		alreadyHasSuperBlock = true
+	} else if exists, canRead, canWrite, modifiedTime, fileSize := util.CheckFile(dataFileName); exists {
+		if !canRead {
+			return fmt.Errorf("cannot read Volume Data file %s", dataFileName)
+		}
+		var dataFile *os.File
+		if canWrite {
+			dataFile, err = os.OpenFile(dataFileName, os.O_RDWR|os.O_CREATE, 0644)
+		} else {
+			glog.V(0).Infof("opening %s in READONLY mode", dataFileName)
+			dataFile, err = os.Open(dataFileName)
+			v.noWriteOrDelete = true
+		}
+		if err != nil {
+			return fmt.Errorf("cannot load Volume Data %s: %v", dataFileName, err)
+		}
+		if v.volumeInfo.ReadOnly {
+			// .vif 中保存的只读标记，比如从远程搬回本地的卷
+			v.noWriteOrDelete = true
+		}
+		v.lastModifiedTsSeconds = uint64(modifiedTime.Unix())
+		if fileSize >= super_block.SuperBlockSize {
+			alreadyHasSuperBlock = true
+		}
+		if canWrite {
+			v.DataBackend = backend.OpenVolumeFile(dataFile, v.memoryMapMaxSizeMb)
+		} else {
+			v.DataBackend = backend.NewDiskFile(dataFile)
+		}
+	} else {
+		if createDatIfMissing {
+			v.DataBackend, err = backend.CreateVolumeFile(dataFileName, preallocate, v.memoryMapMaxSizeMb)
+		} else {
+			return fmt.Errorf("volume data file %s does not exist", dataFileName)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot create Volume Data %s: %v", dataFileName, err)
+	}
+	if alreadyHasSuperBlock {
+		err = v.readSuperBlock()
+	} else {
+		if !v.SuperBlock.Initialized() {
+			return fmt.Errorf("volume %s not initialized", dataFileName)
+		}
+		err = v.maybeWriteSuperBlock()
+	}
+	if err != nil {
+		v.doClose()
+		return err
+	}
+	if v.memoryMapMaxSizeMb != v.volumeInfo.MemoryMapMaxSizeMb {
+		// 新建时指定的 mmap 大小记到 .vif，重新加载时仍以 mmap 打开
+		v.volumeInfo.MemoryMapMaxSizeMb = v.memoryMapMaxSizeMb
+		if err = v.saveVolumeInfo(); err != nil {
+			v.doClose()
+			return fmt.Errorf("save volume %d info: %v", v.Id, err)
+		}
+	}
+
+	if alsoLoadIndex {
+		if err = v.loadIndex(); err != nil {
+			v.doClose()
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Volume) loadIndex() (err error) {
+	indexFileName := v.FileName(".idx")
+	var indexFile *os.File
+	if v.noWriteOrDelete {
+		glog.V(0).Infof("open to read file %s", indexFileName)
+		if indexFile, err = os.OpenFile(indexFileName, os.O_RDONLY, 0644); err != nil {
+			return fmt.Errorf("cannot read Volume Index %s: %v", indexFileName, err)
+		}
+	} else {
+		glog.V(1).Infof("open to write file %s", indexFileName)
+		if indexFile, err = os.OpenFile(indexFileName, os.O_RDWR|os.O_CREATE, 0644); err != nil {
+			return fmt.Errorf("cannot write Volume Index %s: %v", indexFileName, err)
+		}
+		if err = v.checkIndexFile(indexFile); err != nil {
+			_ = indexFile.Close()
+			return fmt.Errorf("check volume index %s: %v", indexFileName, err)
+		}
+	}
+
+	indexBaseFileName := v.IndexFileName()
+	switch v.needleMapKind {
+	case NeedleMapInMemory:
+		glog.V(0).Infof("loading memory index %s to memory", indexFileName)
+		if v.nm, err = LoadCompactNeedleMapWithSnapshot(indexBaseFileName, indexFile); err != nil {
+			glog.V(0).Infof("loading index %s to memory error: %v", indexFileName, err)
+		}
+	case NeedleMapLevelDb:
+		glog.V(0).Infof("loading leveldb %s", indexBaseFileName+".ldb")
+		opts := &opt.Options{
+			BlockCacheCapacity: 2 * 1024 * 1024,
+			WriteBuffer:        1 * 1024 * 1024,
+		}
+		if v.nm, err = NewLevelDbNeedleMap(indexBaseFileName+".ldb", indexFile, opts); err != nil {
+			glog.V(0).Infof("loading leveldb %s error: %v", indexBaseFileName+".ldb", err)
+		}
+	case NeedleMapSortedFile:
+		glog.V(0).Infof("loading sorted index %s", indexBaseFileName+".sdx")
+		if v.nm, err = NewSortedFileNeedleMap(indexBaseFileName, indexFile); err != nil {
+			glog.V(0).Infof("loading sorted index %s error: %v", indexBaseFileName+".sdx", err)
+		}
+	default:
+		err = fmt.Errorf("unknown needle map kind %v", v.needleMapKind)
+	}
+	if err != nil {
+		_ = indexFile.Close()
+	}
+	return err
+}
+
+// 进程崩溃时 .idx 可能比 .dat 多出指向不完整 needle 的记录（先写 .dat 再写 .idx 时不会发生，但 .dat 可能被截断），
+// 从尾部往回检查，丢掉无法在 .dat 中找到完整 needle 的记录，同时恢复 lastAppendAtNs
+func (v *Volume) checkIndexFile(indexFile *os.File) error {
+	indexSize, err := truncateTornIndexEntry(indexFile)
+	if err != nil {
+		return err
+	}
+	if indexSize == 0 {
+		return nil
+	}
+
+	datFileSize, _, err := v.DataBackend.GetStat()
+	if err != nil {
+		return fmt.Errorf("get stat %s: %v", v.DataBackend.Name(), err)
+	}
+
+	entryCount := indexSize / NeedleMapEntrySize
+	healthyIndexSize := indexSize
+	for i := entryCount - 1; i >= 0 && i >= entryCount-maxIndexEntriesToCheck; i-- {
+		bytes := make([]byte, NeedleMapEntrySize)
+		if _, err = indexFile.ReadAt(bytes, i*NeedleMapEntrySize); err != nil {
+			return fmt.Errorf("read %s entry %d: %v", indexFile.Name(), i, err)
+		}
+		key, offset, size := idx.IdxFileEntry(bytes)
+		lastAppendAtNs, e := v.verifyNeedleAt(datFileSize, key, offset, size)
+		if e == nil {
+			if lastAppendAtNs > v.lastAppendAtNs {
+				v.lastAppendAtNs = lastAppendAtNs
+			}
+			break
+		}
+		glog.Warningf("volume %d index entry %d key %d: %v", v.Id, i, key, e)
+		healthyIndexSize = i * NeedleMapEntrySize
+	}
+
+	if healthyIndexSize < indexSize {
+		glog.Warningf("truncate %s from %d to %d", indexFile.Name(), indexSize, healthyIndexSize)
+		if err = indexFile.Truncate(healthyIndexSize); err != nil {
+			return fmt.Errorf("truncate %s: %v", indexFile.Name(), err)
+		}
+	}
+	return nil
+}
+
+// 检查索引记录指向的 needle 是否完整，返回其 AppendAtNs
+func (v *Volume) verifyNeedleAt(datFileSize int64, key NeedleId, offset Offset, size Size) (uint64, error) {
+	if offset.IsZero() {
+		return 0, nil
+	}
+	actualOffset := offset.ToActualOffset()
+	if size.IsDeleted() {
+		// 删除记录的 offset 指向追加的删除标记 needle
+		size = 0
+	}
+	if actualOffset+needle.GetActualSize(size, v.SuperBlock.Version) > datFileSize {
+		return 0, fmt.Errorf("needle at %d size %d exceeds data file size %d", actualOffset, size, datFileSize)
+	}
+	n := new(needle.Needle)
+	if err := n.ReadData(v.DataBackend, actualOffset, size, v.SuperBlock.Version); err != nil {
+		return 0, err
+	}
+	if n.Id != key {
+		return 0, fmt.Errorf("needle id %d does not match index key %d", n.Id, key)
+	}
+	return n.AppendAtNs, nil
+}
+
+func (v *Volume) maybeWriteSuperBlock() error {
+	datSize, _, e := v.DataBackend.GetStat()
+	if e != nil {
+		glog.V(0).Infof("failed to stat datafile %s: %v", v.DataBackend.Name(), e)
+		return e
+	}
+	if datSize == 0 {
+		v.SuperBlock.Version = needle.GetCurrentVersion()
+		_, e = v.DataBackend.WriteAt(v.SuperBlock.Bytes(), 0)
+		if e != nil && os.IsPermission(e) {
+			// read-only, but zero length - recreate it!
+			var dataFile *os.File
+			if dataFile, e = os.Create(v.DataBackend.Name()); e == nil {
+				v.DataBackend = backend.NewDiskFile(dataFile)
+				if _, e = v.DataBackend.WriteAt(v.SuperBlock.Bytes(), 0); e == nil {
+					v.noWriteOrDelete = false
+					v.noWriteCanDelete = false
+				}
+			}
+		}
+	}
+	return e
+}
+
+func (v *Volume) readSuperBlock() (err error) {
+	v.SuperBlock, err = super_block.ReadSuperBlock(v.DataBackend)
+	if v.SuperBlock.Version == 0 && err == nil {
+		err = fmt.Errorf("volume %s super block version 0", v.DataBackend.Name())
+	}
+	return err
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ReadNeedle 根据 n.Id 读取 needle，n.Cookie 非 0 时校验 cookie
+func (v *Volume) ReadNeedle(n *needle.Needle) (int, error) {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+
+	atomic.StoreInt64(&v.lastAccessTsSeconds, time.Now().Unix())
+	nv, err := v.locateNeedle(n.Id)
+	if err != nil {
+		return -1, err
+	}
+	if nv.Size == 0 {
+		return 0, nil
+	}
+
+	cookie := n.Cookie
+	err = n.ReadData(v.DataBackend, nv.Offset.ToActualOffset(), nv.Size, v.SuperBlock.Version)
+	if err = checkReadNeedle(n, cookie, err, time.Now()); err != nil {
+		if err == ErrorNotFound {
+			return -1, err
+		}
+		return 0, err
+	}
+	return int(n.DataSize), nil
+}
+
+// ReadNeedles 一次提交多个 needle 的读取，每个 needle 的结果和单独调用 ReadNeedle 相同
+func (v *Volume) ReadNeedles(needles []*needle.Needle) []error {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+
+	atomic.StoreInt64(&v.lastAccessTsSeconds, time.Now().Unix())
+	errs := make([]error, len(needles))
+	var requests []*needle.NeedleBlobRequest
+	var requested []int
+	for i, n := range needles {
+		nv, err := v.locateNeedle(n.Id)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		if nv.Size == 0 {
+			continue
+		}
+		requests = append(requests, &needle.NeedleBlobRequest{Offset: nv.Offset.ToActualOffset(), Size: nv.Size})
+		requested = append(requested, i)
+	}
+
+	version := v.SuperBlock.Version
+	needle.ReadNeedleBlobs(backend.DefaultBatchReader(), v.DataBackend, requests, version)
+	now := time.Now()
+	for j, req := range requests {
+		i, n := requested[j], needles[requested[j]]
+		if req.Err != nil {
+			errs[i] = req.Err
+			continue
+		}
+		cookie := n.Cookie
+		err := n.ReadBytes(req.Blob, req.Offset, req.Size, version)
+		if err == needle.ErrorSizeMismatch {
+			// 4 字节偏移的卷超过 32GB 后需要换算偏移，交给 ReadData 处理
+			err = n.ReadData(v.DataBackend, req.Offset, req.Size, version)
+		}
+		errs[i] = checkReadNeedle(n, cookie, err, now)
+	}
+	return errs
+}
+
+func (v *Volume) locateNeedle(id NeedleId) (*needle_map.NeedleValue, error) {
+	if v.nm == nil {
+		return nil, ErrorNotFound
+	}
+	nv, ok := v.nm.Get(id)
+	if !ok || nv.Offset.IsZero() {
+		return nil, ErrorNotFound
+	}
+	if nv.Size.IsDeleted() {
+		return nil, ErrorDeleted
+	}
+	return nv, nil
+}
+
+// 读取之后校验 cookie 和 TTL
+func checkReadNeedle(n *needle.Needle, cookie Cookie, readErr error, now time.Time) error {
+	if readErr != nil {
+		return readErr
+	}
+	if cookie != 0 && cookie != n.Cookie {
+		return ErrorCookieMismatch
+	}
+	if isNeedleExpired(n, now) {
+		return ErrorNotFound
+	}
+	return nil
+}
+
+// needle 带 TTL 时，从最后修改时间（没有时用追加时间）算起超过 TTL 即视为不存在
+func isNeedleExpired(n *needle.Needle, now time.Time) bool {
+	if !n.HasTtl() || n.Ttl == nil || n.Ttl.Minutes() == 0 {
+		return false
+	}
+	var writtenAt time.Time
+	switch {
+	case n.HasLastModifiedDate() && n.LastModified > 0:
+		writtenAt = time.Unix(int64(n.LastModified), 0)
+	case n.AppendAtNs > 0:
+		writtenAt = time.Unix(0, int64(n.AppendAtNs))
+	default:
+		return false
+	}
+	return !now.Before(writtenAt.Add(time.Duration(n.Ttl.Minutes()) * time.Minute))
+}
+
+// ReadFile 按文件 id 读取，cookie 必须匹配
+func (v *Volume) ReadFile(fid *needle.FileId) (*needle.Needle, error) {
+	if fid.VolumeId != v.Id {
+		return nil, fmt.Errorf("file id %s does not belong to volume %d", fid, v.Id)
+	}
+	n := &needle.Needle{Id: fid.Key, Cookie: fid.Cookie}
+	if _, err := v.ReadNeedle(n); err != nil {
+		return nil, err
+	}
+	// 文件 id 中 cookie 为 0 也必须和存储的一致
+	if n.Cookie != fid.Cookie {
+		return nil, ErrorCookieMismatch
+	}
+	return n, nil
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestVolumeReadNeedles(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 1, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	defer v.Close()
+	for key := uint64(1); key <= 200; key++ {
+		if _, _, err = v.WriteFile(needle.NewFileId(1, key, 7), newTestNeedle(fmt.Sprintf("data-%d", key))); err != nil {
+			t.Fatalf("write %d: %v", key, err)
+		}
+	}
+	if _, err = v.DeleteFile(needle.NewFileId(1, 5, 7)); err != nil {
+		t.Fatal(err)
+	}
+
+	var needles []*needle.Needle
+	for key := uint64(1); key <= 200; key++ {
+		needles = append(needles, &needle.Needle{Id: NeedleId(key), Cookie: 7})
+	}
+	needles[9].Cookie = 8                                         // cookie 不匹配
+	needles = append(needles, &needle.Needle{Id: 999, Cookie: 7}) // 不存在
+
+	errs := v.ReadNeedles(needles)
+	for i, n := range needles {
+		switch {
+		case n.Id == 5:
+			if !errors.Is(errs[i], ErrorDeleted) {
+				t.Fatalf("read deleted: %v", errs[i])
+			}
+		case n.Id == 10:
+			if !errors.Is(errs[i], ErrorCookieMismatch) {
+				t.Fatalf("read with wrong cookie: %v", errs[i])
+			}
+		case n.Id == 999:
+			if !errors.Is(errs[i], ErrorNotFound) {
+				t.Fatalf("read missing: %v", errs[i])
+			}
+		default:
+			if errs[i] != nil || string(n.Data) != fmt.Sprintf("data-%d", n.Id) {
+				t.Fatalf("read %d: %q %v", n.Id, n.Data, errs[i])
+			}
+			single := &needle.Needle{Id: n.Id, Cookie: 7}
+			if _, err = v.ReadNeedle(single); err != nil || string(single.Data) != string(n.Data) || single.AppendAtNs != n.AppendAtNs {
+				t.Fatalf("batch and single read of %d differ: %v", n.Id, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/backend/memory_map"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"errors"
+	"os"
+	"testing"
+)
+
+func newTestNeedle(data string) *needle.Needle {
+	n := &needle.Needle{Data: []byte(data)}
+	n.Checksum = needle.NewCRC(n.Data)
+	return n
+}
+
+var testNeedleMapKinds = []NeedleMapKind{NeedleMapInMemory, NeedleMapLevelDb, NeedleMapSortedFile}
+
+func TestVolumeWriteReadDeleteReload(t *testing.T) {
+	for _, kind := range testNeedleMapKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			testVolumeWriteReadDeleteReload(t, kind)
+		})
+	}
+}
+
+func testVolumeWriteReadDeleteReload(t *testing.T, kind NeedleMapKind) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 1, kind, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+
+	fid1 := needle.NewFileId(1, 1, 0x1234)
+	fid2 := needle.NewFileId(1, 2, 0x5678)
+	if _, _, err = v.WriteFile(fid1, newTestNeedle("hello")); err != nil {
+		t.Fatalf("write fid1: %v", err)
+	}
+	if _, _, err = v.WriteFile(fid2, newTestNeedle("world")); err != nil {
+		t.Fatalf("write fid2: %v", err)
+	}
+
+	// cookie 不一致时不能读，也不能覆盖
+	if _, err = v.ReadFile(needle.NewFileId(1, 1, 0x9999)); !errors.Is(err, ErrorCookieMismatch) {
+		t.Fatalf("read with wrong cookie: %v", err)
+	}
+	if _, _, err = v.WriteFile(needle.NewFileId(1, 1, 0x9999), newTestNeedle("evil")); !errors.Is(err, ErrorCookieMismatch) {
+		t.Fatalf("overwrite with wrong cookie: %v", err)
+	}
+	if _, err = v.DeleteFile(fid2); err != nil {
+		t.Fatalf("delete fid2: %v", err)
+	}
+	if _, err = v.ReadFile(fid2); !errors.Is(err, ErrorDeleted) {
+		t.Fatalf("read deleted: %v", err)
+	}
+	v.Close()
+
+	// 重新加载后索引从 .idx 恢复
+	v, err = NewVolume(dir, dir, "", 1, kind, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	n, err := v.ReadFile(fid1)
+	if err != nil {
+		t.Fatalf("read fid1 after reload: %v", err)
+	}
+	if string(n.Data) != "hello" {
+		t.Fatalf("unexpected data %q", n.Data)
+	}
+	if _, err = v.ReadFile(fid2); !errors.Is(err, ErrorDeleted) {
+		t.Fatalf("read deleted after reload: %v", err)
+	}
+	if v.FileCount() != 2 || v.DeletedCount() != 1 {
+		t.Fatalf("unexpected counts: files %d deleted %d", v.FileCount(), v.DeletedCount())
+	}
+}
+
+func TestVolumeTruncatesTornIndexEntry(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 2, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	fid := needle.NewFileId(2, 7, 1)
+	if _, _, err = v.WriteFile(fid, newTestNeedle("data")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	v.Close()
+
+	// 模拟写 .idx 时崩溃，尾部只写了半条记录
+	f, err := os.OpenFile(v.FileName(".idx"), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{1, 2, 3})
+	f.Close()
+
+	v, err = NewVolume(dir, dir, "", 2, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	if _, err = v.ReadFile(fid); err != nil {
+		t.Fatalf("read after reload: %v", err)
+	}
+}
+
+func TestVolumeMemoryMapReload(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 3, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 4)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	if _, ok := v.DataBackend.(*memory_map.MemoryMappedFile); !ok {
+		t.Fatalf("new volume backend %T, want memory mapped file", v.DataBackend)
+	}
+	fid1, fid2 := needle.NewFileId(3, 1, 1), needle.NewFileId(3, 2, 2)
+	for _, fid := range []*needle.FileId{fid1, fid2} {
+		if _, _, err = v.WriteFile(fid, newTestNeedle("mmap")); err != nil {
+			t.Fatalf("write %v: %v", fid, err)
+		}
+	}
+	if _, err = v.DeleteFile(fid2); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	v.Close()
+
+	// 重新加载时 mmap 大小从 .vif 读取
+	v, err = NewVolume(dir, dir, "", 3, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	if _, ok := v.DataBackend.(*memory_map.MemoryMappedFile); !ok {
+		t.Fatalf("reloaded backend %T, want memory mapped file", v.DataBackend)
+	}
+	if n, err := v.ReadFile(fid1); err != nil || string(n.Data) != "mmap" {
+		t.Fatalf("read after reload: %v", err)
+	}
+
+	// 压缩后的新 .dat 同样以 mmap 打开
+	if err = v.Compact2(0, 0, nil); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err = v.CommitCompact(); err != nil {
+		t.Fatalf("commit compact: %v", err)
+	}
+	if _, ok := v.DataBackend.(*memory_map.MemoryMappedFile); !ok {
+		t.Fatalf("compacted backend %T, want memory mapped file", v.DataBackend)
+	}
+	if n, err := v.ReadFile(fid1); err != nil || string(n.Data) != "mmap" {
+		t.Fatalf("read after compact: %v", err)
+	}
+}
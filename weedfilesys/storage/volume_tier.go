@@ -0,0 +1,372 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/volume_info"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// 分层存储：只读卷的 .dat 可以整体搬到远程存储，.idx 仍留在本地。
+// 远程文件记录在 .vif 的 VolumeInfo.Files 中，加载卷时只要 .vif 里有远程文件就从远程读取，本地 .dat 即使还在也不再使用。
+// 分层卷标记为可写后，追加的数据先暂存在本地 .stg 文件，再作为新的一段上传，见 backend.WriteBackRemoteFile
+
+// 加载 .vif，没有时保留默认的 VolumeInfo
+func (v *Volume) maybeLoadVolumeInfo() error {
+	volumeInfo, hasRemoteFile, _, err := volume_info.MaybeLoadVolumeInfo(v.FileName(".vif"))
+	if err != nil {
+		return err
+	}
+	v.volumeInfo, v.hasRemoteFile = volumeInfo, hasRemoteFile
+	return nil
+}
+
+func (v *Volume) saveVolumeInfo() error {
+	if v.volumeInfo.Version == 0 {
+		v.volumeInfo.Version = uint32(v.SuperBlock.Version)
+	}
+	return volume_info.SaveVolumeInfo(v.FileName(".vif"), v.volumeInfo)
+}
+
+func (v *Volume) HasRemoteFile() bool {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	return v.hasRemoteFile
+}
+
+// RemoteStorageNameKey 远程存储的名称（类型.id）和文件 key，没有远程文件时为空
+func (v *Volume) RemoteStorageNameKey() (storageName, storageKey string) {
+	v.dataFileAccessLock.RLock()
+	defer v.dataFileAccessLock.RUnlock()
+	return v.remoteStorageNameKey()
+}
+
+func (v *Volume) remoteStorageNameKey() (storageName, storageKey string) {
+	if v.volumeInfo == nil || len(v.volumeInfo.Files) == 0 {
+		return
+	}
+	remoteFile := v.volumeInfo.Files[0]
+	return remoteFile.BackendType + "." + remoteFile.BackendId, remoteFile.Key
+}
+
+// 打开 .vif 中记录的远程文件，读取经过块缓存，追加先写到本地的 .stg 暂存文件
+func (v *Volume) loadRemoteFile() error {
+	storageName, _ := v.remoteStorageNameKey()
+	remoteFile, err := backend.NewWriteBackRemoteFile(storageName, v.volumeInfo.Files, v.FileName(".stg"), backend.DefaultWriteBackOption, v.saveRemoteFiles)
+	if err != nil {
+		return fmt.Errorf("open volume %d remote file: %v", v.Id, err)
+	}
+	v.DataBackend = remoteFile
+	v.noWriteOrDelete = v.volumeInfo.ReadOnly
+	return nil
+}
+
+// 暂存数据上传为新的一段后记录到 .vif，调用方持有写锁
+func (v *Volume) saveRemoteFiles(files []*volume_server_pb.RemoteFile) error {
+	oldFiles := v.volumeInfo.Files
+	v.volumeInfo.Files = files
+	if err := v.saveVolumeInfo(); err != nil {
+		v.volumeInfo.Files = oldFiles
+		return err
+	}
+	return nil
+}
+
+// FlushRemoteStaging 把分层卷暂存的追加数据上传到远程，本地卷直接返回
+func (v *Volume) FlushRemoteStaging() error {
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	return v.flushRemoteStaging()
+}
+
+func (v *Volume) flushRemoteStaging() error {
+	if remoteFile, ok := v.DataBackend.(*backend.WriteBackRemoteFile); ok {
+		return remoteFile.Flush()
+	}
+	return nil
+}
+
+func (v *Volume) discardRemoteStaging() {
+	if remoteFile, ok := v.DataBackend.(*backend.WriteBackRemoteFile); ok {
+		if err := remoteFile.DiscardStaging(); err != nil {
+			glog.Warningf("discard volume %d staging: %v", v.Id, err)
+		}
+	}
+}
+
+// 只读标记保存在 .vif 中，重新加载后保持；改为可写时需要以读写方式重新打开 .idx
+func (v *Volume) markPersistentReadOnly(readOnly bool) error {
+	if v.volumeInfo.ReadOnly == readOnly {
+		v.noWriteOrDelete = readOnly
+		return nil
+	}
+	v.volumeInfo.ReadOnly = readOnly
+	if err := v.saveVolumeInfo(); err != nil {
+		v.volumeInfo.ReadOnly = !readOnly
+		return fmt.Errorf("save volume %d info: %v", v.Id, err)
+	}
+	if readOnly {
+		v.noWriteOrDelete = true
+		return nil
+	}
+	return v.reload()
+}
+
+// MoveDatToRemote 把只读卷的 .dat 上传到 backendName（如 s3.default）指定的远程存储，
+// 记录到 .vif 后改为从远程读取；keepLocalDatFile 为 false 时删除本地 .dat
+func (v *Volume) MoveDatToRemote(backendName string, keepLocalDatFile bool, fn func(progressed int64, percentage float32) error) error {
+	if fn == nil {
+		fn = noProgress
+	}
+	backendType, backendId := backend.BackendNameToTypeId(backendName)
+	backendStorage, found := backend.GetBackendStorage(backendType + "." + backendId)
+	if !found {
+		return fmt.Errorf("remote storage %s not configured", backendName)
+	}
+
+	// 上传期间持读锁：读取照常进行，写入和删除会等待，保证上传的是完整的 .dat
+	v.dataFileAccessLock.RLock()
+	if err := v.checkTierable(); err != nil {
+		v.dataFileAccessLock.RUnlock()
+		return err
+	}
+	if err := v.DataBackend.Sync(); err != nil {
+		v.dataFileAccessLock.RUnlock()
+		return fmt.Errorf("sync volume %d: %v", v.Id, err)
+	}
+	datFileName := v.FileName(".dat")
+	key, size, modTime, err := uploadDatFile(backendStorage, datFileName, fn)
+	v.dataFileAccessLock.RUnlock()
+	if err != nil {
+		return fmt.Errorf("upload volume %d to %s: %v", v.Id, backendName, err)
+	}
+
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if err = v.checkTierable(); err != nil {
+		backendStorage.DeleteFile(key)
+		return err
+	}
+	if datSize, _, _ := v.DataBackend.GetStat(); datSize != size {
+		// 上传期间卷被改动过
+		backendStorage.DeleteFile(key)
+		return fmt.Errorf("volume %d changed during upload: %d != %d", v.Id, datSize, size)
+	}
+
+	// 搬到远程的都是只读的冷卷，需要接受写入时再标记为可写
+	v.volumeInfo.ReadOnly = true
+	v.volumeInfo.Files = []*volume_server_pb.RemoteFile{{
+		BackendType:  backendType,
+		BackendId:    backendId,
+		Key:          key,
+		FileSize:     uint64(size),
+		ModifiedTime: uint64(modTime.Unix()),
+		Extension:    ".dat",
+	}}
+	if err = v.saveVolumeInfo(); err != nil {
+		v.volumeInfo.Files, v.volumeInfo.ReadOnly = nil, false
+		backendStorage.DeleteFile(key)
+		return fmt.Errorf("save volume %d info: %v", v.Id, err)
+	}
+	glog.V(0).Infof("volume %d moved to %s as %s, %d bytes", v.Id, backendName, key, size)
+
+	// .vif 已经指向远程文件，从这里开始重新加载即可切换过去
+	if err = v.reload(); err != nil {
+		return fmt.Errorf("reload volume %d from remote: %v", v.Id, err)
+	}
+	if !keepLocalDatFile {
+		if err = os.Remove(datFileName); err != nil {
+			glog.Warningf("remove local %s: %v", datFileName, err)
+		}
+	}
+	return nil
+}
+
+func noProgress(progressed int64, percentage float32) error {
+	return nil
+}
+
+func (v *Volume) checkTierable() error {
+	switch {
+	case v.DataBackend == nil:
+		return fmt.Errorf("volume %d is not loaded", v.Id)
+	case v.hasRemoteFile:
+		return fmt.Errorf("volume %d is already on remote storage", v.Id)
+	case !v.noWriteOrDelete && !v.noWriteCanDelete:
+		return fmt.Errorf("volume %d is writable, mark it read only first", v.Id)
+	case v.isCompacting:
+		return fmt.Errorf("volume %d is compacting", v.Id)
+	}
+	return nil
+}
+
+func uploadDatFile(backendStorage backend.BackendStorage, datFileName string, fn func(progressed int64, percentage float32) error) (key string, size int64, modTime time.Time, err error) {
+	datFile, err := os.Open(datFileName)
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	defer datFile.Close()
+	stat, err := datFile.Stat()
+	if err != nil {
+		return "", 0, time.Time{}, err
+	}
+	if key, size, err = backendStorage.CopyFile(datFile, fn); err != nil {
+		return "", 0, time.Time{}, err
+	}
+	if size != stat.Size() {
+		backendStorage.DeleteFile(key)
+		return "", 0, time.Time{}, fmt.Errorf("uploaded %d bytes, expected %d", size, stat.Size())
+	}
+	return key, size, stat.ModTime(), nil
+}
+
+// MoveDatFromRemote 把远程的 .dat 下载回本地，恢复为本地卷；keepRemoteDatFile 为 false 时删除远程文件
+func (v *Volume) MoveDatFromRemote(keepRemoteDatFile bool, fn func(progressed int64, percentage float32) error) error {
+	if fn == nil {
+		fn = noProgress
+	}
+	// 先把暂存的追加上传，远程的段就是完整的 .dat
+	v.dataFileAccessLock.Lock()
+	if !v.hasRemoteFile {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("volume %d is not on remote storage", v.Id)
+	}
+	if err := v.flushRemoteStaging(); err != nil {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("flush volume %d staging: %v", v.Id, err)
+	}
+	storageName, _ := v.remoteStorageNameKey()
+	files := v.volumeInfo.Files
+	v.dataFileAccessLock.Unlock()
+
+	backendStorage, found := backend.GetBackendStorage(storageName)
+	if !found {
+		return fmt.Errorf("remote storage %s not configured", storageName)
+	}
+
+	// 先下载到临时文件，.vif 仍指向远程，中途崩溃不影响卷的加载
+	datFileName := v.FileName(".dat")
+	tmpFileName := datFileName + ".tmp"
+	size, err := downloadRemoteFiles(backendStorage, files, tmpFileName, fn)
+	if err != nil {
+		os.Remove(tmpFileName)
+		return fmt.Errorf("download volume %d from %s: %v", v.Id, storageName, err)
+	}
+
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if name, _ := v.remoteStorageNameKey(); name != storageName || len(v.volumeInfo.Files) < len(files) {
+		os.Remove(tmpFileName)
+		return fmt.Errorf("volume %d remote files changed during download", v.Id)
+	}
+	// 下载期间写入的数据从当前的数据文件补齐
+	if err = v.appendMissingData(tmpFileName, size); err != nil {
+		os.Remove(tmpFileName)
+		return fmt.Errorf("catch up volume %d: %v", v.Id, err)
+	}
+	if err = os.Rename(tmpFileName, datFileName); err != nil {
+		os.Remove(tmpFileName)
+		return fmt.Errorf("rename %s: %v", tmpFileName, err)
+	}
+	// 仍保持只读，只读标记留在 .vif 中，重新加载和重启后都不会变成可写，需要时再标记为可写
+	remoteFiles, readOnly := v.volumeInfo.Files, v.volumeInfo.ReadOnly
+	v.volumeInfo.Files, v.volumeInfo.ReadOnly = nil, true
+	if err = v.saveVolumeInfo(); err != nil {
+		v.volumeInfo.Files, v.volumeInfo.ReadOnly = remoteFiles, readOnly
+		return fmt.Errorf("save volume %d info: %v", v.Id, err)
+	}
+	glog.V(0).Infof("volume %d moved back from %s, %d files, %d bytes", v.Id, storageName, len(remoteFiles), size)
+
+	// 暂存数据已经补进本地 .dat，关闭时不再上传
+	v.discardRemoteStaging()
+	if err = v.reload(); err != nil {
+		return fmt.Errorf("reload volume %d: %v", v.Id, err)
+	}
+	if !keepRemoteDatFile {
+		for _, remoteFile := range remoteFiles {
+			if err = backendStorage.DeleteFile(remoteFile.Key); err != nil {
+				glog.Warningf("delete remote %s %s: %v", storageName, remoteFile.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// 按顺序下载各段拼成完整的 .dat
+func downloadRemoteFiles(backendStorage backend.BackendStorage, files []*volume_server_pb.RemoteFile, fileName string, fn func(progressed int64, percentage float32) error) (size int64, err error) {
+	var total int64
+	for _, remoteFile := range files {
+		total += int64(remoteFile.FileSize)
+	}
+	if len(files) == 1 {
+		if size, err = backendStorage.DownloadFile(fileName, files[0].Key, fn); err == nil && size != total {
+			err = fmt.Errorf("downloaded %d bytes, expected %d", size, total)
+		}
+		return
+	}
+
+	dst, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	partFileName := fileName + ".part"
+	defer os.Remove(partFileName)
+	for _, remoteFile := range files {
+		base := size
+		partSize, err := backendStorage.DownloadFile(partFileName, remoteFile.Key, func(progressed int64, percentage float32) error {
+			return fn(base+progressed, float32(base+progressed)*100/float32(total))
+		})
+		if err != nil {
+			return 0, fmt.Errorf("download %s: %v", remoteFile.Key, err)
+		}
+		if partSize != int64(remoteFile.FileSize) {
+			return 0, fmt.Errorf("downloaded %s %d bytes, expected %d", remoteFile.Key, partSize, remoteFile.FileSize)
+		}
+		part, err := os.Open(partFileName)
+		if err != nil {
+			return 0, err
+		}
+		_, err = io.Copy(dst, part)
+		part.Close()
+		if err != nil {
+			return 0, err
+		}
+		size += partSize
+	}
+	return size, dst.Sync()
+}
+
+// 把数据文件中 size 之后的部分追加到 fileName，调用方持有写锁
+func (v *Volume) appendMissingData(fileName string, size int64) error {
+	datSize, _, err := v.DataBackend.GetStat()
+	if err != nil {
+		return err
+	}
+	if datSize < size {
+		return fmt.Errorf("volume size %d is less than downloaded %d", datSize, size)
+	}
+	if datSize == size {
+		return nil
+	}
+	dst, err := os.OpenFile(fileName, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	if _, err = io.Copy(dst, io.NewSectionReader(v.DataBackend, size, datSize-size)); err != nil {
+		return err
+	}
+	return dst.Sync()
+}
+
+// 按当前的 .vif 重新打开数据文件和索引，调用方持有写锁
+func (v *Volume) reload() error {
+	v.doClose()
+	v.noWriteOrDelete, v.noWriteCanDelete = false, false
+	return v.load(true, false, 0)
+}
@@ -0,0 +1,329 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/backend"
+	_ "cayoyibackend/weedfilesys/storage/backend/local_backend"
+	_ "cayoyibackend/weedfilesys/storage/backend/s3_backend"
+	"cayoyibackend/weedfilesys/storage/backend/s3_backend/fake_s3"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// 按配置创建远程存储并注册到 BackendStorages，测试结束后移除
+func registerTestBackend(t *testing.T, name string, properties map[string]string) backend.BackendStorage {
+	backendType, backendId := backend.BackendNameToTypeId(name)
+	storage, err := backend.BackendStorageFactories[backend.StorageType(backendType)].BuildStorage(fake_s3.Properties(properties), "", backendId)
+	if err != nil {
+		t.Fatalf("build %s: %v", name, err)
+	}
+	backend.BackendStorages[name] = storage
+	t.Cleanup(func() { delete(backend.BackendStorages, name) })
+	return storage
+}
+
+func TestVolumeMoveDatToRemoteAndBack(t *testing.T) {
+	remoteDir := t.TempDir()
+	registerTestBackend(t, "local.tier", map[string]string{"dir": remoteDir})
+	dir := t.TempDir()
+	v := newTestTtlVolume(t, dir, 1, "")
+	defer v.Close()
+
+	fid1 := writeAgedFile(t, v, 1, 0)
+	fid2 := writeAgedFile(t, v, 2, 0)
+
+	// 可写卷不能分层
+	if err := v.MoveDatToRemote("local.tier", false, nil); err == nil {
+		t.Fatalf("expect writable volume rejected")
+	}
+	v.dataFileAccessLock.Lock()
+	v.noWriteOrDelete = true
+	v.dataFileAccessLock.Unlock()
+
+	var progressed int64
+	if err := v.MoveDatToRemote("local.tier", false, func(p int64, _ float32) error {
+		progressed = p
+		return nil
+	}); err != nil {
+		t.Fatalf("move to remote: %v", err)
+	}
+	if _, err := os.Stat(v.FileName(".dat")); !os.IsNotExist(err) {
+		t.Fatalf("local dat should be removed: %v", err)
+	}
+	storageName, storageKey := v.RemoteStorageNameKey()
+	if !v.HasRemoteFile() || storageName != "local.tier" || storageKey == "" {
+		t.Fatalf("remote file %s %s", storageName, storageKey)
+	}
+	if stat, err := os.Stat(filepath.Join(remoteDir, storageKey)); err != nil || stat.Size() != progressed {
+		t.Fatalf("remote dat %v, progressed %d", err, progressed)
+	}
+	if _, err := v.ReadFile(fid1); err != nil {
+		t.Fatalf("read from remote: %v", err)
+	}
+	if _, _, err := v.WriteFile(needle.NewFileId(1, 3, 1), newTestNeedle("new")); err == nil {
+		t.Fatalf("expect remote volume read only")
+	}
+	v.Close()
+
+	// 重新加载时按 .vif 从远程读取
+	v, err := NewVolume(dir, dir, "", 1, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if !v.HasRemoteFile() || !v.IsReadOnly() {
+		t.Fatalf("reloaded volume should be remote and read only")
+	}
+	if _, err = v.ReadFile(fid2); err != nil {
+		t.Fatalf("read after reload: %v", err)
+	}
+	_, message := v.ToVolumeInformationMessage()
+	if message.RemoteStorageName != "local.tier" || message.RemoteStorageKey != storageKey {
+		t.Fatalf("heartbeat remote %s %s", message.RemoteStorageName, message.RemoteStorageKey)
+	}
+
+	if err = v.MoveDatFromRemote(false, nil); err != nil {
+		t.Fatalf("move from remote: %v", err)
+	}
+	if v.HasRemoteFile() || !v.IsReadOnly() {
+		t.Fatalf("volume should be local and still read only")
+	}
+	if _, err = os.Stat(filepath.Join(remoteDir, storageKey)); !os.IsNotExist(err) {
+		t.Fatalf("remote dat should be removed: %v", err)
+	}
+	if _, err = v.ReadFile(fid1); err != nil {
+		t.Fatalf("read local: %v", err)
+	}
+	v.Close()
+
+	// 只读标记保存在 .vif 中，重新加载后仍然只读
+	if v, err = NewVolume(dir, dir, "", 1, NeedleMapInMemory, nil, nil, 0, 0); err != nil {
+		t.Fatalf("reload local: %v", err)
+	}
+	defer v.Close()
+	if v.HasRemoteFile() || !v.IsReadOnly() {
+		t.Fatalf("reloaded local volume should be read only")
+	}
+	v.dataFileAccessLock.Lock()
+	err = v.markPersistentReadOnly(false)
+	v.dataFileAccessLock.Unlock()
+	if err != nil {
+		t.Fatalf("mark writable: %v", err)
+	}
+	if _, _, err = v.WriteFile(needle.NewFileId(1, 3, 1), newTestNeedle("new")); err != nil {
+		t.Fatalf("write after marked writable: %v", err)
+	}
+}
+
+// 本地 .dat 已删除的分层卷在重启后按 .vif 从远程加载
+func TestStoreLoadTieredVolumeAfterRestart(t *testing.T) {
+	registerTestBackend(t, "local.tier", map[string]string{"dir": t.TempDir()})
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{8}, []types.DiskType{types.HardDriveType})
+	if err := s.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	drainStoreChans(s)
+	fid := writeAgedFile(t, s.GetVolume(1), 1, 0)
+	if err := s.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.MoveVolumeDatToRemote(1, "local.tier", false, nil); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	s.Close()
+	if util.FileExists(filepath.Join(dir, "col_1.dat")) {
+		t.Fatalf("local dat should be removed")
+	}
+
+	s = newTestStore(t, []string{dir}, []int32{8}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	v := s.GetVolume(1)
+	if v == nil || !v.HasRemoteFile() || !v.IsReadOnly() {
+		t.Fatalf("tiered volume should be loaded from remote")
+	}
+	if _, err := v.ReadFile(fid); err != nil {
+		t.Fatalf("read after restart: %v", err)
+	}
+}
+
+func TestStorePickVolumesToTier(t *testing.T) {
+	registerTestBackend(t, "local.tier", map[string]string{"dir": t.TempDir()})
+	s := newTestStore(t, []string{t.TempDir()}, []int32{8}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	for vid := needle.VolumeId(1); vid <= 4; vid++ {
+		if err := s.AddVolume(vid, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+			t.Fatalf("add volume %d: %v", vid, err)
+		}
+		drainStoreChans(s)
+		writeAgedFile(t, s.GetVolume(vid), 1, 0)
+	}
+	now := time.Now().Add(48 * time.Hour)
+	// 1 仍可写；2 最近读取过；3、4 都满足，4 更早访问
+	for vid := needle.VolumeId(2); vid <= 4; vid++ {
+		if err := s.MarkVolumeReadonly(vid, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s.GetVolume(2).lastAccessTsSeconds = now.Add(-time.Minute).Unix()
+	s.GetVolume(3).lastAccessTsSeconds = now.Add(-2 * time.Hour).Unix()
+	s.GetVolume(4).lastAccessTsSeconds = now.Add(-3 * time.Hour).Unix()
+
+	policy := TierPolicy{BackendName: "local.tier", MinAge: 24 * time.Hour, MinIdle: time.Hour}
+	vids := s.PickVolumesToTier(policy, now)
+	if len(vids) != 2 || vids[0] != 4 || vids[1] != 3 {
+		t.Fatalf("picked %v", vids)
+	}
+	policy.MaxVolumes = 1
+	if vids = s.PickVolumesToTier(policy, now); len(vids) != 1 || vids[0] != 4 {
+		t.Fatalf("picked %v", vids)
+	}
+	// 修改时间不够久的不挑
+	if vids = s.PickVolumesToTier(policy, time.Now()); len(vids) != 0 {
+		t.Fatalf("picked young volumes %v", vids)
+	}
+
+	if err := s.MoveVolumeDatToRemote(4, "local.tier", false, nil); err != nil {
+		t.Fatalf("move: %v", err)
+	}
+	if vids = s.PickVolumesToTier(policy, now); len(vids) != 1 || vids[0] != 3 {
+		t.Fatalf("remote volume picked again: %v", vids)
+	}
+	// 分层卷的只读标记保存在 .vif 中
+	if v := s.GetVolume(4); !v.IsReadOnly() || !v.volumeInfo.ReadOnly {
+		t.Fatalf("tiered volume should be read only")
+	}
+	if err := s.MarkVolumeWritable(4); err != nil {
+		t.Fatalf("mark remote volume writable: %v", err)
+	}
+	if v := s.GetVolume(4); v.IsReadOnly() || v.volumeInfo.ReadOnly {
+		t.Fatalf("tiered volume should be writable")
+	}
+}
+
+func TestTieredVolumeWritesAndDeletes(t *testing.T) {
+	remoteDir := t.TempDir()
+	registerTestBackend(t, "local.tier", map[string]string{"dir": remoteDir})
+	dir := t.TempDir()
+	v := newTestTtlVolume(t, dir, 1, "")
+	fid1 := writeAgedFile(t, v, 1, 0)
+	fid2 := writeAgedFile(t, v, 2, 0)
+	v.dataFileAccessLock.Lock()
+	v.noWriteOrDelete = true
+	v.dataFileAccessLock.Unlock()
+	if err := v.MoveDatToRemote("local.tier", false, nil); err != nil {
+		t.Fatalf("move to remote: %v", err)
+	}
+
+	v.dataFileAccessLock.Lock()
+	err := v.markPersistentReadOnly(false)
+	v.dataFileAccessLock.Unlock()
+	if err != nil {
+		t.Fatalf("mark writable: %v", err)
+	}
+	// 写入和删除先进入本地暂存文件
+	fid3 := writeAgedFile(t, v, 3, 0)
+	if _, err = v.DeleteFile(fid1); err != nil {
+		t.Fatalf("delete from tiered volume: %v", err)
+	}
+	if _, err = os.Stat(v.FileName(".stg")); err != nil {
+		t.Fatalf("staging file: %v", err)
+	}
+	if _, err = v.ReadFile(fid3); err != nil {
+		t.Fatalf("read staged: %v", err)
+	}
+	if err = v.FlushRemoteStaging(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	if len(v.volumeInfo.Files) != 2 || v.volumeInfo.Files[1].Offset != v.volumeInfo.Files[0].FileSize {
+		t.Fatalf("remote files %v", v.volumeInfo.Files)
+	}
+
+	// 关闭时上传剩余的暂存数据，重新加载后仍可写
+	fid4 := writeAgedFile(t, v, 4, 0)
+	v.Close()
+	if _, err = os.Stat(v.FileName(".stg")); !os.IsNotExist(err) {
+		t.Fatalf("staging file should be removed after close: %v", err)
+	}
+	v, err = NewVolume(dir, dir, "", 1, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	defer v.Close()
+	if v.IsReadOnly() || len(v.volumeInfo.Files) != 3 {
+		t.Fatalf("read only %v, %d remote files", v.IsReadOnly(), len(v.volumeInfo.Files))
+	}
+	for _, fid := range []*needle.FileId{fid2, fid3, fid4} {
+		if _, err = v.ReadFile(fid); err != nil {
+			t.Fatalf("read %v after reload: %v", fid, err)
+		}
+	}
+	if _, err = v.ReadFile(fid1); !errors.Is(err, ErrorDeleted) {
+		t.Fatalf("read deleted: %v", err)
+	}
+	if err = v.Compact2(0, 0, nil); err == nil {
+		t.Fatalf("expect compaction of remote volume rejected")
+	}
+
+	// 搬回本地时拼接所有段，并补上暂存数据
+	fid5 := writeAgedFile(t, v, 5, 0)
+	if err = v.MoveDatFromRemote(false, nil); err != nil {
+		t.Fatalf("move from remote: %v", err)
+	}
+	if entries, _ := os.ReadDir(remoteDir); len(entries) != 0 {
+		t.Fatalf("%d remote files left", len(entries))
+	}
+	for _, fid := range []*needle.FileId{fid2, fid3, fid4, fid5} {
+		if _, err = v.ReadFile(fid); err != nil {
+			t.Fatalf("read %v from local: %v", fid, err)
+		}
+	}
+}
+
+func TestVolumeTierToFakeS3(t *testing.T) {
+	fake := fake_s3.NewServer()
+	defer fake.Close()
+	registerTestBackend(t, "s3.tier", fake.Properties("tier"))
+
+	dir := t.TempDir()
+	v := newTestTtlVolume(t, dir, 1, "")
+	defer v.Close()
+	var fids []*needle.FileId
+	for key := uint64(1); key <= 20; key++ {
+		fids = append(fids, writeAgedFile(t, v, key, 0))
+	}
+	v.dataFileAccessLock.Lock()
+	v.noWriteOrDelete = true
+	v.dataFileAccessLock.Unlock()
+
+	gets := fake.Gets()
+	if err := v.MoveDatToRemote("s3.tier", false, nil); err != nil {
+		t.Fatalf("move to s3: %v", err)
+	}
+	if keys := fake.Keys("tier"); len(keys) != 1 {
+		t.Fatalf("objects %v", keys)
+	}
+	for _, fid := range fids {
+		if _, err := v.ReadFile(fid); err != nil {
+			t.Fatalf("read %v from s3: %v", fid, err)
+		}
+	}
+	// 整个卷在一个缓存块内，加载时读超级块回源一次，之后的读取都命中缓存
+	if n := fake.Gets() - gets; n != 1 {
+		t.Fatalf("%d GETs for reads", n)
+	}
+
+	if err := v.MoveDatFromRemote(false, nil); err != nil {
+		t.Fatalf("move from s3: %v", err)
+	}
+	if keys := fake.Keys("tier"); len(keys) != 0 {
+		t.Fatalf("objects left %v", keys)
+	}
+	if _, err := v.ReadFile(fids[0]); err != nil {
+		t.Fatalf("read local: %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestTtlVolume(t *testing.T, dir string, id needle.VolumeId, ttlString string) *Volume {
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	ttl, _ := needle.ReadTTL(ttlString)
+	v, err := NewVolume(dir, dir, "", id, NeedleMapInMemory, rp, ttl, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	return v
+}
+
+// 写入一个修改时间在 age 之前的文件
+func writeAgedFile(t *testing.T, v *Volume, key uint64, age time.Duration) *needle.FileId {
+	fid := needle.NewFileId(v.Id, key, 1)
+	n := newTestNeedle("ttl data")
+	n.LastModified = uint64(time.Now().Add(-age).Unix())
+	n.SetHasLastModifiedDate()
+	if _, _, err := v.WriteFile(fid, n); err != nil {
+		t.Fatalf("write %d: %v", key, err)
+	}
+	return fid
+}
+
+func TestVolumeTtlNeedleExpiry(t *testing.T) {
+	dir := t.TempDir()
+	v := newTestTtlVolume(t, dir, 1, "5m")
+	defer v.Close()
+
+	expired := writeAgedFile(t, v, 1, 10*time.Minute)
+	fresh := writeAgedFile(t, v, 2, time.Minute)
+
+	if _, err := v.ReadFile(expired); !errors.Is(err, ErrorNotFound) {
+		t.Fatalf("read expired: %v", err)
+	}
+	n, err := v.ReadFile(fresh)
+	if err != nil {
+		t.Fatalf("read fresh: %v", err)
+	}
+	// 没有指定 TTL 的文件沿用卷的 TTL
+	if !n.HasTtl() || n.Ttl.String() != "5m" {
+		t.Fatalf("needle ttl %v", n.Ttl)
+	}
+
+	// 压缩时丢弃过期的文件
+	if err = v.Compact2(0, 0, nil); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err = v.CommitCompact(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if nv, ok := v.nm.Get(expired.Key); ok && nv.Size.IsValid() {
+		t.Fatalf("expired needle kept after compaction")
+	}
+	if _, err = v.ReadFile(fresh); err != nil {
+		t.Fatalf("read fresh after compaction: %v", err)
+	}
+}
+
+func TestStoreDeletesExpiredTtlVolume(t *testing.T) {
+	dir := t.TempDir()
+	s := newTestStore(t, []string{dir}, []int32{3}, []types.DiskType{types.HardDriveType})
+	defer s.Close()
+	s.SetVolumeSizeLimit(1024 * 1024)
+
+	if err := s.AddVolume(1, "", "000", "5m", 0, 0, types.HardDriveType); err != nil {
+		t.Fatalf("add ttl volume: %v", err)
+	}
+	if err := s.AddVolume(2, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatalf("add volume: %v", err)
+	}
+	drainStoreChans(s)
+	for vid := needle.VolumeId(1); vid <= 2; vid++ {
+		writeAgedFile(t, s.GetVolume(vid), 1, time.Hour)
+	}
+
+	// 最后一次写入已经超过 TTL 加上删除延迟
+	v := s.GetVolume(1)
+	v.lastModifiedTsSeconds = uint64(time.Now().Add(-time.Hour).Unix())
+	datFileName := v.FileName(".dat")
+
+	hb := s.CollectHeartbeat()
+	if len(hb.Volumes) != 1 || hb.Volumes[0].Id != 2 {
+		t.Fatalf("unexpected heartbeat volumes %v", hb.Volumes)
+	}
+	if s.HasVolume(1) || util.FileExists(datFileName) {
+		t.Fatalf("expired volume not deleted")
+	}
+	if !s.HasVolume(2) {
+		t.Fatalf("volume without ttl deleted")
+	}
+}
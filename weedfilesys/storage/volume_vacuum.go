@@ -0,0 +1,439 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/backend"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/needle_map"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// 压缩（vacuum）分两步：
+//  1. Compact2 按 .idx 把存活的 needle 拷贝到 .cpd/.cpx，期间卷照常读写
+//  2. CommitCompact 把压缩期间新追加的记录补到 .cpd/.cpx，再替换 .dat/.idx 并重新加载
+//
+// 替换前先落盘 .cpc 提交标记：没有标记时原 .dat/.idx 完好，重启后丢弃 .cpd/.cpx；
+// 有标记时 .cpd/.cpx 已经完整，重启后继续完成替换
+
+// ProgressFunc 汇报已处理的字节数，返回 false 时中止压缩
+type ProgressFunc func(processed int64) bool
+
+// 垃圾比例：被删除或覆盖的字节数占写入总字节数的比例
+func (v *Volume) garbageLevel() float64 {
+	contentSize := v.ContentSize()
+	if contentSize == 0 {
+		return 0
+	}
+	deletedSize := v.DeletedSize()
+	if v.DeletedCount() > 0 && deletedSize == 0 {
+		// 删除记录缺少大小时，用 .dat 的实际大小估算
+		datFileSize, _, _ := v.FileStat()
+		if datFileSize <= contentSize+super_block.SuperBlockSize {
+			return 0
+		}
+		deletedSize = datFileSize - contentSize - super_block.SuperBlockSize
+		contentSize = datFileSize
+	}
+	return float64(deletedSize) / float64(contentSize)
+}
+
+// Compact2 根据 .idx 拷贝存活的 needle 生成 .cpd/.cpx，新超级块的压缩版本号加一
+func (v *Volume) Compact2(preallocate int64, compactionBytePerSecond int64, progressFn ProgressFunc) error {
+	v.dataFileAccessLock.Lock()
+	if v.isCompacting {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("volume %d is already compacting", v.Id)
+	}
+	if v.nm == nil || v.DataBackend == nil {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("volume %d is not loaded", v.Id)
+	}
+	if v.hasRemoteFile {
+		// 压缩结果写在本地，需要先搬回本地
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("volume %d is on remote storage", v.Id)
+	}
+	if err := v.nm.Sync(); err != nil {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("sync volume %d index: %v", v.Id, err)
+	}
+	if err := v.DataBackend.Sync(); err != nil {
+		v.dataFileAccessLock.Unlock()
+		return fmt.Errorf("sync volume %d data: %v", v.Id, err)
+	}
+	v.isCompacting = true
+	// 记录压缩开始时的 .idx 位置和版本号，提交时从这里补齐
+	v.lastCompactIndexOffset = v.nm.IndexFileSize()
+	v.lastCompactRevision = v.SuperBlock.CompactionRevision
+	superBlock := v.SuperBlock
+	datBackend := v.DataBackend
+	v.dataFileAccessLock.Unlock()
+
+	defer func() {
+		v.dataFileAccessLock.Lock()
+		v.isCompacting = false
+		v.dataFileAccessLock.Unlock()
+	}()
+
+	glog.V(3).Infof("Compacting volume %d ...", v.Id)
+	superBlock.CompactionRevision++
+	err := v.copyDataBasedOnIndexFile(datBackend, superBlock, v.FileName(".idx"), v.lastCompactIndexOffset,
+		v.FileName(".cpd"), v.FileName(".cpx"), preallocate, compactionBytePerSecond, progressFn)
+	if err != nil {
+		// 不完整的 .cpd/.cpx 不能被提交
+		v.removeCompactFiles()
+	}
+	return err
+}
+
+// 只读取 .idx 的前 indexSize 字节，之后追加的记录由 makeupDiff 处理
+func (v *Volume) copyDataBasedOnIndexFile(srcDat backend.BackendStorageFile, superBlock super_block.SuperBlock, srcIdxName string, indexSize uint64,
+	dstDatName, dstIdxName string, preallocate int64, compactionBytePerSecond int64, progressFn ProgressFunc) (err error) {
+	srcIdx, err := os.Open(srcIdxName)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", srcIdxName, err)
+	}
+	defer srcIdx.Close()
+
+	oldNm := needle_map.NewMemDb()
+	defer oldNm.Close()
+	if err = oldNm.LoadFromReaderAt(io.NewSectionReader(srcIdx, 0, int64(indexSize))); err != nil {
+		return fmt.Errorf("load %s: %v", srcIdxName, err)
+	}
+
+	dstDat, err := backend.CreateVolumeFile(dstDatName, preallocate, v.memoryMapMaxSizeMb)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", dstDatName, err)
+	}
+	defer dstDat.Close()
+	if _, err = dstDat.WriteAt(superBlock.Bytes(), 0); err != nil {
+		return fmt.Errorf("write super block to %s: %v", dstDatName, err)
+	}
+
+	newNm := needle_map.NewMemDb()
+	defer newNm.Close()
+
+	version := superBlock.Version
+	newOffset := int64(superBlock.BlockSize())
+	writeThrottler := util.NewWriteThrottler(compactionBytePerSecond)
+	now := time.Now()
+	var processed int64
+	err = oldNm.AscendingVisit(func(value needle_map.NeedleValue) error {
+		if !value.Size.IsValid() {
+			return nil
+		}
+		n, blob, err := readVerifiedNeedleBlob(srcDat, value.Key, value.Offset.ToActualOffset(), value.Size, version)
+		if err != nil {
+			return err
+		}
+		// 已过期的 TTL 文件不再拷贝，空间随压缩回收
+		if isNeedleExpired(n, now) {
+			return nil
+		}
+		if _, err = dstDat.WriteAt(blob, newOffset); err != nil {
+			return fmt.Errorf("write needle %d to %s: %v", value.Key, dstDat.Name(), err)
+		}
+		if err = newNm.Set(value.Key, ToOffset(newOffset), value.Size); err != nil {
+			return err
+		}
+		newOffset += int64(len(blob))
+		processed += int64(len(blob))
+		writeThrottler.MaybeSlowdown(int64(len(blob)))
+		if progressFn != nil && !progressFn(processed) {
+			return fmt.Errorf("interrupted")
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("compact volume %d: %v", v.Id, err)
+	}
+	if err = dstDat.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %v", dstDatName, err)
+	}
+	return newNm.SaveToIdx(dstIdxName)
+}
+
+// 读出完整的 needle 并校验，返回解析结果和原始字节
+func readVerifiedNeedleBlob(src backend.BackendStorageFile, key NeedleId, srcOffset int64, size Size, version needle.Version) (*needle.Needle, []byte, error) {
+	blob, err := needle.ReadNeedleBlob(src, srcOffset, size, version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read needle %d at %d: %v", key, srcOffset, err)
+	}
+	n := new(needle.Needle)
+	if err = n.ReadBytes(blob, srcOffset, size, version); err != nil {
+		return nil, nil, fmt.Errorf("parse needle %d at %d: %v", key, srcOffset, err)
+	}
+	if n.Id != key {
+		return nil, nil, fmt.Errorf("needle at %d has id %d, expected %d", srcOffset, n.Id, key)
+	}
+	return n, blob, nil
+}
+
+// 从 src 读出完整的 needle 原样写到 dst 的 dstOffset，AppendAtNs 等字段保持不变
+func copyNeedleBlob(src, dst backend.BackendStorageFile, key NeedleId, srcOffset int64, size Size, dstOffset int64, version needle.Version) ([]byte, error) {
+	_, blob, err := readVerifiedNeedleBlob(src, key, srcOffset, size, version)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = dst.WriteAt(blob, dstOffset); err != nil {
+		return nil, fmt.Errorf("write needle %d to %s: %v", key, dst.Name(), err)
+	}
+	return blob, nil
+}
+
+// CommitCompact 补齐压缩期间的写入和删除，用 .cpd/.cpx 替换 .dat/.idx 后重新加载
+func (v *Volume) CommitCompact() error {
+	glog.V(0).Infof("Committing volume %d vacuuming...", v.Id)
+
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+
+	if v.isCompacting {
+		return fmt.Errorf("volume %d is compacting", v.Id)
+	}
+	if !util.FileExists(v.FileName(".cpd")) || !util.FileExists(v.FileName(".cpx")) {
+		return fmt.Errorf("volume %d has no compacted files to commit", v.Id)
+	}
+	v.isCompacting = true
+	defer func() {
+		v.isCompacting = false
+	}()
+
+	lastAppendAtNs := v.lastAppendAtNs
+	v.doClose()
+
+	var commitErr error
+	if commitErr = v.makeupDiff(v.FileName(".cpd"), v.FileName(".cpx"), v.FileName(".dat"), v.FileName(".idx")); commitErr != nil {
+		// 补齐失败时原文件没有动过，丢弃压缩结果继续使用原卷
+		glog.Errorf("makeup diff for volume %d: %v", v.Id, commitErr)
+		v.removeCompactFiles()
+	} else if commitErr = v.replaceWithCompactFiles(); commitErr != nil {
+		glog.Errorf("replace volume %d with compacted files: %v", v.Id, commitErr)
+	}
+
+	if err := v.load(true, false, 0); err != nil {
+		return fmt.Errorf("reload volume %d after compaction: %v", v.Id, err)
+	}
+	if v.lastAppendAtNs < lastAppendAtNs {
+		v.lastAppendAtNs = lastAppendAtNs
+	}
+	return commitErr
+}
+
+// makeupDiff 把压缩开始后追加到 .idx 的记录对应的 needle 和删除标记补到 .cpd/.cpx
+func (v *Volume) makeupDiff(newDatFileName, newIdxFileName, oldDatFileName, oldIdxFileName string) (err error) {
+	oldIdxFile, err := os.Open(oldIdxFileName)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", oldIdxFileName, err)
+	}
+	defer oldIdxFile.Close()
+	oldIdxStat, err := oldIdxFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", oldIdxFileName, err)
+	}
+	indexSize := uint64(oldIdxStat.Size())
+	if indexSize < v.lastCompactIndexOffset {
+		return fmt.Errorf("index file %s shrank from %d to %d during compaction", oldIdxFileName, v.lastCompactIndexOffset, indexSize)
+	}
+	if (indexSize-v.lastCompactIndexOffset)%NeedleMapEntrySize != 0 {
+		return fmt.Errorf("index file %s size %d is not aligned", oldIdxFileName, indexSize)
+	}
+
+	oldDatFile, err := os.Open(oldDatFileName)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", oldDatFileName, err)
+	}
+	oldDatBackend := backend.NewDiskFile(oldDatFile)
+	defer oldDatBackend.Close()
+	oldSuperBlock, err := super_block.ReadSuperBlock(oldDatBackend)
+	if err != nil {
+		return fmt.Errorf("read super block %s: %v", oldDatFileName, err)
+	}
+	if oldSuperBlock.CompactionRevision != v.lastCompactRevision {
+		return fmt.Errorf("%s compaction revision %d, expected %d", oldDatFileName, oldSuperBlock.CompactionRevision, v.lastCompactRevision)
+	}
+
+	// 同一个 key 只需要保留最后一条记录
+	incrementedNm := needle_map.NewMemDb()
+	defer incrementedNm.Close()
+	err = idx.WalkIndexFile(oldIdxFile, v.lastCompactIndexOffset/NeedleMapEntrySize, func(key NeedleId, offset Offset, size Size) error {
+		return incrementedNm.Set(key, offset, size)
+	})
+	if err != nil {
+		return fmt.Errorf("walk %s: %v", oldIdxFileName, err)
+	}
+
+	newDatFile, err := os.OpenFile(newDatFileName, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", newDatFileName, err)
+	}
+	newDatBackend := backend.NewDiskFile(newDatFile)
+	defer newDatBackend.Close()
+	newSuperBlock, err := super_block.ReadSuperBlock(newDatBackend)
+	if err != nil {
+		return fmt.Errorf("read super block %s: %v", newDatFileName, err)
+	}
+	if newSuperBlock.CompactionRevision != oldSuperBlock.CompactionRevision+1 {
+		return fmt.Errorf("%s compaction revision %d, expected %d", newDatFileName, newSuperBlock.CompactionRevision, oldSuperBlock.CompactionRevision+1)
+	}
+
+	newIdxFile, err := os.OpenFile(newIdxFileName, os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("open %s: %v", newIdxFileName, err)
+	}
+	defer newIdxFile.Close()
+	newIdxStat, err := newIdxFile.Stat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", newIdxFileName, err)
+	}
+	newIdxOffset := newIdxStat.Size()
+
+	newDatSize, _, err := newDatBackend.GetStat()
+	if err != nil {
+		return fmt.Errorf("stat %s: %v", newDatFileName, err)
+	}
+
+	version := oldSuperBlock.Version
+	err = incrementedNm.AscendingVisit(func(value needle_map.NeedleValue) error {
+		if value.Offset.IsZero() {
+			return nil
+		}
+		// 删除记录指向追加的删除标记 needle，同样拷贝过去，保证按 AppendAtNs 增量同步时能看到删除
+		blobSize := value.Size
+		if value.Size.IsDeleted() {
+			blobSize = 0
+		}
+		blob, err := copyNeedleBlob(oldDatBackend, newDatBackend, value.Key, value.Offset.ToActualOffset(), blobSize, newDatSize, version)
+		if err != nil {
+			return err
+		}
+		entry := needle_map.NeedleValue{Key: value.Key, Offset: ToOffset(newDatSize), Size: value.Size}.ToBytes()
+		if _, err = newIdxFile.WriteAt(entry, newIdxOffset); err != nil {
+			return fmt.Errorf("write %s: %v", newIdxFileName, err)
+		}
+		newDatSize += int64(len(blob))
+		newIdxOffset += NeedleMapEntrySize
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = newDatBackend.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %v", newDatFileName, err)
+	}
+	if err = newIdxFile.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %v", newIdxFileName, err)
+	}
+	glog.V(0).Infof("volume %d makeup %d entries appended during compaction", v.Id, incrementedNm.Len())
+	return nil
+}
+
+// 先落盘提交标记再改名，任何一步崩溃都可以在下次加载时由 recoverCompaction 收尾
+func (v *Volume) replaceWithCompactFiles() error {
+	if err := writeCommitMarker(v.FileName(".cpc")); err != nil {
+		return err
+	}
+	return v.finishCompactCommit()
+}
+
+// 用 .cpd/.cpx 替换 .dat/.idx，删除依赖旧 .idx 的派生索引，最后删除提交标记
+func (v *Volume) finishCompactCommit() error {
+	if util.FileExists(v.FileName(".cpd")) {
+		if err := os.Rename(v.FileName(".cpd"), v.FileName(".dat")); err != nil {
+			return fmt.Errorf("rename %s: %v", v.FileName(".cpd"), err)
+		}
+	}
+	if util.FileExists(v.FileName(".cpx")) {
+		if err := os.Rename(v.FileName(".cpx"), v.FileName(".idx")); err != nil {
+			return fmt.Errorf("rename %s: %v", v.FileName(".cpx"), err)
+		}
+	}
+	if err := syncDir(v.dir); err != nil {
+		return err
+	}
+	if v.dirIdx != v.dir {
+		if err := syncDir(v.dirIdx); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(v.FileName(".ldb")); err != nil {
+		return fmt.Errorf("remove %s: %v", v.FileName(".ldb"), err)
+	}
+	for _, ext := range []string{".sdx", ".sdm", ".cpc"} {
+		if err := os.Remove(v.FileName(ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %v", v.FileName(ext), err)
+		}
+	}
+	return nil
+}
+
+// 加载卷之前处理上次没有完成的压缩
+func (v *Volume) recoverCompaction() error {
+	if util.FileExists(v.FileName(".cpc")) {
+		glog.V(0).Infof("volume %d finishing interrupted compaction commit", v.Id)
+		return v.finishCompactCommit()
+	}
+	if util.FileExists(v.FileName(".cpd")) || util.FileExists(v.FileName(".cpx")) {
+		glog.V(0).Infof("volume %d discarding uncommitted compaction files", v.Id)
+		v.removeCompactFiles()
+	}
+	return nil
+}
+
+// Cleanup 放弃这次压缩，删除 .cpd/.cpx
+func (v *Volume) cleanupCompact() error {
+	glog.V(0).Infof("Cleaning up volume %d vacuuming...", v.Id)
+
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+	if v.isCompacting {
+		return fmt.Errorf("volume %d is compacting", v.Id)
+	}
+	return v.removeCompactFiles()
+}
+
+func (v *Volume) removeCompactFiles() error {
+	for _, ext := range []string{".cpd", ".cpx"} {
+		if err := os.Remove(v.FileName(ext)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove %s: %v", v.FileName(ext), err)
+		}
+	}
+	return nil
+}
+
+func writeCommitMarker(fileName string) error {
+	f, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("create %s: %v", fileName, err)
+	}
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("sync %s: %v", fileName, err)
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(fileName))
+}
+
+// 改名和新建文件需要同步目录项才能在断电后保留
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open dir %s: %v", dir, err)
+	}
+	defer d.Close()
+	if err = d.Sync(); err != nil {
+		return fmt.Errorf("sync dir %s: %v", dir, err)
+	}
+	return nil
+}
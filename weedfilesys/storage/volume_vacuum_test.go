@@ -0,0 +1,186 @@
+package storage
+
+import (
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/util"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestVolumeCompactWhileWriting(t *testing.T) {
+	for _, kind := range testNeedleMapKinds {
+		t.Run(kind.String(), func(t *testing.T) {
+			testVolumeCompactWhileWriting(t, kind)
+		})
+	}
+}
+
+func testVolumeCompactWhileWriting(t *testing.T, kind NeedleMapKind) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 1, kind, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+
+	fid := func(key uint64) *needle.FileId { return needle.NewFileId(1, key, uint32(key)) }
+	writeFile := func(key uint64, data string) {
+		if _, _, err := v.WriteFile(fid(key), newTestNeedle(data)); err != nil {
+			t.Fatalf("write %d: %v", key, err)
+		}
+	}
+	for key := uint64(1); key <= 100; key++ {
+		writeFile(key, fmt.Sprintf("data-%d", key))
+	}
+	for key := uint64(1); key <= 50; key++ {
+		if _, err = v.DeleteFile(fid(key)); err != nil {
+			t.Fatalf("delete %d: %v", key, err)
+		}
+	}
+	if level := v.garbageLevel(); level < 0.4 {
+		t.Fatalf("unexpected garbage level %f", level)
+	}
+	datSizeBefore, _, _ := v.FileStat()
+
+	if err = v.Compact2(0, 0, nil); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+
+	// 压缩完成到提交之间的写入和删除由 makeupDiff 补齐
+	writeFile(60, "data-60-updated")
+	writeFile(200, "data-200")
+	if _, err = v.DeleteFile(fid(70)); err != nil {
+		t.Fatalf("delete 70: %v", err)
+	}
+
+	if err = v.CommitCompact(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if v.SuperBlock.CompactionRevision != 1 {
+		t.Fatalf("compaction revision %d", v.SuperBlock.CompactionRevision)
+	}
+	for _, ext := range []string{".cpd", ".cpx", ".cpc"} {
+		if util.FileExists(v.FileName(ext)) {
+			t.Fatalf("%s left after commit", ext)
+		}
+	}
+	datSizeAfter, _, _ := v.FileStat()
+	if datSizeAfter >= datSizeBefore {
+		t.Fatalf("dat size %d not smaller than %d", datSizeAfter, datSizeBefore)
+	}
+
+	check := func() {
+		for key := uint64(1); key <= 100; key++ {
+			n, err := v.ReadFile(fid(key))
+			switch {
+			case key <= 50 || key == 70:
+				if !errors.Is(err, ErrorNotFound) && !errors.Is(err, ErrorDeleted) {
+					t.Fatalf("read deleted %d: %v", key, err)
+				}
+			case key == 60:
+				if err != nil || string(n.Data) != "data-60-updated" {
+					t.Fatalf("read updated %d: %q %v", key, n.Data, err)
+				}
+			default:
+				if err != nil || string(n.Data) != fmt.Sprintf("data-%d", key) {
+					t.Fatalf("read %d: %v", key, err)
+				}
+			}
+		}
+		n, err := v.ReadFile(fid(200))
+		if err != nil || string(n.Data) != "data-200" {
+			t.Fatalf("read 200: %v", err)
+		}
+	}
+	check()
+
+	// 提交后继续写入，再重新加载
+	writeFile(300, "data-300")
+	v.Close()
+	v, err = NewVolume(dir, dir, "", 1, kind, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	check()
+	if v.SuperBlock.CompactionRevision != 1 {
+		t.Fatalf("compaction revision after reload %d", v.SuperBlock.CompactionRevision)
+	}
+	if _, err = v.ReadFile(fid(300)); err != nil {
+		t.Fatalf("read 300: %v", err)
+	}
+}
+
+func TestVolumeCompactCrashRecovery(t *testing.T) {
+	dir := t.TempDir()
+	rp, _ := super_block.NewReplicaPlacementFromString("000")
+	v, err := NewVolume(dir, dir, "", 3, NeedleMapInMemory, rp, needle.EMPTY_TTL, 0, 0)
+	if err != nil {
+		t.Fatalf("new volume: %v", err)
+	}
+	fid1, fid2 := needle.NewFileId(3, 1, 1), needle.NewFileId(3, 2, 2)
+	if _, _, err = v.WriteFile(fid1, newTestNeedle("keep")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err = v.WriteFile(fid2, newTestNeedle("drop")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = v.DeleteFile(fid2); err != nil {
+		t.Fatal(err)
+	}
+	if err = v.Compact2(0, 0, nil); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err = v.makeupDiff(v.FileName(".cpd"), v.FileName(".cpx"), v.FileName(".dat"), v.FileName(".idx")); err != nil {
+		t.Fatalf("makeup diff: %v", err)
+	}
+	v.Close()
+
+	// 没有提交标记：原文件完好，丢弃压缩结果
+	v, err = NewVolume(dir, dir, "", 3, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	if util.FileExists(v.FileName(".cpd")) || util.FileExists(v.FileName(".cpx")) {
+		t.Fatalf("uncommitted compaction files not removed")
+	}
+	if v.SuperBlock.CompactionRevision != 0 {
+		t.Fatalf("compaction revision %d", v.SuperBlock.CompactionRevision)
+	}
+
+	// 写完提交标记、改名前崩溃：加载时继续完成替换
+	if err = v.Compact2(0, 0, nil); err != nil {
+		t.Fatalf("compact: %v", err)
+	}
+	if err = v.makeupDiff(v.FileName(".cpd"), v.FileName(".cpx"), v.FileName(".dat"), v.FileName(".idx")); err != nil {
+		t.Fatalf("makeup diff: %v", err)
+	}
+	if err = writeCommitMarker(v.FileName(".cpc")); err != nil {
+		t.Fatal(err)
+	}
+	v.Close()
+
+	v, err = NewVolume(dir, dir, "", 3, NeedleMapInMemory, nil, nil, 0, 0)
+	if err != nil {
+		t.Fatalf("reload volume: %v", err)
+	}
+	defer v.Close()
+	if v.SuperBlock.CompactionRevision != 1 {
+		t.Fatalf("compaction revision %d", v.SuperBlock.CompactionRevision)
+	}
+	for _, ext := range []string{".cpd", ".cpx", ".cpc"} {
+		if _, err := os.Stat(v.FileName(ext)); !os.IsNotExist(err) {
+			t.Fatalf("%s left after recovery", ext)
+		}
+	}
+	n, err := v.ReadFile(fid1)
+	if err != nil || string(n.Data) != "keep" {
+		t.Fatalf("read after recovery: %v", err)
+	}
+	if _, err = v.ReadFile(fid2); err == nil {
+		t.Fatalf("deleted file readable after recovery")
+	}
+}
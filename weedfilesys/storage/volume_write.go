@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/storage/needle"
+	. "cayoyibackend/weedfilesys/storage/types"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var ErrorNotFound = errors.New("not found")
+var ErrorDeleted = errors.New("already deleted")
+var ErrorCookieMismatch = errors.New("cookie mismatch")
+var ErrorVolumeReadOnly = errors.New("volume is read only")
+
+// 和已存在的同 id needle 内容完全一致时不再重复追加
+func (v *Volume) isFileUnchanged(n *needle.Needle) bool {
+	if v.Ttl.String() != "" {
+		return false
+	}
+
+	nv, ok := v.nm.Get(n.Id)
+	if ok && !nv.Offset.IsZero() && nv.Size.IsValid() {
+		oldNeedle := new(needle.Needle)
+		err := oldNeedle.ReadData(v.DataBackend, nv.Offset.ToActualOffset(), nv.Size, v.SuperBlock.Version)
+		if err != nil {
+			glog.V(0).Infof("Failed to check updated file at offset %d size %d: %v", nv.Offset.ToActualOffset(), nv.Size, err)
+			return false
+		}
+		if oldNeedle.Cookie == n.Cookie && oldNeedle.Checksum == n.Checksum && bytes.Equal(oldNeedle.Data, n.Data) {
+			n.DataSize = oldNeedle.DataSize
+			return true
+		}
+	}
+	return false
+}
+
+// 覆盖已有文件时 cookie 必须一致，防止猜到 needle id 就能改写别人的文件
+func (v *Volume) checkCookie(n *needle.Needle) error {
+	nv, ok := v.nm.Get(n.Id)
+	if !ok || nv.Offset.IsZero() {
+		return nil
+	}
+	existingNeedle, _, _, err := needle.ReadNeedleHeader(v.DataBackend, v.SuperBlock.Version, nv.Offset.ToActualOffset())
+	if err != nil {
+		return fmt.Errorf("reading needle %d header: %w", n.Id, err)
+	}
+	if existingNeedle.Cookie != n.Cookie {
+		glog.V(0).Infof("write cookie mismatch: existing %s, new %s", needle.NewFileIdFromNeedle(v.Id, existingNeedle), needle.NewFileIdFromNeedle(v.Id, n))
+		return ErrorCookieMismatch
+	}
+	return nil
+}
+
+// WriteNeedle 追加写入 needle 并更新索引，返回 needle 大小以及内容是否未变
+func (v *Volume) WriteNeedle(n *needle.Needle) (offset uint64, size Size, isUnchanged bool, err error) {
+	glog.V(4).Infof("writing needle %s", needle.NewFileIdFromNeedle(v.Id, n).String())
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+
+	return v.doWriteRequest(n)
+}
+
+func (v *Volume) doWriteRequest(n *needle.Needle) (offset uint64, size Size, isUnchanged bool, err error) {
+	if v.IsReadOnly() {
+		err = fmt.Errorf("%s: %w", v.DataBackend.Name(), ErrorVolumeReadOnly)
+		return
+	}
+	if v.isFileUnchanged(n) {
+		size = Size(n.DataSize)
+		isUnchanged = true
+		return
+	}
+	if err = v.checkCookie(n); err != nil {
+		return
+	}
+
+	// TTL 卷中没有指定 TTL 的文件沿用卷的 TTL，过期时间从写入时刻算起
+	if v.Ttl != nil && v.Ttl.Minutes() > 0 && (n.Ttl == nil || n.Ttl.Minutes() == 0) {
+		n.Ttl = v.Ttl
+		n.SetHasTtl()
+	}
+	if n.HasTtl() && !n.HasLastModifiedDate() {
+		n.LastModified = uint64(time.Now().Unix())
+		n.SetHasLastModifiedDate()
+	}
+
+	// 保证同一个卷内 AppendAtNs 单调递增，增量同步依赖这个顺序
+	n.UpdateAppendAtNs(v.lastAppendAtNs)
+
+	var actualSize int64
+	if offset, size, actualSize, err = n.Append(v.DataBackend, v.SuperBlock.Version); err != nil {
+		return
+	}
+	v.lastAppendAtNs = n.AppendAtNs
+
+	// 索引中记录的是 needle 头部中的 Size 字段，读取时需要用它校验
+	if err = v.nm.Put(n.Id, ToOffset(int64(offset)), n.Size); err != nil {
+		glog.V(4).Infof("failed to save in needle map %d: %v", n.Id, err)
+	}
+	if v.lastModifiedTsSeconds < n.LastModified {
+		v.lastModifiedTsSeconds = n.LastModified
+	}
+	glog.V(4).Infof("volume %d appended %d bytes at %d", v.Id, actualSize, offset)
+	return
+}
+
+// DeleteNeedle 追加一个只有头部的删除标记 needle，并在索引中记录删除，返回被删除文件的大小
+func (v *Volume) DeleteNeedle(n *needle.Needle) (Size, error) {
+	glog.V(4).Infof("delete needle %s", needle.NewFileIdFromNeedle(v.Id, n).String())
+	v.dataFileAccessLock.Lock()
+	defer v.dataFileAccessLock.Unlock()
+
+	return v.doDeleteRequest(n)
+}
+
+func (v *Volume) doDeleteRequest(n *needle.Needle) (Size, error) {
+	if v.noWriteOrDelete {
+		return 0, fmt.Errorf("%s: %w", v.DataBackend.Name(), ErrorVolumeReadOnly)
+	}
+	nv, ok := v.nm.Get(n.Id)
+	if !ok || !nv.Size.IsValid() {
+		return 0, nil
+	}
+	if err := v.checkCookie(n); err != nil {
+		return 0, err
+	}
+
+	size := nv.Size
+	n.Data = nil
+	n.UpdateAppendAtNs(v.lastAppendAtNs)
+	offset, _, _, err := n.Append(v.DataBackend, v.SuperBlock.Version)
+	if err != nil {
+		return size, err
+	}
+	v.lastAppendAtNs = n.AppendAtNs
+	if err = v.nm.Delete(n.Id, ToOffset(int64(offset))); err != nil {
+		return size, err
+	}
+	v.lastModifiedTsSeconds = uint64(time.Now().Unix())
+	return size, nil
+}
+
+// WriteFile 按文件 id 写入，fid 中的卷 id、needle id 和 cookie 会覆盖 n 中的值
+func (v *Volume) WriteFile(fid *needle.FileId, n *needle.Needle) (size Size, isUnchanged bool, err error) {
+	if fid.VolumeId != v.Id {
+		return 0, false, fmt.Errorf("file id %s does not belong to volume %d", fid, v.Id)
+	}
+	n.Id, n.Cookie = fid.Key, fid.Cookie
+	_, size, isUnchanged, err = v.WriteNeedle(n)
+	return
+}
+
+// DeleteFile 按文件 id 删除，cookie 不一致时返回 ErrorCookieMismatch
+func (v *Volume) DeleteFile(fid *needle.FileId) (Size, error) {
+	if fid.VolumeId != v.Id {
+		return 0, fmt.Errorf("file id %s does not belong to volume %d", fid, v.Id)
+	}
+	n := &needle.Needle{Id: fid.Key, Cookie: fid.Cookie}
+	return v.DeleteNeedle(n)
+}
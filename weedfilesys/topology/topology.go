@@ -0,0 +1,167 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/storage/types"
+	"fmt"
+	"sync"
+)
+
+// 卷按 collection、副本策略、TTL 和磁盘类型分组，TTL 用存储格式比较，"60m" 和 "1h" 属于同一组
+type layoutKey struct {
+	collection  string
+	replication byte
+	ttl         uint32
+	diskType    types.DiskType
+}
+
+// Topology master 侧根据卷服务器心跳维护的卷分布，用于分配写入
+type Topology struct {
+	volumeSizeLimit uint64
+
+	layouts       map[layoutKey]*VolumeLayout
+	serverVolumes map[string]map[needle.VolumeId]layoutKey // 每个卷服务器上报过的卷
+	dataNodes     map[string]*DataNode
+	ecShards      map[needle.VolumeId]*ecShardLocations
+	sync.RWMutex
+}
+
+// DataNode 卷服务器所在的机房、机架和容量，EC 分片按机架分散放置
+type DataNode struct {
+	Server         string
+	DataCenter     string
+	Rack           string
+	MaxVolumeCount int
+}
+
+func NewTopology(volumeSizeLimit uint64) *Topology {
+	return &Topology{
+		volumeSizeLimit: volumeSizeLimit,
+		layouts:         make(map[layoutKey]*VolumeLayout),
+		serverVolumes:   make(map[string]map[needle.VolumeId]layoutKey),
+		dataNodes:       make(map[string]*DataNode),
+		ecShards:        make(map[needle.VolumeId]*ecShardLocations),
+	}
+}
+
+func newLayoutKey(collection string, rp *super_block.ReplicaPlacement, ttl *needle.TTL, diskType types.DiskType) layoutKey {
+	key := layoutKey{collection: collection, diskType: diskType}
+	if rp != nil {
+		key.replication = rp.Byte()
+	}
+	if ttl != nil {
+		key.ttl = ttl.ToUint32()
+	}
+	return key
+}
+
+func (t *Topology) GetVolumeLayout(collection string, rp *super_block.ReplicaPlacement, ttl *needle.TTL, diskType types.DiskType) *VolumeLayout {
+	key := newLayoutKey(collection, rp, ttl, diskType)
+	t.Lock()
+	defer t.Unlock()
+	return t.getOrCreateLayout(key, rp, ttl, diskType)
+}
+
+func (t *Topology) getOrCreateLayout(key layoutKey, rp *super_block.ReplicaPlacement, ttl *needle.TTL, diskType types.DiskType) *VolumeLayout {
+	vl, found := t.layouts[key]
+	if !found {
+		vl = NewVolumeLayout(rp, ttl, diskType, t.volumeSizeLimit)
+		t.layouts[key] = vl
+	}
+	return vl
+}
+
+// SyncDataNode 处理卷服务器的完整心跳：更新机房、机架和容量，
+// 心跳中带有卷列表或 EC 分片列表（包括声明没有）时同步对应部分
+func (t *Topology) SyncDataNode(server string, heartbeat *master_pb.Heartbeat) {
+	t.Lock()
+	defer t.Unlock()
+
+	dn, found := t.dataNodes[server]
+	if !found {
+		dn = &DataNode{Server: server}
+		t.dataNodes[server] = dn
+	}
+	if heartbeat.DataCenter != "" || heartbeat.Rack != "" {
+		dn.DataCenter, dn.Rack = heartbeat.DataCenter, heartbeat.Rack
+	}
+	if len(heartbeat.MaxVolumeCounts) > 0 {
+		dn.MaxVolumeCount = 0
+		for _, count := range heartbeat.MaxVolumeCounts {
+			dn.MaxVolumeCount += int(count)
+		}
+	}
+	if len(heartbeat.Volumes) > 0 || heartbeat.HasNoVolumes {
+		t.syncDataNodeVolumes(server, heartbeat.Volumes)
+	}
+	if len(heartbeat.EcShards) > 0 || heartbeat.HasNoEcShards {
+		t.syncDataNodeEcShards(server, heartbeat.EcShards)
+	}
+}
+
+// SyncDataNodeVolumes 用完整心跳中的卷列表更新该服务器的卷，没有再上报的卷（比如过期删除的 TTL 卷）随之移除
+func (t *Topology) SyncDataNodeVolumes(server string, volumes []*master_pb.VolumeInformationMessage) {
+	t.Lock()
+	defer t.Unlock()
+	t.syncDataNodeVolumes(server, volumes)
+}
+
+func (t *Topology) syncDataNodeVolumes(server string, volumes []*master_pb.VolumeInformationMessage) {
+	previous := t.serverVolumes[server]
+	current := make(map[needle.VolumeId]layoutKey, len(volumes))
+	for _, v := range volumes {
+		rp, err := super_block.NewReplicaPlacementFromByte(byte(v.ReplicaPlacement))
+		if err != nil {
+			continue
+		}
+		ttl := needle.LoadTTLFromUint32(v.Ttl)
+		diskType := types.ToDiskType(v.DiskType)
+		key := newLayoutKey(v.Collection, rp, ttl, diskType)
+		t.getOrCreateLayout(key, rp, ttl, diskType).RegisterVolume(v, server)
+		current[needle.VolumeId(v.Id)] = key
+	}
+	for vid, key := range previous {
+		if currentKey, found := current[vid]; found && currentKey == key {
+			continue
+		}
+		if vl, found := t.layouts[key]; found {
+			vl.UnRegisterVolume(vid, server)
+		}
+	}
+	t.serverVolumes[server] = current
+}
+
+// LookupVolume 返回卷最近一次上报的信息和所有副本所在的服务器
+func (t *Topology) LookupVolume(vid needle.VolumeId) (*master_pb.VolumeInformationMessage, []string, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	for _, vl := range t.layouts {
+		if info, servers, found := vl.Lookup(vid); found {
+			return info, servers, true
+		}
+	}
+	return nil, nil, false
+}
+
+// PickForWrite 按分配请求中的 collection、副本策略、TTL 和磁盘类型找到对应分组并挑选可写卷
+func (t *Topology) PickForWrite(collection, replication, ttlString, diskTypeString string) (needle.VolumeId, []string, error) {
+	rp, err := super_block.NewReplicaPlacementFromString(replication)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse replication %q: %v", replication, err)
+	}
+	ttl, err := needle.ReadTTL(ttlString)
+	if err != nil {
+		return 0, nil, fmt.Errorf("parse ttl %q: %v", ttlString, err)
+	}
+	key := newLayoutKey(collection, rp, ttl, types.ToDiskType(diskTypeString))
+
+	t.RLock()
+	vl, found := t.layouts[key]
+	t.RUnlock()
+	if !found {
+		return 0, nil, ErrNoWritableVolume
+	}
+	return vl.PickForWrite()
+}
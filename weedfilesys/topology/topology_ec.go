@@ -0,0 +1,192 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"fmt"
+	"sort"
+)
+
+// 一个 EC 卷的方案和分片分布，按服务器记录各自挂载的分片
+type ecShardLocations struct {
+	collection string
+	scheme     erasure_coding.EcScheme
+	servers    map[string]erasure_coding.ShardBits
+}
+
+// 用完整心跳中的 EC 分片更新该服务器，没有再上报的分片随之移除，调用方持有写锁
+func (t *Topology) syncDataNodeEcShards(server string, shards []*master_pb.VolumeEcShardInformationMessage) {
+	current := make(map[needle.VolumeId]bool, len(shards))
+	for _, shard := range shards {
+		vid := needle.VolumeId(shard.Id)
+		locations, found := t.ecShards[vid]
+		if !found {
+			locations = &ecShardLocations{servers: make(map[string]erasure_coding.ShardBits)}
+			t.ecShards[vid] = locations
+		}
+		locations.collection = shard.Collection
+		locations.scheme = erasure_coding.EcSchemeFromMessage(shard.DataShards, shard.ParityShards)
+		locations.servers[server] = erasure_coding.ShardBits(shard.EcIndexBits)
+		current[vid] = true
+	}
+	for vid, locations := range t.ecShards {
+		if current[vid] {
+			continue
+		}
+		delete(locations.servers, server)
+		if len(locations.servers) == 0 {
+			delete(t.ecShards, vid)
+		}
+	}
+}
+
+// LookupEcShards 返回 EC 卷的 collection 以及每个分片所在的服务器
+func (t *Topology) LookupEcShards(vid needle.VolumeId) (collection string, shardServers map[erasure_coding.ShardId][]string, found bool) {
+	t.RLock()
+	defer t.RUnlock()
+	locations, found := t.ecShards[vid]
+	if !found {
+		return "", nil, false
+	}
+	shardServers = make(map[erasure_coding.ShardId][]string)
+	for _, server := range sortedServers(locations.servers) {
+		for _, shardId := range locations.servers[server].ShardIds() {
+			shardServers[shardId] = append(shardServers[shardId], server)
+		}
+	}
+	return locations.collection, shardServers, true
+}
+
+// LookupEcScheme 返回 EC 卷的方案
+func (t *Topology) LookupEcScheme(vid needle.VolumeId) (erasure_coding.EcScheme, bool) {
+	t.RLock()
+	defer t.RUnlock()
+	locations, found := t.ecShards[vid]
+	if !found {
+		return erasure_coding.EcScheme{}, false
+	}
+	return locations.scheme, true
+}
+
+// LookupEcVolume 按 master 的 LookupEcVolume 接口返回各分片的位置，卷服务器据此读取远程分片
+func (t *Topology) LookupEcVolume(vid needle.VolumeId) (*master_pb.LookupEcVolumeResponse, error) {
+	_, shardServers, found := t.LookupEcShards(vid)
+	if !found {
+		return nil, fmt.Errorf("ec volume %d not found", vid)
+	}
+	resp := &master_pb.LookupEcVolumeResponse{VolumeId: uint32(vid)}
+	for _, shardId := range sortedShardIds(shardServers) {
+		shardIdLocation := &master_pb.LookupEcVolumeResponse_EcShardIdLocation{ShardId: uint32(shardId)}
+		for _, server := range shardServers[shardId] {
+			shardIdLocation.Locations = append(shardIdLocation.Locations, pb.ServerAddress(server).ToLocation())
+		}
+		resp.ShardIdLocations = append(resp.ShardIdLocations, shardIdLocation)
+	}
+	return resp, nil
+}
+
+// 空闲的分片名额：每个空闲的卷名额可以放 DataShardsCount 个分片
+func (t *Topology) freeEcShardSlots(server string) int {
+	dn := t.dataNodes[server]
+	if dn == nil {
+		return 0
+	}
+	free := (dn.MaxVolumeCount - len(t.serverVolumes[server])) * erasure_coding.DataShardsCount
+	for _, locations := range t.ecShards {
+		free -= locations.servers[server].ShardIdCount()
+	}
+	return free
+}
+
+// 参与放置的服务器，assigned 为已经放在它上面的同一个卷的分片数
+type ecPlacementNode struct {
+	server   string
+	rack     string // 机房:机架
+	free     int
+	assigned int
+}
+
+// PlanEcShardPlacement 为卷 vid 的 shardIds 挑选服务器，已有的分片也计入分布
+func (t *Topology) PlanEcShardPlacement(vid needle.VolumeId, shardIds []erasure_coding.ShardId) (map[string][]erasure_coding.ShardId, error) {
+	t.RLock()
+	defer t.RUnlock()
+	var nodes []*ecPlacementNode
+	for _, server := range sortedServers(t.dataNodes) {
+		dn := t.dataNodes[server]
+		node := &ecPlacementNode{server: server, rack: dn.DataCenter + ":" + dn.Rack, free: t.freeEcShardSlots(server)}
+		if locations, found := t.ecShards[vid]; found {
+			node.assigned = locations.servers[server].ShardIdCount()
+		}
+		nodes = append(nodes, node)
+	}
+	return planEcShardPlacement(nodes, shardIds)
+}
+
+// 逐个分片挑选：先选已放分片最少的机架，再在机架内选已放分片最少的服务器，同样多时选空闲名额多的，
+// 这样分片在机架和服务器之间都尽量平均，一个机架出问题时丢失的分片最少
+func planEcShardPlacement(nodes []*ecPlacementNode, shardIds []erasure_coding.ShardId) (map[string][]erasure_coding.ShardId, error) {
+	placement := make(map[string][]erasure_coding.ShardId)
+	for _, shardId := range shardIds {
+		rackAssigned, rackFree := make(map[string]int), make(map[string]int)
+		for _, node := range nodes {
+			rackAssigned[node.rack] += node.assigned
+			if node.free > 0 {
+				rackFree[node.rack] += node.free
+			}
+		}
+		var picked *ecPlacementNode
+		for _, node := range nodes {
+			if node.free <= 0 {
+				continue
+			}
+			switch {
+			case picked == nil:
+				picked = node
+			case node.rack != picked.rack:
+				if lessLoaded(rackAssigned[node.rack], rackAssigned[picked.rack], rackFree[node.rack], rackFree[picked.rack], node.rack, picked.rack) {
+					picked = node
+				}
+			case lessLoaded(node.assigned, picked.assigned, node.free, picked.free, node.server, picked.server):
+				picked = node
+			}
+		}
+		if picked == nil {
+			return nil, fmt.Errorf("no free slot for ec shard %d", shardId)
+		}
+		picked.assigned++
+		picked.free--
+		placement[picked.server] = append(placement[picked.server], shardId)
+	}
+	return placement, nil
+}
+
+// 已放的少优先，其次空闲多的，最后按名字保证结果稳定
+func lessLoaded(assigned, otherAssigned, free, otherFree int, name, otherName string) bool {
+	if assigned != otherAssigned {
+		return assigned < otherAssigned
+	}
+	if free != otherFree {
+		return free > otherFree
+	}
+	return name < otherName
+}
+
+func sortedServers[V any](m map[string]V) []string {
+	servers := make([]string, 0, len(m))
+	for server := range m {
+		servers = append(servers, server)
+	}
+	sort.Strings(servers)
+	return servers
+}
+
+func sortedShardIds[V any](m map[erasure_coding.ShardId]V) []erasure_coding.ShardId {
+	shardIds := make([]erasure_coding.ShardId, 0, len(m))
+	for shardId := range m {
+		shardIds = append(shardIds, shardId)
+	}
+	sort.Slice(shardIds, func(i, j int) bool { return shardIds[i] < shardIds[j] })
+	return shardIds
+}
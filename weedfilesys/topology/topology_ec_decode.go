@@ -0,0 +1,92 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// EcDecodeVolume 把 EC 卷还原成可写的普通卷，用于重新变热的数据：
+// 在数据分片最多的服务器上收集其他服务器上的数据分片，所有服务器上都没有的数据分片由它用复制过去的校验分片重建，
+// VolumeEcShardsToVolume 还原并挂载之后，删除其他服务器上的分片。
+// 还原之前失败时删除复制过去的分片，EC 卷保持不变
+func (t *Topology) EcDecodeVolume(ctx context.Context, vid needle.VolumeId, grpcDialOption grpc.DialOption) error {
+	collection, shardServers, found := t.LookupEcShards(vid)
+	if !found {
+		return fmt.Errorf("ec volume %d not found", vid)
+	}
+	scheme, _ := t.LookupEcScheme(vid)
+	option := EcEncodeOption{GrpcDialOption: grpcDialOption}
+
+	serverShards := make(map[string]erasure_coding.ShardBits)
+	for shardId, servers := range shardServers {
+		for _, server := range servers {
+			serverShards[server] = serverShards[server].AddShardId(shardId)
+		}
+	}
+	var target string
+	for _, server := range sortedServers(serverShards) {
+		dataShards, targetDataShards := serverShards[server].MinusParityShards(scheme).ShardIdCount(), serverShards[target].MinusParityShards(scheme).ShardIdCount()
+		if target == "" || dataShards > targetDataShards ||
+			dataShards == targetDataShards && serverShards[server].ShardIdCount() > serverShards[target].ShardIdCount() {
+			target = server
+		}
+	}
+
+	// 先复制缺少的数据分片，有数据分片丢失时再用校验分片凑足 DataShards 个
+	copies := make(map[string][]erasure_coding.ShardId)
+	local := serverShards[target]
+	for _, shardId := range scheme.ShardIds() {
+		isParity := int(shardId) >= scheme.DataShards
+		if local.HasShardId(shardId) || len(shardServers[shardId]) == 0 || isParity && local.ShardIdCount() >= scheme.DataShards {
+			continue
+		}
+		source := shardServers[shardId][0]
+		copies[source] = append(copies[source], shardId)
+		local = local.AddShardId(shardId)
+	}
+	if local.ShardIdCount() < scheme.DataShards {
+		return fmt.Errorf("ec volume %d has only %d shards, %s needs %d", vid, local.ShardIdCount(), scheme, scheme.DataShards)
+	}
+
+	var copied []uint32
+	err := withVolumeServer(target, option, func(client volume_server_pb.VolumeServerClient) error {
+		for _, source := range sortedServers(copies) {
+			if _, err := client.VolumeEcShardsCopy(ctx, &volume_server_pb.VolumeEcShardsCopyRequest{
+				VolumeId:       uint32(vid),
+				Collection:     collection,
+				ShardIds:       toUint32ShardIds(copies[source]),
+				SourceDataNode: source,
+			}); err != nil {
+				return fmt.Errorf("copy shards %v from %s: %v", copies[source], source, err)
+			}
+			copied = append(copied, toUint32ShardIds(copies[source])...)
+		}
+		_, err := client.VolumeEcShardsToVolume(ctx, &volume_server_pb.VolumeEcShardsToVolumeRequest{VolumeId: uint32(vid), Collection: collection})
+		return err
+	})
+	if err != nil {
+		if cleanupErr := deleteEcShards(ctx, target, vid, collection, copied, option); cleanupErr != nil {
+			glog.Warningf("clean up ec shards of volume %d on %s: %v", vid, target, cleanupErr)
+		}
+		return fmt.Errorf("decode ec volume %d on %s: %v", vid, target, err)
+	}
+
+	var errs []error
+	for _, server := range sortedServers(serverShards) {
+		if server == target {
+			continue
+		}
+		if err = deleteEcShards(ctx, server, vid, collection, toUint32ShardIds(scheme.ShardIds()), option); err != nil {
+			errs = append(errs, fmt.Errorf("delete ec shards of volume %d on %s: %v", vid, server, err))
+		}
+	}
+	glog.V(0).Infof("ec volume %d is decoded into a normal volume on %s", vid, target)
+	return errors.Join(errs...)
+}
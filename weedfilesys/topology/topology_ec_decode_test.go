@@ -0,0 +1,80 @@
+package topology
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEcDecodeVolume(t *testing.T) {
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	servers := startTestVolumeServers(t, []string{"r1", "r2", "r3"}, 2)
+	source := servers[0]
+	if err := source.store.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, source.store, 1, 20)
+	topo := NewTopology(1024 * 1024 * 1024)
+	syncTestVolumeServers(topo, servers)
+	option := EcEncodeOption{GrpcDialOption: testGrpcDialOption, EcSchemes: map[string]erasure_coding.EcScheme{"col": scheme}}
+	if err := topo.EcEncodeVolume(context.Background(), 1, option); err != nil {
+		t.Fatal(err)
+	}
+	syncTestVolumeServers(topo, servers)
+
+	// 数据分片 0 丢失，需要复制校验分片过去重建
+	_, shardServers, _ := topo.LookupEcShards(1)
+	for _, s := range servers {
+		if s.address == shardServers[0][0] {
+			if err := s.store.DeleteEcShards(1, "col", []erasure_coding.ShardId{0}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	syncTestVolumeServers(topo, servers)
+
+	if err := topo.EcDecodeVolume(context.Background(), 2, testGrpcDialOption); err == nil {
+		t.Fatalf("expect missing ec volume rejected")
+	}
+	if err := topo.EcDecodeVolume(context.Background(), 1, testGrpcDialOption); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	syncTestVolumeServers(topo, servers)
+
+	if _, _, found := topo.LookupEcShards(1); found {
+		t.Fatalf("ec shards should be removed")
+	}
+	_, locations, found := topo.LookupVolume(1)
+	if !found || len(locations) != 1 {
+		t.Fatalf("volume locations %v", locations)
+	}
+	for _, s := range servers {
+		if s.address != locations[0] {
+			if entries, _ := os.ReadDir(s.dir); len(entries) != 0 {
+				t.Fatalf("files left on %s: %v", s.address, entries)
+			}
+			continue
+		}
+		v := s.store.GetVolume(1)
+		if v == nil || v.IsReadOnly() {
+			t.Fatalf("expect writable volume on %s", s.address)
+		}
+		for id, data := range written {
+			n := &needle.Needle{Id: id, Cookie: 0x1234}
+			if _, err := s.store.ReadVolumeNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+				t.Fatalf("read needle %d: %v", id, err)
+			}
+		}
+		for _, ext := range []string{".ec00", ".ec04", ".ecx", ".ecj"} {
+			if util.FileExists(filepath.Join(s.dir, "col_1"+ext)) {
+				t.Fatalf("%s left on %s", ext, s.address)
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package topology
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/idx"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"fmt"
+	"io"
+	"slices"
+
+	"google.golang.org/grpc"
+)
+
+// 挂载之后默认抽查的文件数
+const defaultEcVerifyNeedles = 16
+
+type EcEncodeOption struct {
+	GrpcDialOption grpc.DialOption
+	VerifyNeedles  int                                // 挂载后抽查读取的文件数，0 表示使用默认值
+	EcSchemes      map[string]erasure_coding.EcScheme // 按 collection 配置的 EC 方案，没有配置的使用默认的 10+4
+}
+
+func (option EcEncodeOption) ecScheme(collection string) erasure_coding.EcScheme {
+	if scheme, found := option.EcSchemes[collection]; found {
+		return scheme
+	}
+	return erasure_coding.DefaultEcScheme
+}
+
+// EcEncodeVolume 把一个卷按 collection 的 EC 方案在线转为 EC 卷：
+// 所有副本标记只读，在第一个副本上生成分片，按机架和服务器分散复制并挂载，
+// 从其他服务器抽查读取与原卷一致后，才删除源服务器上已分出去的分片和原卷的所有副本。
+// 中途失败时删除已经复制和生成的分片，原卷保持只读
+func (t *Topology) EcEncodeVolume(ctx context.Context, vid needle.VolumeId, option EcEncodeOption) error {
+	info, servers, found := t.LookupVolume(vid)
+	if !found {
+		return fmt.Errorf("volume %d not found", vid)
+	}
+	if _, _, found = t.LookupEcShards(vid); found {
+		return fmt.Errorf("volume %d already has ec shards", vid)
+	}
+	collection, source := info.Collection, servers[0]
+	scheme := option.ecScheme(collection)
+	if err := scheme.Validate(); err != nil {
+		return fmt.Errorf("collection %q: %v", collection, err)
+	}
+
+	for _, server := range servers {
+		err := withVolumeServer(server, option, func(client volume_server_pb.VolumeServerClient) error {
+			_, err := client.VolumeMarkReadonly(ctx, &volume_server_pb.VolumeMarkReadonlyRequest{VolumeId: uint32(vid), Persist: true})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("mark volume %d read only on %s: %v", vid, server, err)
+		}
+	}
+	err := withVolumeServer(source, option, func(client volume_server_pb.VolumeServerClient) error {
+		_, err := client.VolumeEcShardsGenerate(ctx, &volume_server_pb.VolumeEcShardsGenerateRequest{
+			VolumeId:      uint32(vid),
+			Collection:    collection,
+			EcShardConfig: scheme.ToEcShardConfig(),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("generate ec shards for volume %d on %s: %v", vid, source, err)
+	}
+
+	placement, err := t.PlanEcShardPlacement(vid, scheme.ShardIds())
+	if err == nil {
+		err = t.spreadEcShards(ctx, vid, collection, source, placement, option)
+	}
+	if err == nil {
+		err = t.verifyEcShards(ctx, vid, collection, source, scheme, placement, option)
+	}
+	if err != nil {
+		t.cleanupEcShards(ctx, vid, collection, source, scheme, placement, option)
+		return fmt.Errorf("ec encode volume %d: %v", vid, err)
+	}
+
+	// 源服务器只保留分给自己的分片
+	var moved []uint32
+	for _, shardId := range scheme.ShardIds() {
+		if !slices.Contains(placement[source], shardId) {
+			moved = append(moved, uint32(shardId))
+		}
+	}
+	if err = deleteEcShards(ctx, source, vid, collection, moved, option); err != nil {
+		return fmt.Errorf("delete moved ec shards of volume %d on %s: %v", vid, source, err)
+	}
+	for _, server := range servers {
+		err = withVolumeServer(server, option, func(client volume_server_pb.VolumeServerClient) error {
+			_, err := client.VolumeDelete(ctx, &volume_server_pb.VolumeDeleteRequest{VolumeId: uint32(vid)})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("delete volume %d on %s: %v", vid, server, err)
+		}
+	}
+	glog.V(0).Infof("volume %d is erasure coded with %s: %v", vid, scheme, placement)
+	return nil
+}
+
+// 目标服务器从源服务器复制分到的分片以及 .ecx、.ecj、.vif，然后挂载
+func (t *Topology) spreadEcShards(ctx context.Context, vid needle.VolumeId, collection, source string, placement map[string][]erasure_coding.ShardId, option EcEncodeOption) error {
+	for _, server := range sortedServers(placement) {
+		shardIds := toUint32ShardIds(placement[server])
+		err := withVolumeServer(server, option, func(client volume_server_pb.VolumeServerClient) error {
+			if server != source {
+				if _, err := client.VolumeEcShardsCopy(ctx, &volume_server_pb.VolumeEcShardsCopyRequest{
+					VolumeId:       uint32(vid),
+					Collection:     collection,
+					ShardIds:       shardIds,
+					CopyEcxFile:    true,
+					CopyEcjFile:    true,
+					CopyVifFile:    true,
+					SourceDataNode: source,
+				}); err != nil {
+					return fmt.Errorf("copy: %v", err)
+				}
+			}
+			if _, err := client.VolumeEcShardsMount(ctx, &volume_server_pb.VolumeEcShardsMountRequest{
+				VolumeId:   uint32(vid),
+				Collection: collection,
+				ShardIds:   shardIds,
+			}); err != nil {
+				return fmt.Errorf("mount: %v", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("ec shards %v on %s: %v", shardIds, server, err)
+		}
+	}
+	return nil
+}
+
+// 按 .ecx 均匀抽查文件：在挂载了该文件全部所在分片的其他服务器上读取，与源服务器上原卷的读取结果比较。
+// 源服务器上原卷还在，读取的是原卷，所以只在其他服务器上验证
+func (t *Topology) verifyEcShards(ctx context.Context, vid needle.VolumeId, collection, source string, scheme erasure_coding.EcScheme, placement map[string][]erasure_coding.ShardId, option EcEncodeOption) error {
+	shardServer := make(map[erasure_coding.ShardId]string)
+	for server, shardIds := range placement {
+		if server == source {
+			continue
+		}
+		for _, shardId := range shardIds {
+			shardServer[shardId] = server
+		}
+	}
+	if len(shardServer) == 0 {
+		glog.Warningf("ec volume %d has all shards on source %s, skip verifying", vid, source)
+		return nil
+	}
+
+	var status *volume_server_pb.ReadVolumeFileStatusResponse
+	var ecx bytes.Buffer
+	err := withVolumeServer(source, option, func(client volume_server_pb.VolumeServerClient) (err error) {
+		if status, err = client.ReadVolumeFileStatus(ctx, &volume_server_pb.ReadVolumeFileStatusRequest{VolumeId: uint32(vid)}); err != nil {
+			return err
+		}
+		stream, err := client.CopyFile(ctx, &volume_server_pb.CopyFileRequest{VolumeId: uint32(vid), Collection: collection, Ext: ".ecx", IsEcVolume: true})
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := stream.Recv()
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			ecx.Write(resp.FileContent)
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("read ecx from %s: %v", source, err)
+	}
+
+	// 每个文件落在哪些分片上，全部分片都在同一台服务器时才能在那里读取
+	type candidate struct {
+		id     types.NeedleId
+		server string
+	}
+	var candidates []candidate
+	shardDatSize := int64(status.DatFileSize) / int64(scheme.DataShards)
+	err = idx.WalkIndexFile(bytes.NewReader(ecx.Bytes()), 0, func(key types.NeedleId, offset types.Offset, size types.Size) error {
+		if !size.IsValid() {
+			return nil
+		}
+		actualSize := needle.GetActualSize(size, needle.Version(status.Version))
+		server := ""
+		for _, interval := range erasure_coding.LocateData(erasure_coding.ErasureCodingLargeBlockSize, erasure_coding.ErasureCodingSmallBlockSize, scheme.DataShards, shardDatSize, offset.ToActualOffset(), types.Size(actualSize)) {
+			shardId, _ := interval.ToShardIdAndOffset(erasure_coding.ErasureCodingLargeBlockSize, erasure_coding.ErasureCodingSmallBlockSize)
+			s := shardServer[shardId]
+			if s == "" || server != "" && s != server {
+				return nil
+			}
+			server = s
+		}
+		candidates = append(candidates, candidate{id: key, server: server})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("walk ecx: %v", err)
+	}
+	if len(candidates) == 0 {
+		glog.Warningf("ec volume %d has no needle readable from a single server, skip verifying", vid)
+		return nil
+	}
+
+	verifyNeedles := option.VerifyNeedles
+	if verifyNeedles <= 0 {
+		verifyNeedles = defaultEcVerifyNeedles
+	}
+	step := max(len(candidates)/verifyNeedles, 1)
+	for i := 0; i < len(candidates); i += step {
+		c := candidates[i]
+		expected, err := needleStatus(ctx, source, vid, c.id, option)
+		if err != nil {
+			return fmt.Errorf("read needle %s from volume %d on %s: %v", c.id, vid, source, err)
+		}
+		actual, err := needleStatus(ctx, c.server, vid, c.id, option)
+		if err != nil {
+			return fmt.Errorf("read needle %s from ec volume %d on %s: %v", c.id, vid, c.server, err)
+		}
+		if actual.Cookie != expected.Cookie || actual.Crc != expected.Crc || actual.Size != expected.Size {
+			return fmt.Errorf("needle %s from ec volume %d on %s mismatch", c.id, vid, c.server)
+		}
+	}
+	return nil
+}
+
+// 失败时尽力删除已经复制和生成的分片，源服务器上的原卷不受影响
+func (t *Topology) cleanupEcShards(ctx context.Context, vid needle.VolumeId, collection, source string, scheme erasure_coding.EcScheme, placement map[string][]erasure_coding.ShardId, option EcEncodeOption) {
+	for server, shardIds := range placement {
+		if server == source {
+			continue
+		}
+		if err := deleteEcShards(ctx, server, vid, collection, toUint32ShardIds(shardIds), option); err != nil {
+			glog.Warningf("clean up ec shards of volume %d on %s: %v", vid, server, err)
+		}
+	}
+	if err := deleteEcShards(ctx, source, vid, collection, toUint32ShardIds(scheme.ShardIds()), option); err != nil {
+		glog.Warningf("clean up ec shards of volume %d on %s: %v", vid, source, err)
+	}
+}
+
+func deleteEcShards(ctx context.Context, server string, vid needle.VolumeId, collection string, shardIds []uint32, option EcEncodeOption) error {
+	if len(shardIds) == 0 {
+		return nil
+	}
+	return withVolumeServer(server, option, func(client volume_server_pb.VolumeServerClient) error {
+		_, err := client.VolumeEcShardsDelete(ctx, &volume_server_pb.VolumeEcShardsDeleteRequest{VolumeId: uint32(vid), Collection: collection, ShardIds: shardIds})
+		return err
+	})
+}
+
+func needleStatus(ctx context.Context, server string, vid needle.VolumeId, id types.NeedleId, option EcEncodeOption) (resp *volume_server_pb.VolumeNeedleStatusResponse, err error) {
+	err = withVolumeServer(server, option, func(client volume_server_pb.VolumeServerClient) error {
+		resp, err = client.VolumeNeedleStatus(ctx, &volume_server_pb.VolumeNeedleStatusRequest{VolumeId: uint32(vid), NeedleId: uint64(id)})
+		return err
+	})
+	return
+}
+
+func withVolumeServer(server string, option EcEncodeOption, fn func(client volume_server_pb.VolumeServerClient) error) error {
+	return pb.WithVolumeServerClient(false, pb.ServerAddress(server), option.GrpcDialOption, fn)
+}
+
+func toUint32ShardIds(shardIds []erasure_coding.ShardId) []uint32 {
+	ids := make([]uint32, len(shardIds))
+	for i, shardId := range shardIds {
+		ids[i] = uint32(shardId)
+	}
+	return ids
+}
@@ -0,0 +1,215 @@
+package topology
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/server"
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+var testGrpcDialOption = grpc.WithTransportCredentials(insecure.NewCredentials())
+
+type testVolumeServer struct {
+	address string
+	dir     string
+	store   *storage.Store
+	server  *server.VolumeServer
+}
+
+// 启动一组带 gRPC 服务的卷服务器，地址形如 127.0.0.1:8080.<grpc 端口>
+func startTestVolumeServers(t *testing.T, racks []string, maxVolumeCount int32) []*testVolumeServer {
+	var servers []*testVolumeServer
+	for i, rack := range racks {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		grpcPort := listener.Addr().(*net.TCPAddr).Port
+		dir := t.TempDir()
+		store := storage.NewStore(testGrpcDialOption, 8080+i, grpcPort, "127.0.0.1", "", []string{dir}, []int32{maxVolumeCount},
+			[]util.MinFreeSpace{{Type: util.AsPercent}}, "", storage.NeedleMapInMemory, []types.DiskType{types.HardDriveType})
+		store.SetDataCenter("dc1")
+		store.SetRack(rack)
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-store.NewVolumesChan:
+				case <-store.DeletedVolumesChan:
+				case <-store.NewEcShardsChan:
+				case <-store.DeletedEcShardsChan:
+				case <-done:
+					return
+				}
+			}
+		}()
+		grpcServer := grpc.NewServer()
+		volumeServer := server.NewVolumeServer(store, testGrpcDialOption)
+		volume_server_pb.RegisterVolumeServerServer(grpcServer, volumeServer)
+		go grpcServer.Serve(listener)
+		t.Cleanup(func() {
+			grpcServer.Stop()
+			volumeServer.Shutdown()
+			store.Close()
+			close(done)
+		})
+		servers = append(servers, &testVolumeServer{
+			address: fmt.Sprintf("127.0.0.1:%d.%d", 8080+i, grpcPort),
+			dir:     dir,
+			store:   store,
+			server:  volumeServer,
+		})
+	}
+	return servers
+}
+
+func syncTestVolumeServers(topo *Topology, servers []*testVolumeServer) {
+	for _, s := range servers {
+		topo.SyncDataNode(s.address, s.store.CollectHeartbeat())
+		topo.SyncDataNode(s.address, s.store.CollectErasureCodingHeartbeat())
+	}
+}
+
+func writeTestNeedles(t *testing.T, store *storage.Store, vid needle.VolumeId, count int) map[types.NeedleId][]byte {
+	written := make(map[types.NeedleId][]byte)
+	for i := 1; i <= count; i++ {
+		data := []byte(fmt.Sprintf("needle %d %s", i, strings.Repeat("x", i)))
+		n := &needle.Needle{Id: types.NeedleId(i), Cookie: 0x1234, Data: data, Checksum: needle.NewCRC(data)}
+		if _, err := store.WriteVolumeNeedle(vid, n); err != nil {
+			t.Fatal(err)
+		}
+		written[n.Id] = data
+	}
+	return written
+}
+
+func ecShardFiles(t *testing.T, dir string) (files []string) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.Contains(filepath.Ext(entry.Name()), ".ec") {
+			files = append(files, entry.Name())
+		}
+	}
+	return
+}
+
+func TestEcEncodeVolume(t *testing.T) {
+	for _, scheme := range []erasure_coding.EcScheme{{DataShards: 4, ParityShards: 2}, erasure_coding.DefaultEcScheme} {
+		t.Run(scheme.String(), func(t *testing.T) {
+			testEcEncodeVolume(t, scheme)
+		})
+	}
+}
+
+func testEcEncodeVolume(t *testing.T, scheme erasure_coding.EcScheme) {
+	servers := startTestVolumeServers(t, []string{"r1", "r1", "r2", "r3", "r3"}, 2)
+	source := servers[0]
+	if err := source.store.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, source.store, 1, 30)
+	large := bytes.Repeat([]byte("large"), 300*1024)
+	if _, err := source.store.WriteVolumeNeedle(1, &needle.Needle{Id: 100, Cookie: 0x1234, Data: large, Checksum: needle.NewCRC(large)}); err != nil {
+		t.Fatal(err)
+	}
+	topo := NewTopology(1024 * 1024 * 1024)
+	syncTestVolumeServers(topo, servers)
+
+	option := EcEncodeOption{GrpcDialOption: testGrpcDialOption, EcSchemes: map[string]erasure_coding.EcScheme{"col": scheme}}
+	if err := topo.EcEncodeVolume(context.Background(), 1, option); err != nil {
+		t.Fatal(err)
+	}
+	if source.store.HasVolume(1) {
+		t.Fatalf("volume 1 should be deleted")
+	}
+
+	// 全部分片都已挂载，每个只在一台服务器上，三个机架平均分
+	syncTestVolumeServers(topo, servers)
+	if _, _, found := topo.LookupVolume(1); found {
+		t.Fatalf("volume 1 still in topology")
+	}
+	collection, shardServers, found := topo.LookupEcShards(1)
+	if !found || collection != "col" || len(shardServers) != scheme.TotalShards() {
+		t.Fatalf("ec shards %v", shardServers)
+	}
+	if ecScheme, _ := topo.LookupEcScheme(1); ecScheme != scheme {
+		t.Fatalf("ec scheme %s", ecScheme)
+	}
+	rackShards := make(map[string]int)
+	for i, s := range servers {
+		ecVolume, found := s.store.FindEcVolume(1)
+		if !found {
+			continue
+		}
+		rackShards[[]string{"r1", "r1", "r2", "r3", "r3"}[i]] += len(ecVolume.Shards)
+		if !util.FileExists(filepath.Join(s.dir, "col_1.vif")) || !util.FileExists(filepath.Join(s.dir, "col_1.ecx")) {
+			t.Fatalf("%s misses .vif or .ecx", s.address)
+		}
+	}
+	for shardId, holders := range shardServers {
+		if len(holders) != 1 {
+			t.Fatalf("shard %d on %v", shardId, holders)
+		}
+	}
+	for rack, count := range rackShards {
+		if count > (scheme.TotalShards()+2)/3 {
+			t.Fatalf("rack %s has %d shards", rack, count)
+		}
+	}
+
+	// 小文件都在 .dat 的第一个小块，也就是分片 0 上
+	var holder *testVolumeServer
+	for _, s := range servers {
+		if s.address == shardServers[0][0] {
+			holder = s
+		}
+	}
+	for id, data := range written {
+		n := &needle.Needle{Id: id, Cookie: 0x1234}
+		if _, err := holder.store.ReadEcShardNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+			t.Fatalf("read needle %d from %s: %v", id, holder.address, err)
+		}
+	}
+}
+
+func TestEcEncodeVolumeWithoutEnoughSlots(t *testing.T) {
+	servers := startTestVolumeServers(t, []string{"r1", "r2"}, 1)
+	source := servers[0]
+	if err := source.store.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	writeTestNeedles(t, source.store, 1, 10)
+	topo := NewTopology(1024 * 1024 * 1024)
+	syncTestVolumeServers(topo, servers)
+
+	// 只有 r2 上的 10 个名额，放不下 14 个分片
+	if err := topo.EcEncodeVolume(context.Background(), 1, EcEncodeOption{GrpcDialOption: testGrpcDialOption}); err == nil {
+		t.Fatalf("expect not enough slots")
+	}
+	v := source.store.GetVolume(1)
+	if v == nil || !v.IsReadOnly() {
+		t.Fatalf("volume 1 should be kept read only")
+	}
+	for _, s := range servers {
+		if files := ecShardFiles(t, s.dir); len(files) != 0 {
+			t.Fatalf("%s has ec files left: %v", s.address, files)
+		}
+	}
+}
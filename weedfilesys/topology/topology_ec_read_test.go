@@ -0,0 +1,180 @@
+package topology
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// 只实现 LookupEcVolume 的 master，位置来自 topology
+type testMasterServer struct {
+	master_pb.UnimplementedWeedfilesysServer
+	topo *Topology
+}
+
+func (ms *testMasterServer) LookupEcVolume(ctx context.Context, req *master_pb.LookupEcVolumeRequest) (*master_pb.LookupEcVolumeResponse, error) {
+	return ms.topo.LookupEcVolume(needle.VolumeId(req.VolumeId))
+}
+
+func startTestMasterServer(t *testing.T, topo *Topology) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	grpcServer := grpc.NewServer()
+	master_pb.RegisterWeedfilesysServer(grpcServer, &testMasterServer{topo: topo})
+	go grpcServer.Serve(listener)
+	t.Cleanup(grpcServer.Stop)
+	return fmt.Sprintf("127.0.0.1:9333.%d", listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestReadEcVolumeWithMissingShards(t *testing.T) {
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	servers := startTestVolumeServers(t, []string{"r1", "r2", "r3", "r4"}, 2)
+	source := servers[0]
+	if err := source.store.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, source.store, 1, 20)
+	// 大文件跨过第一个小块，落在两个分片上
+	written[100] = bytes.Repeat([]byte("large"), 300*1024)
+	if _, err := source.store.WriteVolumeNeedle(1, &needle.Needle{Id: 100, Cookie: 0x1234, Data: written[100], Checksum: needle.NewCRC(written[100])}); err != nil {
+		t.Fatal(err)
+	}
+	topo := NewTopology(1024 * 1024 * 1024)
+	syncTestVolumeServers(topo, servers)
+	option := EcEncodeOption{GrpcDialOption: testGrpcDialOption, EcSchemes: map[string]erasure_coding.EcScheme{"col": scheme}}
+	if err := topo.EcEncodeVolume(context.Background(), 1, option); err != nil {
+		t.Fatal(err)
+	}
+	syncTestVolumeServers(topo, servers)
+	masterAddress := startTestMasterServer(t, topo)
+	for _, s := range servers {
+		s.store.MasterAddress = masterAddress
+	}
+
+	_, shardServers, _ := topo.LookupEcShards(1)
+	findServer := func(address string) *testVolumeServer {
+		for _, s := range servers {
+			if s.address == address {
+				return s
+			}
+		}
+		t.Fatalf("server %s not found", address)
+		return nil
+	}
+	// 读取方没有分片 0 和 1，小文件和大文件都要从其他服务器读取
+	var reader *testVolumeServer
+	for _, s := range servers {
+		if ecVolume, found := s.store.FindEcVolume(1); found {
+			if _, found = ecVolume.FindEcVolumeShard(0); found {
+				continue
+			}
+			if _, found = ecVolume.FindEcVolumeShard(1); !found {
+				reader = s
+			}
+		}
+	}
+	if reader == nil {
+		t.Fatalf("no server without shard 0 and 1: %v", shardServers)
+	}
+	readAll := func() error {
+		for id, data := range written {
+			n := &needle.Needle{Id: id, Cookie: 0x1234}
+			if _, err := reader.store.ReadEcShardNeedle(1, n); err != nil {
+				return fmt.Errorf("read needle %d: %w", id, err)
+			}
+			if !bytes.Equal(n.Data, data) {
+				return fmt.Errorf("needle %d mismatch", id)
+			}
+		}
+		return nil
+	}
+	if err := readAll(); err != nil {
+		t.Fatal(err)
+	}
+	ecVolume, _ := reader.store.FindEcVolume(1)
+	ecVolume.ShardLocationsLock.RLock()
+	cached := len(ecVolume.ShardLocations)
+	ecVolume.ShardLocationsLock.RUnlock()
+	if cached != scheme.TotalShards() || ecVolume.ShardLocationsRefreshTime.IsZero() {
+		t.Fatalf("cached %d shard locations", cached)
+	}
+
+	// 远程的 .ecx 标记删除后，读取方的 .ecx 没有同步也能得知已删除
+	shard0Holder := findServer(shardServers[0][0])
+	holderEcVolume, _ := shard0Holder.store.FindEcVolume(1)
+	if err := holderEcVolume.DeleteNeedleFromEcx(5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reader.store.ReadEcShardNeedle(1, &needle.Needle{Id: 5, Cookie: 0x1234}); !errors.Is(err, storage.ErrorDeleted) {
+		t.Fatalf("expect deleted, got %v", err)
+	}
+	delete(written, 5)
+
+	// 分片 0 丢失，缓存中的位置读取失败后移除，改为从其他分片恢复
+	if err := shard0Holder.store.DeleteEcShards(1, "col", []erasure_coding.ShardId{0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := readAll(); err != nil {
+		t.Fatal(err)
+	}
+	ecVolume.ShardLocationsLock.RLock()
+	_, stillCached := ecVolume.ShardLocations[0]
+	ecVolume.ShardLocationsLock.RUnlock()
+	if stillCached {
+		t.Fatalf("location of lost shard 0 should be forgotten")
+	}
+
+	// 再丢失两个分片，剩下的不够恢复
+	var lost []erasure_coding.ShardId
+	for shardId := erasure_coding.ShardId(1); len(lost) < 2; shardId++ {
+		holder := findServer(shardServers[shardId][0])
+		if holder == reader {
+			continue
+		}
+		if err := holder.store.DeleteEcShards(1, "col", []erasure_coding.ShardId{shardId}); err != nil {
+			t.Fatal(err)
+		}
+		lost = append(lost, shardId)
+	}
+	if !slices.Contains(lost, 1) {
+		t.Fatalf("shard 1 should be lost: %v", lost)
+	}
+	if err := readAll(); err == nil {
+		t.Fatalf("expect read failure with shards 0 and %v lost", lost)
+	}
+
+	// master 不可用时查询失败也记下时间，有效期内不再查询
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader.store.MasterAddress = fmt.Sprintf("127.0.0.1:9333.%d", listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+	ecVolume.ShardLocationsLock.Lock()
+	ecVolume.ShardLocationsRefreshTime = time.Time{}
+	ecVolume.ShardLocationsLock.Unlock()
+	lookupStart := time.Now()
+	if err := readAll(); err == nil {
+		t.Fatalf("expect read failure without master")
+	}
+	ecVolume.ShardLocationsLock.RLock()
+	refreshTime := ecVolume.ShardLocationsRefreshTime
+	ecVolume.ShardLocationsLock.RUnlock()
+	if refreshTime.Before(lookupStart) {
+		t.Fatalf("failed lookup should back off, refresh time %v", refreshTime)
+	}
+}
@@ -0,0 +1,422 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// EC 卷的修复和均衡，由 master 定期执行一轮，也可以只输出计划：
+//  1. 按心跳汇总每个 EC 卷上报的分片，与方案应有的分片比较。缺少分片时在持有分片最多的服务器上重建：
+//     先从其他服务器复制它没有的分片，VolumeEcShardsRebuild 重建，按机架分散放置，最后删除只为重建复制来的分片
+//  2. 同一个卷在机架之间、机架内服务器之间的分片数相差超过限制时，从多的一方移动分片到少的一方
+// 剩下的分片不到 DataShards 个的卷无法恢复，只记录在计划中
+
+type EcRepairOption struct {
+	GrpcDialOption grpc.DialOption
+	DryRun         bool // 只生成计划，不执行
+	MaxRackSkew    int  // 同一个卷各机架之间允许相差的分片数，0 表示 1
+	MaxNodeSkew    int  // 同一个卷在机架内各服务器之间允许相差的分片数，0 表示 1
+	MaxMoves       int  // 每轮最多移动的分片数，不含重建的分片，0 表示不限
+}
+
+// EcRebuild 在 Rebuilder 上重建丢失的分片
+type EcRebuild struct {
+	VolumeId   needle.VolumeId
+	Collection string
+	Scheme     erasure_coding.EcScheme
+	Rebuilder  string
+	Copies     map[string][]erasure_coding.ShardId // 重建前从这些服务器复制到 Rebuilder 的分片
+	Missing    []erasure_coding.ShardId
+	Placement  map[string][]erasure_coding.ShardId // 重建出的分片放到哪些服务器
+}
+
+// EcShardMove 把一个分片从 Source 移到 Target
+type EcShardMove struct {
+	VolumeId   needle.VolumeId
+	Collection string
+	ShardId    erasure_coding.ShardId
+	Source     string
+	Target     string
+	Reason     string // 因为哪一级不均衡而移动：rack 或者 node
+}
+
+// EcRepairSkip 无法处理的卷及原因
+type EcRepairSkip struct {
+	VolumeId needle.VolumeId
+	Reason   string
+}
+
+type EcRepairPlan struct {
+	Rebuilds []*EcRebuild
+	Moves    []*EcShardMove
+	Skipped  []*EcRepairSkip
+}
+
+func (p *EcRepairPlan) IsEmpty() bool {
+	return len(p.Rebuilds) == 0 && len(p.Moves) == 0 && len(p.Skipped) == 0
+}
+
+// String 每个操作一行，用于 dry run 的输出
+func (p *EcRepairPlan) String() string {
+	var b strings.Builder
+	for _, r := range p.Rebuilds {
+		fmt.Fprintf(&b, "rebuild %s ec volume %d shards %v on %s, copy %v, place %v\n", r.Scheme, r.VolumeId, r.Missing, r.Rebuilder, r.Copies, r.Placement)
+	}
+	for _, m := range p.Moves {
+		fmt.Fprintf(&b, "move ec volume %d shard %d from %s to %s for %s balance\n", m.VolumeId, m.ShardId, m.Source, m.Target, m.Reason)
+	}
+	for _, s := range p.Skipped {
+		fmt.Fprintf(&b, "skip ec volume %d: %s\n", s.VolumeId, s.Reason)
+	}
+	return b.String()
+}
+
+// 规划时服务器的空闲名额，计划中的操作随之扣减，多个卷共用
+type ecRepairNode struct {
+	server string
+	rack   string // 机房:机架
+	free   int
+}
+
+// 规划时一个卷的分片分布，只含方案内的分片
+type ecRepairVolume struct {
+	vid        needle.VolumeId
+	collection string
+	scheme     erasure_coding.EcScheme
+	shards     map[string]erasure_coding.ShardBits
+}
+
+func (v *ecRepairVolume) count(server string) int {
+	return v.shards[server].ShardIdCount()
+}
+
+func (v *ecRepairVolume) placementNodes(nodes map[string]*ecRepairNode) (placementNodes []*ecPlacementNode) {
+	for _, server := range sortedServers(nodes) {
+		node := nodes[server]
+		placementNodes = append(placementNodes, &ecPlacementNode{server: server, rack: node.rack, free: node.free, assigned: v.count(server)})
+	}
+	return
+}
+
+// PlanEcRepair 按当前的心跳生成一轮修复和均衡计划
+func (t *Topology) PlanEcRepair(option EcRepairOption) *EcRepairPlan {
+	t.RLock()
+	defer t.RUnlock()
+	nodes := make(map[string]*ecRepairNode)
+	for server, dn := range t.dataNodes {
+		nodes[server] = &ecRepairNode{server: server, rack: dn.DataCenter + ":" + dn.Rack, free: t.freeEcShardSlots(server)}
+	}
+	budget := option.MaxMoves
+	if budget <= 0 {
+		budget = math.MaxInt
+	}
+
+	plan := &EcRepairPlan{}
+	for _, vid := range sortedEcVolumeIds(t.ecShards) {
+		locations := t.ecShards[vid]
+		var expected, reported erasure_coding.ShardBits
+		for _, shardId := range locations.scheme.ShardIds() {
+			expected = expected.AddShardId(shardId)
+		}
+		v := &ecRepairVolume{vid: vid, collection: locations.collection, scheme: locations.scheme, shards: make(map[string]erasure_coding.ShardBits)}
+		for server, shardBits := range locations.servers {
+			if shardBits &= expected; shardBits != 0 {
+				v.shards[server] = shardBits
+				reported = reported.Plus(shardBits)
+			}
+		}
+
+		if missing := expected.Minus(reported); missing != 0 {
+			if reported.ShardIdCount() < v.scheme.DataShards {
+				plan.Skipped = append(plan.Skipped, &EcRepairSkip{VolumeId: vid,
+					Reason: fmt.Sprintf("only %d shards left, %s needs %d to recover", reported.ShardIdCount(), v.scheme, v.scheme.DataShards)})
+				continue
+			}
+			rebuild, err := planEcRebuild(v, nodes, missing)
+			if err != nil {
+				plan.Skipped = append(plan.Skipped, &EcRepairSkip{VolumeId: vid, Reason: err.Error()})
+				continue
+			}
+			plan.Rebuilds = append(plan.Rebuilds, rebuild)
+		}
+		plan.Moves = append(plan.Moves, planEcBalance(v, nodes, option, &budget)...)
+	}
+	return plan
+}
+
+// 在持有分片最多的服务器上重建，需要的其余分片从其他服务器复制过去，重建出的分片和编码时一样放置
+func planEcRebuild(v *ecRepairVolume, nodes map[string]*ecRepairNode, missing erasure_coding.ShardBits) (*EcRebuild, error) {
+	var rebuilder string
+	for _, server := range sortedServers(v.shards) {
+		if rebuilder == "" || v.count(server) > v.count(rebuilder) {
+			rebuilder = server
+		}
+	}
+	r := &EcRebuild{
+		VolumeId:   v.vid,
+		Collection: v.collection,
+		Scheme:     v.scheme,
+		Rebuilder:  rebuilder,
+		Copies:     make(map[string][]erasure_coding.ShardId),
+		Missing:    missing.ShardIds(),
+	}
+	local := v.shards[rebuilder]
+	for _, server := range sortedServers(v.shards) {
+		for _, shardId := range v.shards[server].Minus(local).ShardIds() {
+			r.Copies[server] = append(r.Copies[server], shardId)
+			local = local.AddShardId(shardId)
+		}
+	}
+
+	placement, err := planEcShardPlacement(v.placementNodes(nodes), r.Missing)
+	if err != nil {
+		return nil, fmt.Errorf("place rebuilt shards %v: %v", r.Missing, err)
+	}
+	r.Placement = placement
+	for server, shardIds := range placement {
+		for _, shardId := range shardIds {
+			v.shards[server] = v.shards[server].AddShardId(shardId)
+		}
+		nodes[server].free -= len(shardIds)
+	}
+	return r, nil
+}
+
+// 先在机架之间、再在各机架内的服务器之间移动分片，直到差值不超过限制或者用完移动次数。
+// 每次从分片最多的一方移到最少的一方，差值大于 1 时分布总会更平均，所以一定会结束
+func planEcBalance(v *ecRepairVolume, nodes map[string]*ecRepairNode, option EcRepairOption, budget *int) (moves []*EcShardMove) {
+	rackSkew, nodeSkew := max(option.MaxRackSkew, 1), max(option.MaxNodeSkew, 1)
+	racks := make(map[string][]string)
+	for _, server := range sortedServers(nodes) {
+		racks[nodes[server].rack] = append(racks[nodes[server].rack], server)
+	}
+
+	for *budget > 0 {
+		rackCount := make(map[string]int)
+		for rack, servers := range racks {
+			for _, server := range servers {
+				rackCount[rack] += v.count(server)
+			}
+		}
+		var sourceRack, targetRack string
+		for _, rack := range sortedServers(racks) {
+			if sourceRack == "" || rackCount[rack] > rackCount[sourceRack] {
+				sourceRack = rack
+			}
+			if hasFreeSlot(racks[rack], nodes) && (targetRack == "" || rackCount[rack] < rackCount[targetRack]) {
+				targetRack = rack
+			}
+		}
+		if targetRack == "" || rackCount[sourceRack]-rackCount[targetRack] <= rackSkew {
+			break
+		}
+		move := v.planMove(mostLoaded(v, racks[sourceRack]), leastLoaded(v, racks[targetRack], nodes, ""), nodes, "rack")
+		if move == nil {
+			break
+		}
+		moves = append(moves, move)
+		*budget--
+	}
+
+	for _, rack := range sortedServers(racks) {
+		for *budget > 0 {
+			source := mostLoaded(v, racks[rack])
+			target := leastLoaded(v, racks[rack], nodes, source)
+			if target == "" || v.count(source)-v.count(target) <= nodeSkew {
+				break
+			}
+			move := v.planMove(source, target, nodes, "node")
+			if move == nil {
+				break
+			}
+			moves = append(moves, move)
+			*budget--
+		}
+	}
+	return
+}
+
+// 移动 source 有而 target 没有的编号最大的分片
+func (v *ecRepairVolume) planMove(source, target string, nodes map[string]*ecRepairNode, reason string) *EcShardMove {
+	candidates := v.shards[source].Minus(v.shards[target]).ShardIds()
+	if len(candidates) == 0 {
+		return nil
+	}
+	shardId := candidates[len(candidates)-1]
+	v.shards[source] = v.shards[source].RemoveShardId(shardId)
+	if v.shards[source] == 0 {
+		delete(v.shards, source)
+	}
+	v.shards[target] = v.shards[target].AddShardId(shardId)
+	nodes[source].free++
+	nodes[target].free--
+	return &EcShardMove{VolumeId: v.vid, Collection: v.collection, ShardId: shardId, Source: source, Target: target, Reason: reason}
+}
+
+func hasFreeSlot(servers []string, nodes map[string]*ecRepairNode) bool {
+	for _, server := range servers {
+		if nodes[server].free > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// servers 已排序，同样多时取排在前面的
+func mostLoaded(v *ecRepairVolume, servers []string) (picked string) {
+	for _, server := range servers {
+		if picked == "" || v.count(server) > v.count(picked) {
+			picked = server
+		}
+	}
+	return
+}
+
+// 有空闲名额的服务器中分片最少的，同样多时取空闲多的
+func leastLoaded(v *ecRepairVolume, servers []string, nodes map[string]*ecRepairNode, exclude string) (picked string) {
+	for _, server := range servers {
+		if server == exclude || nodes[server].free <= 0 {
+			continue
+		}
+		if picked == "" || lessLoaded(v.count(server), v.count(picked), nodes[server].free, nodes[picked].free, server, picked) {
+			picked = server
+		}
+	}
+	return
+}
+
+// RepairEcVolumes 生成并执行一轮计划，返回计划以及执行中的错误，DryRun 时只返回计划。
+// 一个卷的操作失败后跳过它后面的操作，等下一轮按新的心跳重新规划
+func (t *Topology) RepairEcVolumes(ctx context.Context, option EcRepairOption) (*EcRepairPlan, error) {
+	plan := t.PlanEcRepair(option)
+	if option.DryRun {
+		return plan, nil
+	}
+	encodeOption := EcEncodeOption{GrpcDialOption: option.GrpcDialOption}
+	failed := make(map[needle.VolumeId]bool)
+	var errs []error
+	for _, rebuild := range plan.Rebuilds {
+		if err := t.rebuildEcShards(ctx, rebuild, encodeOption); err != nil {
+			failed[rebuild.VolumeId] = true
+			errs = append(errs, err)
+		}
+	}
+	for _, move := range plan.Moves {
+		if failed[move.VolumeId] {
+			continue
+		}
+		if err := t.moveEcShard(ctx, move, encodeOption); err != nil {
+			failed[move.VolumeId] = true
+			errs = append(errs, err)
+		}
+	}
+	return plan, errors.Join(errs...)
+}
+
+func (t *Topology) rebuildEcShards(ctx context.Context, r *EcRebuild, option EcEncodeOption) (err error) {
+	// 复制来的分片只用于重建，放到其他服务器的重建分片也不留在 Rebuilder 上；失败时重建出的分片全部删除
+	defer func() {
+		var temporary []erasure_coding.ShardId
+		for _, shardIds := range r.Copies {
+			temporary = append(temporary, shardIds...)
+		}
+		for _, shardId := range r.Missing {
+			if err != nil || !slices.Contains(r.Placement[r.Rebuilder], shardId) {
+				temporary = append(temporary, shardId)
+			}
+		}
+		if cleanupErr := deleteEcShards(ctx, r.Rebuilder, r.VolumeId, r.Collection, toUint32ShardIds(temporary), option); cleanupErr != nil {
+			glog.Warningf("clean up ec shards of volume %d on %s: %v", r.VolumeId, r.Rebuilder, cleanupErr)
+		}
+		if err != nil {
+			err = fmt.Errorf("rebuild ec volume %d on %s: %v", r.VolumeId, r.Rebuilder, err)
+		}
+	}()
+
+	err = withVolumeServer(r.Rebuilder, option, func(client volume_server_pb.VolumeServerClient) error {
+		for _, source := range sortedServers(r.Copies) {
+			if _, err := client.VolumeEcShardsCopy(ctx, &volume_server_pb.VolumeEcShardsCopyRequest{
+				VolumeId:       uint32(r.VolumeId),
+				Collection:     r.Collection,
+				ShardIds:       toUint32ShardIds(r.Copies[source]),
+				SourceDataNode: source,
+			}); err != nil {
+				return fmt.Errorf("copy shards %v from %s: %v", r.Copies[source], source, err)
+			}
+		}
+		resp, err := client.VolumeEcShardsRebuild(ctx, &volume_server_pb.VolumeEcShardsRebuildRequest{VolumeId: uint32(r.VolumeId), Collection: r.Collection})
+		if err != nil {
+			return err
+		}
+		for _, shardId := range r.Missing {
+			if !slices.Contains(resp.RebuiltShardIds, uint32(shardId)) {
+				return fmt.Errorf("shard %d is not rebuilt, got %v", shardId, resp.RebuiltShardIds)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err = t.spreadEcShards(ctx, r.VolumeId, r.Collection, r.Rebuilder, r.Placement, option); err != nil {
+		return err
+	}
+	glog.V(0).Infof("rebuilt ec volume %d shards %v: %v", r.VolumeId, r.Missing, r.Placement)
+	return nil
+}
+
+func (t *Topology) moveEcShard(ctx context.Context, m *EcShardMove, option EcEncodeOption) error {
+	placement := map[string][]erasure_coding.ShardId{m.Target: {m.ShardId}}
+	if err := t.spreadEcShards(ctx, m.VolumeId, m.Collection, m.Source, placement, option); err != nil {
+		return fmt.Errorf("move ec volume %d shard %d from %s: %v", m.VolumeId, m.ShardId, m.Source, err)
+	}
+	if err := deleteEcShards(ctx, m.Source, m.VolumeId, m.Collection, []uint32{uint32(m.ShardId)}, option); err != nil {
+		return fmt.Errorf("delete moved ec volume %d shard %d on %s: %v", m.VolumeId, m.ShardId, m.Source, err)
+	}
+	return nil
+}
+
+// StartEcRepairScheduler 每隔 interval 修复和均衡一轮，返回的函数用于停止
+func (t *Topology) StartEcRepairScheduler(option EcRepairOption, interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				plan, err := t.RepairEcVolumes(context.Background(), option)
+				if err != nil {
+					glog.Warningf("repair ec volumes: %v", err)
+				}
+				if !plan.IsEmpty() {
+					glog.V(0).Infof("ec repair plan, dry run %v:\n%s", option.DryRun, plan)
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+func sortedEcVolumeIds(m map[needle.VolumeId]*ecShardLocations) []needle.VolumeId {
+	vids := make([]needle.VolumeId, 0, len(m))
+	for vid := range m {
+		vids = append(vids, vid)
+	}
+	sort.Slice(vids, func(i, j int) bool { return vids[i] < vids[j] })
+	return vids
+}
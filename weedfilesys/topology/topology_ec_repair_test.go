@@ -0,0 +1,187 @@
+package topology
+
+import (
+	"bytes"
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"cayoyibackend/weedfilesys/util"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func ecShardsHeartbeat(vid uint32, scheme erasure_coding.EcScheme, shardIds ...erasure_coding.ShardId) *master_pb.Heartbeat {
+	var shardBits erasure_coding.ShardBits
+	for _, shardId := range shardIds {
+		shardBits = shardBits.AddShardId(shardId)
+	}
+	return &master_pb.Heartbeat{EcShards: []*master_pb.VolumeEcShardInformationMessage{{
+		Id:           vid,
+		EcIndexBits:  uint32(shardBits),
+		DataShards:   uint32(scheme.DataShards),
+		ParityShards: uint32(scheme.ParityShards),
+	}}}
+}
+
+func TestPlanEcRepair(t *testing.T) {
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	topo := NewTopology(1024)
+	for _, server := range []string{"a1:8080", "a2:8080", "b1:8080", "c1:8080"} {
+		topo.SyncDataNode(server, dataNodeHeartbeat(server[:1], 10))
+	}
+	// 卷 1 缺少分片 5，卷 2 只剩 3 个分片
+	topo.SyncDataNode("a1:8080", ecShardsHeartbeat(1, scheme, 0, 1, 2, 3))
+	topo.SyncDataNode("b1:8080", ecShardsHeartbeat(1, scheme, 4))
+	topo.SyncDataNode("c1:8080", ecShardsHeartbeat(2, erasure_coding.DefaultEcScheme, 0, 1, 2))
+
+	plan := topo.PlanEcRepair(EcRepairOption{})
+	if len(plan.Rebuilds) != 1 || len(plan.Skipped) != 1 || plan.Skipped[0].VolumeId != 2 {
+		t.Fatalf("plan:\n%s", plan)
+	}
+	rebuild := plan.Rebuilds[0]
+	expectedCopies := map[string][]erasure_coding.ShardId{"b1:8080": {4}}
+	expectedPlacement := map[string][]erasure_coding.ShardId{"c1:8080": {5}}
+	if rebuild.Rebuilder != "a1:8080" || !reflect.DeepEqual(rebuild.Copies, expectedCopies) || !reflect.DeepEqual(rebuild.Placement, expectedPlacement) {
+		t.Fatalf("rebuild %+v", rebuild)
+	}
+	// 重建后机架 a、b、c 为 4、1、1，先在机架之间均衡为 2、2、2，再在机架 a 内均衡
+	var reasons []string
+	for _, move := range plan.Moves {
+		reasons = append(reasons, move.Reason)
+	}
+	if !reflect.DeepEqual(reasons, []string{"rack", "rack", "node"}) || plan.Moves[2].Source != "a1:8080" || plan.Moves[2].Target != "a2:8080" {
+		t.Fatalf("plan:\n%s", plan)
+	}
+	if !strings.Contains(plan.String(), "rebuild 4+2 ec volume 1 shards [5] on a1:8080") {
+		t.Fatalf("plan:\n%s", plan)
+	}
+
+	// 放宽限制后只需要在机架 a 内移动一次
+	plan = topo.PlanEcRepair(EcRepairOption{MaxRackSkew: 3, MaxNodeSkew: 3})
+	if len(plan.Moves) != 1 || plan.Moves[0].Reason != "node" {
+		t.Fatalf("plan:\n%s", plan)
+	}
+	if plan = topo.PlanEcRepair(EcRepairOption{MaxMoves: 1}); len(plan.Moves) != 1 {
+		t.Fatalf("plan:\n%s", plan)
+	}
+}
+
+func TestRepairEcVolumes(t *testing.T) {
+	scheme := erasure_coding.EcScheme{DataShards: 4, ParityShards: 2}
+	racks := []string{"r1", "r1", "r2", "r3"}
+	servers := startTestVolumeServers(t, racks, 2)
+	source := servers[0]
+	if err := source.store.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, source.store, 1, 20)
+	topo := NewTopology(1024 * 1024 * 1024)
+	syncTestVolumeServers(topo, servers)
+	option := EcEncodeOption{GrpcDialOption: testGrpcDialOption, EcSchemes: map[string]erasure_coding.EcScheme{"col": scheme}}
+	if err := topo.EcEncodeVolume(context.Background(), 1, option); err != nil {
+		t.Fatal(err)
+	}
+	syncTestVolumeServers(topo, servers)
+
+	// 记下原来的分片内容，然后丢掉分片 0 所在服务器上的全部分片
+	_, shardServers, _ := topo.LookupEcShards(1)
+	original := make(map[erasure_coding.ShardId][]byte)
+	var lost *testVolumeServer
+	for _, s := range servers {
+		for shardId, holders := range shardServers {
+			if holders[0] != s.address {
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(s.dir, "col_1"+erasure_coding.ToExt(int(shardId))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			original[shardId] = data
+			if shardId == 0 {
+				lost = s
+			}
+		}
+	}
+	ecVolume, _ := lost.store.FindEcVolume(1)
+	var lostShardIds []erasure_coding.ShardId
+	for _, shard := range ecVolume.Shards {
+		lostShardIds = append(lostShardIds, shard.ShardId)
+	}
+	if err := lost.store.DeleteEcShards(1, "col", lostShardIds); err != nil {
+		t.Fatal(err)
+	}
+	syncTestVolumeServers(topo, servers)
+
+	repairOption := EcRepairOption{GrpcDialOption: testGrpcDialOption, DryRun: true}
+	plan, err := topo.RepairEcVolumes(context.Background(), repairOption)
+	if err != nil || len(plan.Rebuilds) != 1 || !reflect.DeepEqual(plan.Rebuilds[0].Missing, lostShardIds) {
+		t.Fatalf("dry run plan %v:\n%s", err, plan)
+	}
+	if _, shardServers, _ = topo.LookupEcShards(1); len(shardServers) != scheme.TotalShards()-len(lostShardIds) {
+		t.Fatalf("dry run should not change shards: %v", shardServers)
+	}
+
+	repairOption.DryRun = false
+	if plan, err = topo.RepairEcVolumes(context.Background(), repairOption); err != nil {
+		t.Fatalf("repair: %v\n%s", err, plan)
+	}
+	syncTestVolumeServers(topo, servers)
+	if _, shardServers, _ = topo.LookupEcShards(1); len(shardServers) != scheme.TotalShards() {
+		t.Fatalf("ec shards after repair %v", shardServers)
+	}
+	rackShards := make(map[string]int)
+	for shardId, holders := range shardServers {
+		if len(holders) != 1 {
+			t.Fatalf("shard %d on %v", shardId, holders)
+		}
+		for i, s := range servers {
+			if s.address != holders[0] {
+				continue
+			}
+			rackShards[racks[i]]++
+			data, _ := os.ReadFile(filepath.Join(s.dir, "col_1"+erasure_coding.ToExt(int(shardId))))
+			if !bytes.Equal(data, original[shardId]) {
+				t.Fatalf("shard %d on %s differs from the original", shardId, s.address)
+			}
+		}
+	}
+	if len(rackShards) != 3 || rackShards["r1"] != 2 || rackShards["r2"] != 2 || rackShards["r3"] != 2 {
+		t.Fatalf("rack distribution %v", rackShards)
+	}
+	// 只为重建复制来的分片已经删除，留下的分片文件都已挂载
+	for _, s := range servers {
+		ecVolume, mounted := s.store.FindEcVolume(1)
+		for _, shardId := range scheme.ShardIds() {
+			if !util.FileExists(filepath.Join(s.dir, "col_1"+erasure_coding.ToExt(int(shardId)))) {
+				continue
+			}
+			if !mounted {
+				t.Fatalf("%s has shard %d without ec volume mounted", s.address, shardId)
+			}
+			if _, found := ecVolume.FindEcVolumeShard(shardId); !found {
+				t.Fatalf("%s has unmounted shard %d", s.address, shardId)
+			}
+		}
+	}
+
+	holder := servers[0]
+	for _, s := range servers {
+		if s.address == shardServers[0][0] {
+			holder = s
+		}
+	}
+	for id, data := range written {
+		n := &needle.Needle{Id: id, Cookie: 0x1234}
+		if _, err = holder.store.ReadEcShardNeedle(1, n); err != nil || !bytes.Equal(n.Data, data) {
+			t.Fatalf("read needle %d from %s: %v", id, holder.address, err)
+		}
+	}
+	if plan = topo.PlanEcRepair(repairOption); !plan.IsEmpty() {
+		t.Fatalf("nothing left to repair:\n%s", plan)
+	}
+}
@@ -0,0 +1,98 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/erasure_coding"
+	"testing"
+)
+
+func dataNodeHeartbeat(rack string, maxVolumeCount uint32) *master_pb.Heartbeat {
+	return &master_pb.Heartbeat{
+		DataCenter:      "dc1",
+		Rack:            rack,
+		MaxVolumeCounts: map[string]uint32{"": maxVolumeCount},
+		HasNoVolumes:    true,
+		HasNoEcShards:   true,
+	}
+}
+
+func countEcShards(placement map[string][]erasure_coding.ShardId, servers ...string) (count int) {
+	for _, server := range servers {
+		count += len(placement[server])
+	}
+	return
+}
+
+func TestPlanEcShardPlacementSpreadsAcrossRacks(t *testing.T) {
+	topo := NewTopology(1024)
+	topo.SyncDataNode("a1:8080", dataNodeHeartbeat("a", 10))
+	topo.SyncDataNode("a2:8080", dataNodeHeartbeat("a", 10))
+	topo.SyncDataNode("b1:8080", dataNodeHeartbeat("b", 10))
+	topo.SyncDataNode("c1:8080", dataNodeHeartbeat("c", 10))
+	topo.SyncDataNode("c2:8080", dataNodeHeartbeat("c", 10))
+	topo.SyncDataNode("c3:8080", dataNodeHeartbeat("c", 10))
+
+	placement, err := topo.PlanEcShardPlacement(1, erasure_coding.DefaultEcScheme.ShardIds())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 三个机架 5、5、4 个，机架内平均
+	a, b, c := countEcShards(placement, "a1:8080", "a2:8080"), countEcShards(placement, "b1:8080"), countEcShards(placement, "c1:8080", "c2:8080", "c3:8080")
+	if a+b+c != erasure_coding.TotalShardsCount || a < 4 || a > 5 || b < 4 || b > 5 || c < 4 || c > 5 {
+		t.Fatalf("rack distribution %d %d %d: %v", a, b, c, placement)
+	}
+	if a1, a2 := len(placement["a1:8080"]), len(placement["a2:8080"]); a1-a2 > 1 || a2-a1 > 1 {
+		t.Fatalf("rack a not balanced: %v", placement)
+	}
+	for _, server := range []string{"c1:8080", "c2:8080", "c3:8080"} {
+		if n := len(placement[server]); n < 1 || n > 2 {
+			t.Fatalf("rack c not balanced: %v", placement)
+		}
+	}
+
+	// 已有的分片计入分布，补放的分片放到分片少的机架
+	topo.SyncDataNode("a1:8080", &master_pb.Heartbeat{EcShards: []*master_pb.VolumeEcShardInformationMessage{
+		{Id: 2, EcIndexBits: uint32(erasure_coding.ShardBits(0).AddShardId(0).AddShardId(1).AddShardId(2))},
+	}})
+	topo.SyncDataNode("b1:8080", &master_pb.Heartbeat{EcShards: []*master_pb.VolumeEcShardInformationMessage{
+		{Id: 2, EcIndexBits: uint32(erasure_coding.ShardBits(0).AddShardId(3))},
+	}})
+	if placement, err = topo.PlanEcShardPlacement(2, []erasure_coding.ShardId{4, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if countEcShards(placement, "c1:8080", "c2:8080", "c3:8080") != 2 {
+		t.Fatalf("missing shards placement %v", placement)
+	}
+	if _, shardServers, found := topo.LookupEcShards(2); !found || len(shardServers) != 4 || shardServers[3][0] != "b1:8080" {
+		t.Fatalf("ec shard locations %v", shardServers)
+	}
+}
+
+func TestPlanEcShardPlacementRespectsFreeSlots(t *testing.T) {
+	topo := NewTopology(1024)
+	// b1 只剩 2 个分片名额：1 个卷名额可放 10 个分片，已有 8 个
+	topo.SyncDataNode("a1:8080", dataNodeHeartbeat("a", 10))
+	topo.SyncDataNode("b1:8080", dataNodeHeartbeat("b", 1))
+	topo.SyncDataNode("b1:8080", &master_pb.Heartbeat{EcShards: []*master_pb.VolumeEcShardInformationMessage{
+		{Id: 9, EcIndexBits: uint32(erasure_coding.ShardBits(0xff))},
+	}})
+	placement, err := topo.PlanEcShardPlacement(1, erasure_coding.DefaultEcScheme.ShardIds())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(placement["b1:8080"]) != 2 || len(placement["a1:8080"]) != 12 {
+		t.Fatalf("placement %v", placement)
+	}
+
+	// 名额不够时失败
+	topo.SyncDataNode("a1:8080", dataNodeHeartbeat("a", 1))
+	if _, err = topo.PlanEcShardPlacement(1, erasure_coding.DefaultEcScheme.ShardIds()); err == nil {
+		t.Fatalf("expect not enough slots")
+	}
+
+	// 心跳不再上报的分片移除
+	topo.SyncDataNode("b1:8080", &master_pb.Heartbeat{HasNoEcShards: true})
+	if _, _, found := topo.LookupEcShards(9); found {
+		t.Fatalf("ec volume 9 should be removed")
+	}
+}
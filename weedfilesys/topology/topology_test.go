@@ -0,0 +1,71 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"errors"
+	"testing"
+	"time"
+)
+
+func volumeMessage(id uint32, ttl string, size uint64, modifiedAt time.Time) *master_pb.VolumeInformationMessage {
+	t, _ := needle.ReadTTL(ttl)
+	return &master_pb.VolumeInformationMessage{
+		Id:               id,
+		Size:             size,
+		Ttl:              t.ToUint32(),
+		Version:          uint32(needle.GetCurrentVersion()),
+		ModifiedAtSecond: modifiedAt.Unix(),
+	}
+}
+
+func TestPickForWriteMatchesTtlBucket(t *testing.T) {
+	topo := NewTopology(1024)
+	now := time.Now()
+	topo.SyncDataNodeVolumes("server1:8080", []*master_pb.VolumeInformationMessage{
+		volumeMessage(1, "", 0, now),
+		volumeMessage(2, "1h", 0, now),
+		volumeMessage(3, "1d", 0, now),
+		volumeMessage(4, "1h", 2048, now), // 已写满
+	})
+
+	for i := 0; i < 20; i++ {
+		// "60m" 和 "1h" 属于同一组
+		vid, servers, err := topo.PickForWrite("", "000", "60m", "")
+		if err != nil {
+			t.Fatalf("pick for 60m: %v", err)
+		}
+		if vid != 2 || len(servers) != 1 || servers[0] != "server1:8080" {
+			t.Fatalf("picked volume %d on %v", vid, servers)
+		}
+	}
+	if vid, _, err := topo.PickForWrite("", "000", "", ""); err != nil || vid != 1 {
+		t.Fatalf("pick without ttl: %d %v", vid, err)
+	}
+	if _, _, err := topo.PickForWrite("", "000", "3d", ""); !errors.Is(err, ErrNoWritableVolume) {
+		t.Fatalf("pick for unknown ttl bucket: %v", err)
+	}
+}
+
+func TestPickForWriteSkipsExpiredAndRemovedVolumes(t *testing.T) {
+	topo := NewTopology(1024)
+	topo.SyncDataNodeVolumes("server1:8080", []*master_pb.VolumeInformationMessage{
+		volumeMessage(1, "5m", 0, time.Now().Add(-10*time.Minute)),
+		volumeMessage(2, "5m", 0, time.Now()),
+	})
+	if vid, _, err := topo.PickForWrite("", "000", "5m", ""); err != nil || vid != 2 {
+		t.Fatalf("pick: %d %v", vid, err)
+	}
+
+	// 卷服务器删除过期卷后不再上报
+	topo.SyncDataNodeVolumes("server1:8080", []*master_pb.VolumeInformationMessage{
+		volumeMessage(1, "5m", 0, time.Now().Add(-10*time.Minute)),
+	})
+	if _, _, err := topo.PickForWrite("", "000", "5m", ""); !errors.Is(err, ErrNoWritableVolume) {
+		t.Fatalf("pick after removal: %v", err)
+	}
+	vids := topo.GetVolumeLayout("", nil, needle.EMPTY_TTL, "").ListVolumeIds()
+	if len(vids) != 0 {
+		t.Fatalf("unexpected volumes without ttl: %v", vids)
+	}
+}
@@ -0,0 +1,129 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage"
+	"cayoyibackend/weedfilesys/storage/backend"
+	_ "cayoyibackend/weedfilesys/storage/backend/local_backend"
+	"cayoyibackend/weedfilesys/storage/backend/s3_backend/fake_s3"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func registerTestLocalBackend(t *testing.T, name string) {
+	_, backendId := backend.BackendNameToTypeId(name)
+	backendStorage, err := backend.BackendStorageFactories["local"].BuildStorage(fake_s3.Properties{"dir": t.TempDir()}, "", backendId)
+	if err != nil {
+		t.Fatalf("build %s: %v", name, err)
+	}
+	backend.BackendStorages[name] = backendStorage
+	t.Cleanup(func() { delete(backend.BackendStorages, name) })
+}
+
+func drainTierStream[T any](recv func() (T, error)) error {
+	for {
+		if _, err := recv(); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// 通过 gRPC 把卷搬到远程再搬回本地
+func TestVolumeTierMoveGrpc(t *testing.T) {
+	registerTestLocalBackend(t, "local.tier")
+	servers := startTestVolumeServers(t, []string{"r1"}, 2)
+	s := servers[0]
+	if err := s.store.AddVolume(1, "col", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, s.store, 1, 5)
+	if err := s.store.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	err := pb.WithVolumeServerClient(false, pb.ServerAddress(s.address), testGrpcDialOption, func(client volume_server_pb.VolumeServerClient) error {
+		ctx := context.Background()
+		stream, err := client.VolumeTierMoveDatToRemote(ctx, &volume_server_pb.VolumeTierMoveDatToRemoteRequest{VolumeId: 1, Collection: "other", DestinationBackendName: "local.tier"})
+		if err == nil {
+			err = drainTierStream(stream.Recv)
+		}
+		if err == nil {
+			t.Fatal("move a volume of another collection should fail")
+		}
+
+		stream, err = client.VolumeTierMoveDatToRemote(ctx, &volume_server_pb.VolumeTierMoveDatToRemoteRequest{VolumeId: 1, Collection: "col", DestinationBackendName: "local.tier"})
+		if err != nil {
+			return err
+		}
+		if err = drainTierStream(stream.Recv); err != nil {
+			return err
+		}
+		if !s.store.GetVolume(1).HasRemoteFile() {
+			t.Fatal("volume should be on remote storage")
+		}
+
+		back, err := client.VolumeTierMoveDatFromRemote(ctx, &volume_server_pb.VolumeTierMoveDatFromRemoteRequest{VolumeId: 1, Collection: "col"})
+		if err != nil {
+			return err
+		}
+		return drainTierStream(back.Recv)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := s.store.GetVolume(1)
+	if v.HasRemoteFile() || !v.IsReadOnly() {
+		t.Fatal("volume should be local and read only")
+	}
+	for id, data := range written {
+		n := &needle.Needle{Id: id, Cookie: 0x1234}
+		if _, err = s.store.ReadVolumeNeedle(1, n); err != nil || string(n.Data) != string(data) {
+			t.Fatalf("read needle %d: %v", id, err)
+		}
+	}
+}
+
+// 卷服务器按策略定期把只读的冷卷搬到远程
+func TestVolumeServerTierPolicy(t *testing.T) {
+	registerTestLocalBackend(t, "local.cold")
+	servers := startTestVolumeServers(t, []string{"r1"}, 2)
+	s := servers[0]
+	for _, vid := range []needle.VolumeId{1, 2} {
+		if err := s.store.AddVolume(vid, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+			t.Fatal(err)
+		}
+		writeTestNeedles(t, s.store, vid, 3)
+	}
+	if err := s.store.MarkVolumeReadonly(1, false); err != nil {
+		t.Fatal(err)
+	}
+
+	s.server.SetTierPolicy(storage.TierPolicy{BackendName: "local.cold", KeepLocal: true}, 10*time.Millisecond)
+	deadline := time.Now().Add(5 * time.Second)
+	for !s.store.GetVolume(1).HasRemoteFile() {
+		if time.Now().After(deadline) {
+			t.Fatal("read only volume not tiered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s.store.GetVolume(2).HasRemoteFile() {
+		t.Fatal("writable volume should stay local")
+	}
+
+	// 停止后不再搬迁
+	s.server.SetTierPolicy(storage.TierPolicy{}, 0)
+	if err := s.store.MarkVolumeReadonly(2, false); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s.store.GetVolume(2).HasRemoteFile() {
+		t.Fatal("volume tiered after the policy is cleared")
+	}
+}
@@ -0,0 +1,84 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb"
+	"cayoyibackend/weedfilesys/pb/volume_server_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/types"
+	"context"
+	"io"
+	"testing"
+)
+
+// 按 master 的顺序通过 gRPC 压缩一个卷
+func TestVacuumVolumeGrpc(t *testing.T) {
+	servers := startTestVolumeServers(t, []string{"r1"}, 2)
+	s := servers[0]
+	if err := s.store.AddVolume(1, "", "000", "", 0, 0, types.HardDriveType); err != nil {
+		t.Fatal(err)
+	}
+	written := writeTestNeedles(t, s.store, 1, 20)
+	for id := types.NeedleId(1); id <= 10; id++ {
+		if _, err := s.store.DeleteVolumeNeedle(1, &needle.Needle{Id: id, Cookie: 0x1234}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	datSize, _, _ := s.store.GetVolume(1).FileStat()
+
+	err := pb.WithVolumeServerClient(false, pb.ServerAddress(s.address), testGrpcDialOption, func(client volume_server_pb.VolumeServerClient) error {
+		ctx := context.Background()
+		check, err := client.VacuumVolumeCheck(ctx, &volume_server_pb.VacuumVolumeCheckRequest{VolumeId: 1})
+		if err != nil {
+			return err
+		}
+		if check.GarbageRatio <= 0.2 {
+			t.Fatalf("garbage ratio %f", check.GarbageRatio)
+		}
+
+		stream, err := client.VacuumVolumeCompact(ctx, &volume_server_pb.VacuumVolumeCompactRequest{VolumeId: 1})
+		if err != nil {
+			return err
+		}
+		for {
+			if _, err = stream.Recv(); err == io.EOF {
+				break
+			} else if err != nil {
+				return err
+			}
+		}
+
+		commit, err := client.VacuumVolumeCommit(ctx, &volume_server_pb.VacuumVolumeCommitRequest{VolumeId: 1})
+		if err != nil {
+			return err
+		}
+		if commit.IsReadOnly || commit.VolumeSize == 0 || commit.VolumeSize >= datSize {
+			t.Fatalf("commit %+v, dat size before %d", commit, datSize)
+		}
+
+		_, err = client.VacuumVolumeCleanup(ctx, &volume_server_pb.VacuumVolumeCleanupRequest{VolumeId: 1})
+		if err != nil {
+			return err
+		}
+		if _, err = client.VacuumVolumeCheck(ctx, &volume_server_pb.VacuumVolumeCheckRequest{VolumeId: 2}); err == nil {
+			t.Fatal("check a missing volume should fail")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for id, data := range written {
+		n := &needle.Needle{Id: id, Cookie: 0x1234}
+		_, err := s.store.ReadVolumeNeedle(1, n)
+		if id <= 10 {
+			if err == nil {
+				t.Fatalf("needle %d should be deleted", id)
+			}
+			continue
+		}
+		if err != nil || string(n.Data) != string(data) {
+			t.Fatalf("needle %d after vacuum: %q %v", id, n.Data, err)
+		}
+	}
+}
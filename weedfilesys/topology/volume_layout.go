@@ -0,0 +1,136 @@
+package topology
+
+import (
+	"cayoyibackend/weedfilesys/pb/master_pb"
+	"cayoyibackend/weedfilesys/storage/needle"
+	"cayoyibackend/weedfilesys/storage/super_block"
+	"cayoyibackend/weedfilesys/storage/types"
+	"errors"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrNoWritableVolume = errors.New("no writable volumes")
+
+// VolumeLayout 同一个 collection、副本策略、TTL 和磁盘类型的卷归为一组，分配写入时只在组内挑选，
+// 这样同一个 TTL 卷里的文件会在相近的时间过期，整卷删除即可回收空间
+type VolumeLayout struct {
+	rp              *super_block.ReplicaPlacement
+	ttl             *needle.TTL
+	diskType        types.DiskType
+	volumeSizeLimit uint64
+
+	vid2location map[needle.VolumeId]*volumeLocation
+	accessLock   sync.RWMutex
+}
+
+// 一个卷的所有副本所在的卷服务器，以及最近一次心跳上报的卷信息
+type volumeLocation struct {
+	servers []string
+	info    *master_pb.VolumeInformationMessage
+}
+
+func NewVolumeLayout(rp *super_block.ReplicaPlacement, ttl *needle.TTL, diskType types.DiskType, volumeSizeLimit uint64) *VolumeLayout {
+	return &VolumeLayout{
+		rp:              rp,
+		ttl:             ttl,
+		diskType:        diskType,
+		volumeSizeLimit: volumeSizeLimit,
+		vid2location:    make(map[needle.VolumeId]*volumeLocation),
+	}
+}
+
+func (vl *VolumeLayout) RegisterVolume(v *master_pb.VolumeInformationMessage, server string) {
+	vl.accessLock.Lock()
+	defer vl.accessLock.Unlock()
+
+	vid := needle.VolumeId(v.Id)
+	loc, found := vl.vid2location[vid]
+	if !found {
+		loc = &volumeLocation{}
+		vl.vid2location[vid] = loc
+	}
+	if !slices.Contains(loc.servers, server) {
+		loc.servers = append(loc.servers, server)
+	}
+	loc.info = v
+}
+
+// UnRegisterVolume 返回卷是否已经没有任何副本
+func (vl *VolumeLayout) UnRegisterVolume(vid needle.VolumeId, server string) bool {
+	vl.accessLock.Lock()
+	defer vl.accessLock.Unlock()
+
+	loc, found := vl.vid2location[vid]
+	if !found {
+		return true
+	}
+	loc.servers = slices.DeleteFunc(loc.servers, func(s string) bool { return s == server })
+	if len(loc.servers) == 0 {
+		delete(vl.vid2location, vid)
+		return true
+	}
+	return false
+}
+
+// 可写：不是只读、没有写满、副本数足够；TTL 卷最后一次写入后超过 TTL 即将被卷服务器删除，也不再分配
+func (vl *VolumeLayout) isWritable(loc *volumeLocation, now time.Time) bool {
+	if loc.info == nil || loc.info.ReadOnly {
+		return false
+	}
+	if vl.volumeSizeLimit > 0 && loc.info.Size >= vl.volumeSizeLimit {
+		return false
+	}
+	if vl.rp != nil && len(loc.servers) < vl.rp.GetCopyCount() {
+		return false
+	}
+	if vl.ttl != nil && vl.ttl.Minutes() > 0 && loc.info.ModifiedAtSecond > 0 {
+		expireAt := time.Unix(loc.info.ModifiedAtSecond, 0).Add(time.Duration(vl.ttl.Minutes()) * time.Minute)
+		if !now.Before(expireAt) {
+			return false
+		}
+	}
+	return true
+}
+
+// PickForWrite 从可写的卷中随机挑一个，返回卷 id 和所有副本所在的服务器
+func (vl *VolumeLayout) PickForWrite() (needle.VolumeId, []string, error) {
+	vl.accessLock.RLock()
+	defer vl.accessLock.RUnlock()
+
+	now := time.Now()
+	var writables []needle.VolumeId
+	for vid, loc := range vl.vid2location {
+		if vl.isWritable(loc, now) {
+			writables = append(writables, vid)
+		}
+	}
+	if len(writables) == 0 {
+		return 0, nil, ErrNoWritableVolume
+	}
+	vid := writables[rand.IntN(len(writables))]
+	return vid, slices.Clone(vl.vid2location[vid].servers), nil
+}
+
+func (vl *VolumeLayout) Lookup(vid needle.VolumeId) (*master_pb.VolumeInformationMessage, []string, bool) {
+	vl.accessLock.RLock()
+	defer vl.accessLock.RUnlock()
+	loc, found := vl.vid2location[vid]
+	if !found || loc.info == nil {
+		return nil, nil, false
+	}
+	return loc.info, slices.Clone(loc.servers), true
+}
+
+func (vl *VolumeLayout) ListVolumeIds() (vids []needle.VolumeId) {
+	vl.accessLock.RLock()
+	defer vl.accessLock.RUnlock()
+
+	for vid := range vl.vid2location {
+		vids = append(vids, vid)
+	}
+	slices.Sort(vids)
+	return
+}
@@ -115,6 +115,18 @@ func (vp *ViperProxy) GetStringSlice(key string) []string {
 	return vp.Viper.GetStringSlice(key)
 }
 
+func (vp *ViperProxy) GetStringMap(key string) map[string]interface{} {
+	vp.Lock()
+	defer vp.Unlock()
+	return vp.Viper.GetStringMap(key)
+}
+
+func (vp *ViperProxy) Get(key string) interface{} {
+	vp.Lock()
+	defer vp.Unlock()
+	return vp.Viper.Get(key)
+}
+
 func GetViper() *ViperProxy {
 	vp.Lock()
 	defer vp.Unlock()
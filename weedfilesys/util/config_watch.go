@@ -0,0 +1,93 @@
+package util
+
+import (
+	"cayoyibackend/weedfilesys/glog"
+	"github.com/spf13/viper"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ConfigurationFilePath 按 LoadConfiguration 的搜索顺序查找配置文件，configFileName 不带扩展名
+func ConfigurationFilePath(configFileName string) (string, bool) {
+	dirs := []string{
+		ResolvePath(ConfigurationFileDirectory.String()),
+		".",
+		ResolvePath("~/.weedfilesys"),
+		"/usr/local/etc/weedfilesys/",
+		"/etc/weedfilesys/",
+	}
+	for _, dir := range dirs {
+		if dir == "" {
+			continue
+		}
+		for _, ext := range viper.SupportedExts {
+			path := filepath.Join(dir, configFileName+"."+ext)
+			if stat, err := os.Stat(path); err == nil && !stat.IsDir() {
+				return path, true
+			}
+		}
+	}
+	return "", false
+}
+
+// ReadConfigurationFile 把配置文件读到独立的 ViperProxy 中，不合并进全局配置，已删除的配置项不会残留
+func ReadConfigurationFile(path string) (*ViperProxy, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	return &ViperProxy{Viper: v}, nil
+}
+
+// WatchConfiguration 每隔 interval 检查一次配置文件，文件出现或修改后重新读取并回调。
+// 启动时不回调，初始配置由调用方自己加载；返回的函数用于停止，返回后不会再回调
+func WatchConfiguration(configFileName string, interval time.Duration, onChange func(config *ViperProxy)) (stop func()) {
+	lastPath, lastModTime := configurationFileState(configFileName)
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+			path, modTime := configurationFileState(configFileName)
+			if path == "" || (path == lastPath && modTime.Equal(lastModTime)) {
+				continue
+			}
+			config, err := ReadConfigurationFile(path)
+			if err != nil {
+				// 可能正在写入，下次再读
+				glog.Warningf("reload %s: %v", path, err)
+				continue
+			}
+			lastPath, lastModTime = path, modTime
+			glog.V(0).Infof("configuration %s changed", path)
+			onChange(config)
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+func configurationFileState(configFileName string) (path string, modTime time.Time) {
+	path, found := ConfigurationFilePath(configFileName)
+	if !found {
+		return "", time.Time{}
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}
+	}
+	return path, stat.ModTime()
+}
@@ -0,0 +1,44 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	old := ConfigurationFileDirectory
+	ConfigurationFileDirectory.Set(dir)
+	defer func() { ConfigurationFileDirectory = old }()
+
+	changes := make(chan *ViperProxy, 4)
+	stop := WatchConfiguration("weedfilesys_watch_test", 10*time.Millisecond, func(config *ViperProxy) {
+		changes <- config
+	})
+	defer stop()
+
+	// 文件出现、修改后各回调一次，读到的是独立的配置
+	path := filepath.Join(dir, "weedfilesys_watch_test.toml")
+	for i, value := range []string{"a", "b"} {
+		if err := os.WriteFile(path, []byte("[storage.backend.local.default]\ndir = \""+value+"\"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i) * time.Second)
+		os.Chtimes(path, modTime, modTime)
+		select {
+		case config := <-changes:
+			if got := config.GetString("storage.backend.local.default.dir"); got != value {
+				t.Fatalf("reloaded dir %q, expected %q", got, value)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no reload after writing %s", value)
+		}
+	}
+	select {
+	case <-changes:
+		t.Fatalf("reloaded without change")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,11 +1,42 @@
 ﻿package util
 
 import (
+	"cayoyibackend/weedfilesys/glog"
+	"os"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// CheckFile 返回文件是否存在、当前进程是否可读写，以及修改时间和大小
+func CheckFile(filename string) (exists, canRead, canWrite bool, modTime time.Time, fileSize int64) {
+	exists = true
+	fi, err := os.Stat(filename)
+	if os.IsNotExist(err) {
+		exists = false
+		return
+	}
+	if err != nil {
+		glog.Errorf("check %s: %v", filename, err)
+		return
+	}
+	if fi.Mode()&0400 != 0 {
+		canRead = true
+	}
+	if fi.Mode()&0200 != 0 {
+		canWrite = true
+	}
+	modTime = fi.ModTime()
+	fileSize = fi.Size()
+	return
+}
+
+func FileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
 func ResolvePath(path string) string {
 
 	if !strings.Contains(path, "~") {
@@ -0,0 +1,126 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MinFreeSpaceType 最小剩余空间的表示方式：百分比或字节数
+type MinFreeSpaceType int
+
+const (
+	AsPercent MinFreeSpaceType = iota
+	AsBytes
+)
+
+// MinFreeSpace 数据目录剩余空间低于该值时不再写入新数据
+type MinFreeSpace struct {
+	Type    MinFreeSpaceType
+	Bytes   uint64
+	Percent float32
+	Raw     string
+}
+
+// IsLow 判断剩余空间是否不足，同时返回用于日志的说明
+func (s MinFreeSpace) IsLow(freeBytes uint64, freePercent float32) (yes bool, desc string) {
+	switch s.Type {
+	case AsPercent:
+		yes = freePercent < s.Percent
+		op := IfElse(yes, "<", ">=")
+		return yes, fmt.Sprintf("disk free %.2f%% %s required %.2f%%", freePercent, op, s.Percent)
+	case AsBytes:
+		yes = freeBytes < s.Bytes
+		op := IfElse(yes, "<", ">=")
+		return yes, fmt.Sprintf("disk free %s %s required %s", BytesToHumanReadable(freeBytes), op, BytesToHumanReadable(s.Bytes))
+	}
+	return false, ""
+}
+
+func (s MinFreeSpace) String() string {
+	switch s.Type {
+	case AsPercent:
+		return fmt.Sprintf("%.2f%%", s.Percent)
+	default:
+		return s.Raw
+	}
+}
+
+// ParseMinFreeSpace 纯数字（可带 %）表示百分比，带单位（如 10GiB、500MB）表示字节数
+func ParseMinFreeSpace(s string) (*MinFreeSpace, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return &MinFreeSpace{Type: AsPercent, Percent: 1, Raw: "1"}, nil
+	}
+	if value, err := strconv.ParseFloat(strings.TrimSuffix(s, "%"), 32); err == nil {
+		if value < 0 || value > 100 {
+			return nil, fmt.Errorf("min free disk space %q: percent must be between 0 and 100", s)
+		}
+		return &MinFreeSpace{Type: AsPercent, Percent: float32(value), Raw: s}, nil
+	}
+	bytes, err := ParseBytes(s)
+	if err != nil {
+		return nil, fmt.Errorf("min free disk space %q: %v", s, err)
+	}
+	return &MinFreeSpace{Type: AsBytes, Bytes: bytes, Raw: s}, nil
+}
+
+var byteUnits = map[string]uint64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"t":   1000 * 1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// ParseBytes 解析带单位的字节数，如 10GiB、1.5TB
+func ParseBytes(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := 0
+	for i < len(s) && (s[i] >= '0' && s[i] <= '9' || s[i] == '.') {
+		i++
+	}
+	if i == 0 {
+		return 0, errors.New("missing number")
+	}
+	value, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, err
+	}
+	unit := strings.ToLower(strings.TrimSpace(s[i:]))
+	multiple, ok := byteUnits[unit]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit %q", s[i:])
+	}
+	return uint64(value * float64(multiple)), nil
+}
+
+func BytesToHumanReadable(b uint64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := uint64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+func IfElse[T any](condition bool, a, b T) T {
+	if condition {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,29 @@
+package util
+
+import "testing"
+
+func TestParseMinFreeSpace(t *testing.T) {
+	tests := []struct {
+		in      string
+		typ     MinFreeSpaceType
+		bytes   uint64
+		percent float32
+	}{
+		{"5", AsPercent, 0, 5},
+		{"2.5%", AsPercent, 0, 2.5},
+		{"10GiB", AsBytes, 10 << 30, 0},
+		{"500MB", AsBytes, 500 * 1000 * 1000, 0},
+	}
+	for _, tt := range tests {
+		got, err := ParseMinFreeSpace(tt.in)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.in, err)
+		}
+		if got.Type != tt.typ || got.Bytes != tt.bytes || got.Percent != tt.percent {
+			t.Fatalf("%s: got %+v", tt.in, got)
+		}
+	}
+	if _, err := ParseMinFreeSpace("10XB"); err == nil {
+		t.Fatalf("expect error for unknown unit")
+	}
+}
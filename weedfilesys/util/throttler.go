@@ -0,0 +1,33 @@
+package util
+
+import "time"
+
+// WriteThrottler 限制后台任务（比如卷压缩）的写入速度，避免占满磁盘带宽
+type WriteThrottler struct {
+	compactionBytePerSecond int64
+	lastSizeCounter         int64
+	lastSizeCheckTime       time.Time
+}
+
+// NewWriteThrottler bytesPerSecond 小于等于 0 时不限速
+func NewWriteThrottler(bytesPerSecond int64) *WriteThrottler {
+	return &WriteThrottler{
+		compactionBytePerSecond: bytesPerSecond,
+		lastSizeCheckTime:       time.Now(),
+	}
+}
+
+// MaybeSlowdown 每累计写入约 1/10 秒的配额检查一次，写得比限速快时睡眠补齐
+func (wt *WriteThrottler) MaybeSlowdown(delta int64) {
+	if wt.compactionBytePerSecond <= 0 {
+		return
+	}
+	wt.lastSizeCounter += delta
+	if wt.lastSizeCounter > wt.compactionBytePerSecond/10 {
+		expected := time.Duration(wt.lastSizeCounter * int64(time.Second) / wt.compactionBytePerSecond)
+		if sleepTime := expected - time.Since(wt.lastSizeCheckTime); sleepTime > 0 {
+			time.Sleep(sleepTime)
+		}
+		wt.lastSizeCounter, wt.lastSizeCheckTime = 0, time.Now()
+	}
+}